@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/benchmarking"
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_benchmark"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// bb_benchmark runs a synthetic workload against a Content Addressable
+// Storage backend and reports latency percentiles and throughput. It
+// can be used to compare storage configurations (e.g., different chains
+// of decorators) before rolling them out.
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_benchmark bb_benchmark.jsonnet")
+	}
+	var configuration bb_benchmark.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+
+	// Stop the workload upon receiving SIGINT or SIGTERM.
+	ctx := util.NewShutdownContext()
+
+	blobAccess, err := blobstore_configuration.CreateCASBlobAccessObjectFromConfig(
+		configuration.BlobAccess,
+		int(configuration.MaximumMessageSizeBytes))
+	if err != nil {
+		log.Fatal("Failed to create blob access: ", err)
+	}
+
+	blobSizes := make([]benchmarking.BlobSizeRange, 0, len(configuration.BlobSizes))
+	for _, blobSize := range configuration.BlobSizes {
+		blobSizes = append(blobSizes, benchmarking.BlobSizeRange{
+			MinimumSizeBytes: blobSize.MinimumSizeBytes,
+			MaximumSizeBytes: blobSize.MaximumSizeBytes,
+			Weight:           blobSize.Weight,
+		})
+	}
+	concurrency := int(configuration.Concurrency)
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	runner, err := benchmarking.NewRunner(
+		blobAccess,
+		clock.SystemClock,
+		benchmarking.Workload{
+			InstanceName:         configuration.InstanceName,
+			BlobSizes:            blobSizes,
+			ReadWeight:           configuration.ReadWeight,
+			WriteWeight:          configuration.WriteWeight,
+			FindMissingWeight:    configuration.FindMissingWeight,
+			FindMissingBatchSize: int(configuration.FindMissingBatchSize),
+			Concurrency:          concurrency,
+		},
+		configuration.Seed)
+	if err != nil {
+		log.Fatal("Invalid workload: ", err)
+	}
+
+	if count := int(configuration.InitialObjectCount); count > 0 {
+		log.Printf("Writing %d initial objects", count)
+		if err := runner.Populate(ctx, count); err != nil {
+			log.Fatal("Failed to write initial objects: ", err)
+		}
+	}
+
+	if configuration.Duration != nil {
+		duration, err := ptypes.Duration(configuration.Duration)
+		if err != nil {
+			log.Fatal("Failed to obtain duration: ", err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	// Periodically report intermediate statistics.
+	if configuration.ReportInterval != nil {
+		reportInterval, err := ptypes.Duration(configuration.ReportInterval)
+		if err != nil {
+			log.Fatal("Failed to obtain report interval: ", err)
+		}
+		go func() {
+			ticker := time.NewTicker(reportInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					printReport(runner.GetReport())
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	log.Print("Starting workload")
+	runner.Run(ctx, 0)
+	printReport(runner.GetReport())
+}
+
+// printReport prints the statistics of each type of operation on a
+// separate line.
+func printReport(report benchmarking.Report) {
+	seconds := report.Elapsed.Seconds()
+	if seconds <= 0 {
+		return
+	}
+	log.Printf("Statistics after %s:", report.Elapsed.Truncate(time.Millisecond))
+	for _, operation := range report.Operations {
+		log.Printf(
+			"%-12s count=%d errors=%d ops/s=%.1f MB/s=%.2f p50=%s p90=%s p99=%s max=%s",
+			operation.Operation,
+			operation.Count,
+			operation.Errors,
+			float64(operation.Count)/seconds,
+			float64(operation.SizeBytes)/seconds/1e6,
+			operation.Latency50,
+			operation.Latency90,
+			operation.Latency99,
+			operation.LatencyMax)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/copying"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_copy"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bb_copy copies objects listed in a file from one Content Addressable
+// Storage backend to another. It can be used to migrate data between
+// storage backends, or to seed a new backend before switching clients
+// over to it. Progress is stored in a checkpoint file, so that copying
+// can be resumed after an interruption.
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_copy bb_copy.jsonnet")
+	}
+	var configuration bb_copy.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+
+	// Stop copying upon receiving SIGINT or SIGTERM. The checkpoint
+	// of the last completed batch is retained.
+	ctx := util.NewShutdownContext()
+
+	source, err := blobstore_configuration.CreateCASBlobAccessObjectFromConfig(
+		configuration.Source,
+		int(configuration.MaximumMessageSizeBytes))
+	if err != nil {
+		log.Fatal("Failed to create source: ", err)
+	}
+	sink, err := blobstore_configuration.CreateCASBlobAccessObjectFromConfig(
+		configuration.Sink,
+		int(configuration.MaximumMessageSizeBytes))
+	if err != nil {
+		log.Fatal("Failed to create sink: ", err)
+	}
+
+	batchSize := int(configuration.BatchSize)
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	concurrency := int(configuration.Concurrency)
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	copier := copying.NewCopier(source, sink, concurrency, configuration.DryRun)
+
+	// Determine where to resume copying.
+	linesDone := 0
+	if configuration.CheckpointPath != "" {
+		if data, err := ioutil.ReadFile(configuration.CheckpointPath); err == nil {
+			linesDone, err = strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				log.Fatalf("Invalid checkpoint in %s: %s", configuration.CheckpointPath, err)
+			}
+			log.Printf("Resuming after line %d", linesDone)
+		} else if !os.IsNotExist(err) {
+			log.Fatal("Failed to read checkpoint: ", err)
+		}
+	}
+
+	f, err := os.Open(configuration.DigestListPath)
+	if err != nil {
+		log.Fatal("Failed to open digest list: ", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	totalChecked, totalCopied, totalSkipped := 0, 0, 0
+	for {
+		// Gather a batch of digests from the list.
+		batch := digest.NewSetBuilder()
+		batchLines := 0
+		for batchLines < batchSize && scanner.Scan() {
+			lineNumber++
+			if lineNumber <= linesDone {
+				continue
+			}
+			batchLines++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			blobDigest, err := parseDigest(configuration.InstanceName, line)
+			if err != nil {
+				log.Fatalf("Invalid digest on line %d: %s", lineNumber, err)
+			}
+			batch.Add(blobDigest)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal("Failed to read digest list: ", err)
+		}
+		if batchLines == 0 {
+			break
+		}
+
+		digests := batch.Build()
+		copied, skipped, err := copier.CopyBatch(ctx, digests)
+		if err != nil {
+			log.Fatalf("Failed to copy batch ending at line %d: %s", lineNumber, err)
+		}
+		// Objects may have been removed from the source since
+		// the digest list was generated. Skip these.
+		for _, blobDigest := range skipped.Items() {
+			log.Print("Missing in source, skipping: ", blobDigest)
+		}
+		totalChecked += digests.Length()
+		totalCopied += copied.Length()
+		totalSkipped += skipped.Length()
+		if configuration.DryRun {
+			for _, blobDigest := range copied.Items() {
+				log.Print("Missing in sink: ", blobDigest)
+			}
+		} else if configuration.CheckpointPath != "" {
+			if err := writeCheckpoint(configuration.CheckpointPath, lineNumber); err != nil {
+				log.Fatal("Failed to write checkpoint: ", err)
+			}
+		}
+		log.Printf("Processed %d lines: %d objects checked, %d objects missing in sink, %d objects missing in source", lineNumber, totalChecked, totalCopied, totalSkipped)
+	}
+	log.Print("Copying complete")
+}
+
+// parseDigest parses a single line of the digest list, having the form
+// "${hash}-${size_bytes}".
+func parseDigest(instanceName string, line string) (digest.Digest, error) {
+	separator := strings.LastIndexByte(line, '-')
+	if separator < 0 {
+		return digest.BadDigest, status.Error(codes.InvalidArgument, "Digest must have the form ${hash}-${size_bytes}")
+	}
+	sizeBytes, err := strconv.ParseInt(line[separator+1:], 10, 64)
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid digest size: %s", err)
+	}
+	return digest.NewDigest(instanceName, line[:separator], sizeBytes)
+}
+
+// writeCheckpoint atomically replaces the checkpoint file, so that it
+// is never left in a partially written state when interrupted.
+func writeCheckpoint(path string, linesDone int) error {
+	temporaryPath := path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, []byte(strconv.Itoa(linesDone)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, path)
+}
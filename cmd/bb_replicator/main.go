@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
 	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_replicator"
+	grpc_configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
 	replicator_pb "github.com/buildbarn/bb-storage/pkg/proto/replicator"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/gorilla/mux"
 
 	"google.golang.org/grpc"
@@ -26,6 +31,18 @@ func main() {
 		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
 	}
 
+	// Upon receiving SIGINT or SIGTERM, stop accepting new requests
+	// and let replications in flight complete before terminating.
+	ctx := util.NewShutdownContext()
+	var gracefulShutdownTimeout time.Duration
+	if configuration.GracefulShutdownTimeout != nil {
+		var err error
+		gracefulShutdownTimeout, err = ptypes.Duration(configuration.GracefulShutdownTimeout)
+		if err != nil {
+			log.Fatal("Failed to obtain graceful shutdown timeout: ", err)
+		}
+	}
+
 	source, err := blobstore_configuration.CreateCASBlobAccessObjectFromConfig(
 		configuration.Source,
 		int(configuration.MaximumMessageSizeBytes))
@@ -47,18 +64,49 @@ func main() {
 		log.Fatal("Failed to create replicator: ", err)
 	}
 
+	// Upon receiving SIGHUP, apply changes to settings of the gRPC
+	// servers that can be changed without restarting.
+	grpcServerReloads := make(chan []*grpc_configuration.GRPCServerConfiguration)
+	util.ReloadConfigurationOnHangup(
+		os.Args[1],
+		func() proto.Message { return &bb_replicator.ApplicationConfiguration{} },
+		func(newConfiguration proto.Message) {
+			// Don't block once the servers have stopped
+			// processing reloads due to shutting down.
+			select {
+			case grpcServerReloads <- newConfiguration.(*bb_replicator.ApplicationConfiguration).GrpcServers:
+			case <-ctx.Done():
+			}
+		})
+
+	grpcServersStopped := make(chan struct{})
 	go func() {
-		log.Fatal(
-			"gRPC server failure: ",
-			bb_grpc.NewGRPCServersFromConfigurationAndServe(
-				configuration.GrpcServers,
-				func(s *grpc.Server) {
-					replicator_pb.RegisterReplicatorServer(s, mirrored.NewReplicatorServer(replicator))
-				}))
+		if err := bb_grpc.NewGRPCServersFromConfigurationAndServe(
+			ctx,
+			configuration.GrpcServers,
+			func(s *grpc.Server) {
+				replicator_pb.RegisterReplicatorServer(s, mirrored.NewReplicatorServer(replicator))
+			},
+			gracefulShutdownTimeout,
+			grpcServerReloads,
+			nil); err != nil {
+			log.Fatal("gRPC server failure: ", err)
+		}
+		close(grpcServersStopped)
 	}()
 
 	// Web server for metrics and profiling.
 	router := mux.NewRouter()
 	util.RegisterAdministrativeHTTPEndpoints(router)
-	log.Fatal(http.ListenAndServe(configuration.HttpListenAddress, router))
+	go func() {
+		log.Fatal(http.ListenAndServe(configuration.HttpListenAddress, router))
+	}()
+
+	// Keep serving metrics until all gRPC requests have completed.
+	<-grpcServersStopped
+
+	// Complete replications that are in progress and flush storage
+	// backends to disk, so that no data is lost.
+	util.RunShutdownHooks(context.Background())
+	log.Print("Shutdown complete")
 }
@@ -1,25 +1,55 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/ac"
+	"github.com/buildbarn/bb-storage/pkg/admin"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/accounting"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/completenesschecking"
 	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/copying"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/leasing"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/signedurl"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/tombstoning"
+	"github.com/buildbarn/bb-storage/pkg/browser"
 	"github.com/buildbarn/bb-storage/pkg/builder"
 	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/fsac"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/hardening"
+	"github.com/buildbarn/bb-storage/pkg/health"
+	"github.com/buildbarn/bb-storage/pkg/iscc"
 	"github.com/buildbarn/bb-storage/pkg/opencensus"
+	accounting_pb "github.com/buildbarn/bb-storage/pkg/proto/accounting"
+	admin_pb "github.com/buildbarn/bb-storage/pkg/proto/admin"
 	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_storage"
+	grpc_configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	fsac_pb "github.com/buildbarn/bb-storage/pkg/proto/fsac"
+	iscc_pb "github.com/buildbarn/bb-storage/pkg/proto/iscc"
+	"github.com/buildbarn/bb-storage/pkg/proto/lease"
+	signedurl_pb "github.com/buildbarn/bb-storage/pkg/proto/signedurl"
+	"github.com/buildbarn/bb-storage/pkg/proto/uncachedactionresult"
+	warmup_pb "github.com/buildbarn/bb-storage/pkg/proto/warmup"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/gorilla/mux"
 
 	"google.golang.org/genproto/googleapis/bytestream"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	grpc_health "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
@@ -36,14 +66,49 @@ func main() {
 		opencensus.Initialize(configuration.Jaeger)
 	}
 
+	// Upon receiving SIGINT or SIGTERM, stop accepting new requests
+	// and let requests in flight complete before terminating.
+	ctx := util.NewShutdownContext()
+	var gracefulShutdownTimeout time.Duration
+	if configuration.GracefulShutdownTimeout != nil {
+		var err error
+		gracefulShutdownTimeout, err = ptypes.Duration(configuration.GracefulShutdownTimeout)
+		if err != nil {
+			log.Fatal("Failed to obtain graceful shutdown timeout: ", err)
+		}
+	}
+
 	// Storage access.
-	contentAddressableStorageBlobAccess, actionCache, err := blobstore_configuration.CreateBlobAccessObjectsFromConfig(
+	contentAddressableStorageBlobAccess, actionCache, contentAddressableStorageBackends, actionCacheBackends, err := blobstore_configuration.CreateBlobAccessObjectsAndBackendsFromConfig(
 		configuration.Blobstore,
 		int(configuration.MaximumMessageSizeBytes))
 	if err != nil {
 		log.Fatal("Failed to create blob access: ", err)
 	}
 
+	var fileSystemAccessCache blobstore.BlobAccess
+	if configuration.FileSystemAccessCache != nil {
+		fileSystemAccessCache, err = blobstore_configuration.CreateFSACBlobAccessObjectFromConfig(
+			configuration.FileSystemAccessCache,
+			int(configuration.MaximumMessageSizeBytes))
+		if err != nil {
+			log.Fatal("Failed to create File System Access Cache: ", err)
+		}
+	}
+
+	var initialSizeClassCache blobstore.BlobAccess
+	if initialSizeClassCacheConfiguration := configuration.InitialSizeClassCache; initialSizeClassCacheConfiguration != nil {
+		if initialSizeClassCacheConfiguration.MaximumPreviousExecutionsPerSizeClass <= 0 {
+			log.Fatal("Initial Size Class Cache maximum previous executions per size class must be positive")
+		}
+		initialSizeClassCache, err = blobstore_configuration.CreateISCCBlobAccessObjectFromConfig(
+			initialSizeClassCacheConfiguration.Backend,
+			int(configuration.MaximumMessageSizeBytes))
+		if err != nil {
+			log.Fatal("Failed to create Initial Size Class Cache: ", err)
+		}
+	}
+
 	// If this instance of bb-storage has access to all data (as in,
 	// it's not a single shard within a distributed setup), it can
 	// be configured to verify that all objects referenced by
@@ -54,11 +119,235 @@ func main() {
 			actionCache,
 			cas.NewBlobAccessContentAddressableStorage(
 				contentAddressableStorageBlobAccess,
+				nil,
 				int(configuration.MaximumMessageSizeBytes)),
 			contentAddressableStorageBlobAccess,
 			100,
 			int(configuration.MaximumMessageSizeBytes))
 	}
+	if actionResultValidation := configuration.ActionResultValidation; actionResultValidation != nil {
+		actionCache = completenesschecking.NewValidatingBlobAccess(
+			actionCache,
+			cas.NewBlobAccessContentAddressableStorage(
+				contentAddressableStorageBlobAccess,
+				nil,
+				int(configuration.MaximumMessageSizeBytes)),
+			contentAddressableStorageBlobAccess,
+			100,
+			int(configuration.MaximumMessageSizeBytes),
+			actionResultValidation.RejectNonZeroExitCode)
+	}
+
+	// Optionally allow entries in the Action Cache to be invalidated
+	// and keep track of ActionResults that are written, so that
+	// they can be managed through the administrative service.
+	var tombstones *tombstoning.TombstoneSet
+	var recentActionResults *admin.RecentActionResults
+	if adminConfiguration := configuration.Admin; adminConfiguration != nil {
+		if adminConfiguration.ActionResultTombstonesPath != "" {
+			tombstones, err = tombstoning.NewTombstoneSetFromFile(adminConfiguration.ActionResultTombstonesPath)
+			if err != nil {
+				log.Fatal("Failed to load Action Cache tombstones: ", err)
+			}
+			actionCache = tombstoning.NewTombstoningBlobAccess(actionCache, tombstones)
+		}
+		if adminConfiguration.RecentActionResults > 0 {
+			if auditLogPath := adminConfiguration.RecentActionResultsAuditLogPath; auditLogPath != "" {
+				recentActionResults, err = admin.NewPersistentRecentActionResults(clock.SystemClock, int(adminConfiguration.RecentActionResults), auditLogPath)
+				if err != nil {
+					log.Fatal("Failed to load recent ActionResults audit log: ", err)
+				}
+				util.RegisterShutdownHook(func(ctx context.Context) error {
+					return recentActionResults.Close()
+				})
+			} else {
+				recentActionResults = admin.NewRecentActionResults(clock.SystemClock, int(adminConfiguration.RecentActionResults))
+			}
+			actionCache = admin.NewRecordingBlobAccess(actionCache, recentActionResults)
+		}
+	}
+
+	// Optionally keep track of the amount of traffic and storage
+	// used by each instance name, for chargeback reporting.
+	var usageTracker *accounting.UsageTracker
+	if accountingConfiguration := configuration.Accounting; accountingConfiguration != nil {
+		persistInterval, err := ptypes.Duration(accountingConfiguration.PersistInterval)
+		if err != nil {
+			log.Fatal("Failed to obtain accounting persist interval: ", err)
+		}
+		if persistInterval <= 0 {
+			log.Fatal("Accounting persist interval must be positive")
+		}
+		samplingRate := accountingConfiguration.StoredObjectsSamplingRate
+		if samplingRate == 0 {
+			samplingRate = 1
+		}
+		usageTracker, err = accounting.NewUsageTracker(
+			contentAddressableStorageBlobAccess,
+			clock.SystemClock,
+			accountingConfiguration.StatePath,
+			samplingRate)
+		if err != nil {
+			log.Fatal("Failed to create usage tracker: ", err)
+		}
+		go usageTracker.Run(ctx, persistInterval)
+	}
+
+	// Allow the storage node to be put into read-only or maintenance
+	// mode, so that it can be drained without clients observing
+	// generic errors.
+	operatingModeSwitch, err := admin.NewOperatingModeSwitch(configuration.OperatingMode)
+	if err != nil {
+		log.Fatal("Invalid operating mode: ", err)
+	}
+	contentAddressableStorageBlobAccess = admin.NewOperatingModeEnforcingBlobAccess(contentAddressableStorageBlobAccess, operatingModeSwitch)
+	actionCache = admin.NewOperatingModeEnforcingBlobAccess(actionCache, operatingModeSwitch)
+	if fileSystemAccessCache != nil {
+		fileSystemAccessCache = admin.NewOperatingModeEnforcingBlobAccess(fileSystemAccessCache, operatingModeSwitch)
+	}
+	var initialSizeClassCacheServer iscc_pb.InitialSizeClassCacheServer
+	if initialSizeClassCache != nil {
+		initialSizeClassCache = admin.NewOperatingModeEnforcingBlobAccess(initialSizeClassCache, operatingModeSwitch)
+		initialSizeClassCacheServer = iscc.NewInitialSizeClassCacheServer(
+			initialSizeClassCache,
+			int(configuration.MaximumMessageSizeBytes),
+			int(configuration.InitialSizeClassCache.MaximumPreviousExecutionsPerSizeClass))
+	}
+
+	// Optionally allow schedulers and workers to take leases on
+	// objects in the Content Addressable Storage, so that the inputs
+	// of actions that are currently running don't get evicted.
+	var blobLeaseManager *leasing.BlobLeaseManager
+	if blobLeasing := configuration.BlobLeasing; blobLeasing != nil {
+		refreshInterval, err := ptypes.Duration(blobLeasing.RefreshInterval)
+		if err != nil {
+			log.Fatal("Failed to obtain blob leasing refresh interval: ", err)
+		}
+		maximumLeaseDuration, err := ptypes.Duration(blobLeasing.MaximumLeaseDuration)
+		if err != nil {
+			log.Fatal("Failed to obtain blob leasing maximum lease duration: ", err)
+		}
+		blobLeaseManager = leasing.NewBlobLeaseManager(
+			contentAddressableStorageBlobAccess,
+			clock.SystemClock,
+			maximumLeaseDuration,
+			int(blobLeasing.MaximumLeasedBlobs),
+			"cas")
+		go blobLeaseManager.Run(ctx, refreshInterval)
+	}
+
+	// Optionally let clients download objects from a cloud storage
+	// provider directly.
+	var signedURLServer signedurl_pb.SignedURLGeneratorServer
+	if signedURLs := configuration.SignedUrls; signedURLs != nil {
+		backend := contentAddressableStorageBackends[signedURLs.BackendPath]
+		generator, ok := backend.(blobstore.SignedURLGenerator)
+		if !ok {
+			log.Fatalf("Storage backend %#v does not exist or is not capable of generating signed URLs", signedURLs.BackendPath)
+		}
+		maximumExpiry, err := ptypes.Duration(signedURLs.MaximumExpiry)
+		if err != nil {
+			log.Fatal("Failed to obtain signed URL maximum expiry: ", err)
+		}
+		signedURLServer = signedurl.NewSignedURLServer(backend, generator, maximumExpiry)
+	}
+
+	// Optionally let operators copy objects into a caching tier
+	// ahead of time, so that subsequent builds are fast.
+	var warmUpServer warmup_pb.WarmUpServer
+	if warmUp := configuration.WarmUp; warmUp != nil {
+		if configuration.Admin == nil {
+			log.Fatal("Warm-up requires the administrative service to be enabled")
+		}
+		source, ok := contentAddressableStorageBackends[warmUp.SourceBackendPath]
+		if !ok {
+			log.Fatalf("Warm-up source storage backend %#v does not exist", warmUp.SourceBackendPath)
+		}
+		sink, ok := contentAddressableStorageBackends[warmUp.SinkBackendPath]
+		if !ok {
+			log.Fatalf("Warm-up sink storage backend %#v does not exist", warmUp.SinkBackendPath)
+		}
+		concurrency := int(warmUp.Concurrency)
+		if concurrency <= 0 {
+			concurrency = 10
+		}
+		batchSize := int(warmUp.BatchSize)
+		if batchSize <= 0 {
+			batchSize = 1000
+		}
+		warmUpServer = copying.NewWarmUpServer(
+			copying.NewWarmer(
+				copying.NewCopier(source, sink, concurrency, false),
+				cas.NewBlobAccessContentAddressableStorage(sink, nil, int(configuration.MaximumMessageSizeBytes)),
+				batchSize))
+	}
+
+	// Optionally keep track of UncachedActionResults of failed
+	// actions, so that they can be listed by CI dashboards.
+	var uncachedActionResultIndexServer uncachedactionresult.UncachedActionResultIndexServer
+	if uncachedActionResultIndex := configuration.UncachedActionResultIndex; uncachedActionResultIndex != nil {
+		retention, err := ptypes.Duration(uncachedActionResultIndex.Retention)
+		if err != nil {
+			log.Fatal("Failed to obtain UncachedActionResult index retention: ", err)
+		}
+		if uncachedActionResultIndex.MaximumEntriesPerInstance <= 0 {
+			log.Fatal("UncachedActionResult index maximum entries per instance must be positive")
+		}
+		pruneInterval := time.Minute
+		if uncachedActionResultIndex.PruneInterval != nil {
+			pruneInterval, err = ptypes.Duration(uncachedActionResultIndex.PruneInterval)
+			if err != nil {
+				log.Fatal("Failed to obtain UncachedActionResult index prune interval: ", err)
+			}
+			if pruneInterval <= 0 {
+				log.Fatal("UncachedActionResult index prune interval must be positive")
+			}
+		}
+		server, err := cas.NewUncachedActionResultIndexServer(
+			cas.NewBlobAccessContentAddressableStorage(contentAddressableStorageBlobAccess, nil, int(configuration.MaximumMessageSizeBytes)),
+			clock.SystemClock,
+			retention,
+			int(uncachedActionResultIndex.MaximumEntriesPerInstance),
+			uncachedActionResultIndex.StatePath)
+		if err != nil {
+			log.Fatal("Failed to create UncachedActionResult index: ", err)
+		}
+		go server.Run(ctx, pruneInterval)
+		uncachedActionResultIndexServer = server
+	}
+
+	// Optionally probe the storage backends, so that load balancers
+	// can stop sending traffic to this instance if its storage is
+	// unreachable.
+	var healthServer *grpc_health.Server
+	var prober *health.Prober
+	if healthChecking := configuration.HealthChecking; healthChecking != nil {
+		probeInterval, err := ptypes.Duration(healthChecking.ProbeInterval)
+		if err != nil {
+			log.Fatal("Failed to obtain health checking probe interval: ", err)
+		}
+		probeTimeout, err := ptypes.Duration(healthChecking.ProbeTimeout)
+		if err != nil {
+			log.Fatal("Failed to obtain health checking probe timeout: ", err)
+		}
+		probeDigest, err := digest.NewDigest(
+			healthChecking.ProbeInstanceName,
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			0)
+		if err != nil {
+			log.Fatal("Failed to create health checking probe digest: ", err)
+		}
+		healthServer = grpc_health.NewServer()
+		prober = health.NewProber(
+			map[string]health.HealthChecker{
+				"ac":  health.NewGetHealthChecker(actionCache, probeDigest, int(configuration.MaximumMessageSizeBytes)),
+				"cas": health.NewFindMissingHealthChecker(contentAddressableStorageBlobAccess, probeDigest),
+			},
+			clock.SystemClock,
+			probeTimeout,
+			healthServer)
+		go prober.Run(ctx, probeInterval)
+	}
 
 	// Ensure that instance names for which we don't have a
 	// scheduler, but allow AC updates, at least have a no-op
@@ -94,22 +383,162 @@ func main() {
 		allowActionCacheUpdatesForInstances[instance] = true
 	}
 
+	// Upon receiving SIGHUP, apply changes to settings of the gRPC
+	// servers and trace sampling that can be changed without
+	// restarting.
+	grpcServerReloads := make(chan []*grpc_configuration.GRPCServerConfiguration)
+	adminGRPCServerReloads := make(chan []*grpc_configuration.GRPCServerConfiguration)
+	util.ReloadConfigurationOnHangup(
+		os.Args[1],
+		func() proto.Message { return &bb_storage.ApplicationConfiguration{} },
+		func(newConfiguration proto.Message) {
+			// Don't block once the servers have stopped
+			// processing reloads due to shutting down.
+			newApplicationConfiguration := newConfiguration.(*bb_storage.ApplicationConfiguration)
+			if configuration.Jaeger != nil {
+				opencensus.ApplySamplingConfiguration(newApplicationConfiguration.Jaeger)
+			}
+			select {
+			case grpcServerReloads <- newApplicationConfiguration.GrpcServers:
+			case <-ctx.Done():
+				return
+			}
+			if configuration.Admin != nil {
+				select {
+				case adminGRPCServerReloads <- newApplicationConfiguration.Admin.GetGrpcServers():
+				case <-ctx.Done():
+				}
+			}
+		})
+
+	// Only account for traffic of clients, so that requests issued
+	// by bb_storage itself (e.g., by the blob lease manager, health
+	// checks and the browser) are not billed.
+	clientContentAddressableStorage := contentAddressableStorageBlobAccess
+	if usageTracker != nil {
+		clientContentAddressableStorage = accounting.NewAccountingBlobAccess(clientContentAddressableStorage, usageTracker)
+	}
+
+	grpcServersListening := make(chan struct{})
+	grpcServersStopped := make(chan struct{})
 	go func() {
-		log.Fatal(
-			"gRPC server failure: ",
-			bb_grpc.NewGRPCServersFromConfigurationAndServe(
-				configuration.GrpcServers,
-				func(s *grpc.Server) {
-					remoteexecution.RegisterActionCacheServer(s, ac.NewActionCacheServer(actionCache, allowActionCacheUpdatesForInstances, int(configuration.MaximumMessageSizeBytes)))
-					remoteexecution.RegisterContentAddressableStorageServer(s, cas.NewContentAddressableStorageServer(contentAddressableStorageBlobAccess))
-					bytestream.RegisterByteStreamServer(s, cas.NewByteStreamServer(contentAddressableStorageBlobAccess, 1<<16))
-					remoteexecution.RegisterCapabilitiesServer(s, buildQueue)
-					remoteexecution.RegisterExecutionServer(s, buildQueue)
-				}))
+		if err := bb_grpc.NewGRPCServersFromConfigurationAndServe(
+			ctx,
+			configuration.GrpcServers,
+			func(s *grpc.Server) {
+				remoteexecution.RegisterActionCacheServer(s, ac.NewActionCacheServer(actionCache, allowActionCacheUpdatesForInstances, int(configuration.MaximumMessageSizeBytes)))
+				remoteexecution.RegisterContentAddressableStorageServer(s, cas.NewContentAddressableStorageServer(clientContentAddressableStorage))
+				bytestream.RegisterByteStreamServer(s, cas.NewByteStreamServer(clientContentAddressableStorage, 1<<16))
+				remoteexecution.RegisterCapabilitiesServer(s, buildQueue)
+				remoteexecution.RegisterExecutionServer(s, buildQueue)
+				if blobLeaseManager != nil {
+					lease.RegisterBlobLeaserServer(s, leasing.NewBlobLeaserServer(blobLeaseManager))
+				}
+				if signedURLServer != nil {
+					signedurl_pb.RegisterSignedURLGeneratorServer(s, signedURLServer)
+				}
+				if fileSystemAccessCache != nil {
+					fsac_pb.RegisterFileSystemAccessCacheServer(s, fsac.NewFileSystemAccessCacheServer(fileSystemAccessCache, int(configuration.MaximumMessageSizeBytes)))
+				}
+				if initialSizeClassCacheServer != nil {
+					iscc_pb.RegisterInitialSizeClassCacheServer(s, initialSizeClassCacheServer)
+				}
+				if uncachedActionResultIndexServer != nil {
+					uncachedactionresult.RegisterUncachedActionResultIndexServer(s, uncachedActionResultIndexServer)
+				}
+				if healthServer != nil {
+					healthpb.RegisterHealthServer(s, healthServer)
+				}
+			},
+			gracefulShutdownTimeout,
+			grpcServerReloads,
+			grpcServersListening); err != nil {
+			log.Fatal("gRPC server failure: ", err)
+		}
+		close(grpcServersStopped)
 	}()
 
+	// Administrative service, exposed on separate gRPC servers, so
+	// that access can be restricted to operators.
+	adminGRPCServersListening := make(chan struct{})
+	adminGRPCServersStopped := make(chan struct{})
+	if configuration.Admin != nil {
+		go func() {
+			if err := bb_grpc.NewGRPCServersFromConfigurationAndServe(
+				ctx,
+				configuration.Admin.GrpcServers,
+				func(s *grpc.Server) {
+					admin_pb.RegisterAdminServer(s, admin.NewAdminServer(contentAddressableStorageBackends, actionCacheBackends, recentActionResults, tombstones, operatingModeSwitch))
+					if usageTracker != nil {
+						accounting_pb.RegisterAccountingServer(s, accounting.NewAccountingServer(usageTracker))
+					}
+					if warmUpServer != nil {
+						warmup_pb.RegisterWarmUpServer(s, warmUpServer)
+					}
+				},
+				gracefulShutdownTimeout,
+				adminGRPCServerReloads,
+				adminGRPCServersListening); err != nil {
+				log.Fatal("Admin gRPC server failure: ", err)
+			}
+			close(adminGRPCServersStopped)
+		}()
+	} else {
+		close(adminGRPCServersListening)
+		close(adminGRPCServersStopped)
+	}
+
 	// Web server for metrics and profiling.
 	router := mux.NewRouter()
 	util.RegisterAdministrativeHTTPEndpoints(router)
-	log.Fatal(http.ListenAndServe(configuration.HttpListenAddress, router))
+	if prober != nil {
+		router.Handle("/readyz", prober)
+	}
+	if usageTracker != nil {
+		// This endpoint performs no authentication, meaning
+		// that the HTTP port should only be reachable by
+		// trusted clients.
+		router.Handle("/usage", usageTracker)
+	}
+	if browserConfiguration := configuration.Browser; browserConfiguration != nil {
+		pageSizeBytes := int(browserConfiguration.PageSizeBytes)
+		if pageSizeBytes <= 0 {
+			pageSizeBytes = 64 * 1024
+		}
+		browser.NewBrowserService(
+			cas.NewBlobAccessContentAddressableStorage(
+				contentAddressableStorageBlobAccess,
+				nil,
+				int(configuration.MaximumMessageSizeBytes)),
+			contentAddressableStorageBlobAccess,
+			actionCache,
+			int(configuration.MaximumMessageSizeBytes),
+			pageSizeBytes,
+			router)
+	}
+	httpListener, err := net.Listen("tcp", configuration.HttpListenAddress)
+	if err != nil {
+		log.Fatal("Failed to create listening socket for HTTP server: ", err)
+	}
+	go func() {
+		log.Fatal(http.Serve(httpListener, router))
+	}()
+
+	// All storage backends have been created and all listening
+	// sockets have been opened. Drop privileges that are no longer
+	// needed.
+	<-grpcServersListening
+	<-adminGRPCServersListening
+	if err := hardening.ApplyConfiguration(configuration.Hardening); err != nil {
+		log.Fatal("Failed to apply hardening: ", err)
+	}
+
+	// Keep serving metrics until all gRPC requests have completed.
+	<-grpcServersStopped
+	<-adminGRPCServersStopped
+
+	// Complete replications that are in progress and flush storage
+	// backends to disk, so that no data is lost.
+	util.RunShutdownHooks(context.Background())
+	log.Print("Shutdown complete")
 }
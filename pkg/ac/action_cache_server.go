@@ -44,6 +44,12 @@ func (s *actionCacheServer) UpdateActionResult(ctx context.Context, in *remoteex
 	if instance := digest.GetInstance(); !s.allowUpdatesForInstances[instance] {
 		return nil, status.Errorf(codes.Unimplemented, "This service can only be used to get action results for instance %#v", instance)
 	}
+	// Propagate the priority provided by the client, so that storage
+	// backends may use it to determine how the entry is stored.
+	ctx = blobstore.NewContextWithPutHints(ctx, blobstore.PutHints{
+		Kind:     blobstore.BlobKindActionResult,
+		Priority: in.ResultsCachePolicy.GetPriority(),
+	})
 	return in.ActionResult, s.blobAccess.Put(
 		ctx,
 		digest,
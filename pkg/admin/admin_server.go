@@ -0,0 +1,274 @@
+package admin
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/tombstoning"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	admin_pb "github.com/buildbarn/bb-storage/pkg/proto/admin"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type adminServer struct {
+	contentAddressableStorageBackends map[string]blobstore.BlobAccess
+	actionCacheBackends               map[string]blobstore.BlobAccess
+	recentActionResults               *RecentActionResults
+	tombstones                        *tombstoning.TombstoneSet
+	operatingModeSwitch               *OperatingModeSwitch
+}
+
+// NewAdminServer creates a gRPC service for inspecting and modifying
+// the contents of individual storage backends. The storage backends
+// are keyed by their path in the configuration, as returned by
+// CreateBlobAccessObjectsAndBackendsFromConfig(). Listing recent
+// ActionResults is only supported if a RecentActionResults is
+// provided. Similarly, tombstones can only be managed if a
+// TombstoneSet is provided, and the operating mode can only be
+// managed if an OperatingModeSwitch is provided.
+func NewAdminServer(contentAddressableStorageBackends map[string]blobstore.BlobAccess, actionCacheBackends map[string]blobstore.BlobAccess, recentActionResults *RecentActionResults, tombstones *tombstoning.TombstoneSet, operatingModeSwitch *OperatingModeSwitch) admin_pb.AdminServer {
+	return &adminServer{
+		contentAddressableStorageBackends: contentAddressableStorageBackends,
+		actionCacheBackends:               actionCacheBackends,
+		recentActionResults:               recentActionResults,
+		tombstones:                        tombstones,
+		operatingModeSwitch:               operatingModeSwitch,
+	}
+}
+
+func (s *adminServer) getBackends(storageType admin_pb.StorageType) (map[string]blobstore.BlobAccess, error) {
+	switch storageType {
+	case admin_pb.StorageType_CONTENT_ADDRESSABLE_STORAGE:
+		return s.contentAddressableStorageBackends, nil
+	case admin_pb.StorageType_ACTION_CACHE:
+		return s.actionCacheBackends, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown storage type %d", storageType)
+	}
+}
+
+func getSortedPaths(backends map[string]blobstore.BlobAccess) []string {
+	paths := make([]string, 0, len(backends))
+	for path := range backends {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *adminServer) StatBlob(ctx context.Context, request *admin_pb.StatBlobRequest) (*admin_pb.StatBlobResponse, error) {
+	backends, err := s.getBackends(request.StorageType)
+	if err != nil {
+		return nil, err
+	}
+	blobDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query all storage backends individually. Failures of
+	// individual backends are reported as part of the response, as
+	// these are likely what the caller is trying to diagnose.
+	// Inspecting objects should not cause them to be retained
+	// longer than they would be otherwise.
+	ctxWithoutRefreshing := blobstore.NewContextWithoutRefreshing(ctx)
+	var response admin_pb.StatBlobResponse
+	digests := digest.NewSetBuilder().Add(blobDigest).Build()
+	for _, path := range getSortedPaths(backends) {
+		backend := &admin_pb.StatBlobResponse_Backend{Path: path}
+		if missing, err := backends[path].FindMissing(ctxWithoutRefreshing, digests); err != nil {
+			backend.Error = err.Error()
+		} else if backend.Present = missing.Empty(); backend.Present {
+			if err := statBlob(ctxWithoutRefreshing, backends[path], blobDigest, backend); err != nil {
+				backend.Error = err.Error()
+			}
+		}
+		response.Backends = append(response.Backends, backend)
+	}
+
+	if request.StorageType == admin_pb.StorageType_ACTION_CACHE && s.recentActionResults != nil {
+		if writeTime, ok := s.recentActionResults.GetLastWriteTime(blobDigest); ok {
+			response.LastWriteTime, err = ptypes.TimestampProto(writeTime)
+			if err != nil {
+				return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to convert last write time")
+			}
+		}
+	}
+	return &response, nil
+}
+
+// statBlob adds the size and last access time of an object to the
+// response of StatBlob(), if the storage backend is capable of
+// reporting these.
+func statBlob(ctx context.Context, blobAccess blobstore.BlobAccess, blobDigest digest.Digest, backend *admin_pb.StatBlobResponse_Backend) error {
+	statter, ok := blobAccess.(blobstore.BlobStatter)
+	if !ok {
+		return nil
+	}
+	blobInfo, err := statter.StatBlob(ctx, blobDigest)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			// Object got removed in the meantime.
+			backend.Present = false
+			return nil
+		case codes.Unimplemented:
+			return nil
+		}
+		return err
+	}
+	backend.SizeBytes = blobInfo.SizeBytes
+	if !blobInfo.LastAccessTime.IsZero() {
+		lastAccessTime, err := ptypes.TimestampProto(blobInfo.LastAccessTime)
+		if err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to convert last access time")
+		}
+		backend.LastAccessTime = lastAccessTime
+	}
+	return nil
+}
+
+func (s *adminServer) DeleteBlob(ctx context.Context, request *admin_pb.DeleteBlobRequest) (*admin_pb.DeleteBlobResponse, error) {
+	backends, err := s.getBackends(request.StorageType)
+	if err != nil {
+		return nil, err
+	}
+	blobDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var response admin_pb.DeleteBlobResponse
+	supported := false
+	for _, path := range getSortedPaths(backends) {
+		if deleter, ok := backends[path].(blobstore.BlobDeleter); ok {
+			supported = true
+			if err := deleter.Delete(ctx, blobDigest); err == nil {
+				response.DeletedFromPaths = append(response.DeletedFromPaths, path)
+			} else if status.Code(err) != codes.NotFound {
+				return nil, util.StatusWrapf(err, "Failed to delete object from %#v", path)
+			}
+		}
+	}
+	if !supported {
+		return nil, status.Error(codes.FailedPrecondition, "None of the storage backends support deleting objects")
+	}
+	return &response, nil
+}
+
+func (s *adminServer) ListRecentActionResults(ctx context.Context, request *admin_pb.ListRecentActionResultsRequest) (*admin_pb.ListRecentActionResultsResponse, error) {
+	if s.recentActionResults == nil {
+		return nil, status.Error(codes.Unimplemented, "Tracking of recent ActionResults is not enabled")
+	}
+	if request.MaximumResults <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Maximum number of results must be positive")
+	}
+
+	var response admin_pb.ListRecentActionResultsResponse
+	for _, entry := range s.recentActionResults.List(request.InstanceName, request.ToolInvocationId, int(request.MaximumResults)) {
+		updateTime, err := ptypes.TimestampProto(entry.UpdateTime)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to convert update time")
+		}
+		response.ActionResults = append(response.ActionResults, &admin_pb.ListRecentActionResultsResponse_ActionResult{
+			ActionDigest:    entry.ActionDigest.GetPartialDigest(),
+			UpdateTime:      updateTime,
+			RequestMetadata: entry.RequestMetadata,
+		})
+	}
+	return &response, nil
+}
+
+func (s *adminServer) GetStorageUtilization(ctx context.Context, request *empty.Empty) (*admin_pb.GetStorageUtilizationResponse, error) {
+	// Paths of storage backends of the Content Addressable Storage
+	// and Action Cache have distinct prefixes, meaning they can be
+	// merged without collisions.
+	backends := map[string]blobstore.BlobAccess{}
+	for path, backend := range s.contentAddressableStorageBackends {
+		backends[path] = backend
+	}
+	for path, backend := range s.actionCacheBackends {
+		backends[path] = backend
+	}
+
+	var response admin_pb.GetStorageUtilizationResponse
+	for _, path := range getSortedPaths(backends) {
+		if utilizationReporter, ok := backends[path].(blobstore.UtilizationReporter); ok {
+			usedBytes, capacityBytes := utilizationReporter.GetUtilization()
+			response.Backends = append(response.Backends, &admin_pb.GetStorageUtilizationResponse_Backend{
+				Path:          path,
+				UsedBytes:     usedBytes,
+				CapacityBytes: capacityBytes,
+			})
+		}
+	}
+	return &response, nil
+}
+
+func (s *adminServer) getTombstoneDigest(request *admin_pb.ActionResultTombstone) (digest.Digest, error) {
+	if s.tombstones == nil {
+		return digest.BadDigest, status.Error(codes.Unimplemented, "Tombstoning of ActionResults is not enabled")
+	}
+	return digest.NewDigestFromPartialDigest(request.InstanceName, request.ActionDigest)
+}
+
+func (s *adminServer) AddActionResultTombstone(ctx context.Context, request *admin_pb.ActionResultTombstone) (*empty.Empty, error) {
+	actionDigest, err := s.getTombstoneDigest(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tombstones.Add(actionDigest); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
+
+func (s *adminServer) RemoveActionResultTombstone(ctx context.Context, request *admin_pb.ActionResultTombstone) (*empty.Empty, error) {
+	actionDigest, err := s.getTombstoneDigest(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tombstones.Remove(actionDigest); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
+
+func (s *adminServer) ListActionResultTombstones(ctx context.Context, request *empty.Empty) (*admin_pb.ListActionResultTombstonesResponse, error) {
+	if s.tombstones == nil {
+		return nil, status.Error(codes.Unimplemented, "Tombstoning of ActionResults is not enabled")
+	}
+	var response admin_pb.ListActionResultTombstonesResponse
+	for _, actionDigest := range s.tombstones.List() {
+		response.Tombstones = append(response.Tombstones, &admin_pb.ActionResultTombstone{
+			InstanceName: actionDigest.GetInstance(),
+			ActionDigest: actionDigest.GetPartialDigest(),
+		})
+	}
+	return &response, nil
+}
+
+func (s *adminServer) GetOperatingMode(ctx context.Context, request *empty.Empty) (*admin_pb.OperatingModeState, error) {
+	if s.operatingModeSwitch == nil {
+		return nil, status.Error(codes.Unimplemented, "Switching of operating modes is not enabled")
+	}
+	return s.operatingModeSwitch.Get(), nil
+}
+
+func (s *adminServer) SetOperatingMode(ctx context.Context, request *admin_pb.OperatingModeState) (*empty.Empty, error) {
+	if s.operatingModeSwitch == nil {
+		return nil, status.Error(codes.Unimplemented, "Switching of operating modes is not enabled")
+	}
+	if err := s.operatingModeSwitch.Set(request); err != nil {
+		return nil, err
+	}
+	log.Printf("Operating mode changed to %s (reason: %#v)", request.Mode, request.Reason)
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,277 @@
+package admin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/admin"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	admin_pb "github.com/buildbarn/bb-storage/pkg/proto/admin"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// deletableBlobAccess is a storage backend that supports deleting
+// objects.
+type deletableBlobAccess struct {
+	*mock.MockBlobAccess
+	*mock.MockBlobDeleter
+}
+
+// fixedCapacityBlobAccess is a storage backend that is capable of
+// reporting its utilization and how objects are stored.
+type fixedCapacityBlobAccess struct {
+	*mock.MockBlobAccess
+	*mock.MockUtilizationReporter
+	*mock.MockBlobStatter
+}
+
+func TestAdminServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	casRoot := mock.NewMockBlobAccess(ctrl)
+	casShard := fixedCapacityBlobAccess{
+		MockBlobAccess:          mock.NewMockBlobAccess(ctrl),
+		MockUtilizationReporter: mock.NewMockUtilizationReporter(ctrl),
+		MockBlobStatter:         mock.NewMockBlobStatter(ctrl),
+	}
+	acRoot := mock.NewMockBlobAccess(ctrl)
+	acBucket := deletableBlobAccess{
+		MockBlobAccess:  mock.NewMockBlobAccess(ctrl),
+		MockBlobDeleter: mock.NewMockBlobDeleter(ctrl),
+	}
+	clock := mock.NewMockClock(ctrl)
+	recentActionResults := admin.NewRecentActionResults(clock, 2)
+	adminServer := admin.NewAdminServer(
+		map[string]blobstore.BlobAccess{
+			"content_addressable_storage":                            casRoot,
+			"content_addressable_storage.sharding.shards[0].backend": casShard,
+		},
+		map[string]blobstore.BlobAccess{
+			"action_cache":                   acRoot,
+			"action_cache.read_caching.slow": acBucket,
+		},
+		recentActionResults,
+		nil,
+		nil)
+
+	blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := digest.NewSetBuilder().Add(blobDigest).Build()
+
+	t.Run("StatBlob", func(t *testing.T) {
+		// Failures of individual storage backends should be
+		// reported as part of the response. Objects should not
+		// be refreshed, so that inspecting them doesn't prevent
+		// them from being evicted.
+		casRoot.EXPECT().FindMissing(gomock.Any(), digests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+		casShard.MockBlobAccess.EXPECT().FindMissing(gomock.Any(), digests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+				require.True(t, blobstore.IsRefreshingDisabled(ctx))
+				return digest.EmptySet, nil
+			})
+		casShard.MockBlobStatter.EXPECT().StatBlob(gomock.Any(), blobDigest).Return(blobstore.BlobInfo{
+			SizeBytes:      4096,
+			LastAccessTime: time.Unix(1600000000, 0),
+		}, nil)
+
+		response, err := adminServer.StatBlob(ctx, &admin_pb.StatBlobRequest{
+			InstanceName: "instance",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+			StorageType: admin_pb.StorageType_CONTENT_ADDRESSABLE_STORAGE,
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&admin_pb.StatBlobResponse{
+			Backends: []*admin_pb.StatBlobResponse_Backend{
+				{
+					Path:  "content_addressable_storage",
+					Error: "rpc error: code = Unavailable desc = Server offline",
+				},
+				{
+					Path:           "content_addressable_storage.sharding.shards[0].backend",
+					Present:        true,
+					SizeBytes:      4096,
+					LastAccessTime: &timestamp.Timestamp{Seconds: 1600000000},
+				},
+			},
+		}, response))
+	})
+
+	t.Run("DeleteBlobUnsupported", func(t *testing.T) {
+		_, err := adminServer.DeleteBlob(ctx, &admin_pb.DeleteBlobRequest{
+			InstanceName: "instance",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+			StorageType: admin_pb.StorageType_CONTENT_ADDRESSABLE_STORAGE,
+		})
+		require.Equal(t, status.Error(codes.FailedPrecondition, "None of the storage backends support deleting objects"), err)
+	})
+
+	t.Run("DeleteBlobSuccess", func(t *testing.T) {
+		acBucket.MockBlobDeleter.EXPECT().Delete(ctx, blobDigest)
+
+		response, err := adminServer.DeleteBlob(ctx, &admin_pb.DeleteBlobRequest{
+			InstanceName: "instance",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+			StorageType: admin_pb.StorageType_ACTION_CACHE,
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&admin_pb.DeleteBlobResponse{
+			DeletedFromPaths: []string{"action_cache.read_caching.slow"},
+		}, response))
+	})
+
+	t.Run("ListRecentActionResults", func(t *testing.T) {
+		// Write three ActionResults through the recording
+		// decorator. Only the last two should be retained.
+		blobAccess := admin.NewRecordingBlobAccess(acRoot, recentActionResults)
+		for i, hash := range []string{
+			"acbd18db4cc2f85cedef654fccc4a4d8",
+			"37b51d194a7513e45b56f6524f2d51f2",
+			"73feffa4b7f6bb68e44cf984c85f6e88",
+		} {
+			actionDigest := digest.MustNewDigest("instance", hash, 3)
+			acRoot.EXPECT().Put(ctx, actionDigest, gomock.Any()).Return(nil)
+			clock.EXPECT().Now().Return(time.Unix(int64(1000+i), 0))
+			require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewValidatedBufferFromByteSlice([]byte("foo"))))
+		}
+
+		// Failed writes should not be recorded.
+		failedDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		acRoot.EXPECT().Put(ctx, failedDigest, gomock.Any()).Return(status.Error(codes.Unavailable, "Server offline"))
+		require.Equal(t, status.Error(codes.Unavailable, "Server offline"), blobAccess.Put(ctx, failedDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		response, err := adminServer.ListRecentActionResults(ctx, &admin_pb.ListRecentActionResultsRequest{
+			InstanceName:   "instance",
+			MaximumResults: 10,
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&admin_pb.ListRecentActionResultsResponse{
+			ActionResults: []*admin_pb.ListRecentActionResultsResponse_ActionResult{
+				{
+					ActionDigest: &remoteexecution.Digest{
+						Hash:      "73feffa4b7f6bb68e44cf984c85f6e88",
+						SizeBytes: 3,
+					},
+					UpdateTime: &timestamp.Timestamp{Seconds: 1002},
+				},
+				{
+					ActionDigest: &remoteexecution.Digest{
+						Hash:      "37b51d194a7513e45b56f6524f2d51f2",
+						SizeBytes: 3,
+					},
+					UpdateTime: &timestamp.Timestamp{Seconds: 1001},
+				},
+			},
+		}, response))
+
+		// Other instance names should yield no results.
+		response, err = adminServer.ListRecentActionResults(ctx, &admin_pb.ListRecentActionResultsRequest{
+			InstanceName:   "other",
+			MaximumResults: 10,
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&admin_pb.ListRecentActionResultsResponse{}, response))
+
+		// RequestMetadata provided by clients should be recorded,
+		// allowing results to be filtered by tool invocation.
+		requestMetadata := &remoteexecution.RequestMetadata{
+			ToolInvocationId:        "a6ef9f81-8b0f-4e2f-9f0e-3d3e7b2f1c9a",
+			CorrelatedInvocationsId: "5f1d3c0e-8b8a-4b55-a2a1-45c0e0d5d1f4",
+		}
+		ctxWithRequestMetadata := util.NewContextWithRequestMetadata(ctx, requestMetadata)
+		acRoot.EXPECT().Put(ctxWithRequestMetadata, failedDigest, gomock.Any()).Return(nil)
+		clock.EXPECT().Now().Return(time.Unix(1003, 0))
+		require.NoError(t, blobAccess.Put(ctxWithRequestMetadata, failedDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		response, err = adminServer.ListRecentActionResults(ctx, &admin_pb.ListRecentActionResultsRequest{
+			InstanceName:     "instance",
+			MaximumResults:   10,
+			ToolInvocationId: "a6ef9f81-8b0f-4e2f-9f0e-3d3e7b2f1c9a",
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&admin_pb.ListRecentActionResultsResponse{
+			ActionResults: []*admin_pb.ListRecentActionResultsResponse_ActionResult{
+				{
+					ActionDigest: &remoteexecution.Digest{
+						Hash:      "8b1a9953c4611296a827abf8c47804d7",
+						SizeBytes: 5,
+					},
+					UpdateTime:      &timestamp.Timestamp{Seconds: 1003},
+					RequestMetadata: requestMetadata,
+				},
+			},
+		}, response))
+	})
+
+	t.Run("StatBlobActionCache", func(t *testing.T) {
+		// For the Action Cache, the time at which the
+		// ActionResult was last written should be reported.
+		acRoot.EXPECT().FindMissing(gomock.Any(), digests).Return(digest.EmptySet, nil)
+		acBucket.MockBlobAccess.EXPECT().FindMissing(gomock.Any(), digests).Return(digest.EmptySet, nil)
+
+		response, err := adminServer.StatBlob(ctx, &admin_pb.StatBlobRequest{
+			InstanceName: "instance",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+			StorageType: admin_pb.StorageType_ACTION_CACHE,
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&admin_pb.StatBlobResponse{
+			Backends: []*admin_pb.StatBlobResponse_Backend{
+				{
+					Path:    "action_cache",
+					Present: true,
+				},
+				{
+					Path:    "action_cache.read_caching.slow",
+					Present: true,
+				},
+			},
+			LastWriteTime: &timestamp.Timestamp{Seconds: 1003},
+		}, response))
+	})
+
+	t.Run("GetStorageUtilization", func(t *testing.T) {
+		casShard.MockUtilizationReporter.EXPECT().GetUtilization().Return(int64(100), int64(1000))
+
+		response, err := adminServer.GetStorageUtilization(ctx, &empty.Empty{})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&admin_pb.GetStorageUtilizationResponse{
+			Backends: []*admin_pb.GetStorageUtilizationResponse_Backend{
+				{
+					Path:          "content_addressable_storage.sharding.shards[0].backend",
+					UsedBytes:     100,
+					CapacityBytes: 1000,
+				},
+			},
+		}, response))
+	})
+
+	t.Run("TombstonesDisabled", func(t *testing.T) {
+		_, err := adminServer.ListActionResultTombstones(ctx, &empty.Empty{})
+		require.Equal(t, status.Error(codes.Unimplemented, "Tombstoning of ActionResults is not enabled"), err)
+	})
+}
@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	admin_pb "github.com/buildbarn/bb-storage/pkg/proto/admin"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// OperatingModeSwitch holds the operating mode of a storage node. It
+// determines whether requests against storage backends decorated with
+// NewOperatingModeEnforcingBlobAccess() are processed.
+type OperatingModeSwitch struct {
+	lock   sync.RWMutex
+	mode   admin_pb.OperatingMode
+	reason string
+}
+
+// NewOperatingModeSwitch creates an OperatingModeSwitch that is set to
+// an initial operating mode. A nil state corresponds to normal
+// operation.
+func NewOperatingModeSwitch(initialState *admin_pb.OperatingModeState) (*OperatingModeSwitch, error) {
+	var s OperatingModeSwitch
+	if initialState != nil {
+		if err := s.Set(initialState); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// Get the current operating mode.
+func (s *OperatingModeSwitch) Get() *admin_pb.OperatingModeState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &admin_pb.OperatingModeState{
+		Mode:   s.mode,
+		Reason: s.reason,
+	}
+}
+
+// Set the current operating mode.
+func (s *OperatingModeSwitch) Set(state *admin_pb.OperatingModeState) error {
+	switch state.Mode {
+	case admin_pb.OperatingMode_NORMAL, admin_pb.OperatingMode_READ_ONLY, admin_pb.OperatingMode_MAINTENANCE:
+	default:
+		return status.Errorf(codes.InvalidArgument, "Unknown operating mode %d", state.Mode)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mode = state.Mode
+	s.reason = state.Reason
+	return nil
+}
+
+// checkAllowed returns an UNAVAILABLE error with a descriptive message
+// in case the storage node is in one of the provided operating modes.
+func (s *OperatingModeSwitch) checkAllowed(disallowedModes ...admin_pb.OperatingMode) error {
+	s.lock.RLock()
+	mode, reason := s.mode, s.reason
+	s.lock.RUnlock()
+
+	for _, disallowedMode := range disallowedModes {
+		if mode == disallowedMode {
+			var message string
+			switch mode {
+			case admin_pb.OperatingMode_READ_ONLY:
+				message = "Storage is in read-only mode"
+			case admin_pb.OperatingMode_MAINTENANCE:
+				message = "Storage is in maintenance mode"
+			}
+			if reason != "" {
+				return status.Errorf(codes.Unavailable, "%s: %s", message, reason)
+			}
+			return status.Error(codes.Unavailable, message)
+		}
+	}
+	return nil
+}
+
+type operatingModeEnforcingBlobAccess struct {
+	blobstore.BlobAccess
+	operatingModeSwitch *OperatingModeSwitch
+}
+
+// NewOperatingModeEnforcingBlobAccess creates a decorator for
+// BlobAccess that rejects requests depending on the operating mode of
+// the storage node. In read-only mode, writes are rejected, while
+// reads continue to be processed. In maintenance mode, all requests
+// are rejected.
+func NewOperatingModeEnforcingBlobAccess(base blobstore.BlobAccess, operatingModeSwitch *OperatingModeSwitch) blobstore.BlobAccess {
+	return &operatingModeEnforcingBlobAccess{
+		BlobAccess:          base,
+		operatingModeSwitch: operatingModeSwitch,
+	}
+}
+
+func (ba *operatingModeEnforcingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.operatingModeSwitch.checkAllowed(admin_pb.OperatingMode_MAINTENANCE); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return ba.BlobAccess.Get(ctx, digest)
+}
+
+func (ba *operatingModeEnforcingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.operatingModeSwitch.checkAllowed(admin_pb.OperatingMode_READ_ONLY, admin_pb.OperatingMode_MAINTENANCE); err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
+
+func (ba *operatingModeEnforcingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if err := ba.operatingModeSwitch.checkAllowed(admin_pb.OperatingMode_MAINTENANCE); err != nil {
+		return digest.EmptySet, err
+	}
+	return ba.BlobAccess.FindMissing(ctx, digests)
+}
@@ -0,0 +1,89 @@
+package admin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/admin"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	admin_pb "github.com/buildbarn/bb-storage/pkg/proto/admin"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOperatingModeEnforcingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	operatingModeSwitch, err := admin.NewOperatingModeSwitch(nil)
+	require.NoError(t, err)
+	blobAccess := admin.NewOperatingModeEnforcingBlobAccess(baseBlobAccess, operatingModeSwitch)
+
+	blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := digest.NewSetBuilder().Add(blobDigest).Build()
+
+	t.Run("Normal", func(t *testing.T) {
+		require.True(t, proto.Equal(&admin_pb.OperatingModeState{}, operatingModeSwitch.Get()))
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).Return(nil)
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		require.NoError(t, operatingModeSwitch.Set(&admin_pb.OperatingModeState{
+			Mode:   admin_pb.OperatingMode_READ_ONLY,
+			Reason: "Replacing disks",
+		}))
+
+		// Reads should continue to be processed.
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+
+		// Writes should be rejected with a clear message.
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Storage is in read-only mode: Replacing disks"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("Maintenance", func(t *testing.T) {
+		require.NoError(t, operatingModeSwitch.Set(&admin_pb.OperatingModeState{
+			Mode: admin_pb.OperatingMode_MAINTENANCE,
+		}))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Storage is in maintenance mode"), err)
+		_, err = blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, status.Error(codes.Unavailable, "Storage is in maintenance mode"), err)
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Storage is in maintenance mode"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Unknown operating mode 42"),
+			operatingModeSwitch.Set(&admin_pb.OperatingModeState{
+				Mode: 42,
+			}))
+		require.True(t, proto.Equal(&admin_pb.OperatingModeState{
+			Mode: admin_pb.OperatingMode_MAINTENANCE,
+		}, operatingModeSwitch.Get()))
+	})
+}
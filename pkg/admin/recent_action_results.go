@@ -0,0 +1,295 @@
+package admin
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	admin_pb "github.com/buildbarn/bb-storage/pkg/proto/admin"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+)
+
+// RecentActionResult is an entry returned by
+// RecentActionResults.List().
+type RecentActionResult struct {
+	ActionDigest digest.Digest
+	UpdateTime   time.Time
+	// The REv2 RequestMetadata provided by the client that wrote
+	// the ActionResult, or nil if none was provided.
+	RequestMetadata *remoteexecution.RequestMetadata
+}
+
+// RecentActionResults keeps track of the ActionResults that were most
+// recently written into the Action Cache. It is backed by a ring
+// buffer of fixed size, meaning that only a bounded number of entries
+// is retained. Entries may optionally be appended to an audit log, so
+// that they are retained across restarts.
+type RecentActionResults struct {
+	clock clock.Clock
+
+	lock    sync.Mutex
+	entries []RecentActionResult
+	next    int
+
+	auditLogPath    string
+	auditLog        *os.File
+	auditLogRecords int
+}
+
+// NewRecentActionResults creates a RecentActionResults that retains a
+// fixed number of entries.
+func NewRecentActionResults(clock clock.Clock, capacity int) *RecentActionResults {
+	return &RecentActionResults{
+		clock:   clock,
+		entries: make([]RecentActionResult, 0, capacity),
+	}
+}
+
+// NewPersistentRecentActionResults is identical to
+// NewRecentActionResults, except that every entry is also appended to
+// an audit log stored at a given path. Entries contained in an existing
+// audit log are loaded, so that they are retained across restarts.
+//
+// Records are not synchronized to disk individually. They are retained
+// if the process crashes, but may get lost if the system crashes. Close()
+// should be called upon shutdown to synchronize the audit log.
+func NewPersistentRecentActionResults(clock clock.Clock, capacity int, auditLogPath string) (*RecentActionResults, error) {
+	rar := NewRecentActionResults(clock, capacity)
+	rar.auditLogPath = auditLogPath
+
+	data, err := ioutil.ReadFile(auditLogPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to read audit log")
+	}
+	for len(data) > 0 {
+		length, n := proto.DecodeVarint(data)
+		if n == 0 || length > uint64(len(data)-n) {
+			// The last record was only written partially,
+			// which may happen if the process crashed.
+			log.Printf("Ignoring truncated record at the end of audit log %#v", auditLogPath)
+			break
+		}
+		var record admin_pb.ActionResultAuditRecord
+		if err := proto.Unmarshal(data[n:n+int(length)], &record); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal audit log record")
+		}
+		data = data[n+int(length):]
+
+		actionDigest, err := digest.NewDigestFromPartialDigest(record.InstanceName, record.ActionDigest)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Invalid action digest in audit log record")
+		}
+		updateTime, err := ptypes.Timestamp(record.UpdateTime)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid update time in audit log record")
+		}
+		rar.insert(RecentActionResult{
+			ActionDigest:    actionDigest,
+			UpdateTime:      updateTime,
+			RequestMetadata: record.RequestMetadata,
+		})
+	}
+
+	// Rewrite the audit log, so that it no longer contains any
+	// entries that have been displaced or records that are
+	// truncated.
+	if err := rar.compactAuditLog(); err != nil {
+		return nil, err
+	}
+	return rar, nil
+}
+
+func (rar *RecentActionResults) insert(entry RecentActionResult) {
+	if len(rar.entries) < cap(rar.entries) {
+		rar.entries = append(rar.entries, entry)
+	} else if len(rar.entries) > 0 {
+		rar.entries[rar.next] = entry
+		rar.next = (rar.next + 1) % len(rar.entries)
+	}
+}
+
+func marshalAuditLogRecord(entry RecentActionResult) ([]byte, error) {
+	updateTime, err := ptypes.TimestampProto(entry.UpdateTime)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to convert update time")
+	}
+	data, err := proto.Marshal(&admin_pb.ActionResultAuditRecord{
+		InstanceName:    entry.ActionDigest.GetInstance(),
+		ActionDigest:    entry.ActionDigest.GetPartialDigest(),
+		UpdateTime:      updateTime,
+		RequestMetadata: entry.RequestMetadata,
+	})
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal audit log record")
+	}
+	return append(proto.EncodeVarint(uint64(len(data))), data...), nil
+}
+
+// compactAuditLog replaces the audit log by one that only contains the
+// entries that are currently retained, oldest first.
+func (rar *RecentActionResults) compactAuditLog() error {
+	var data []byte
+	for i := 0; i < len(rar.entries); i++ {
+		record, err := marshalAuditLogRecord(rar.entries[(rar.next+i)%len(rar.entries)])
+		if err != nil {
+			return err
+		}
+		data = append(data, record...)
+	}
+
+	temporaryPath := rar.auditLogPath + ".tmp"
+	f, err := os.OpenFile(temporaryPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create audit log")
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write audit log")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize audit log")
+	}
+	if err := f.Close(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to close audit log")
+	}
+	if rar.auditLog != nil {
+		rar.auditLog.Close()
+		rar.auditLog = nil
+	}
+	if err := os.Rename(temporaryPath, rar.auditLogPath); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to replace audit log")
+	}
+	auditLog, err := os.OpenFile(rar.auditLogPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to open audit log")
+	}
+	rar.auditLog = auditLog
+	rar.auditLogRecords = len(rar.entries)
+	return nil
+}
+
+func (rar *RecentActionResults) appendToAuditLog(entry RecentActionResult) error {
+	record, err := marshalAuditLogRecord(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := rar.auditLog.Write(record); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to append to audit log")
+	}
+
+	// Prevent the audit log from growing indefinitely by compacting
+	// it once it contains twice as many records as are retained.
+	rar.auditLogRecords++
+	if rar.auditLogRecords >= 2*cap(rar.entries) {
+		return rar.compactAuditLog()
+	}
+	return nil
+}
+
+func (rar *RecentActionResults) record(ctx context.Context, actionDigest digest.Digest) {
+	entry := RecentActionResult{
+		ActionDigest:    actionDigest,
+		UpdateTime:      rar.clock.Now(),
+		RequestMetadata: util.GetRequestMetadataFromContext(ctx),
+	}
+
+	rar.lock.Lock()
+	defer rar.lock.Unlock()
+	rar.insert(entry)
+	if rar.auditLog != nil {
+		if err := rar.appendToAuditLog(entry); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// Close the audit log, after synchronizing it to disk. Entries that
+// are recorded afterwards are no longer appended to the audit log.
+func (rar *RecentActionResults) Close() error {
+	rar.lock.Lock()
+	defer rar.lock.Unlock()
+
+	if rar.auditLog == nil {
+		return nil
+	}
+	auditLog := rar.auditLog
+	rar.auditLog = nil
+	if err := auditLog.Sync(); err != nil {
+		auditLog.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize audit log")
+	}
+	if err := auditLog.Close(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to close audit log")
+	}
+	return nil
+}
+
+// List the most recently written ActionResults for a given instance
+// name, most recent first. If a tool invocation ID is provided, only
+// ActionResults written by that tool invocation are returned.
+func (rar *RecentActionResults) List(instanceName string, toolInvocationID string, maximumResults int) []RecentActionResult {
+	rar.lock.Lock()
+	defer rar.lock.Unlock()
+
+	var results []RecentActionResult
+	for i := 0; i < len(rar.entries) && len(results) < maximumResults; i++ {
+		entry := rar.entries[(rar.next+len(rar.entries)-i-1)%len(rar.entries)]
+		if entry.ActionDigest.GetInstance() == instanceName &&
+			(toolInvocationID == "" || entry.RequestMetadata.GetToolInvocationId() == toolInvocationID) {
+			results = append(results, entry)
+		}
+	}
+	return results
+}
+
+// GetLastWriteTime returns the time at which the ActionResult for a
+// given Action was most recently written, if it is still known.
+func (rar *RecentActionResults) GetLastWriteTime(actionDigest digest.Digest) (time.Time, bool) {
+	rar.lock.Lock()
+	defer rar.lock.Unlock()
+
+	for i := 0; i < len(rar.entries); i++ {
+		entry := rar.entries[(rar.next+len(rar.entries)-i-1)%len(rar.entries)]
+		if entry.ActionDigest == actionDigest {
+			return entry.UpdateTime, true
+		}
+	}
+	return time.Time{}, false
+}
+
+type recordingBlobAccess struct {
+	blobstore.BlobAccess
+	recentActionResults *RecentActionResults
+}
+
+// NewRecordingBlobAccess creates a decorator for the Action Cache that
+// records all ActionResults that are written successfully into a
+// RecentActionResults, together with the REv2 RequestMetadata of the
+// client that wrote them.
+func NewRecordingBlobAccess(base blobstore.BlobAccess, recentActionResults *RecentActionResults) blobstore.BlobAccess {
+	return &recordingBlobAccess{
+		BlobAccess:          base,
+		recentActionResults: recentActionResults,
+	}
+}
+
+func (ba *recordingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, digest, b); err != nil {
+		return err
+	}
+	ba.recentActionResults.record(ctx, digest)
+	return nil
+}
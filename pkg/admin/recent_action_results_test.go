@@ -0,0 +1,76 @@
+package admin_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/admin"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentRecentActionResults(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	directory, err := ioutil.TempDir("", "recent_action_results")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+	auditLogPath := filepath.Join(directory, "audit.log")
+
+	// Write three ActionResults into an instance that only retains
+	// two entries.
+	clock := mock.NewMockClock(ctrl)
+	recentActionResults, err := admin.NewPersistentRecentActionResults(clock, 2, auditLogPath)
+	require.NoError(t, err)
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := admin.NewRecordingBlobAccess(baseBlobAccess, recentActionResults)
+	digests := []digest.Digest{
+		digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5),
+		digest.MustNewDigest("instance", "6fc422233a40a75a1f028e11c3cd1140", 7),
+		digest.MustNewDigest("instance", "c4e9b9b2e0b0b3d4ae1b0b8a0e5d8c1f", 9),
+	}
+	for i, actionDigest := range digests {
+		baseBlobAccess.EXPECT().Put(gomock.Any(), actionDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		clock.EXPECT().Now().Return(time.Unix(1600000000+int64(i), 0).UTC())
+		require.NoError(t, blobAccess.Put(
+			util.NewContextWithRequestMetadata(ctx, &remoteexecution.RequestMetadata{
+				ToolInvocationId: "d6a1a8ca-9c9c-4ad1-8a4c-0e7e1f0b1e3a",
+			}),
+			actionDigest,
+			buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{}, buffer.UserProvided)))
+	}
+	require.NoError(t, recentActionResults.Close())
+
+	// A truncated record at the end of the audit log, caused by a
+	// crash, should be ignored.
+	f, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x10, 0x0a})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Entries should be retained across restarts.
+	recentActionResults, err = admin.NewPersistentRecentActionResults(clock, 2, auditLogPath)
+	require.NoError(t, err)
+	defer recentActionResults.Close()
+	entries := recentActionResults.List("instance", "d6a1a8ca-9c9c-4ad1-8a4c-0e7e1f0b1e3a", 10)
+	require.Len(t, entries, 2)
+	require.Equal(t, digests[2], entries[0].ActionDigest)
+	require.Equal(t, time.Unix(1600000002, 0).UTC(), entries[0].UpdateTime)
+	require.Equal(t, digests[1], entries[1].ActionDigest)
+	require.Equal(t, time.Unix(1600000001, 0).UTC(), entries[1].UpdateTime)
+}
@@ -13,6 +13,10 @@ func (f acStorageType) GetDigestKey(blobDigest digest.Digest) string {
 	return blobDigest.GetKey(digest.KeyWithInstance)
 }
 
+func (f acStorageType) ParseDigestKey(key string) (digest.Digest, error) {
+	return digest.NewDigestFromKey(key, digest.KeyWithInstance)
+}
+
 func (f acStorageType) NewBufferFromByteSlice(digest digest.Digest, data []byte, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewACBufferFromByteSlice(data, repairStrategy)
 }
@@ -21,6 +25,24 @@ func (f acStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadCloser
 	return buffer.NewACBufferFromReader(r, repairStrategy)
 }
 
+func (f acStorageType) NewBufferFromReaderAt(digest digest.Digest, r buffer.ReadAtCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	// ActionResult messages are small and always read in their
+	// entirety. There is no need to provide random access.
+	return buffer.NewACBufferFromReader(
+		&sectionReadCloser{
+			SectionReader: io.NewSectionReader(r, 0, sizeBytes),
+			Closer:        r,
+		},
+		repairStrategy)
+}
+
+// sectionReadCloser is an io.SectionReader that also forwards calls to
+// Close() to the underlying reader.
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
 // ACStorageType is capable of creating identifiers and buffers for
 // objects stored in the Action Cache (AC).
 var ACStorageType StorageType = acStorageType{}
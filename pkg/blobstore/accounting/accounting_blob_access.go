@@ -0,0 +1,71 @@
+package accounting
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type accountingBlobAccess struct {
+	blobstore.BlobAccess
+	usageTracker *UsageTracker
+}
+
+// NewAccountingBlobAccess creates a decorator for BlobAccess that
+// reports the objects that are uploaded, downloaded and found to be
+// present to a UsageTracker.
+func NewAccountingBlobAccess(base blobstore.BlobAccess, usageTracker *UsageTracker) blobstore.BlobAccess {
+	return &accountingBlobAccess{
+		BlobAccess:   base,
+		usageTracker: usageTracker,
+	}
+}
+
+func (ba *accountingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	// Only account for downloads once the object has been read
+	// successfully. Requests that fail (e.g., because the object
+	// does not exist) or are interrupted are not counted.
+	return buffer.WithErrorHandler(
+		ba.BlobAccess.Get(ctx, digest),
+		&accountingErrorHandler{
+			usageTracker: ba.usageTracker,
+			digest:       digest,
+		})
+}
+
+func (ba *accountingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, digest, b); err != nil {
+		return err
+	}
+	ba.usageTracker.recordUpload(digest)
+	return nil
+}
+
+func (ba *accountingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, err := ba.BlobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	present, _, _ := digest.GetDifferenceAndIntersection(digests, missing)
+	ba.usageTracker.recordPresent(present)
+	return missing, nil
+}
+
+type accountingErrorHandler struct {
+	usageTracker *UsageTracker
+	digest       digest.Digest
+	failed       bool
+}
+
+func (eh *accountingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	eh.failed = true
+	return nil, err
+}
+
+func (eh *accountingErrorHandler) Done() {
+	if !eh.failed {
+		eh.usageTracker.recordDownload(eh.digest)
+	}
+}
@@ -0,0 +1,184 @@
+package accounting_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/accounting"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	accounting_pb "github.com/buildbarn/bb-storage/pkg/proto/accounting"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccountingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	stateDirectory, err := ioutil.TempDir("", "accounting")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDirectory)
+	statePath := filepath.Join(stateDirectory, "usage")
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	usageTracker, err := accounting.NewUsageTracker(baseBlobAccess, clock, statePath, 1)
+	require.NoError(t, err)
+	blobAccess := accounting.NewAccountingBlobAccess(baseBlobAccess, usageTracker)
+
+	digest1 := digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("a", "6fc422233a40a75a1f028e11c3cd1140", 7)
+	digest3 := digest.MustNewDigest("b", "a5e66b1e70b8d5e4b2d0f3d9b0e4b3c1", 11)
+
+	t.Run("PutSuccess", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, digest1, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, digest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PutFailure", func(t *testing.T) {
+		// Failed uploads should not be accounted for.
+		baseBlobAccess.EXPECT().Put(ctx, digest2, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server offline"),
+			blobAccess.Put(ctx, digest2, buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye"))))
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, digest1).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err := blobAccess.Get(ctx, digest1).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetFailure", func(t *testing.T) {
+		// Requests for objects that don't exist should not be
+		// accounted for.
+		baseBlobAccess.EXPECT().Get(ctx, digest2).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		_, err := blobAccess.Get(ctx, digest2).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("GetCorrupted", func(t *testing.T) {
+		// Downloads should only be accounted for if the object
+		// was read successfully.
+		baseBlobAccess.EXPECT().Get(ctx, digest1).Return(buffer.NewCASBufferFromByteSlice(digest1, []byte("Hellx"), buffer.Irreparable))
+		_, err := blobAccess.Get(ctx, digest1).ToByteSlice(100)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// Objects reported as present should be accounted for
+		// as being stored, even if they weren't uploaded through
+		// this decorator.
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Add(digest3).Build()).
+			Return(digest.NewSetBuilder().Add(digest2).Build(), nil)
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Add(digest3).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digest2).Build(), missing)
+	})
+
+	t.Run("PutOtherInstance", func(t *testing.T) {
+		// Objects that are uploaded under multiple instance
+		// names are only stored once. Storage should only be
+		// accounted for under the first instance name.
+		digest1B := digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)
+		baseBlobAccess.EXPECT().Put(ctx, digest1B, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, digest1B, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("GetUsage", func(t *testing.T) {
+		require.Equal(t, []*accounting_pb.InstanceUsage{
+			{
+				InstanceName:      "a",
+				BytesUploaded:     5,
+				ObjectsUploaded:   1,
+				BytesDownloaded:   5,
+				ObjectsDownloaded: 1,
+				BytesStored:       5,
+				ObjectsStored:     1,
+			},
+			{
+				InstanceName:    "b",
+				BytesUploaded:   5,
+				ObjectsUploaded: 1,
+				BytesStored:     11,
+				ObjectsStored:   1,
+			},
+		}, usageTracker.GetUsage(nil))
+
+		// Instance names for which no usage is known should be
+		// reported as having no usage.
+		require.Equal(t, []*accounting_pb.InstanceUsage{
+			{
+				InstanceName:    "b",
+				BytesUploaded:   5,
+				ObjectsUploaded: 1,
+				BytesStored:     11,
+				ObjectsStored:   1,
+			},
+			{
+				InstanceName: "c",
+			},
+		}, usageTracker.GetUsage([]string{"c", "b"}))
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		// Objects that have been evicted from the backend
+		// should no longer be accounted for as being stored.
+		// Checking for presence should not cause objects to be
+		// refreshed.
+		baseBlobAccess.EXPECT().FindMissing(gomock.Any(), digest.NewSetBuilder().Add(digest1).Add(digest3).Build()).
+			DoAndReturn(func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+				require.True(t, blobstore.IsRefreshingDisabled(ctx))
+				return digest.NewSetBuilder().Add(digest1).Build(), nil
+			})
+		require.NoError(t, usageTracker.Refresh(ctx))
+
+		require.Equal(t, []*accounting_pb.InstanceUsage{
+			{
+				InstanceName:      "a",
+				BytesUploaded:     5,
+				ObjectsUploaded:   1,
+				BytesDownloaded:   5,
+				ObjectsDownloaded: 1,
+			},
+			{
+				InstanceName:    "b",
+				BytesUploaded:   5,
+				ObjectsUploaded: 1,
+				BytesStored:     11,
+				ObjectsStored:   1,
+			},
+		}, usageTracker.GetUsage(nil))
+	})
+
+	t.Run("SaveAndLoad", func(t *testing.T) {
+		// Usage should be retained when the state is reloaded.
+		require.NoError(t, usageTracker.Save())
+		reloadedUsageTracker, err := accounting.NewUsageTracker(baseBlobAccess, clock, statePath, 1)
+		require.NoError(t, err)
+		require.Equal(t, usageTracker.GetUsage(nil), reloadedUsageTracker.GetUsage(nil))
+	})
+}
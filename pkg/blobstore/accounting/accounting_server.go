@@ -0,0 +1,43 @@
+package accounting
+
+import (
+	"context"
+	"net/http"
+
+	accounting_pb "github.com/buildbarn/bb-storage/pkg/proto/accounting"
+	"github.com/golang/protobuf/jsonpb"
+)
+
+type accountingServer struct {
+	usageTracker *UsageTracker
+}
+
+// NewAccountingServer creates a gRPC service that returns the usage
+// of instance names, as tracked by a UsageTracker. It can be used to
+// implement chargeback reporting.
+func NewAccountingServer(usageTracker *UsageTracker) accounting_pb.AccountingServer {
+	return &accountingServer{
+		usageTracker: usageTracker,
+	}
+}
+
+func (s *accountingServer) GetUsage(ctx context.Context, request *accounting_pb.GetUsageRequest) (*accounting_pb.GetUsageResponse, error) {
+	return &accounting_pb.GetUsageResponse{
+		Instances: s.usageTracker.GetUsage(request.InstanceNames),
+	}, nil
+}
+
+// ServeHTTP implements an HTTP handler that returns the usage of
+// instance names in JSON form. Instance names may be provided through
+// one or more "instance_name" query parameters. If none are provided,
+// the usage of all instance names is returned.
+func (ut *UsageTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := accounting_pb.GetUsageResponse{
+		Instances: ut.GetUsage(r.URL.Query()["instance_name"]),
+	}
+	marshaler := jsonpb.Marshaler{EmitDefaults: true, Indent: "  "}
+	w.Header().Set("Content-Type", "application/json")
+	if err := marshaler.Marshal(w, &response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,324 @@
+package accounting
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	accounting_pb "github.com/buildbarn/bb-storage/pkg/proto/accounting"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// refreshBatchSize is the maximum number of sampled objects whose
+// presence is checked through a single call to FindMissing().
+const refreshBatchSize = 1000
+
+type instanceUsage struct {
+	bytesUploaded        int64
+	objectsUploaded      int64
+	bytesDownloaded      int64
+	objectsDownloaded    int64
+	sampledBytesStored   int64
+	sampledObjectsStored int64
+}
+
+// UsageTracker keeps track of the amount of traffic and storage used
+// by each instance name of the Content Addressable Storage.
+//
+// Traffic is counted exactly. Storage is estimated by keeping track of
+// a sample of the objects that are stored, where objects are sampled
+// based on their hash. The presence of sampled objects is checked
+// periodically, so that objects that are evicted by the storage
+// backend are no longer accounted for. These checks are performed
+// without refreshing the objects, so that they don't prevent eviction.
+// With a sampling rate of one, all objects are tracked, meaning that
+// storage usage is exact at the cost of memory usage proportional to
+// the number of objects stored.
+//
+// Objects are only stored once, even if they are uploaded under
+// multiple instance names. Storage of such objects is accounted for
+// under the instance name through which they were first observed.
+//
+// The state of the UsageTracker may be persisted in a file, so that
+// usage is retained across restarts.
+type UsageTracker struct {
+	blobAccess   blobstore.BlobAccess
+	clock        clock.Clock
+	path         string
+	samplingRate uint32
+
+	lock      sync.Mutex
+	instances map[string]*instanceUsage
+	// Sampled objects, keyed by their digest without the instance
+	// name, so that objects are not counted multiple times. Values
+	// contain the digest including the instance name that is
+	// charged for storing the object.
+	sampledObjects map[digest.Digest]digest.Digest
+}
+
+// NewUsageTracker creates a UsageTracker for objects stored in a
+// BlobAccess. One in every samplingRate objects is tracked to estimate
+// storage usage. If a path is provided, the state of the UsageTracker
+// is loaded from it, if it exists.
+func NewUsageTracker(blobAccess blobstore.BlobAccess, clock clock.Clock, path string, samplingRate uint32) (*UsageTracker, error) {
+	if samplingRate == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Sampling rate must be positive")
+	}
+	ut := &UsageTracker{
+		blobAccess:   blobAccess,
+		clock:        clock,
+		path:         path,
+		samplingRate: samplingRate,
+
+		instances:      map[string]*instanceUsage{},
+		sampledObjects: map[digest.Digest]digest.Digest{},
+	}
+	if path == "" {
+		return ut, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ut, nil
+	} else if err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to read usage state file %#v", path)
+	}
+	var state accounting_pb.UsageState
+	if err := proto.Unmarshal(data, &state); err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to unmarshal usage state file %#v", path)
+	}
+	for _, instance := range state.Instances {
+		u := ut.getInstanceUsage(instance.InstanceName)
+		u.bytesUploaded = instance.BytesUploaded
+		u.objectsUploaded = instance.ObjectsUploaded
+		u.bytesDownloaded = instance.BytesDownloaded
+		u.objectsDownloaded = instance.ObjectsDownloaded
+	}
+	for i, sampledObject := range state.SampledObjects {
+		blobDigest, err := digest.NewDigestFromPartialDigest(sampledObject.InstanceName, sampledObject.Digest)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid sampled object at index %d in usage state file %#v", i, path)
+		}
+		// Objects that are not sampled under the current
+		// sampling rate are discarded, as they would skew the
+		// estimate.
+		ut.addSampledObject(blobDigest)
+	}
+	return ut, nil
+}
+
+func (ut *UsageTracker) getInstanceUsage(instanceName string) *instanceUsage {
+	u, ok := ut.instances[instanceName]
+	if !ok {
+		u = &instanceUsage{}
+		ut.instances[instanceName] = u
+	}
+	return u
+}
+
+// isSampled returns whether an object should be tracked to estimate
+// storage usage. Objects are sampled based on their hash, so that the
+// same objects are sampled across restarts.
+func (ut *UsageTracker) isSampled(blobDigest digest.Digest) bool {
+	return binary.BigEndian.Uint32(blobDigest.GetHashBytes())%ut.samplingRate == 0
+}
+
+// addSampledObject starts tracking an object for the purpose of
+// estimating storage usage, if it is sampled and not already tracked.
+// The caller must hold the lock.
+func (ut *UsageTracker) addSampledObject(blobDigest digest.Digest) {
+	if !ut.isSampled(blobDigest) {
+		return
+	}
+	key := blobDigest.WithoutInstance()
+	if _, ok := ut.sampledObjects[key]; ok {
+		return
+	}
+	ut.sampledObjects[key] = blobDigest
+	u := ut.getInstanceUsage(blobDigest.GetInstance())
+	u.sampledBytesStored += blobDigest.GetSizeBytes()
+	u.sampledObjectsStored++
+}
+
+func (ut *UsageTracker) recordUpload(blobDigest digest.Digest) {
+	ut.lock.Lock()
+	defer ut.lock.Unlock()
+	u := ut.getInstanceUsage(blobDigest.GetInstance())
+	u.bytesUploaded += blobDigest.GetSizeBytes()
+	u.objectsUploaded++
+	ut.addSampledObject(blobDigest)
+}
+
+func (ut *UsageTracker) recordDownload(blobDigest digest.Digest) {
+	ut.lock.Lock()
+	defer ut.lock.Unlock()
+	u := ut.getInstanceUsage(blobDigest.GetInstance())
+	u.bytesDownloaded += blobDigest.GetSizeBytes()
+	u.objectsDownloaded++
+}
+
+// recordPresent is called for objects that are reported as present by
+// FindMissing(). This permits tracking objects that were written
+// before usage tracking was enabled.
+func (ut *UsageTracker) recordPresent(digests digest.Set) {
+	ut.lock.Lock()
+	defer ut.lock.Unlock()
+	for _, blobDigest := range digests.Items() {
+		ut.addSampledObject(blobDigest)
+	}
+}
+
+// GetUsage returns the usage of a list of instance names, sorted by
+// instance name. If no instance names are provided, the usage of all
+// instance names is returned.
+func (ut *UsageTracker) GetUsage(instanceNames []string) []*accounting_pb.InstanceUsage {
+	ut.lock.Lock()
+	defer ut.lock.Unlock()
+
+	if len(instanceNames) == 0 {
+		for instanceName := range ut.instances {
+			instanceNames = append(instanceNames, instanceName)
+		}
+	}
+	sortedInstanceNames := append([]string(nil), instanceNames...)
+	sort.Strings(sortedInstanceNames)
+
+	usage := make([]*accounting_pb.InstanceUsage, 0, len(sortedInstanceNames))
+	for i, instanceName := range sortedInstanceNames {
+		if i > 0 && instanceName == sortedInstanceNames[i-1] {
+			continue
+		}
+		instance := &accounting_pb.InstanceUsage{InstanceName: instanceName}
+		if u, ok := ut.instances[instanceName]; ok {
+			instance.BytesUploaded = u.bytesUploaded
+			instance.ObjectsUploaded = u.objectsUploaded
+			instance.BytesDownloaded = u.bytesDownloaded
+			instance.ObjectsDownloaded = u.objectsDownloaded
+			instance.BytesStored = u.sampledBytesStored * int64(ut.samplingRate)
+			instance.ObjectsStored = u.sampledObjectsStored * int64(ut.samplingRate)
+		}
+		usage = append(usage, instance)
+	}
+	return usage
+}
+
+// Refresh checks whether the sampled objects are still present in the
+// storage backend. Objects that have been evicted are no longer
+// accounted for.
+func (ut *UsageTracker) Refresh(ctx context.Context) error {
+	// Checking for presence should not cause objects to be retained
+	// longer than they would be otherwise.
+	ctx = blobstore.NewContextWithoutRefreshing(ctx)
+
+	ut.lock.Lock()
+	sampledObjects := make([]digest.Digest, 0, len(ut.sampledObjects))
+	for _, blobDigest := range ut.sampledObjects {
+		sampledObjects = append(sampledObjects, blobDigest)
+	}
+	ut.lock.Unlock()
+
+	for len(sampledObjects) > 0 {
+		batchSize := len(sampledObjects)
+		if batchSize > refreshBatchSize {
+			batchSize = refreshBatchSize
+		}
+		digests := digest.NewSetBuilder()
+		for _, blobDigest := range sampledObjects[:batchSize] {
+			digests.Add(blobDigest)
+		}
+		sampledObjects = sampledObjects[batchSize:]
+
+		missing, err := ut.blobAccess.FindMissing(ctx, digests.Build())
+		if err != nil {
+			return util.StatusWrap(err, "Failed to determine presence of sampled objects")
+		}
+		ut.lock.Lock()
+		for _, blobDigest := range missing.Items() {
+			key := blobDigest.WithoutInstance()
+			if sampledDigest, ok := ut.sampledObjects[key]; ok && sampledDigest == blobDigest {
+				delete(ut.sampledObjects, key)
+				u := ut.getInstanceUsage(blobDigest.GetInstance())
+				u.sampledBytesStored -= blobDigest.GetSizeBytes()
+				u.sampledObjectsStored--
+			}
+		}
+		ut.lock.Unlock()
+	}
+	return nil
+}
+
+// Save the state of the UsageTracker to disk. The file is replaced
+// atomically, so that it is never left in a partially written state.
+func (ut *UsageTracker) Save() error {
+	if ut.path == "" {
+		return nil
+	}
+
+	ut.lock.Lock()
+	var state accounting_pb.UsageState
+	for instanceName, u := range ut.instances {
+		state.Instances = append(state.Instances, &accounting_pb.InstanceUsage{
+			InstanceName:      instanceName,
+			BytesUploaded:     u.bytesUploaded,
+			ObjectsUploaded:   u.objectsUploaded,
+			BytesDownloaded:   u.bytesDownloaded,
+			ObjectsDownloaded: u.objectsDownloaded,
+		})
+	}
+	for _, blobDigest := range ut.sampledObjects {
+		state.SampledObjects = append(state.SampledObjects, &accounting_pb.SampledObject{
+			InstanceName: blobDigest.GetInstance(),
+			Digest:       blobDigest.GetPartialDigest(),
+		})
+	}
+	ut.lock.Unlock()
+
+	data, err := proto.Marshal(&state)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal usage state")
+	}
+	temporaryPath := ut.path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, data, 0644); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write usage state file")
+	}
+	if err := os.Rename(temporaryPath, ut.path); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to replace usage state file")
+	}
+	return nil
+}
+
+// Run calls Refresh() and Save() at a fixed interval. This function
+// returns when the provided context is cancelled, after saving the
+// state one last time.
+func (ut *UsageTracker) Run(ctx context.Context, interval time.Duration) {
+	for {
+		timer, t := ut.clock.NewTimer(interval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			if err := ut.Save(); err != nil {
+				log.Print(err)
+			}
+			return
+		}
+		if err := ut.Refresh(ctx); err != nil {
+			log.Print(err)
+		}
+		if err := ut.Save(); err != nil {
+			log.Print(err)
+		}
+	}
+}
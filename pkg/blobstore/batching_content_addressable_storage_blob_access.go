@@ -0,0 +1,379 @@
+package blobstore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type batchingContentAddressableStorageBlobAccess struct {
+	BlobAccess
+	contentAddressableStorageClient remoteexecution.ContentAddressableStorageClient
+	maximumBlobSizeBytes            int64
+
+	readBatcher  blobRequestBatcher
+	writeBatcher blobRequestBatcher
+}
+
+// NewBatchingContentAddressableStorageBlobAccess creates a decorator
+// for a BlobAccess that is backed by a GRPC service implementing the
+// remoteexecution.ContentAddressableStorage service. Get() and Put()
+// calls for objects that don't exceed a given size are coalesced into
+// BatchReadBlobs() and BatchUpdateBlobs() calls, thereby eliminating
+// the overhead of creating a separate ByteStream stream per object.
+//
+// Requests are queued for up to a given amount of time, or until the
+// total size of the objects in the queue reaches a certain limit.
+// Requests for larger objects and calls to FindMissing() are forwarded
+// to the backend directly.
+//
+// Only requests that carry identical gRPC metadata are placed in the
+// same batch, so that batches carry the metadata (e.g., credentials) of
+// the callers. The list of headers that are forwarded from incoming
+// RPCs by the gRPC client should be provided, so that these headers are
+// considered as well. Batches are sent with the latest deadline of
+// their callers, and are cancelled once all callers have gone away.
+func NewBatchingContentAddressableStorageBlobAccess(base BlobAccess, client *grpc.ClientConn, clock clock.Clock, maximumBlobSizeBytes int64, maximumBatchSizeBytes int64, batchDelay time.Duration, forwardMetadata []string) BlobAccess {
+	ba := &batchingContentAddressableStorageBlobAccess{
+		BlobAccess:                      base,
+		contentAddressableStorageClient: remoteexecution.NewContentAddressableStorageClient(client),
+		maximumBlobSizeBytes:            maximumBlobSizeBytes,
+	}
+	ba.readBatcher = blobRequestBatcher{
+		clock:                 clock,
+		maximumBatchSizeBytes: maximumBatchSizeBytes,
+		batchDelay:            batchDelay,
+		forwardMetadata:       forwardMetadata,
+		flush:                 ba.flushReads,
+		queues:                map[string]*blobRequestQueue{},
+	}
+	ba.writeBatcher = blobRequestBatcher{
+		clock:                 clock,
+		maximumBatchSizeBytes: maximumBatchSizeBytes,
+		batchDelay:            batchDelay,
+		forwardMetadata:       forwardMetadata,
+		flush:                 ba.flushWrites,
+		queues:                map[string]*blobRequestQueue{},
+	}
+	return ba
+}
+
+func (ba *batchingContentAddressableStorageBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if blobDigest.GetSizeBytes() > ba.maximumBlobSizeBytes {
+		return ba.BlobAccess.Get(ctx, blobDigest)
+	}
+	r := newBlobRequest(blobDigest, nil)
+	if err := ba.readBatcher.enqueue(ctx, r); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewCASBufferFromByteSlice(blobDigest, r.data, buffer.Irreparable)
+}
+
+func (ba *batchingContentAddressableStorageBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if blobDigest.GetSizeBytes() > ba.maximumBlobSizeBytes {
+		return ba.BlobAccess.Put(ctx, blobDigest, b)
+	}
+	data, err := b.ToByteSlice(int(ba.maximumBlobSizeBytes))
+	if err != nil {
+		return err
+	}
+	return ba.writeBatcher.enqueue(ctx, newBlobRequest(blobDigest, data))
+}
+
+// flushReads sends a batch of queued Get() calls to the backend using
+// a single BatchReadBlobs() call.
+func (ba *batchingContentAddressableStorageBlobAccess) flushReads(ctx context.Context, instanceName string, requests []*blobRequest) {
+	pending := newPendingBlobRequests(requests)
+	request := remoteexecution.BatchReadBlobsRequest{
+		InstanceName: instanceName,
+		Digests:      pending.partialDigests,
+	}
+	response, err := ba.contentAddressableStorageClient.BatchReadBlobs(ctx, &request)
+	if err != nil {
+		pending.failRemaining(err)
+		return
+	}
+	for _, blobResponse := range response.Responses {
+		pending.complete(blobResponse.Digest, blobResponse.Data, status.ErrorProto(blobResponse.Status))
+	}
+	pending.failRemaining(status.Error(codes.Internal, "Server did not return a response for this object"))
+}
+
+// flushWrites sends a batch of queued Put() calls to the backend using
+// a single BatchUpdateBlobs() call.
+func (ba *batchingContentAddressableStorageBlobAccess) flushWrites(ctx context.Context, instanceName string, requests []*blobRequest) {
+	pending := newPendingBlobRequests(requests)
+	request := remoteexecution.BatchUpdateBlobsRequest{
+		InstanceName: instanceName,
+	}
+	for i, partialDigest := range pending.partialDigests {
+		request.Requests = append(request.Requests, &remoteexecution.BatchUpdateBlobsRequest_Request{
+			Digest: partialDigest,
+			Data:   pending.data[i],
+		})
+	}
+	response, err := ba.contentAddressableStorageClient.BatchUpdateBlobs(ctx, &request)
+	if err != nil {
+		pending.failRemaining(err)
+		return
+	}
+	for _, blobResponse := range response.Responses {
+		pending.complete(blobResponse.Digest, nil, status.ErrorProto(blobResponse.Status))
+	}
+	pending.failRemaining(status.Error(codes.Internal, "Server did not return a response for this object"))
+}
+
+// blobRequest is a single Get() or Put() call that is queued, waiting
+// to be sent to the backend as part of a batch.
+type blobRequest struct {
+	digest digest.Digest
+	data   []byte
+	err    error
+	done   chan struct{}
+
+	// The deadline of the caller, if any.
+	deadline    time.Time
+	hasDeadline bool
+}
+
+func newBlobRequest(blobDigest digest.Digest, data []byte) *blobRequest {
+	return &blobRequest{
+		digest: blobDigest,
+		data:   data,
+		done:   make(chan struct{}),
+	}
+}
+
+// pendingBlobRequests keeps track of which requests in a batch have
+// not been completed yet. Requests for identical objects are merged,
+// so that they are only sent to the backend once.
+type pendingBlobRequests struct {
+	partialDigests []*remoteexecution.Digest
+	data           [][]byte
+	requests       map[string][]*blobRequest
+}
+
+func newPendingBlobRequests(requests []*blobRequest) *pendingBlobRequests {
+	p := &pendingBlobRequests{
+		requests: map[string][]*blobRequest{},
+	}
+	for _, r := range requests {
+		key := r.digest.GetKey(digest.KeyWithoutInstance)
+		if _, ok := p.requests[key]; !ok {
+			p.partialDigests = append(p.partialDigests, r.digest.GetPartialDigest())
+			p.data = append(p.data, r.data)
+		}
+		p.requests[key] = append(p.requests[key], r)
+	}
+	return p
+}
+
+func (p *pendingBlobRequests) complete(partialDigest *remoteexecution.Digest, data []byte, err error) {
+	// The instance name is irrelevant, as it is not part of the
+	// key.
+	blobDigest, digestErr := digest.NewDigestFromPartialDigest("", partialDigest)
+	if digestErr != nil {
+		return
+	}
+	key := blobDigest.GetKey(digest.KeyWithoutInstance)
+	for _, r := range p.requests[key] {
+		r.data = data
+		r.err = err
+		close(r.done)
+	}
+	delete(p.requests, key)
+}
+
+func (p *pendingBlobRequests) failRemaining(err error) {
+	for key, requests := range p.requests {
+		for _, r := range requests {
+			r.err = err
+			close(r.done)
+		}
+		delete(p.requests, key)
+	}
+}
+
+// blobRequestQueue is a list of requests for a single instance name
+// and set of metadata that have not been sent to the backend yet.
+type blobRequestQueue struct {
+	requests  []*blobRequest
+	sizeBytes int64
+	timer     clock.Timer
+	flushed   chan struct{}
+
+	// Context that is used to send the batch. It carries the
+	// metadata shared by all requests in the batch, and is
+	// cancelled once all callers have gone away.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	abandoned int
+}
+
+// blobRequestBatcher groups requests by instance name, as
+// BatchReadBlobs() and BatchUpdateBlobs() can only process objects
+// belonging to a single instance. Requests are also grouped by
+// metadata, so that batches don't mix requests of different callers.
+// Batches are flushed once they are large enough, or when the batch
+// delay has passed.
+type blobRequestBatcher struct {
+	clock                 clock.Clock
+	maximumBatchSizeBytes int64
+	batchDelay            time.Duration
+	forwardMetadata       []string
+	flush                 func(ctx context.Context, instanceName string, requests []*blobRequest)
+
+	lock   sync.Mutex
+	queues map[string]*blobRequestQueue
+}
+
+// getMetadata extracts the metadata from a caller's context that is
+// sent to the backend: headers of the outgoing RPC, and headers of the
+// incoming RPC that are forwarded by the gRPC client.
+func (rb *blobRequestBatcher) getMetadata(ctx context.Context) (incoming metadata.MD, outgoing metadata.MD) {
+	incoming = metadata.MD{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, header := range rb.forwardMetadata {
+			if values := md.Get(header); len(values) > 0 {
+				incoming.Set(header, values...)
+			}
+		}
+	}
+	outgoing, _ = metadata.FromOutgoingContext(ctx)
+	return incoming, outgoing.Copy()
+}
+
+// getMetadataKey converts metadata to a string, so that it can be used
+// to group requests having identical metadata.
+func getMetadataKey(md metadata.MD) string {
+	headers := make([]string, 0, len(md))
+	for header := range md {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	var key strings.Builder
+	for _, header := range headers {
+		for _, value := range md[header] {
+			key.WriteString(header)
+			key.WriteByte(0)
+			key.WriteString(value)
+			key.WriteByte(0)
+		}
+	}
+	return key.String()
+}
+
+// flushQueue sends the requests in a queue to the backend. Requests
+// are sent with the latest deadline of all callers, if all of them
+// have a deadline.
+func (rb *blobRequestBatcher) flushQueue(instanceName string, q *blobRequestQueue) {
+	defer q.cancel()
+	ctx := q.ctx
+	var deadline time.Time
+	for _, r := range q.requests {
+		if !r.hasDeadline {
+			deadline = time.Time{}
+			break
+		}
+		if r.deadline.After(deadline) {
+			deadline = r.deadline
+		}
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	rb.flush(ctx, instanceName, q.requests)
+}
+
+func (rb *blobRequestBatcher) enqueue(ctx context.Context, r *blobRequest) error {
+	instanceName := r.digest.GetInstance()
+	r.deadline, r.hasDeadline = ctx.Deadline()
+	incoming, outgoing := rb.getMetadata(ctx)
+	queueKey := strings.Join([]string{instanceName, getMetadataKey(incoming), getMetadataKey(outgoing)}, "\x00")
+
+	rb.lock.Lock()
+	q, ok := rb.queues[queueKey]
+	if !ok {
+		// First request for this instance name and set of
+		// metadata. Schedule the batch to be flushed after the
+		// batch delay.
+		batchCtx := context.Background()
+		if len(incoming) > 0 {
+			batchCtx = metadata.NewIncomingContext(batchCtx, incoming)
+		}
+		if len(outgoing) > 0 {
+			batchCtx = metadata.NewOutgoingContext(batchCtx, outgoing)
+		}
+		batchCtx, cancel := context.WithCancel(batchCtx)
+		timer, t := rb.clock.NewTimer(rb.batchDelay)
+		q = &blobRequestQueue{
+			timer:   timer,
+			flushed: make(chan struct{}),
+			ctx:     batchCtx,
+			cancel:  cancel,
+		}
+		rb.queues[queueKey] = q
+		go func() {
+			select {
+			case <-t:
+				rb.lock.Lock()
+				if rb.queues[queueKey] == q {
+					delete(rb.queues, queueKey)
+					rb.lock.Unlock()
+					rb.flushQueue(instanceName, q)
+				} else {
+					rb.lock.Unlock()
+				}
+			case <-q.flushed:
+			}
+		}()
+	}
+	q.requests = append(q.requests, r)
+	q.sizeBytes += r.digest.GetSizeBytes()
+	if q.sizeBytes >= rb.maximumBatchSizeBytes {
+		// Batch is full. Flush it immediately.
+		delete(rb.queues, queueKey)
+		q.timer.Stop()
+		close(q.flushed)
+		rb.lock.Unlock()
+		go rb.flushQueue(instanceName, q)
+	} else {
+		rb.lock.Unlock()
+	}
+
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		// Cancel the batch if all callers have gone away. If
+		// the batch hasn't been flushed yet, remove it, so that
+		// callers arriving later don't join a cancelled batch.
+		rb.lock.Lock()
+		q.abandoned++
+		if q.abandoned == len(q.requests) {
+			if rb.queues[queueKey] == q {
+				delete(rb.queues, queueKey)
+				q.timer.Stop()
+				close(q.flushed)
+			}
+			q.cancel()
+		}
+		rb.lock.Unlock()
+		return util.StatusFromContext(ctx)
+	}
+}
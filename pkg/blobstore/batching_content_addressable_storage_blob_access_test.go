@@ -0,0 +1,244 @@
+package blobstore_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestBatchingContentAddressableStorageBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	// Create an RPC server/client pair.
+	l := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	contentAddressableStorageServer := mock.NewMockContentAddressableStorageServer(ctrl)
+	remoteexecution.RegisterContentAddressableStorageServer(server, contentAddressableStorageServer)
+	go func() {
+		require.NoError(t, server.Serve(l))
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+		return l.Dial()
+	}), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer server.Stop()
+	defer conn.Close()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewBatchingContentAddressableStorageBlobAccess(baseBlobAccess, conn, clock, 5, 10, time.Second, nil)
+
+	digest1 := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("instance", "6fc422233a40a75a1f028e11c3cd1140", 5)
+
+	t.Run("GetLarge", func(t *testing.T) {
+		// Objects exceeding the maximum blob size should be
+		// requested from the backend directly.
+		largeDigest := digest.MustNewDigest("instance", "ebbbb099e9d2f7892d97ab3640ae8283", 9)
+		baseBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello you")))
+
+		data, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello you"), data)
+	})
+
+	t.Run("GetBatchFull", func(t *testing.T) {
+		// Two objects of size 5 fill up a batch entirely. This
+		// should cause them to be requested using a single
+		// BatchReadBlobs() call without waiting for the timer.
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, nil)
+		timer.EXPECT().Stop().Return(true)
+		contentAddressableStorageServer.EXPECT().BatchReadBlobs(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, in *remoteexecution.BatchReadBlobsRequest) (*remoteexecution.BatchReadBlobsResponse, error) {
+				require.Equal(t, "instance", in.InstanceName)
+				require.ElementsMatch(t, []string{digest1.GetHashString(), digest2.GetHashString()}, []string{in.Digests[0].Hash, in.Digests[1].Hash})
+				return &remoteexecution.BatchReadBlobsResponse{
+					Responses: []*remoteexecution.BatchReadBlobsResponse_Response{
+						{
+							Digest: digest1.GetPartialDigest(),
+							Data:   []byte("Hello"),
+						},
+						{
+							Digest: digest2.GetPartialDigest(),
+							Status: status.New(codes.NotFound, "Blob not found").Proto(),
+						},
+					},
+				}, nil
+			})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			data, err := blobAccess.Get(ctx, digest1).ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := blobAccess.Get(ctx, digest2).ToByteSlice(100)
+			require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+		}()
+		wg.Wait()
+	})
+
+	t.Run("PutTimerExpired", func(t *testing.T) {
+		// A single object doesn't fill up a batch. It should be
+		// written once the batch delay has passed.
+		timer := mock.NewMockTimer(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(time.Second).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+			timerChannel <- time.Unix(1000, 0)
+			return timer, timerChannel
+		})
+		contentAddressableStorageServer.EXPECT().BatchUpdateBlobs(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, in *remoteexecution.BatchUpdateBlobsRequest) (*remoteexecution.BatchUpdateBlobsResponse, error) {
+				require.Equal(t, &remoteexecution.BatchUpdateBlobsRequest{
+					InstanceName: "instance",
+					Requests: []*remoteexecution.BatchUpdateBlobsRequest_Request{
+						{
+							Digest: digest1.GetPartialDigest(),
+							Data:   []byte("Hello"),
+						},
+					},
+				}, in)
+				return &remoteexecution.BatchUpdateBlobsResponse{
+					Responses: []*remoteexecution.BatchUpdateBlobsResponse_Response{
+						{
+							Digest: digest1.GetPartialDigest(),
+							Status: status.New(codes.OK, "").Proto(),
+						},
+					},
+				}, nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, digest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PutRPCFailure", func(t *testing.T) {
+		// Errors returned by BatchUpdateBlobs() should be
+		// propagated to all callers.
+		timer := mock.NewMockTimer(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(time.Second).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+			timerChannel <- time.Unix(1000, 0)
+			return timer, timerChannel
+		})
+		contentAddressableStorageServer.EXPECT().BatchUpdateBlobs(gomock.Any(), gomock.Any()).
+			Return(nil, status.Error(codes.Unavailable, "Server offline"))
+
+		err := blobAccess.Put(ctx, digest2, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("GetAbandonedThenNewCaller", func(t *testing.T) {
+		// The only caller of a batch goes away before the batch
+		// is flushed. This should cause the batch to be
+		// discarded, so that a caller arriving afterwards ends
+		// up in a new batch, as opposed to joining the batch
+		// that has already been cancelled.
+		timer1 := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer1, nil)
+		timer1.EXPECT().Stop().Return(true)
+
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := blobAccess.Get(canceledCtx, digest1).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+
+		timer2 := mock.NewMockTimer(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(time.Second).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+			timerChannel <- time.Unix(1000, 0)
+			return timer2, timerChannel
+		})
+		contentAddressableStorageServer.EXPECT().BatchReadBlobs(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, in *remoteexecution.BatchReadBlobsRequest) (*remoteexecution.BatchReadBlobsResponse, error) {
+				require.Equal(t, []*remoteexecution.Digest{digest1.GetPartialDigest()}, in.Digests)
+				return &remoteexecution.BatchReadBlobsResponse{
+					Responses: []*remoteexecution.BatchReadBlobsResponse_Response{
+						{
+							Digest: digest1.GetPartialDigest(),
+							Data:   []byte("Hello"),
+						},
+					},
+				}, nil
+			})
+
+		data, err := blobAccess.Get(ctx, digest1).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetMetadataGrouping", func(t *testing.T) {
+		// Requests carrying different metadata should not be
+		// placed in the same batch, even though they would fill
+		// it up entirely. Batches should carry the metadata and
+		// deadline of their callers.
+		for i := 0; i < 2; i++ {
+			timer := mock.NewMockTimer(ctrl)
+			timerChannel := make(chan time.Time, 1)
+			clock.EXPECT().NewTimer(time.Second).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+				timerChannel <- time.Unix(1000, 0)
+				return timer, timerChannel
+			})
+		}
+		var lock sync.Mutex
+		remainingTenants := map[string]bool{"a": true, "b": true}
+		contentAddressableStorageServer.EXPECT().BatchReadBlobs(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, in *remoteexecution.BatchReadBlobsRequest) (*remoteexecution.BatchReadBlobsResponse, error) {
+				_, ok := ctx.Deadline()
+				require.True(t, ok)
+				md, ok := metadata.FromIncomingContext(ctx)
+				require.True(t, ok)
+				tenant := md.Get("tenant")
+				require.Len(t, tenant, 1)
+
+				lock.Lock()
+				require.True(t, remainingTenants[tenant[0]])
+				delete(remainingTenants, tenant[0])
+				lock.Unlock()
+				require.Equal(t, []*remoteexecution.Digest{digest1.GetPartialDigest()}, in.Digests)
+				return &remoteexecution.BatchReadBlobsResponse{
+					Responses: []*remoteexecution.BatchReadBlobsResponse_Response{
+						{
+							Digest: digest1.GetPartialDigest(),
+							Data:   []byte("Hello"),
+						},
+					},
+				}, nil
+			}).Times(2)
+
+		ctxWithDeadline, cancel := context.WithTimeout(ctx, time.Hour)
+		defer cancel()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		for _, tenant := range []string{"a", "b"} {
+			go func(tenant string) {
+				defer wg.Done()
+				data, err := blobAccess.Get(metadata.AppendToOutgoingContext(ctxWithDeadline, "tenant", tenant), digest1).ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+			}(tenant)
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,347 @@
+package benchmarking
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximumTrackedObjects is the maximum number of written objects that
+// a Runner remembers for reading them back. Once reached, randomly
+// chosen objects are forgotten to make space for new ones.
+const maximumTrackedObjects = 1 << 16
+
+// latencyBuckets are the upper bounds in seconds of the histogram
+// buckets in which latencies of operations are counted. They range from
+// 1 microsecond to 1000 seconds, with ten buckets per power of ten.
+// Tracking latencies in a histogram ensures that memory usage remains
+// constant, regardless of how long the workload runs.
+var latencyBuckets = util.DecimalExponentialBuckets(-6, 9, 9)
+
+type operationStatistics struct {
+	// Number of operations per bucket in latencyBuckets. The last
+	// element counts operations exceeding the largest bucket.
+	latencyCounts  []int
+	count          int
+	maximumLatency time.Duration
+	errors         int
+	sizeBytes      int64
+}
+
+// Runner of a synthetic workload against a BlobAccess. It can be used
+// to compare the latency and throughput of different storage
+// configurations, such as chains of decorators or remote servers.
+//
+// Objects are generated randomly and identified by their SHA-256 hash.
+// Reads are only performed against objects previously written by the
+// Runner, while FindMissing() is called with a mixture of existent and
+// non-existent objects.
+type Runner struct {
+	blobAccess     blobstore.BlobAccess
+	clock          clock.Clock
+	workload       Workload
+	digestTemplate digest.Digest
+	operations     weightedChoice
+	blobSizes      weightedChoice
+	nextSeed       int64
+
+	lock       sync.Mutex
+	objects    []digest.Digest
+	startTime  time.Time
+	statistics [operationCount]operationStatistics
+}
+
+// NewRunner creates a Runner of a workload against a BlobAccess. The
+// seed is used to initialize the random number generators that
+// determine the sequence of operations and the contents of objects.
+func NewRunner(blobAccess blobstore.BlobAccess, clock clock.Clock, workload Workload, seed int64) (*Runner, error) {
+	if err := workload.validate(); err != nil {
+		return nil, err
+	}
+	blobSizeWeights := make([]uint32, 0, len(workload.BlobSizes))
+	for _, blobSize := range workload.BlobSizes {
+		blobSizeWeights = append(blobSizeWeights, blobSize.Weight)
+	}
+	return &Runner{
+		blobAccess:     blobAccess,
+		clock:          clock,
+		workload:       workload,
+		digestTemplate: digest.MustNewDigest(workload.InstanceName, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0),
+		operations: newWeightedChoice([]uint32{
+			OperationRead:        workload.ReadWeight,
+			OperationWrite:       workload.WriteWeight,
+			OperationFindMissing: workload.FindMissingWeight,
+		}),
+		blobSizes: newWeightedChoice(blobSizeWeights),
+		nextSeed:  seed,
+	}, nil
+}
+
+func (r *Runner) newRandom() *rand.Rand {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	seed := r.nextSeed
+	r.nextSeed++
+	return rand.New(rand.NewSource(seed))
+}
+
+// Populate writes a number of objects, so that reads performed by
+// subsequent calls to Run() have objects to operate on. Writes
+// performed by Populate() are not included in the report.
+func (r *Runner) Populate(ctx context.Context, count int) error {
+	if count > 0 && r.blobSizes.totalWeight() == 0 {
+		return status.Error(codes.InvalidArgument, "Cannot populate without any blob size ranges")
+	}
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
+	remaining := int64(count)
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	for i := 0; i < r.workload.Concurrency; i++ {
+		wg.Add(1)
+		go func(random *rand.Rand) {
+			defer wg.Done()
+			for ctxWithCancel.Err() == nil && atomic.AddInt64(&remaining, -1) >= 0 {
+				blobDigest, data := r.generateObject(random)
+				if err := r.blobAccess.Put(ctxWithCancel, blobDigest, buffer.NewValidatedBufferFromByteSlice(data)); err != nil {
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = util.StatusWrapf(err, "Failed to write object %s", blobDigest)
+						cancel()
+					}
+					errLock.Unlock()
+					return
+				}
+				r.addObject(blobDigest, random)
+			}
+		}(r.newRandom())
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if ctx.Err() != nil {
+		return util.StatusFromContext(ctx)
+	}
+	return nil
+}
+
+// Run the workload until the context is cancelled or the maximum
+// number of operations is reached. A maximum of zero indicates that
+// there is no limit. Failing operations are counted in the report, as
+// opposed to terminating the workload.
+func (r *Runner) Run(ctx context.Context, maximumOperations int64) {
+	r.lock.Lock()
+	if r.startTime.IsZero() {
+		r.startTime = r.clock.Now()
+	}
+	r.lock.Unlock()
+
+	remaining := maximumOperations
+	var wg sync.WaitGroup
+	for i := 0; i < r.workload.Concurrency; i++ {
+		wg.Add(1)
+		go func(random *rand.Rand) {
+			defer wg.Done()
+			for ctx.Err() == nil && (maximumOperations == 0 || atomic.AddInt64(&remaining, -1) >= 0) {
+				r.performOperation(ctx, random)
+			}
+		}(r.newRandom())
+	}
+	wg.Wait()
+}
+
+func (r *Runner) performOperation(ctx context.Context, random *rand.Rand) {
+	operation := Operation(r.operations.pick(random))
+	switch operation {
+	case OperationRead:
+		if blobDigest, ok := r.getRandomObject(random); ok {
+			startTime := r.clock.Now()
+			err := r.blobAccess.Get(ctx, blobDigest).IntoWriter(ioutil.Discard)
+			r.record(ctx, OperationRead, startTime, blobDigest.GetSizeBytes(), err)
+			return
+		}
+		// No objects have been written yet. Write one, so that
+		// it can be read back later on.
+		fallthrough
+	case OperationWrite:
+		blobDigest, data := r.generateObject(random)
+		startTime := r.clock.Now()
+		err := r.blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(data))
+		r.record(ctx, OperationWrite, startTime, blobDigest.GetSizeBytes(), err)
+		if err == nil {
+			r.addObject(blobDigest, random)
+		}
+	case OperationFindMissing:
+		digests := digest.NewSetBuilder()
+		for i := 0; i < r.workload.FindMissingBatchSize; i++ {
+			if blobDigest, ok := r.getRandomObject(random); ok && random.Intn(2) == 0 {
+				digests.Add(blobDigest)
+			} else {
+				digests.Add(r.generateNonExistentDigest(random))
+			}
+		}
+		startTime := r.clock.Now()
+		_, err := r.blobAccess.FindMissing(ctx, digests.Build())
+		r.record(ctx, OperationFindMissing, startTime, 0, err)
+	}
+}
+
+// generateObject creates an object with random contents, with a size
+// drawn from the configured distribution.
+func (r *Runner) generateObject(random *rand.Rand) (digest.Digest, []byte) {
+	blobSize := r.workload.BlobSizes[r.blobSizes.pick(random)]
+	data := make([]byte, blobSize.MinimumSizeBytes+random.Int63n(blobSize.MaximumSizeBytes-blobSize.MinimumSizeBytes+1))
+	random.Read(data)
+	generator := r.digestTemplate.NewGenerator()
+	generator.Write(data)
+	return generator.Sum(), data
+}
+
+// generateNonExistentDigest creates a digest having a random hash.
+// Such a digest is extremely unlikely to correspond to an object
+// stored in the backend.
+func (r *Runner) generateNonExistentDigest(random *rand.Rand) digest.Digest {
+	var hash [32]byte
+	random.Read(hash[:])
+	return digest.MustNewDigest(r.workload.InstanceName, hex.EncodeToString(hash[:]), random.Int63n(1<<20))
+}
+
+func (r *Runner) getRandomObject(random *rand.Rand) (digest.Digest, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.objects) == 0 {
+		return digest.BadDigest, false
+	}
+	return r.objects[random.Intn(len(r.objects))], true
+}
+
+func (r *Runner) addObject(blobDigest digest.Digest, random *rand.Rand) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.objects) < maximumTrackedObjects {
+		r.objects = append(r.objects, blobDigest)
+	} else {
+		r.objects[random.Intn(len(r.objects))] = blobDigest
+	}
+}
+
+func (r *Runner) record(ctx context.Context, operation Operation, startTime time.Time, sizeBytes int64, err error) {
+	latency := r.clock.Now().Sub(startTime)
+	if err != nil && ctx.Err() != nil {
+		// Operation got interrupted by the workload being
+		// stopped. Don't count it as a failure.
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := &r.statistics[operation]
+	if s.latencyCounts == nil {
+		s.latencyCounts = make([]int, len(latencyBuckets)+1)
+	}
+	s.latencyCounts[sort.SearchFloat64s(latencyBuckets, latency.Seconds())]++
+	s.count++
+	if s.maximumLatency < latency {
+		s.maximumLatency = latency
+	}
+	if err == nil {
+		s.sizeBytes += sizeBytes
+	} else {
+		s.errors++
+	}
+}
+
+// OperationReport contains statistics on all calls of a single type of
+// operation performed by a Runner.
+type OperationReport struct {
+	Operation Operation
+	// The number of operations performed, including failures.
+	Count int
+	// The number of operations that failed.
+	Errors int
+	// The total size of objects transferred by operations that
+	// succeeded.
+	SizeBytes int64
+	// Latency percentiles of all operations performed. Percentiles
+	// are approximated by the upper bound of the histogram bucket
+	// in which they fall, meaning they may overestimate the actual
+	// latency by up to 26%. The maximum latency is exact.
+	Latency50  time.Duration
+	Latency90  time.Duration
+	Latency99  time.Duration
+	LatencyMax time.Duration
+}
+
+// Report contains statistics on all operations performed by a Runner.
+type Report struct {
+	// The amount of time since the Runner was first started.
+	Elapsed time.Duration
+	// Statistics of each type of operation that was performed at
+	// least once.
+	Operations []OperationReport
+}
+
+// GetReport returns statistics on all operations performed by calls to
+// Run() up to this point in time.
+func (r *Runner) GetReport() Report {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var report Report
+	if !r.startTime.IsZero() {
+		report.Elapsed = r.clock.Now().Sub(r.startTime)
+	}
+	for operation, s := range r.statistics {
+		if s.count == 0 {
+			continue
+		}
+		report.Operations = append(report.Operations, OperationReport{
+			Operation:  Operation(operation),
+			Count:      s.count,
+			Errors:     s.errors,
+			SizeBytes:  s.sizeBytes,
+			Latency50:  s.getPercentile(0.5),
+			Latency90:  s.getPercentile(0.9),
+			Latency99:  s.getPercentile(0.99),
+			LatencyMax: s.maximumLatency,
+		})
+	}
+	return report
+}
+
+// getPercentile returns an approximation of a percentile of the
+// latencies of all operations, using the nearest-rank method.
+func (s *operationStatistics) getPercentile(p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(s.count)))
+	seen := 0
+	for i, count := range s.latencyCounts[:len(latencyBuckets)] {
+		seen += count
+		if seen >= rank {
+			// Never report a latency that exceeds the
+			// maximum observed.
+			if latency := time.Duration(latencyBuckets[i] * float64(time.Second)); latency < s.maximumLatency {
+				return latency
+			}
+			break
+		}
+	}
+	return s.maximumLatency
+}
@@ -0,0 +1,121 @@
+package benchmarking_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/benchmarking"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRunnerInvalidWorkload(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+
+	_, err := benchmarking.NewRunner(blobAccess, clock, benchmarking.Workload{
+		ReadWeight:  1,
+		Concurrency: 1,
+	}, 0)
+	require.Equal(t, status.Error(codes.InvalidArgument, "At least one blob size range must have a non-zero weight"), err)
+
+	_, err = benchmarking.NewRunner(blobAccess, clock, benchmarking.Workload{
+		FindMissingWeight:    1,
+		FindMissingBatchSize: 0,
+		Concurrency:          1,
+	}, 0)
+	require.Equal(t, status.Error(codes.InvalidArgument, "FindMissing() batch size must be positive"), err)
+
+	_, err = benchmarking.NewRunner(blobAccess, clock, benchmarking.Workload{
+		BlobSizes:   []benchmarking.BlobSizeRange{{MinimumSizeBytes: 10, MaximumSizeBytes: 5, Weight: 1}},
+		WriteWeight: 1,
+		Concurrency: 1,
+	}, 0)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Blob size range at index 0 is invalid"), err)
+}
+
+func TestRunner(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	// Let every call to Now() advance the clock by one second, so
+	// that every operation takes exactly one second.
+	clock := mock.NewMockClock(ctrl)
+	now := time.Unix(1000, 0)
+	clock.EXPECT().Now().DoAndReturn(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}).AnyTimes()
+
+	// Back the benchmark by a simple in-memory store.
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	objects := map[digest.Digest][]byte{}
+	blobAccess.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, "instance", blobDigest.GetInstance())
+			require.Equal(t, int64(len(data)), blobDigest.GetSizeBytes())
+			require.True(t, len(data) >= 10 && len(data) <= 20)
+			objects[blobDigest] = data
+			return nil
+		}).AnyTimes()
+	blobAccess.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+			data, ok := objects[blobDigest]
+			require.True(t, ok, "Runner attempted to read an object it did not write")
+			return buffer.NewValidatedBufferFromByteSlice(data)
+		}).AnyTimes()
+
+	runner, err := benchmarking.NewRunner(blobAccess, clock, benchmarking.Workload{
+		InstanceName:         "instance",
+		BlobSizes:            []benchmarking.BlobSizeRange{{MinimumSizeBytes: 10, MaximumSizeBytes: 20, Weight: 1}},
+		ReadWeight:           1,
+		FindMissingWeight:    1,
+		FindMissingBatchSize: 4,
+		Concurrency:          1,
+	}, 123)
+	require.NoError(t, err)
+
+	// Writes performed while populating should not be part of the
+	// report.
+	require.NoError(t, runner.Populate(ctx, 3))
+	require.Len(t, objects, 3)
+	require.Equal(t, benchmarking.Report{}, runner.GetReport())
+
+	// Let FindMissing() fail, so that errors are reported.
+	blobAccess.EXPECT().FindMissing(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+			require.True(t, digests.Length() >= 1 && digests.Length() <= 4)
+			return digest.EmptySet, status.Error(codes.Unavailable, "Server offline")
+		}).AnyTimes()
+
+	runner.Run(ctx, 100)
+	report := runner.GetReport()
+	require.Len(t, report.Operations, 2)
+
+	read := report.Operations[0]
+	require.Equal(t, benchmarking.OperationRead, read.Operation)
+	require.Equal(t, 0, read.Errors)
+	require.True(t, read.SizeBytes >= int64(10*read.Count) && read.SizeBytes <= int64(20*read.Count))
+	require.Equal(t, time.Second, read.Latency50)
+	require.Equal(t, time.Second, read.LatencyMax)
+
+	findMissing := report.Operations[1]
+	require.Equal(t, benchmarking.OperationFindMissing, findMissing.Operation)
+	require.Equal(t, findMissing.Count, findMissing.Errors)
+	require.Equal(t, int64(0), findMissing.SizeBytes)
+	require.Equal(t, time.Second, findMissing.Latency99)
+
+	require.Equal(t, 100, read.Count+findMissing.Count)
+}
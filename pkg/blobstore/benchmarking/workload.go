@@ -0,0 +1,122 @@
+package benchmarking
+
+import (
+	"math/rand"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Operation is a type of call that a Runner performs against a
+// BlobAccess.
+type Operation int
+
+const (
+	// OperationRead downloads a previously written object through
+	// BlobAccess.Get().
+	OperationRead Operation = iota
+	// OperationWrite uploads a newly generated object through
+	// BlobAccess.Put().
+	OperationWrite
+	// OperationFindMissing checks for the existence of a batch of
+	// objects through BlobAccess.FindMissing().
+	OperationFindMissing
+
+	operationCount
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationRead:
+		return "Read"
+	case OperationWrite:
+		return "Write"
+	case OperationFindMissing:
+		return "FindMissing"
+	default:
+		panic("Unknown operation")
+	}
+}
+
+// BlobSizeRange is a range of object sizes from which the sizes of
+// generated objects are drawn uniformly. The weight of a range relative
+// to that of other ranges determines how often it is picked.
+type BlobSizeRange struct {
+	MinimumSizeBytes int64
+	MaximumSizeBytes int64
+	Weight           uint32
+}
+
+// Workload describes the mix of operations that a Runner performs.
+type Workload struct {
+	// The instance name of the objects that are read and written.
+	InstanceName string
+	// The distribution of the sizes of objects that are written.
+	BlobSizes []BlobSizeRange
+	// Relative frequencies of each of the operations.
+	ReadWeight        uint32
+	WriteWeight       uint32
+	FindMissingWeight uint32
+	// The number of objects whose existence is checked through a
+	// single call to FindMissing().
+	FindMissingBatchSize int
+	// The number of operations that are performed concurrently.
+	Concurrency int
+}
+
+// weightedChoice picks one of a number of alternatives at random,
+// where the probability of each of the alternatives being picked is
+// proportional to its weight.
+type weightedChoice struct {
+	cumulativeWeights []uint64
+}
+
+func newWeightedChoice(weights []uint32) weightedChoice {
+	var total uint64
+	cumulativeWeights := make([]uint64, 0, len(weights))
+	for _, weight := range weights {
+		total += uint64(weight)
+		cumulativeWeights = append(cumulativeWeights, total)
+	}
+	return weightedChoice{cumulativeWeights: cumulativeWeights}
+}
+
+func (wc weightedChoice) totalWeight() uint64 {
+	if len(wc.cumulativeWeights) == 0 {
+		return 0
+	}
+	return wc.cumulativeWeights[len(wc.cumulativeWeights)-1]
+}
+
+func (wc weightedChoice) pick(r *rand.Rand) int {
+	n := uint64(r.Int63n(int64(wc.totalWeight())))
+	for i, cumulativeWeight := range wc.cumulativeWeights {
+		if n < cumulativeWeight {
+			return i
+		}
+	}
+	panic("Random number exceeds total weight")
+}
+
+func (w *Workload) validate() error {
+	if w.Concurrency <= 0 {
+		return status.Error(codes.InvalidArgument, "Concurrency must be positive")
+	}
+	if w.ReadWeight == 0 && w.WriteWeight == 0 && w.FindMissingWeight == 0 {
+		return status.Error(codes.InvalidArgument, "At least one operation must have a non-zero weight")
+	}
+	if w.FindMissingWeight > 0 && w.FindMissingBatchSize <= 0 {
+		return status.Error(codes.InvalidArgument, "FindMissing() batch size must be positive")
+	}
+	var totalWeight uint64
+	for i, blobSize := range w.BlobSizes {
+		if blobSize.MinimumSizeBytes < 0 || blobSize.MaximumSizeBytes < blobSize.MinimumSizeBytes {
+			return status.Errorf(codes.InvalidArgument, "Blob size range at index %d is invalid", i)
+		}
+		totalWeight += uint64(blobSize.Weight)
+	}
+	if totalWeight == 0 && (w.ReadWeight > 0 || w.WriteWeight > 0) {
+		return status.Error(codes.InvalidArgument, "At least one blob size range must have a non-zero weight")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package blobstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -15,3 +16,56 @@ type BlobAccess interface {
 	Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error
 	FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error)
 }
+
+// BlobDeleter is an optional interface that may be implemented by
+// BlobAccess backends that are capable of removing individual objects.
+// It is used by administrative tooling to purge objects manually (e.g.,
+// ActionResults of actions that turned out to be non-deterministic).
+type BlobDeleter interface {
+	Delete(ctx context.Context, digest digest.Digest) error
+}
+
+// UtilizationReporter is an optional interface that may be implemented
+// by BlobAccess backends that have a fixed capacity, so that their
+// utilization can be inspected by administrative tooling.
+type UtilizationReporter interface {
+	GetUtilization() (usedBytes int64, capacityBytes int64)
+}
+
+// SignedURLGenerator is an optional interface that may be implemented
+// by BlobAccess backends that store objects at a cloud storage
+// provider. It can be used to generate time-limited URLs through
+// which clients may download objects directly, instead of streaming
+// them through Buildbarn.
+type SignedURLGenerator interface {
+	GetSignedURL(ctx context.Context, digest digest.Digest, expiry time.Duration) (string, error)
+}
+
+// BlobInfo contains details on how an object is stored by a storage
+// backend, as returned by BlobStatter.
+type BlobInfo struct {
+	// The amount of space the object occupies in storage.
+	SizeBytes int64
+	// The time at or after which the object was last written or
+	// refreshed. The zero value indicates that it is not known.
+	// Reads that don't cause the object to be refreshed are not
+	// taken into account.
+	LastAccessTime time.Time
+}
+
+// BlobStatter is an optional interface that may be implemented by
+// BlobAccess backends that can report how individual objects are
+// stored. It is used by administrative tooling. Implementations should
+// not refresh objects, so that inspecting them does not prevent them
+// from being evicted.
+type BlobStatter interface {
+	StatBlob(ctx context.Context, digest digest.Digest) (BlobInfo, error)
+}
+
+// DigestLister is an optional interface that may be implemented by
+// BlobAccess backends that are capable of enumerating the objects they
+// contain. It can be used to populate data structures that summarize
+// the contents of a backend, such as Bloom filters.
+type DigestLister interface {
+	ListDigests(ctx context.Context, callback func(digest digest.Digest) error) error
+}
@@ -0,0 +1,140 @@
+package bloomfiltering
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bloomFilterMagic is written at the start of serialized Bloom
+// filters, so that files of a different format are rejected.
+var bloomFilterMagic = [8]byte{'B', 'B', 'B', 'L', 'O', 'O', 'M', '1'}
+
+// BloomFilter is a probabilistic set of strings. Testing for membership
+// may yield false positives, but never false negatives. This makes it
+// suitable for determining that objects are definitely absent.
+//
+// BloomFilter is not safe for concurrent use.
+type BloomFilter struct {
+	hashFunctions uint32
+	words         []uint64
+}
+
+// NewBloomFilter creates a BloomFilter that is empty, having a given
+// size in bits and number of hash functions. The size is rounded up to
+// a multiple of 64 bits.
+func NewBloomFilter(sizeBits uint64, hashFunctions uint32) *BloomFilter {
+	return &BloomFilter{
+		hashFunctions: hashFunctions,
+		words:         make([]uint64, (sizeBits+63)/64),
+	}
+}
+
+// NewBloomFilterFromFalsePositiveRate creates a BloomFilter that is
+// empty, having a size and number of hash functions that are optimal
+// for storing a given number of elements while yielding false
+// positives at a given rate.
+func NewBloomFilterFromFalsePositiveRate(expectedElements uint64, falsePositiveRate float64) (*BloomFilter, error) {
+	if expectedElements == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Expected number of elements must be positive")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, status.Error(codes.InvalidArgument, "False positive rate must lie in (0.0, 1.0)")
+	}
+	n := float64(expectedElements)
+	sizeBits := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashFunctions := math.Max(1, math.Round(sizeBits/n*math.Ln2))
+	return NewBloomFilter(uint64(sizeBits), uint32(hashFunctions)), nil
+}
+
+// forEachBit calls a function for every bit that corresponds to a
+// key. Bit positions are computed using double hashing. Iteration
+// stops as soon as the function returns false.
+func (bf *BloomFilter) forEachBit(key string, f func(word *uint64, mask uint64) bool) bool {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	var sum [16]byte
+	h.Sum(sum[:0])
+	h1 := binary.LittleEndian.Uint64(sum[:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:]) | 1
+
+	sizeBits := uint64(len(bf.words)) * 64
+	for i := uint32(0); i < bf.hashFunctions; i++ {
+		bit := (h1 + uint64(i)*h2) % sizeBits
+		if !f(&bf.words[bit/64], 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Add a key to the Bloom filter.
+func (bf *BloomFilter) Add(key string) {
+	bf.forEachBit(key, func(word *uint64, mask uint64) bool {
+		*word |= mask
+		return true
+	})
+}
+
+// MayContain returns false if a key is definitely not part of the
+// Bloom filter. It returns true if the key may have been added.
+func (bf *BloomFilter) MayContain(key string) bool {
+	return bf.forEachBit(key, func(word *uint64, mask uint64) bool {
+		return *word&mask != 0
+	})
+}
+
+// WriteTo writes the contents of the Bloom filter to a stream, so that
+// it may be reloaded using ReadFrom() at a later point in time.
+func (bf *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	var header bytes.Buffer
+	header.Write(bloomFilterMagic[:])
+	binary.Write(&header, binary.LittleEndian, bf.hashFunctions)
+	binary.Write(&header, binary.LittleEndian, uint64(len(bf.words)))
+	n, err := w.Write(header.Bytes())
+	if err != nil {
+		return int64(n), err
+	}
+	if err := binary.Write(w, binary.LittleEndian, bf.words); err != nil {
+		return int64(n), err
+	}
+	return int64(n + 8*len(bf.words)), nil
+}
+
+// ReadFrom replaces the contents of the Bloom filter with data written
+// by WriteTo(). The data must correspond to a Bloom filter having the
+// same size and number of hash functions.
+func (bf *BloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var header struct {
+		Magic         [8]byte
+		HashFunctions uint32
+		Words         uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return 0, err
+	}
+	n := int64(binary.Size(header))
+	if header.Magic != bloomFilterMagic {
+		return n, status.Error(codes.InvalidArgument, "Data does not contain a Bloom filter")
+	}
+	if header.HashFunctions != bf.hashFunctions || header.Words != uint64(len(bf.words)) {
+		return n, status.Errorf(
+			codes.InvalidArgument,
+			"Bloom filter has %d bits and %d hash functions, while %d bits and %d hash functions were expected",
+			header.Words*64,
+			header.HashFunctions,
+			len(bf.words)*64,
+			bf.hashFunctions)
+	}
+	words := make([]uint64, len(bf.words))
+	if err := binary.Read(r, binary.LittleEndian, words); err != nil {
+		return n, err
+	}
+	bf.words = words
+	return n + int64(8*len(words)), nil
+}
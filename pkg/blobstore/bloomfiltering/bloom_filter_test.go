@@ -0,0 +1,65 @@
+package bloomfiltering_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/bloomfiltering"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBloomFilter(t *testing.T) {
+	bloomFilter, err := bloomfiltering.NewBloomFilterFromFalsePositiveRate(1000, 0.01)
+	require.NoError(t, err)
+
+	t.Run("NoFalseNegatives", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			bloomFilter.Add(fmt.Sprintf("present-%d", i))
+		}
+		for i := 0; i < 1000; i++ {
+			require.True(t, bloomFilter.MayContain(fmt.Sprintf("present-%d", i)))
+		}
+	})
+
+	t.Run("FalsePositiveRate", func(t *testing.T) {
+		// The false positive rate should be close to the one
+		// requested.
+		falsePositives := 0
+		for i := 0; i < 10000; i++ {
+			if bloomFilter.MayContain(fmt.Sprintf("absent-%d", i)) {
+				falsePositives++
+			}
+		}
+		require.Less(t, falsePositives, 200)
+	})
+
+	t.Run("WriteAndReadFrom", func(t *testing.T) {
+		var b bytes.Buffer
+		_, err := bloomFilter.WriteTo(&b)
+		require.NoError(t, err)
+		data := b.Bytes()
+
+		reloaded, err := bloomfiltering.NewBloomFilterFromFalsePositiveRate(1000, 0.01)
+		require.NoError(t, err)
+		_, err = reloaded.ReadFrom(bytes.NewReader(data))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.True(t, reloaded.MayContain(fmt.Sprintf("present-%d", i)))
+		}
+
+		// Bloom filters of a different size cannot be loaded.
+		_, err = bloomfiltering.NewBloomFilter(128, 3).ReadFrom(bytes.NewReader(data))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Bloom filter has 9600 bits and 7 hash functions, while 128 bits and 3 hash functions were expected"), err)
+	})
+
+	t.Run("InvalidParameters", func(t *testing.T) {
+		_, err := bloomfiltering.NewBloomFilterFromFalsePositiveRate(0, 0.01)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Expected number of elements must be positive"), err)
+		_, err = bloomfiltering.NewBloomFilterFromFalsePositiveRate(1000, 1)
+		require.Equal(t, status.Error(codes.InvalidArgument, "False positive rate must lie in (0.0, 1.0)"), err)
+	})
+}
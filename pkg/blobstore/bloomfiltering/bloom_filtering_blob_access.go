@@ -0,0 +1,61 @@
+package bloomfiltering
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type bloomFilteringBlobAccess struct {
+	blobstore.BlobAccess
+	filter *ExistenceFilter
+}
+
+// NewBloomFilteringBlobAccess creates a decorator for BlobAccess that
+// uses an ExistenceFilter to determine which objects are definitely
+// absent. Get() and FindMissing() calls for such objects are answered
+// without contacting the backend. This is useful for backends that
+// have a high per-request latency (e.g., S3).
+//
+// Objects written through this decorator are added to the filter. As
+// objects written into the backend through other means are not
+// reflected by the filter, this decorator should only be used if all
+// writes are performed through it.
+func NewBloomFilteringBlobAccess(base blobstore.BlobAccess, filter *ExistenceFilter) blobstore.BlobAccess {
+	return &bloomFilteringBlobAccess{
+		BlobAccess: base,
+		filter:     filter,
+	}
+}
+
+func (ba *bloomFilteringBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if ba.filter.IsDefinitelyMissing(blobDigest) {
+		return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Object %s is not present according to the Bloom filter", blobDigest))
+	}
+	return ba.BlobAccess.Get(ctx, blobDigest)
+}
+
+func (ba *bloomFilteringBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	// Add the object to the filter up front. Doing it afterwards
+	// would allow a concurrent FindMissing() to report the object
+	// as absent while it is already present.
+	ba.filter.Add(blobDigest)
+	return ba.BlobAccess.Put(ctx, blobDigest, b)
+}
+
+func (ba *bloomFilteringBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	definitelyMissing, maybePresent := ba.filter.PartitionMissing(digests)
+	if maybePresent.Empty() {
+		return definitelyMissing, nil
+	}
+	missing, err := ba.BlobAccess.FindMissing(ctx, maybePresent)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	return digest.GetUnion([]digest.Set{definitelyMissing, missing}), nil
+}
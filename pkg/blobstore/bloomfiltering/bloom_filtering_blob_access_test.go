@@ -0,0 +1,103 @@
+package bloomfiltering_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/bloomfiltering"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBloomFilteringBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	directory, err := ioutil.TempDir("", "bloomfiltering")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "bloom_filter")
+
+	newFilter := func() *bloomfiltering.ExistenceFilter {
+		bloomFilter, err := bloomfiltering.NewBloomFilterFromFalsePositiveRate(1000, 0.01)
+		require.NoError(t, err)
+		filter, err := bloomfiltering.NewExistenceFilter(bloomFilter, digest.KeyWithoutInstance, path, clock.SystemClock)
+		require.NoError(t, err)
+		return filter
+	}
+	filter := newFilter()
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := bloomfiltering.NewBloomFilteringBlobAccess(baseBlobAccess, filter)
+
+	presentDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+	writtenDigest := digest.MustNewDigest("instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+	missingDigest := digest.MustNewDigest("instance", "73feffa4b7f6bb68e44cf984c85f6e88", 3)
+	allDigests := digest.NewSetBuilder().Add(presentDigest).Add(writtenDigest).Add(missingDigest).Build()
+
+	t.Run("NotReady", func(t *testing.T) {
+		// As long as the filter has not been populated, all
+		// requests should be forwarded.
+		require.False(t, filter.IsReady())
+		baseBlobAccess.EXPECT().Get(ctx, missingDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		_, err := blobAccess.Get(ctx, missingDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("WarmUp", func(t *testing.T) {
+		lister := mock.NewMockDigestLister(ctrl)
+		lister.EXPECT().ListDigests(ctx, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, callback func(digest.Digest) error) error {
+				return callback(presentDigest)
+			})
+		require.NoError(t, filter.WarmUp(ctx, lister))
+		require.True(t, filter.IsReady())
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, writtenDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, writtenDigest, buffer.NewValidatedBufferFromByteSlice([]byte("bar"))))
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		// Objects that are definitely absent should not be
+		// requested from the backend.
+		_, err := blobAccess.Get(ctx, missingDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object 73feffa4b7f6bb68e44cf984c85f6e88-3-instance is not present according to the Bloom filter"), err)
+
+		baseBlobAccess.EXPECT().Get(ctx, presentDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("foo")))
+		data, err := blobAccess.Get(ctx, presentDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("foo"), data)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(presentDigest).Add(writtenDigest).Build()).Return(digest.EmptySet, nil)
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(missingDigest).Build(), missing)
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		// Upon restart, the filter should be loaded from disk,
+		// including objects written after it was last saved.
+		reloadedFilter := newFilter()
+		require.True(t, reloadedFilter.IsReady())
+		definitelyMissing, maybePresent := reloadedFilter.PartitionMissing(allDigests)
+		require.Equal(t, digest.NewSetBuilder().Add(missingDigest).Build(), definitelyMissing)
+		require.Equal(t, digest.NewSetBuilder().Add(presentDigest).Add(writtenDigest).Build(), maybePresent)
+	})
+}
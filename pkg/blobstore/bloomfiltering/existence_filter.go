@@ -0,0 +1,257 @@
+package bloomfiltering
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ExistenceFilter keeps track of which objects are present in a
+// storage backend, using a BloomFilter. It can only be used to
+// determine that objects are absent once it is ready, meaning that it
+// has been populated with all objects in the storage backend. This is
+// either done by loading a previously saved copy of the filter, or by
+// listing the contents of the storage backend.
+//
+// The filter may optionally be persisted on disk. It is saved
+// periodically. Objects added in between are appended to a journal
+// file, so that they are not lost when the process terminates
+// unexpectedly.
+//
+// It is safe to access ExistenceFilter concurrently.
+type ExistenceFilter struct {
+	keyFormat digest.KeyFormat
+	path      string
+	clock     clock.Clock
+
+	lock    sync.RWMutex
+	filter  *BloomFilter
+	ready   bool
+	journal *os.File
+}
+
+// NewExistenceFilter creates an ExistenceFilter that is backed by a
+// BloomFilter. If a path is provided, a copy of the filter stored at
+// that path is loaded, causing the ExistenceFilter to become ready
+// immediately. If no path is provided, the filter is not persisted.
+func NewExistenceFilter(filter *BloomFilter, keyFormat digest.KeyFormat, path string, clock clock.Clock) (*ExistenceFilter, error) {
+	ef := &ExistenceFilter{
+		keyFormat: keyFormat,
+		path:      path,
+		clock:     clock,
+		filter:    filter,
+	}
+	if path == "" {
+		return ef, nil
+	}
+
+	if err := ef.load(); err != nil {
+		// Discard a journal that can no longer be applied,
+		// as it only contains objects added after the
+		// filter was last saved.
+		log.Printf("Not using Bloom filter stored at %#v: %s", path, err)
+		if err := os.Remove(ef.getJournalPath()); err != nil && !os.IsNotExist(err) {
+			return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to remove Bloom filter journal")
+		}
+		return ef, nil
+	}
+	journal, err := os.OpenFile(ef.getJournalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to open Bloom filter journal")
+	}
+	ef.journal = journal
+	ef.ready = true
+	return ef, nil
+}
+
+func (ef *ExistenceFilter) getJournalPath() string {
+	return ef.path + ".journal"
+}
+
+// load a previously saved copy of the filter, followed by replaying
+// the journal.
+func (ef *ExistenceFilter) load() error {
+	f, err := os.Open(ef.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := ef.filter.ReadFrom(bufio.NewReader(f)); err != nil {
+		return err
+	}
+
+	journal, err := os.Open(ef.getJournalPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer journal.Close()
+	scanner := bufio.NewScanner(journal)
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			ef.filter.Add(key)
+		}
+	}
+	return scanner.Err()
+}
+
+// invalidatePersistentState removes the copy of the filter stored on
+// disk. This is done when the journal cannot be written, as the copy
+// would otherwise fail to mention objects that are present. This
+// function must be called with the lock held.
+func (ef *ExistenceFilter) invalidatePersistentState(err error) {
+	log.Printf("Failed to write Bloom filter journal, discarding Bloom filter stored at %#v: %s", ef.path, err)
+	if ef.journal != nil {
+		ef.journal.Close()
+		ef.journal = nil
+	}
+	if err := os.Remove(ef.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove Bloom filter stored at %#v: %s", ef.path, err)
+	}
+}
+
+// Add an object to the filter.
+func (ef *ExistenceFilter) Add(blobDigest digest.Digest) {
+	key := blobDigest.GetKey(ef.keyFormat)
+
+	ef.lock.Lock()
+	defer ef.lock.Unlock()
+	ef.filter.Add(key)
+	if ef.journal != nil {
+		if _, err := ef.journal.WriteString(key + "\n"); err != nil {
+			ef.invalidatePersistentState(err)
+		}
+	}
+}
+
+// IsReady returns whether the filter has been populated with all
+// objects in the storage backend.
+func (ef *ExistenceFilter) IsReady() bool {
+	ef.lock.RLock()
+	defer ef.lock.RUnlock()
+	return ef.ready
+}
+
+// IsDefinitelyMissing returns true if an object is known to be absent.
+func (ef *ExistenceFilter) IsDefinitelyMissing(blobDigest digest.Digest) bool {
+	key := blobDigest.GetKey(ef.keyFormat)
+
+	ef.lock.RLock()
+	defer ef.lock.RUnlock()
+	return ef.ready && !ef.filter.MayContain(key)
+}
+
+// PartitionMissing splits up a set of digests into ones that are known
+// to be absent and ones that may be present.
+func (ef *ExistenceFilter) PartitionMissing(digests digest.Set) (definitelyMissing digest.Set, maybePresent digest.Set) {
+	ef.lock.RLock()
+	defer ef.lock.RUnlock()
+	if !ef.ready {
+		return digest.EmptySet, digests
+	}
+	missing := digest.NewSetBuilder()
+	present := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if ef.filter.MayContain(blobDigest.GetKey(ef.keyFormat)) {
+			present.Add(blobDigest)
+		} else {
+			missing.Add(blobDigest)
+		}
+	}
+	return missing.Build(), present.Build()
+}
+
+// WarmUp populates the filter by listing all objects in a storage
+// backend. Once completed, the filter becomes ready. Objects that are
+// added concurrently are retained.
+func (ef *ExistenceFilter) WarmUp(ctx context.Context, lister blobstore.DigestLister) error {
+	if err := lister.ListDigests(ctx, func(blobDigest digest.Digest) error {
+		ef.Add(blobDigest)
+		return nil
+	}); err != nil {
+		return util.StatusWrap(err, "Failed to list objects")
+	}
+
+	ef.lock.Lock()
+	defer ef.lock.Unlock()
+	ef.ready = true
+	return ef.save()
+}
+
+// Save the filter to disk, if persistence is enabled and the filter is
+// ready.
+func (ef *ExistenceFilter) Save() error {
+	ef.lock.Lock()
+	defer ef.lock.Unlock()
+	return ef.save()
+}
+
+func (ef *ExistenceFilter) save() error {
+	if ef.path == "" || !ef.ready {
+		return nil
+	}
+
+	// Atomically replace the copy stored on disk, so that it is never
+	// left in a partially written state.
+	temporaryPath := ef.path + ".tmp"
+	f, err := os.OpenFile(temporaryPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create Bloom filter file")
+	}
+	w := bufio.NewWriter(f)
+	if _, err := ef.filter.WriteTo(w); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write Bloom filter file")
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write Bloom filter file")
+	}
+	if err := f.Close(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to close Bloom filter file")
+	}
+	if err := os.Rename(temporaryPath, ef.path); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to replace Bloom filter file")
+	}
+
+	// Objects in the journal are now part of the copy on disk.
+	if ef.journal != nil {
+		ef.journal.Close()
+		ef.journal = nil
+	}
+	journal, err := os.OpenFile(ef.getJournalPath(), os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		ef.invalidatePersistentState(err)
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to truncate Bloom filter journal")
+	}
+	ef.journal = journal
+	return nil
+}
+
+// Run calls Save() at a fixed interval. This function returns when the
+// provided context is cancelled.
+func (ef *ExistenceFilter) Run(ctx context.Context, saveInterval time.Duration) {
+	for {
+		timer, t := ef.clock.NewTimer(saveInterval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		if err := ef.Save(); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package buffer
+
+import (
+	"io"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReadAtCloser is a ReaderAt that needs to be closed explicitly, so
+// that resources associated with it (e.g., a connection to a storage
+// backend) may be released.
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+type casReaderAtBuffer struct {
+	digest         digest.Digest
+	r              ReadAtCloser
+	repairStrategy RepairStrategy
+}
+
+// NewCASBufferFromReaderAt creates a buffer for an object stored in
+// the Content Addressable Storage, whose contents may be obtained
+// through a ReadAtCloser. It should be used by storage backends that
+// are capable of performing range reads (e.g., local block storage
+// and cloud storage providers).
+//
+// Reading the contents of the buffer causes the checksum of the data to
+// be validated, just like buffers created through
+// NewCASBufferFromReader(). As a checksum can only be computed over the
+// full object, calls to ReadAt() and ToChunkReader() with a non-zero
+// offset still read the object in its entirety. Range reads are only
+// performed when resuming reads after I/O errors, as the data leading
+// up to that point has already been validated.
+func NewCASBufferFromReaderAt(digest digest.Digest, r ReadAtCloser, repairStrategy RepairStrategy) Buffer {
+	return &casReaderAtBuffer{
+		digest:         digest,
+		r:              r,
+		repairStrategy: repairStrategy,
+	}
+}
+
+func (b *casReaderAtBuffer) GetSizeBytes() (int64, error) {
+	return b.digest.GetSizeBytes(), nil
+}
+
+// toCASReaderBuffer converts the buffer to one that reads the full
+// contents of the object sequentially, so that its checksum may be
+// validated.
+func (b *casReaderAtBuffer) toCASReaderBuffer() Buffer {
+	return NewCASBufferFromReader(
+		b.digest,
+		newReaderAtSection(b.r, 0, b.digest.GetSizeBytes()),
+		b.repairStrategy)
+}
+
+func (b *casReaderAtBuffer) IntoWriter(w io.Writer) error {
+	return b.toCASReaderBuffer().IntoWriter(w)
+}
+
+func (b *casReaderAtBuffer) ReadAt(p []byte, off int64) (int, error) {
+	return b.toCASReaderBuffer().ReadAt(p, off)
+}
+
+func (b *casReaderAtBuffer) ToActionResult(maximumSizeBytes int) (*remoteexecution.ActionResult, error) {
+	return toActionResultViaByteSlice(b, maximumSizeBytes)
+}
+
+func (b *casReaderAtBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	return b.toCASReaderBuffer().ToByteSlice(maximumSizeBytes)
+}
+
+func (b *casReaderAtBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+	return b.toCASReaderBuffer().ToChunkReader(off, maximumChunkSizeBytes)
+}
+
+func (b *casReaderAtBuffer) ToReader() io.ReadCloser {
+	return b.toCASReaderBuffer().ToReader()
+}
+
+func (b *casReaderAtBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	return cloneCopyViaByteSlice(b, maximumSizeBytes)
+}
+
+func (b *casReaderAtBuffer) CloneStream() (Buffer, Buffer) {
+	return newCASClonedBuffer(b, b.digest, b.repairStrategy).CloneStream()
+}
+
+func (b *casReaderAtBuffer) Discard() {
+	b.r.Close()
+}
+
+func (b *casReaderAtBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	// For stream-backed buffers, it is not yet known whether they
+	// may be read successfully. Wrap the buffer into one that
+	// handles I/O errors upon access.
+	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.repairStrategy), false
+}
+
+func (b *casReaderAtBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+	if err := validateReaderOffset(b.digest.GetSizeBytes(), off); err != nil {
+		b.r.Close()
+		return newErrorChunkReader(err)
+	}
+	return newReaderBackedChunkReader(newReaderAtSection(b.r, off, b.digest.GetSizeBytes()), maximumChunkSizeBytes)
+}
+
+func (b *casReaderAtBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
+	if err := validateReaderOffset(b.digest.GetSizeBytes(), off); err != nil {
+		b.r.Close()
+		return newErrorReader(err)
+	}
+	return newReaderAtSection(b.r, off, b.digest.GetSizeBytes())
+}
+
+// readerAtSection is a ReadCloser that reads a range of data from a
+// ReadAtCloser sequentially. Unlike io.SectionReader, it fails in case
+// the underlying ReadAtCloser contains less data than expected, so
+// that truncated objects are detected.
+type readerAtSection struct {
+	r   ReadAtCloser
+	off int64
+	end int64
+}
+
+func newReaderAtSection(r ReadAtCloser, off int64, end int64) io.ReadCloser {
+	return &readerAtSection{
+		r:   r,
+		off: off,
+		end: end,
+	}
+}
+
+func (r *readerAtSection) Read(p []byte) (int, error) {
+	if r.off >= r.end {
+		return 0, io.EOF
+	}
+	if remaining := r.end - r.off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.r.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF {
+		if r.off < r.end {
+			return n, status.Errorf(codes.Internal, "Object is %d bytes shorter than expected", r.end-r.off)
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (r *readerAtSection) Close() error {
+	return r.r.Close()
+}
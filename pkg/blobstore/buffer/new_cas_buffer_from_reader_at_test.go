@@ -0,0 +1,169 @@
+package buffer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// byteSliceReadAtCloser is a ReadAtCloser backed by a byte slice. It
+// keeps track of the number of bytes read and whether it was closed.
+type byteSliceReadAtCloser struct {
+	r         *bytes.Reader
+	bytesRead int
+	closed    bool
+}
+
+func newByteSliceReadAtCloser(data string) *byteSliceReadAtCloser {
+	return &byteSliceReadAtCloser{r: bytes.NewReader([]byte(data))}
+}
+
+func (r *byteSliceReadAtCloser) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.bytesRead += n
+	return n, err
+}
+
+func (r *byteSliceReadAtCloser) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestNewCASBufferFromReaderAtIntoWriter(t *testing.T) {
+	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hello")
+		writer := bytes.NewBuffer(nil)
+		require.NoError(t, buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).IntoWriter(writer))
+		require.Equal(t, []byte("Hello"), writer.Bytes())
+		require.True(t, r.closed)
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hallo")
+		err := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).IntoWriter(bytes.NewBuffer(nil))
+		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+		require.True(t, r.closed)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hel")
+		err := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).IntoWriter(bytes.NewBuffer(nil))
+		require.Equal(t, status.Error(codes.Internal, "Object is 2 bytes shorter than expected"), err)
+		require.True(t, r.closed)
+	})
+}
+
+func TestNewCASBufferFromReaderAtReadAt(t *testing.T) {
+	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Partial", func(t *testing.T) {
+		// The full object should be read, so that its
+		// checksum can be validated.
+		r := newByteSliceReadAtCloser("Hello")
+		var p [2]byte
+		n, err := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ReadAt(p[:], 1)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		require.Equal(t, []byte("el"), p[:])
+		require.Equal(t, 5, r.bytesRead)
+		require.True(t, r.closed)
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		// Corruption outside of the requested range should
+		// also be detected.
+		r := newByteSliceReadAtCloser("Hallo")
+		var p [2]byte
+		_, err := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ReadAt(p[:], 3)
+		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+		require.True(t, r.closed)
+	})
+
+	t.Run("EndOfFile", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hello")
+		var p [5]byte
+		n, err := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ReadAt(p[:], 3)
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, 2, n)
+		require.Equal(t, []byte("lo"), p[:n])
+		require.True(t, r.closed)
+	})
+
+	t.Run("NegativeOffset", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hello")
+		var p [5]byte
+		_, err := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ReadAt(p[:], -1)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -1"), err)
+		require.True(t, r.closed)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hel")
+		var p [2]byte
+		_, err := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ReadAt(p[:], 2)
+		require.Equal(t, status.Error(codes.Internal, "Object is 2 bytes shorter than expected"), err)
+		require.True(t, r.closed)
+	})
+}
+
+func TestNewCASBufferFromReaderAtToChunkReader(t *testing.T) {
+	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Offset", func(t *testing.T) {
+		// Reads at a non-zero offset should still cause the
+		// leading part of the object to be read, so that the
+		// checksum can be validated.
+		r := newByteSliceReadAtCloser("Hello")
+		chunkReader := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ToChunkReader(2, 2)
+		chunk, err := chunkReader.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("ll"), chunk)
+		chunk, err = chunkReader.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("o"), chunk)
+		_, err = chunkReader.Read()
+		require.Equal(t, io.EOF, err)
+		chunkReader.Close()
+		require.Equal(t, 5, r.bytesRead)
+		require.True(t, r.closed)
+	})
+
+	t.Run("OffsetTooFar", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hello")
+		chunkReader := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ToChunkReader(6, 2)
+		_, err := chunkReader.Read()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while a read at offset 6 was requested"), err)
+		chunkReader.Close()
+		require.True(t, r.closed)
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		r := newByteSliceReadAtCloser("Hallo")
+		chunkReader := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ToChunkReader(0, 10)
+		_, err := chunkReader.Read()
+		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+		chunkReader.Close()
+		require.True(t, r.closed)
+	})
+
+	t.Run("ChecksumMismatchAtOffset", func(t *testing.T) {
+		// Reads at a non-zero offset should also be validated,
+		// even if the corruption is in the leading part of the
+		// object.
+		r := newByteSliceReadAtCloser("Hallo")
+		chunkReader := buffer.NewCASBufferFromReaderAt(helloDigest, r, buffer.Irreparable).ToChunkReader(3, 10)
+		_, err := chunkReader.Read()
+		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+		chunkReader.Close()
+		require.True(t, r.closed)
+	})
+}
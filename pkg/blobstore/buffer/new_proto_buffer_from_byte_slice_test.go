@@ -0,0 +1,41 @@
+package buffer_test
+
+import (
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewProtoBufferFromByteSlice(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	t.Run("Success", func(t *testing.T) {
+		var actionResult remoteexecution.ActionResult
+		data, err := buffer.NewProtoBufferFromByteSlice(
+			&actionResult,
+			exampleActionResultBytes,
+			buffer.Irreparable).ToByteSlice(1000)
+		require.NoError(t, err)
+		require.Equal(t, exampleActionResultBytes, data)
+	})
+
+	t.Run("DataCorruption", func(t *testing.T) {
+		repairFunc := mock.NewMockRepairFunc(ctrl)
+		repairFunc.EXPECT().Call()
+
+		var actionResult remoteexecution.ActionResult
+		_, err := buffer.NewProtoBufferFromByteSlice(
+			&actionResult,
+			[]byte("Hello world"),
+			buffer.Reparable(exampleDigest, repairFunc.Call)).ToByteSlice(1000)
+		require.Equal(t, status.Error(codes.Internal, "Failed to unmarshal message: proto: can't skip unknown wire type 4"), err)
+	})
+}
@@ -0,0 +1,47 @@
+package buffer
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// NewProtoBufferFromProto creates a buffer for an object that contains
+// an arbitrary Protobuf message, based on an unmarshaled copy of the
+// message. This can be used by stores other than the Action Cache that
+// hold messages which are not content addressed, such as the File
+// System Access Cache.
+func NewProtoBufferFromProto(message proto.Message, repairStrategy RepairStrategy) Buffer {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return NewBufferFromError(repairStrategy.repairACMarshalFailure(err))
+	}
+	return NewValidatedBufferFromByteSlice(data)
+}
+
+// NewProtoBufferFromByteSlice creates a buffer for an object that
+// contains an arbitrary Protobuf message, based on a marshaled copy of
+// the message stored in a byte slice. The data is validated by
+// unmarshaling it into the provided message.
+func NewProtoBufferFromByteSlice(message proto.Message, data []byte, repairStrategy RepairStrategy) Buffer {
+	if err := proto.Unmarshal(data, message); err != nil {
+		return NewBufferFromError(repairStrategy.repairACUnmarshalFailure(err))
+	}
+	return NewValidatedBufferFromByteSlice(data)
+}
+
+// NewProtoBufferFromReader creates a buffer for an object that contains
+// an arbitrary Protobuf message, based on a marshaled copy of the
+// message that may be obtained through a ReadCloser.
+func NewProtoBufferFromReader(message proto.Message, r io.ReadCloser, repairStrategy RepairStrategy) Buffer {
+	// Similar to messages stored in the Action Cache, these
+	// messages are expected to be small. Read them into memory
+	// immediately, so that they can be validated.
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return NewBufferFromError(err)
+	}
+	return NewProtoBufferFromByteSlice(message, data, repairStrategy)
+}
@@ -0,0 +1,657 @@
+package blobstore
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	bundlingBlobAccessPrometheusMetrics sync.Once
+
+	bundlingBlobAccessPutsDeduplicated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "bundling_blob_access_puts_deduplicated_total",
+			Help:      "Number of Put() calls for objects that were already part of the bundle that was being assembled.",
+		},
+		[]string{"name"})
+	bundlingBlobAccessBundlesWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "bundling_blob_access_bundles_written_total",
+			Help:      "Number of bundles written to the backend, either as part of Put() calls or compaction.",
+		},
+		[]string{"name", "reason"})
+	bundlingBlobAccessBundlesCompacted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "bundling_blob_access_bundles_compacted_total",
+			Help:      "Number of sparse bundles whose contents were merged into other bundles.",
+		},
+		[]string{"name"})
+)
+
+const (
+	// bundlingBlobAccessIndexConcurrency is the maximum number of
+	// index buckets that are loaded or updated in parallel.
+	bundlingBlobAccessIndexConcurrency = 16
+	// bundlingBlobAccessIndexLocks is the number of locks used to
+	// serialize updates of index buckets within this process.
+	bundlingBlobAccessIndexLocks = 64
+)
+
+// bundleEntry is the location of an object within a bundle.
+type bundleEntry struct {
+	digest      digest.Digest
+	offsetBytes int64
+}
+
+// pendingBundle is a bundle that is still being assembled. Callers of
+// Put() block until the bundle containing their object has been
+// written.
+type pendingBundle struct {
+	ctx     context.Context
+	data    []byte
+	entries []bundleEntry
+	present map[digest.Digest]struct{}
+	flushed chan struct{}
+	err     error
+}
+
+// sparseBundle is a bundle that has been written while being
+// considerably smaller than the desired bundle size. Its contents are
+// merged into other bundles by compaction.
+type sparseBundle struct {
+	digest  digest.Digest
+	entries []bundleEntry
+}
+
+// bundlingInstance holds the state of bundles of a single instance
+// name. Bundles only contain objects belonging to the same instance
+// name, as the digest of the bundle carries the instance name.
+type bundlingInstance struct {
+	pending         *pendingBundle
+	flushing        bool
+	sparse          []sparseBundle
+	sparseSizeBytes int64
+	compacting      bool
+}
+
+type bundlingBlobAccess struct {
+	BlobAccess
+	deleter                    BlobDeleter
+	index                      BlobAccess
+	maximumBlobSizeBytes       int64
+	bundleSizeBytes            int64
+	indexBucketCount           uint32
+	maximumIndexEntrySizeBytes int
+
+	lock               sync.Mutex
+	instances          map[string]*bundlingInstance
+	compactionDisabled bool
+
+	indexLocks [bundlingBlobAccessIndexLocks]sync.Mutex
+
+	putsDeduplicated         prometheus.Counter
+	bundlesWrittenFull       prometheus.Counter
+	bundlesWrittenPartial    prometheus.Counter
+	bundlesWrittenCompaction prometheus.Counter
+	bundlesCompacted         prometheus.Counter
+}
+
+// NewBundlingBlobAccess creates a decorator for BlobAccess that packs
+// small objects into larger bundle objects. This reduces the number of
+// objects stored in the backend, which is useful for backends that
+// charge per object or per request (e.g., S3).
+//
+// Put() calls for small objects are collected into a bundle, which is
+// written as soon as no other bundle is being written, or once it
+// reaches the desired size. Put() calls thus never wait for more
+// objects to arrive. Under load, objects that arrive while a bundle is
+// being written are combined into the next bundle. Objects that are
+// uploaded multiple times while a bundle is being assembled are only
+// stored once.
+//
+// The index that stores the location of every bundled object is split
+// up into a fixed number of buckets, selected by the hash of the
+// object. After writing a bundle, every bucket containing one of its
+// objects is updated once, meaning that a bundle requires at most one
+// index update per bucket, as opposed to one per object. Put() calls
+// only return after the index has been updated. If a bucket exceeds
+// the maximum index entry size, its oldest entries are discarded.
+// Concurrent updates of the same bucket by different processes may
+// also cause entries to be lost. Affected objects are reported as
+// absent, causing clients to upload them once more.
+//
+// Get() calls for small objects look up the index entry of the object
+// and perform a range read against the bundle. The backend should thus
+// be capable of serving range reads efficiently.
+//
+// Bundles that are written while being smaller than half of the
+// desired size (e.g., due to low traffic) are considered sparse. Once
+// enough sparse bundles have been written to fill a bundle, their
+// contents are merged into new bundles in the background, after which
+// the sparse bundles are deleted using the provided BlobDeleter. Get()
+// calls that are performed concurrently may then fail with NOT_FOUND,
+// which clients handle by uploading the object once more. Compaction
+// is disabled if the BlobDeleter is nil or reports that deletion is
+// unimplemented, in which case sparse bundles are left in place until
+// evicted by the backend. The list of sparse bundles is only kept in
+// memory, meaning that compaction only covers bundles that have been
+// written since the process started.
+//
+// As the index stores information keyed by the digest of the object,
+// it needs to be backed by storage that is suitable for storing an
+// Action Cache. Index buckets are keyed by digests that have no
+// instance name, as this decorator may only be used for the Content
+// Addressable Storage.
+func NewBundlingBlobAccess(base BlobAccess, deleter BlobDeleter, index BlobAccess, maximumBlobSizeBytes, bundleSizeBytes int64, indexBucketCount uint32, maximumIndexEntrySizeBytes int, name string) BlobAccess {
+	bundlingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(bundlingBlobAccessPutsDeduplicated)
+		prometheus.MustRegister(bundlingBlobAccessBundlesWritten)
+		prometheus.MustRegister(bundlingBlobAccessBundlesCompacted)
+	})
+
+	return &bundlingBlobAccess{
+		BlobAccess:                 base,
+		deleter:                    deleter,
+		index:                      index,
+		maximumBlobSizeBytes:       maximumBlobSizeBytes,
+		bundleSizeBytes:            bundleSizeBytes,
+		indexBucketCount:           indexBucketCount,
+		maximumIndexEntrySizeBytes: maximumIndexEntrySizeBytes,
+
+		instances:          map[string]*bundlingInstance{},
+		compactionDisabled: deleter == nil,
+
+		putsDeduplicated:         bundlingBlobAccessPutsDeduplicated.WithLabelValues(name),
+		bundlesWrittenFull:       bundlingBlobAccessBundlesWritten.WithLabelValues(name, "Full"),
+		bundlesWrittenPartial:    bundlingBlobAccessBundlesWritten.WithLabelValues(name, "Partial"),
+		bundlesWrittenCompaction: bundlingBlobAccessBundlesWritten.WithLabelValues(name, "Compaction"),
+		bundlesCompacted:         bundlingBlobAccessBundlesCompacted.WithLabelValues(name),
+	}
+}
+
+func (ba *bundlingBlobAccess) isBundled(blobDigest digest.Digest) bool {
+	return blobDigest.GetSizeBytes() <= ba.maximumBlobSizeBytes
+}
+
+// getIndexBucket returns the number of the index bucket in which the
+// location of an object is stored.
+func (ba *bundlingBlobAccess) getIndexBucket(blobDigest digest.Digest) uint32 {
+	return binary.BigEndian.Uint32(blobDigest.GetHashBytes()) % ba.indexBucketCount
+}
+
+// getIndexBucketDigest returns the digest under which an index bucket
+// is stored. It is derived from the number of the bucket, using the
+// same hashing algorithm as the objects stored in it.
+func (ba *bundlingBlobAccess) getIndexBucketDigest(blobDigest digest.Digest) digest.Digest {
+	generator := blobDigest.WithoutInstance().NewGenerator()
+	fmt.Fprintf(generator, "bundling-index-bucket-%d", ba.getIndexBucket(blobDigest))
+	return generator.Sum()
+}
+
+// getIndexEntryPath returns the path of the output file in an index
+// bucket that stores the location of an object. The path consists of
+// the key of the object and its offset within the bundle.
+func getIndexEntryPath(blobDigest digest.Digest, offsetBytes int64) string {
+	return fmt.Sprintf("%s/%d", blobDigest.GetKey(digest.KeyWithoutInstance), offsetBytes)
+}
+
+// getIndexEntryKey returns the key of the object whose location is
+// stored in an output file of an index bucket.
+func getIndexEntryKey(path string) string {
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// getIndexBucketContents loads an index bucket. Buckets that do not
+// exist are treated as if they are empty.
+func (ba *bundlingBlobAccess) getIndexBucketContents(ctx context.Context, bucketDigest digest.Digest) (*remoteexecution.ActionResult, error) {
+	actionResult, err := ba.index.Get(ctx, bucketDigest).ToActionResult(ba.maximumIndexEntrySizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &remoteexecution.ActionResult{}, nil
+		}
+		return nil, util.StatusWrap(err, "Failed to load index bucket")
+	}
+	return actionResult, nil
+}
+
+// lookupIndexEntry returns the digest of the bundle containing an
+// object, and the offset at which the object is stored in the bundle.
+func lookupIndexEntry(bucket *remoteexecution.ActionResult, blobDigest digest.Digest) (digest.Digest, int64, error) {
+	prefix := blobDigest.GetKey(digest.KeyWithoutInstance) + "/"
+	for _, outputFile := range bucket.OutputFiles {
+		if !strings.HasPrefix(outputFile.Path, prefix) {
+			continue
+		}
+		offsetBytes, err := strconv.ParseInt(outputFile.Path[len(prefix):], 10, 64)
+		if err != nil || offsetBytes < 0 {
+			return digest.BadDigest, 0, status.Errorf(codes.Internal, "Index entry contains invalid path %#v", outputFile.Path)
+		}
+		bundleDigest, err := blobDigest.NewDerivedDigest(outputFile.Digest)
+		if err != nil {
+			return digest.BadDigest, 0, util.StatusWrapWithCode(err, codes.Internal, "Index entry contains invalid bundle digest")
+		}
+		if offsetBytes+blobDigest.GetSizeBytes() > bundleDigest.GetSizeBytes() {
+			return digest.BadDigest, 0, status.Errorf(codes.Internal, "Index entry refers to data beyond the end of bundle %s", bundleDigest)
+		}
+		return bundleDigest, offsetBytes, nil
+	}
+	return digest.BadDigest, 0, status.Error(codes.NotFound, "Object not found")
+}
+
+func (ba *bundlingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if !ba.isBundled(blobDigest) {
+		return ba.BlobAccess.Get(ctx, blobDigest)
+	}
+	bucket, err := ba.getIndexBucketContents(ctx, ba.getIndexBucketDigest(blobDigest))
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	bundleDigest, offsetBytes, err := lookupIndexEntry(bucket, blobDigest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	data := make([]byte, blobDigest.GetSizeBytes())
+	if n, err := ba.BlobAccess.Get(ctx, bundleDigest).ReadAt(data, offsetBytes); err != nil && (err != io.EOF || n != len(data)) {
+		return buffer.NewBufferFromError(util.StatusWrapf(err, "Failed to read from bundle %s", bundleDigest))
+	}
+	return buffer.NewCASBufferFromByteSlice(blobDigest, data, buffer.Irreparable)
+}
+
+func (ba *bundlingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if !ba.isBundled(blobDigest) {
+		return ba.BlobAccess.Put(ctx, blobDigest, b)
+	}
+	data, err := b.ToByteSlice(int(ba.maximumBlobSizeBytes))
+	if err != nil {
+		return err
+	}
+
+	p := ba.addToPendingBundle(ctx, blobDigest, data)
+	select {
+	case <-p.flushed:
+		return p.err
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	}
+}
+
+func (ba *bundlingBlobAccess) getInstance(instanceName string) *bundlingInstance {
+	i, ok := ba.instances[instanceName]
+	if !ok {
+		i = &bundlingInstance{}
+		ba.instances[instanceName] = i
+	}
+	return i
+}
+
+// addToPendingBundle adds an object to the bundle that is currently
+// being assembled for its instance name, creating a new bundle if
+// needed. The bundle is written immediately if it has reached the
+// desired size, or if no other bundle is being written. Otherwise, it
+// is written once the bundle that is currently being written completes.
+func (ba *bundlingBlobAccess) addToPendingBundle(ctx context.Context, blobDigest digest.Digest, data []byte) *pendingBundle {
+	instanceName := blobDigest.GetInstance()
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	i := ba.getInstance(instanceName)
+	p := i.pending
+	if p == nil {
+		// Writing the bundle is shared with other callers,
+		// meaning that it should not be interrupted when the
+		// current caller's context is cancelled.
+		p = &pendingBundle{
+			ctx:     util.NewDetachedContext(ctx),
+			present: map[digest.Digest]struct{}{},
+			flushed: make(chan struct{}),
+		}
+		i.pending = p
+	}
+
+	if _, ok := p.present[blobDigest]; ok {
+		ba.putsDeduplicated.Inc()
+		return p
+	}
+	p.present[blobDigest] = struct{}{}
+	p.entries = append(p.entries, bundleEntry{
+		digest:      blobDigest,
+		offsetBytes: int64(len(p.data)),
+	})
+	p.data = append(p.data, data...)
+	if int64(len(p.data)) >= ba.bundleSizeBytes {
+		i.pending = nil
+		ba.bundlesWrittenFull.Inc()
+		go ba.flushBundle(instanceName, p)
+	} else if !i.flushing {
+		i.pending = nil
+		i.flushing = true
+		ba.bundlesWrittenPartial.Inc()
+		go ba.flushPartialBundles(instanceName, i, p)
+	}
+	return p
+}
+
+func (ba *bundlingBlobAccess) flushBundle(instanceName string, p *pendingBundle) {
+	p.err = ba.writeBundle(p.ctx, instanceName, p.data, p.entries)
+	close(p.flushed)
+}
+
+// flushPartialBundles writes a bundle that has not reached the desired
+// size. Upon completion, it writes any objects that have been
+// collected in the meantime.
+func (ba *bundlingBlobAccess) flushPartialBundles(instanceName string, i *bundlingInstance, p *pendingBundle) {
+	for {
+		ba.flushBundle(instanceName, p)
+
+		ba.lock.Lock()
+		p = i.pending
+		if p == nil {
+			i.flushing = false
+			ba.lock.Unlock()
+			return
+		}
+		i.pending = nil
+		ba.bundlesWrittenPartial.Inc()
+		ba.lock.Unlock()
+	}
+}
+
+// writeBundle writes a bundle to the backend, followed by the index
+// entries for all of the objects contained in it. Index entries are
+// only written after the bundle itself, so that they never refer to
+// bundles that do not exist.
+func (ba *bundlingBlobAccess) writeBundle(ctx context.Context, instanceName string, data []byte, entries []bundleEntry) error {
+	generator := entries[0].digest.NewGenerator()
+	generator.Write(data)
+	bundleDigest := generator.Sum()
+	if err := ba.BlobAccess.Put(ctx, bundleDigest, buffer.NewValidatedBufferFromByteSlice(data)); err != nil {
+		return util.StatusWrap(err, "Failed to store bundle")
+	}
+
+	// Update every index bucket containing one of the objects once.
+	var bucketDigests []digest.Digest
+	entriesPerBucket := map[digest.Digest][]bundleEntry{}
+	for _, entry := range entries {
+		bucketDigest := ba.getIndexBucketDigest(entry.digest)
+		if _, ok := entriesPerBucket[bucketDigest]; !ok {
+			bucketDigests = append(bucketDigests, bucketDigest)
+		}
+		entriesPerBucket[bucketDigest] = append(entriesPerBucket[bucketDigest], entry)
+	}
+	errs := make([]error, len(bucketDigests))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, bundlingBlobAccessIndexConcurrency)
+	for i, bucketDigest := range bucketDigests {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, bucketDigest digest.Digest) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			bucketEntries := entriesPerBucket[bucketDigest]
+			errs[i] = ba.updateIndexBucket(ctx, bucketDigest, ba.getIndexBucket(bucketEntries[0].digest), bundleDigest, bucketEntries)
+		}(i, bucketDigest)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	if int64(len(data)) < ba.bundleSizeBytes/2 {
+		ba.addSparseBundle(ctx, instanceName, bundleDigest, entries)
+	}
+	return nil
+}
+
+// updateIndexBucket adds index entries for objects contained in a
+// bundle to an index bucket, replacing any existing entries for the
+// same objects.
+func (ba *bundlingBlobAccess) updateIndexBucket(ctx context.Context, bucketDigest digest.Digest, bucket uint32, bundleDigest digest.Digest, entries []bundleEntry) error {
+	indexLock := &ba.indexLocks[bucket%bundlingBlobAccessIndexLocks]
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	oldContents, err := ba.getIndexBucketContents(ctx, bucketDigest)
+	if err != nil {
+		return err
+	}
+	replaced := map[string]struct{}{}
+	for _, entry := range entries {
+		replaced[entry.digest.GetKey(digest.KeyWithoutInstance)] = struct{}{}
+	}
+	outputFiles := make([]*remoteexecution.OutputFile, 0, len(oldContents.OutputFiles)+len(entries))
+	for _, outputFile := range oldContents.OutputFiles {
+		if _, ok := replaced[getIndexEntryKey(outputFile.Path)]; !ok {
+			outputFiles = append(outputFiles, outputFile)
+		}
+	}
+	for _, entry := range entries {
+		outputFiles = append(outputFiles, &remoteexecution.OutputFile{
+			Path:   getIndexEntryPath(entry.digest, entry.offsetBytes),
+			Digest: bundleDigest.GetPartialDigest(),
+		})
+	}
+
+	// Discard the oldest entries if the bucket has grown too large.
+	// Repeated fields are encoded by concatenating their elements,
+	// meaning the size of the bucket can be computed incrementally.
+	sizeBytes := proto.Size(&remoteexecution.ActionResult{OutputFiles: outputFiles})
+	for len(outputFiles) > 0 && sizeBytes > ba.maximumIndexEntrySizeBytes {
+		sizeBytes -= proto.Size(&remoteexecution.ActionResult{OutputFiles: outputFiles[:1]})
+		outputFiles = outputFiles[1:]
+	}
+
+	if err := ba.index.Put(ctx, bucketDigest, buffer.NewACBufferFromActionResult(
+		&remoteexecution.ActionResult{OutputFiles: outputFiles},
+		buffer.UserProvided)); err != nil {
+		return util.StatusWrapf(err, "Failed to store index bucket %d", bucket)
+	}
+	return nil
+}
+
+// addSparseBundle registers a bundle that is smaller than desired.
+// Compaction is started once the sparse bundles of an instance name
+// contain enough data to fill a bundle.
+func (ba *bundlingBlobAccess) addSparseBundle(ctx context.Context, instanceName string, bundleDigest digest.Digest, entries []bundleEntry) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	if ba.compactionDisabled {
+		return
+	}
+	i := ba.getInstance(instanceName)
+	i.sparse = append(i.sparse, sparseBundle{
+		digest:  bundleDigest,
+		entries: entries,
+	})
+	i.sparseSizeBytes += bundleDigest.GetSizeBytes()
+	if !i.compacting && i.sparseSizeBytes >= ba.bundleSizeBytes {
+		i.compacting = true
+		bundles := i.sparse
+		i.sparse = nil
+		i.sparseSizeBytes = 0
+		go func() {
+			ba.compact(ctx, instanceName, bundles)
+			ba.lock.Lock()
+			i.compacting = false
+			ba.lock.Unlock()
+		}()
+	}
+}
+
+// disableCompaction stops compaction from being performed, because the
+// backend does not support deleting bundles.
+func (ba *bundlingBlobAccess) disableCompaction() {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	if !ba.compactionDisabled {
+		log.Print("Disabling compaction of bundles, as the backend does not support deleting objects")
+		ba.compactionDisabled = true
+		for _, i := range ba.instances {
+			i.sparse = nil
+			i.sparseSizeBytes = 0
+		}
+	}
+}
+
+// compact merges the contents of a list of sparse bundles into new
+// bundles. Bundles that can no longer be read (e.g., because they have
+// been evicted from the backend) are skipped.
+func (ba *bundlingBlobAccess) compact(ctx context.Context, instanceName string, bundles []sparseBundle) {
+	var data []byte
+	var entries []bundleEntry
+	var compacted []digest.Digest
+	present := map[digest.Digest]struct{}{}
+	unimplemented := false
+	flush := func() {
+		ba.bundlesWrittenCompaction.Inc()
+		if err := ba.writeBundle(ctx, instanceName, data, entries); err != nil {
+			log.Printf("Failed to write compacted bundle: %s", err)
+		} else {
+			ba.bundlesCompacted.Add(float64(len(compacted)))
+			for _, bundleDigest := range compacted {
+				if err := ba.deleter.Delete(ctx, bundleDigest); status.Code(err) == codes.Unimplemented {
+					ba.disableCompaction()
+					unimplemented = true
+					break
+				} else if err != nil {
+					log.Printf("Failed to delete compacted bundle %s: %s", bundleDigest, err)
+				}
+			}
+		}
+		data, entries, compacted = nil, nil, nil
+	}
+
+	for _, bundle := range bundles {
+		bundleData, err := ba.BlobAccess.Get(ctx, bundle.digest).ToByteSlice(int(bundle.digest.GetSizeBytes()))
+		if err != nil {
+			log.Printf("Failed to read bundle %s for compaction: %s", bundle.digest, err)
+			continue
+		}
+		for _, entry := range bundle.entries {
+			if _, ok := present[entry.digest]; ok {
+				continue
+			}
+			present[entry.digest] = struct{}{}
+			entries = append(entries, bundleEntry{
+				digest:      entry.digest,
+				offsetBytes: int64(len(data)),
+			})
+			data = append(data, bundleData[entry.offsetBytes:entry.offsetBytes+entry.digest.GetSizeBytes()]...)
+		}
+		compacted = append(compacted, bundle.digest)
+		if int64(len(data)) >= ba.bundleSizeBytes {
+			flush()
+			if unimplemented {
+				return
+			}
+		}
+	}
+	if len(entries) > 0 {
+		flush()
+	}
+}
+
+func (ba *bundlingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Small objects are only present if they have an index entry,
+	// and the bundle referenced by the index entry is present.
+	// Objects are grouped by index bucket, so that every bucket
+	// only needs to be loaded once.
+	digestsToCheck := digest.NewSetBuilder()
+	var bucketDigests []digest.Digest
+	objectsPerBucket := map[digest.Digest][]digest.Digest{}
+	for _, blobDigest := range digests.Items() {
+		if !ba.isBundled(blobDigest) {
+			digestsToCheck.Add(blobDigest)
+			continue
+		}
+		bucketDigest := ba.getIndexBucketDigest(blobDigest)
+		if _, ok := objectsPerBucket[bucketDigest]; !ok {
+			bucketDigests = append(bucketDigests, bucketDigest)
+		}
+		objectsPerBucket[bucketDigest] = append(objectsPerBucket[bucketDigest], blobDigest)
+	}
+
+	buckets := make([]*remoteexecution.ActionResult, len(bucketDigests))
+	errs := make([]error, len(bucketDigests))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, bundlingBlobAccessIndexConcurrency)
+	for i, bucketDigest := range bucketDigests {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, bucketDigest digest.Digest) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			buckets[i], errs[i] = ba.getIndexBucketContents(ctx, bucketDigest)
+		}(i, bucketDigest)
+	}
+	wg.Wait()
+
+	bundlePerObject := map[digest.Digest]digest.Digest{}
+	for i, bucketDigest := range bucketDigests {
+		if errs[i] != nil {
+			return digest.EmptySet, errs[i]
+		}
+		for _, blobDigest := range objectsPerBucket[bucketDigest] {
+			bundleDigest, _, err := lookupIndexEntry(buckets[i], blobDigest)
+			if err == nil {
+				digestsToCheck.Add(bundleDigest)
+				bundlePerObject[blobDigest] = bundleDigest
+			} else if status.Code(err) != codes.NotFound {
+				return digest.EmptySet, util.StatusWrapf(err, "Object %s", blobDigest)
+			}
+		}
+	}
+
+	missingInBackend, err := ba.BlobAccess.FindMissing(ctx, digestsToCheck.Build())
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	isMissingInBackend := map[digest.Digest]bool{}
+	for _, blobDigest := range missingInBackend.Items() {
+		isMissingInBackend[blobDigest] = true
+	}
+
+	missing := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if !ba.isBundled(blobDigest) {
+			if isMissingInBackend[blobDigest] {
+				missing.Add(blobDigest)
+			}
+		} else if bundleDigest, ok := bundlePerObject[blobDigest]; !ok || isMissingInBackend[bundleDigest] {
+			missing.Add(blobDigest)
+		}
+	}
+	return missing.Build(), nil
+}
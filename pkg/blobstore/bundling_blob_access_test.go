@@ -0,0 +1,282 @@
+package blobstore_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeBundleIndex is a simple in-memory Action Cache that is used to
+// store the index buckets of BundlingBlobAccess.
+type fakeBundleIndex struct {
+	lock    sync.Mutex
+	buckets map[digest.Digest]*remoteexecution.ActionResult
+}
+
+func newFakeBundleIndex(ctrl *gomock.Controller) (*fakeBundleIndex, blobstore.BlobAccess) {
+	index := &fakeBundleIndex{buckets: map[digest.Digest]*remoteexecution.ActionResult{}}
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+			if actionResult := index.get(blobDigest); actionResult != nil {
+				return buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable)
+			}
+			return buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))
+		}).AnyTimes()
+	blobAccess.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			actionResult, err := b.ToActionResult(1000)
+			if err != nil {
+				return err
+			}
+			index.set(blobDigest, actionResult)
+			return nil
+		}).AnyTimes()
+	return index, blobAccess
+}
+
+func (index *fakeBundleIndex) get(bucketDigest digest.Digest) *remoteexecution.ActionResult {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	return index.buckets[bucketDigest]
+}
+
+func (index *fakeBundleIndex) set(bucketDigest digest.Digest, actionResult *remoteexecution.ActionResult) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.buckets[bucketDigest] = actionResult
+}
+
+// expectBundlePut adds an expectation for a bundle to be written.
+func expectBundlePut(t *testing.T, baseBlobAccess *mock.MockBlobAccess, bundleDigest digest.Digest, expected string) {
+	baseBlobAccess.EXPECT().Put(gomock.Any(), bundleDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte(expected), data)
+			return nil
+		})
+}
+
+func TestBundlingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	baseBlobDeleter := mock.NewMockBlobDeleter(ctrl)
+	index, indexBlobAccess := newFakeBundleIndex(ctrl)
+	blobAccess := blobstore.NewBundlingBlobAccess(baseBlobAccess, baseBlobDeleter, indexBlobAccess, 5, 10, 1, 1000, "TestBundlingBlobAccess")
+
+	// As only a single bucket is used, all index entries are
+	// stored in the same bucket, which has no instance name.
+	bucketDigest := digest.MustNewDigest("", "971a5f710ccb76d12f4288a22bec4d32", 23)
+	helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	worldDigest := digest.MustNewDigest("instance", "f5a7924e621e84c9280a9a27e1bcb7f6", 5)
+	bundleDigest := digest.MustNewDigest("instance", "68e109f0f40ca72a15e05cc22786f8e6", 10)
+	largeDigest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("LargePut", func(t *testing.T) {
+		// Large objects should be forwarded as is.
+		baseBlobAccess.EXPECT().Put(ctx, largeDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("SmallPut", func(t *testing.T) {
+		// As no other bundle is being written, the object
+		// should be written immediately, without waiting for
+		// the bundle to fill up.
+		expectBundlePut(t, baseBlobAccess, helloDigest, "Hello")
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		require.True(t, proto.Equal(&remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "8b1a9953c4611296a827abf8c47804d7-5/0", Digest: helloDigest.GetPartialDigest()},
+			},
+		}, index.get(bucketDigest)))
+	})
+
+	t.Run("SmallPutWhileWriting", func(t *testing.T) {
+		// Objects that are uploaded while another bundle is
+		// being written should be written afterwards. Existing
+		// index entries for the same objects are replaced.
+		fooDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+		writing := make(chan struct{})
+		release := make(chan struct{})
+		baseBlobAccess.EXPECT().Put(gomock.Any(), fooDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				close(writing)
+				<-release
+				return nil
+			})
+		expectBundlePut(t, baseBlobAccess, helloDigest, "Hello")
+
+		errs := make(chan error, 2)
+		go func() {
+			errs <- blobAccess.Put(ctx, fooDigest, buffer.NewValidatedBufferFromByteSlice([]byte("foo")))
+		}()
+		<-writing
+		go func() {
+			errs <- blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		}()
+		close(release)
+		require.NoError(t, <-errs)
+		require.NoError(t, <-errs)
+
+		actionResult := index.get(bucketDigest)
+		require.Len(t, actionResult.OutputFiles, 2)
+		require.ElementsMatch(t, []string{
+			"8b1a9953c4611296a827abf8c47804d7-5/0",
+			"acbd18db4cc2f85cedef654fccc4a4d8-3/0",
+		}, []string{
+			actionResult.OutputFiles[0].Path,
+			actionResult.OutputFiles[1].Path,
+		})
+	})
+
+	t.Run("SmallPutBackendFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(gomock.Any(), worldDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Internal, "Server on fire")
+			})
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Failed to store bundle: Server on fire"),
+			blobAccess.Put(ctx, worldDigest, buffer.NewValidatedBufferFromByteSlice([]byte("World"))))
+	})
+
+	// Replace the contents of the index with a bundle containing
+	// both objects.
+	index.set(bucketDigest, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "8b1a9953c4611296a827abf8c47804d7-5/0", Digest: bundleDigest.GetPartialDigest()},
+			{Path: "f5a7924e621e84c9280a9a27e1bcb7f6-5/5", Digest: bundleDigest.GetPartialDigest()},
+		},
+	})
+
+	t.Run("SmallGet", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, bundleDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("HelloWorld")))
+
+		data, err := blobAccess.Get(ctx, worldDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("World"), data)
+	})
+
+	t.Run("SmallGetOtherInstance", func(t *testing.T) {
+		// The index is shared by all instance names.
+		otherWorldDigest := digest.MustNewDigest("other", "f5a7924e621e84c9280a9a27e1bcb7f6", 5)
+		otherBundleDigest := digest.MustNewDigest("other", "68e109f0f40ca72a15e05cc22786f8e6", 10)
+		baseBlobAccess.EXPECT().Get(ctx, otherBundleDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("HelloWorld")))
+
+		data, err := blobAccess.Get(ctx, otherWorldDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("World"), data)
+	})
+
+	t.Run("SmallGetNotFound", func(t *testing.T) {
+		smallDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+
+		_, err := blobAccess.Get(ctx, smallDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// The object without an index entry should be reported
+		// as missing, as well as the object whose bundle is
+		// absent.
+		missingBundleDigest := digest.MustNewDigest("instance", "6df23dc03f9b54cc38a0fc1483df6e21", 9)
+		index.set(bucketDigest, &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "8b1a9953c4611296a827abf8c47804d7-5/0", Digest: bundleDigest.GetPartialDigest()},
+				{Path: "f5a7924e621e84c9280a9a27e1bcb7f6-5/0", Digest: missingBundleDigest.GetPartialDigest()},
+			},
+		})
+		smallDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+		baseBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(bundleDigest).Add(missingBundleDigest).Add(largeDigest).Build(),
+		).Return(digest.NewSetBuilder().Add(missingBundleDigest).Build(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(helloDigest).Add(worldDigest).Add(smallDigest).Add(largeDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(worldDigest).Add(smallDigest).Build(), missing)
+	})
+
+	t.Run("SmallGetInvalidIndexEntry", func(t *testing.T) {
+		index.set(bucketDigest, &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "f5a7924e621e84c9280a9a27e1bcb7f6-5/6", Digest: bundleDigest.GetPartialDigest()},
+			},
+		})
+
+		_, err := blobAccess.Get(ctx, worldDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Index entry refers to data beyond the end of bundle 68e109f0f40ca72a15e05cc22786f8e6-10-instance"), err)
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		// Write three objects, each of them ending up in their
+		// own bundle, as no other bundles are being written. As
+		// these bundles together contain enough data to fill a
+		// bundle, they should be merged. The original bundles
+		// should be deleted afterwards.
+		_, indexBlobAccess := newFakeBundleIndex(ctrl)
+		blobAccess := blobstore.NewBundlingBlobAccess(baseBlobAccess, baseBlobDeleter, indexBlobAccess, 5, 8, 1, 1000, "TestBundlingBlobAccess")
+		fooDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+		barDigest := digest.MustNewDigest("instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+		bazDigest := digest.MustNewDigest("instance", "73feffa4b7f6bb68e44cf984c85f6e88", 3)
+		blobs := []struct {
+			digest digest.Digest
+			data   string
+		}{
+			{fooDigest, "foo"},
+			{barDigest, "bar"},
+			{bazDigest, "baz"},
+		}
+		for _, blob := range blobs {
+			expectBundlePut(t, baseBlobAccess, blob.digest, blob.data)
+		}
+		baseBlobAccess.EXPECT().Get(gomock.Any(), fooDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("foo")))
+		baseBlobAccess.EXPECT().Get(gomock.Any(), barDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("bar")))
+		baseBlobAccess.EXPECT().Get(gomock.Any(), bazDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("baz")))
+		compactedDigest := digest.MustNewDigest("instance", "6df23dc03f9b54cc38a0fc1483df6e21", 9)
+		expectBundlePut(t, baseBlobAccess, compactedDigest, "foobarbaz")
+		baseBlobDeleter.EXPECT().Delete(gomock.Any(), fooDigest)
+		baseBlobDeleter.EXPECT().Delete(gomock.Any(), barDigest)
+		compactionDone := make(chan struct{})
+		baseBlobDeleter.EXPECT().Delete(gomock.Any(), bazDigest).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest) error {
+				close(compactionDone)
+				return nil
+			})
+
+		for _, blob := range blobs {
+			require.NoError(t, blobAccess.Put(ctx, blob.digest, buffer.NewValidatedBufferFromByteSlice([]byte(blob.data))))
+		}
+		<-compactionDone
+
+		// Objects should now be read from the compacted bundle.
+		baseBlobAccess.EXPECT().Get(ctx, compactedDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("foobarbaz")))
+		data, err := blobAccess.Get(ctx, barDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("bar"), data)
+	})
+}
@@ -13,6 +13,10 @@ func (f casStorageType) GetDigestKey(blobDigest digest.Digest) string {
 	return blobDigest.GetKey(digest.KeyWithoutInstance)
 }
 
+func (f casStorageType) ParseDigestKey(key string) (digest.Digest, error) {
+	return digest.NewDigestFromKey(key, digest.KeyWithoutInstance)
+}
+
 func (f casStorageType) NewBufferFromByteSlice(digest digest.Digest, data []byte, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewCASBufferFromByteSlice(digest, data, repairStrategy)
 }
@@ -21,6 +25,10 @@ func (f casStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadClose
 	return buffer.NewCASBufferFromReader(digest, r, repairStrategy)
 }
 
+func (f casStorageType) NewBufferFromReaderAt(digest digest.Digest, r buffer.ReadAtCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	return buffer.NewCASBufferFromReaderAt(digest, r, repairStrategy)
+}
+
 // CASStorageType is capable of creating identifiers and buffers for
 // objects stored in the Content Addressable Storage (CAS).
 var CASStorageType StorageType = casStorageType{}
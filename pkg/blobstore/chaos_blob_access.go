@@ -0,0 +1,215 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	chaosBlobAccessPrometheusMetrics sync.Once
+
+	chaosBlobAccessInjectedFaults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "chaos_blob_access_injected_faults_total",
+			Help:      "Number of faults injected into operations by ChaosBlobAccess.",
+		},
+		[]string{"name", "operation", "fault"})
+)
+
+// FaultInjectionConfiguration contains the probabilities at which
+// faults are injected by ChaosBlobAccess into a single type of
+// operation. Probabilities lie in [0.0, 1.0].
+type FaultInjectionConfiguration struct {
+	// Probability at which an operation is delayed, and the amount
+	// of time by which it is delayed.
+	LatencyProbability float64
+	Latency            time.Duration
+	// Probability at which an operation fails without being
+	// forwarded to the backend, and the error code it fails with.
+	ErrorProbability float64
+	ErrorCode        codes.Code
+}
+
+// ChaosConfiguration contains the faults that are injected by
+// ChaosBlobAccess.
+type ChaosConfiguration struct {
+	Get         FaultInjectionConfiguration
+	Put         FaultInjectionConfiguration
+	FindMissing FaultInjectionConfiguration
+	// Probability at which an object returned by Get() is truncated
+	// at a random offset.
+	TruncationProbability float64
+	// Probability at which a single byte at a random offset of an
+	// object returned by Get() is corrupted.
+	CorruptionProbability float64
+}
+
+type chaosFaultCounters struct {
+	latency prometheus.Counter
+	err     prometheus.Counter
+}
+
+func newChaosFaultCounters(name string, operation string) chaosFaultCounters {
+	return chaosFaultCounters{
+		latency: chaosBlobAccessInjectedFaults.WithLabelValues(name, operation, "Latency"),
+		err:     chaosBlobAccessInjectedFaults.WithLabelValues(name, operation, "Error"),
+	}
+}
+
+type chaosBlobAccess struct {
+	blobAccess    BlobAccess
+	storageType   StorageType
+	clock         clock.Clock
+	configuration ChaosConfiguration
+
+	getFaults         chaosFaultCounters
+	putFaults         chaosFaultCounters
+	findMissingFaults chaosFaultCounters
+	getTruncated      prometheus.Counter
+	getCorrupted      prometheus.Counter
+}
+
+// NewChaosBlobAccess creates a decorator for BlobAccess that injects
+// faults into operations at random, such as additional latency, errors,
+// and truncated or corrupted data.
+//
+// This decorator is intended to be used in staging environments, to
+// verify that decorators that are supposed to deal with such failures
+// (e.g., RetryingBlobAccess, MirroredBlobAccess and checksum
+// validation) actually do so. It should never be used in production.
+//
+// Truncated and corrupted data is returned through buffers created
+// using the provided storage type, meaning that it is subject to
+// regular validation. Such buffers are irreparable, as the data stored
+// in the backend itself is intact.
+func NewChaosBlobAccess(blobAccess BlobAccess, storageType StorageType, clock clock.Clock, configuration ChaosConfiguration, name string) BlobAccess {
+	chaosBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(chaosBlobAccessInjectedFaults)
+	})
+
+	return &chaosBlobAccess{
+		blobAccess:    blobAccess,
+		storageType:   storageType,
+		clock:         clock,
+		configuration: configuration,
+
+		getFaults:         newChaosFaultCounters(name, "Get"),
+		putFaults:         newChaosFaultCounters(name, "Put"),
+		findMissingFaults: newChaosFaultCounters(name, "FindMissing"),
+		getTruncated:      chaosBlobAccessInjectedFaults.WithLabelValues(name, "Get", "Truncation"),
+		getCorrupted:      chaosBlobAccessInjectedFaults.WithLabelValues(name, "Get", "Corruption"),
+	}
+}
+
+// injectFaults delays an operation or lets it fail, as determined by a
+// fault injection configuration. If an error is returned, the
+// operation should not be forwarded to the backend.
+func (ba *chaosBlobAccess) injectFaults(ctx context.Context, configuration *FaultInjectionConfiguration, counters *chaosFaultCounters) error {
+	if configuration.LatencyProbability > 0 && rand.Float64() < configuration.LatencyProbability {
+		counters.latency.Inc()
+		timer, t := ba.clock.NewTimer(configuration.Latency)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			return util.StatusFromContext(ctx)
+		}
+	}
+	if configuration.ErrorProbability > 0 && rand.Float64() < configuration.ErrorProbability {
+		counters.err.Inc()
+		return status.Error(configuration.ErrorCode, "Fault injected by chaos testing")
+	}
+	return nil
+}
+
+func (ba *chaosBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.injectFaults(ctx, &ba.configuration.Get, &ba.getFaults); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	b := ba.blobAccess.Get(ctx, digest)
+
+	truncate := ba.configuration.TruncationProbability > 0 && rand.Float64() < ba.configuration.TruncationProbability
+	corrupt := ba.configuration.CorruptionProbability > 0 && rand.Float64() < ba.configuration.CorruptionProbability
+	if !truncate && !corrupt {
+		return b
+	}
+	sizeBytes, err := b.GetSizeBytes()
+	if err != nil || sizeBytes == 0 {
+		// Buffer is in an error state, or has no data to tamper
+		// with.
+		return b
+	}
+	r := &chaosReader{
+		r:                b.ToReader(),
+		truncationOffset: -1,
+		corruptionOffset: -1,
+	}
+	if truncate {
+		ba.getTruncated.Inc()
+		r.truncationOffset = rand.Int63n(sizeBytes)
+	}
+	if corrupt {
+		ba.getCorrupted.Inc()
+		r.corruptionOffset = rand.Int63n(sizeBytes)
+	}
+	return ba.storageType.NewBufferFromReader(digest, r, buffer.Irreparable)
+}
+
+func (ba *chaosBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.injectFaults(ctx, &ba.configuration.Put, &ba.putFaults); err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.blobAccess.Put(ctx, digest, b)
+}
+
+func (ba *chaosBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if err := ba.injectFaults(ctx, &ba.configuration.FindMissing, &ba.findMissingFaults); err != nil {
+		return digest.EmptySet, err
+	}
+	return ba.blobAccess.FindMissing(ctx, digests)
+}
+
+// chaosReader is a decorator for io.ReadCloser that truncates and
+// corrupts data at fixed offsets. Offsets that are negative are
+// ignored.
+type chaosReader struct {
+	r                io.ReadCloser
+	offset           int64
+	truncationOffset int64
+	corruptionOffset int64
+}
+
+func (r *chaosReader) Read(p []byte) (int, error) {
+	if r.truncationOffset >= 0 {
+		if remaining := r.truncationOffset - r.offset; remaining <= 0 {
+			return 0, io.EOF
+		} else if int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := r.r.Read(p)
+	if i := r.corruptionOffset - r.offset; i >= 0 && i < int64(n) {
+		p[i] ^= 0xff
+	}
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *chaosReader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,117 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChaosBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := digest.NewSetBuilder().Add(blobDigest).Build()
+
+	t.Run("NoFaults", func(t *testing.T) {
+		// Without any faults configured, operations should be
+		// forwarded as is.
+		blobAccess := blobstore.NewChaosBlobAccess(baseBlobAccess, blobstore.CASStorageType, clock, blobstore.ChaosConfiguration{}, "cas")
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digests, nil)
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digests, missing)
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		blobAccess := blobstore.NewChaosBlobAccess(baseBlobAccess, blobstore.CASStorageType, clock, blobstore.ChaosConfiguration{
+			FindMissing: blobstore.FaultInjectionConfiguration{
+				LatencyProbability: 1.0,
+				Latency:            time.Second,
+			},
+		}, "cas")
+
+		timerChannel := make(chan time.Time, 1)
+		timerChannel <- time.Unix(1000, 0)
+		clock.EXPECT().NewTimer(time.Second).Return(nil, timerChannel)
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("LatencyCancelled", func(t *testing.T) {
+		// Cancelling the context should interrupt the delay.
+		blobAccess := blobstore.NewChaosBlobAccess(baseBlobAccess, blobstore.CASStorageType, clock, blobstore.ChaosConfiguration{
+			Get: blobstore.FaultInjectionConfiguration{
+				LatencyProbability: 1.0,
+				Latency:            time.Second,
+			},
+		}, "cas")
+
+		ctxWithCancel, cancel := context.WithCancel(ctx)
+		cancel()
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, make(chan time.Time))
+		timer.EXPECT().Stop().Return(true)
+
+		_, err := blobAccess.Get(ctxWithCancel, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		// Failing operations should not be forwarded.
+		blobAccess := blobstore.NewChaosBlobAccess(baseBlobAccess, blobstore.CASStorageType, clock, blobstore.ChaosConfiguration{
+			Put: blobstore.FaultInjectionConfiguration{
+				ErrorProbability: 1.0,
+				ErrorCode:        codes.Unavailable,
+			},
+		}, "cas")
+
+		err := blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		require.Equal(t, status.Error(codes.Unavailable, "Fault injected by chaos testing"), err)
+	})
+
+	t.Run("Truncation", func(t *testing.T) {
+		// Truncated data should be caught by checksum
+		// validation.
+		blobAccess := blobstore.NewChaosBlobAccess(baseBlobAccess, blobstore.CASStorageType, clock, blobstore.ChaosConfiguration{
+			TruncationProbability: 1.0,
+		}, "cas")
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("Corruption", func(t *testing.T) {
+		// Corrupted data should be caught by checksum
+		// validation.
+		blobAccess := blobstore.NewChaosBlobAccess(baseBlobAccess, blobstore.CASStorageType, clock, blobstore.ChaosConfiguration{
+			CorruptionProbability: 1.0,
+		}, "cas")
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+}
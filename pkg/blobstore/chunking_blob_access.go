@@ -0,0 +1,303 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// chunkingBlobAccessReadChunkSizeBytes is the size of the chunks of
+// data returned when reading chunks from the backend.
+const chunkingBlobAccessReadChunkSizeBytes = 1 << 16
+
+type chunkingBlobAccess struct {
+	base                     BlobAccess
+	manifests                BlobAccess
+	minimumSizeBytes         int64
+	chunkSizeBytes           int64
+	concurrency              int
+	maximumManifestSizeBytes int
+}
+
+// NewChunkingBlobAccess creates a decorator for the Content
+// Addressable Storage that splits objects above a given size into
+// fixed-size chunks. The chunks are stored in the backend as separate
+// objects, keyed by their own digests. A manifest listing the digests
+// of the chunks is stored in a separate backend, keyed by the digest
+// of the original object. Upon retrieval, the chunks are reassembled
+// and validated against the digest of the original object.
+//
+// This can be used to work around per-object size limits of storage
+// backends (e.g., Redis and S3), and permits chunks to be uploaded in
+// parallel. As chunks are content addressed, identical chunks of
+// different objects are only stored once.
+//
+// Manifests are encoded as ActionResult messages, where every chunk
+// is listed as an output file. This allows any backend that is capable
+// of storing Action Cache entries to be used to store manifests. As the
+// Content Addressable Storage is not partitioned by instance name,
+// manifests are keyed by digests that have their instance name
+// cleared.
+func NewChunkingBlobAccess(base BlobAccess, manifests BlobAccess, minimumSizeBytes int64, chunkSizeBytes int64, concurrency int, maximumManifestSizeBytes int) BlobAccess {
+	return &chunkingBlobAccess{
+		base:                     base,
+		manifests:                manifests,
+		minimumSizeBytes:         minimumSizeBytes,
+		chunkSizeBytes:           chunkSizeBytes,
+		concurrency:              concurrency,
+		maximumManifestSizeBytes: maximumManifestSizeBytes,
+	}
+}
+
+func (ba *chunkingBlobAccess) isChunked(blobDigest digest.Digest) bool {
+	return blobDigest.GetSizeBytes() >= ba.minimumSizeBytes
+}
+
+// getChunkDigests loads the manifest of a chunked object, returning
+// the digests of its chunks.
+func (ba *chunkingBlobAccess) getChunkDigests(ctx context.Context, blobDigest digest.Digest) ([]digest.Digest, error) {
+	manifest, err := ba.manifests.Get(ctx, blobDigest.WithoutInstance()).ToActionResult(ba.maximumManifestSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, err
+		}
+		return nil, util.StatusWrap(err, "Failed to load manifest")
+	}
+	chunkDigests := make([]digest.Digest, 0, len(manifest.OutputFiles))
+	totalSizeBytes := int64(0)
+	for i, outputFile := range manifest.OutputFiles {
+		chunkDigest, err := blobDigest.NewDerivedDigest(outputFile.Digest)
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.Internal, "Manifest contains invalid digest for chunk %d", i)
+		}
+		chunkDigests = append(chunkDigests, chunkDigest)
+		totalSizeBytes += chunkDigest.GetSizeBytes()
+	}
+	if totalSizeBytes != blobDigest.GetSizeBytes() {
+		return nil, status.Errorf(codes.Internal, "Manifest contains chunks with a total size of %d bytes, while %d bytes were expected", totalSizeBytes, blobDigest.GetSizeBytes())
+	}
+	return chunkDigests, nil
+}
+
+func (ba *chunkingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if !ba.isChunked(blobDigest) {
+		return ba.base.Get(ctx, blobDigest)
+	}
+	chunkDigests, err := ba.getChunkDigests(ctx, blobDigest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewCASBufferFromChunkReader(
+		blobDigest,
+		&concatenatingChunkReader{
+			ctx:          ctx,
+			base:         ba.base,
+			chunkDigests: chunkDigests,
+		},
+		buffer.Irreparable)
+}
+
+func (ba *chunkingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if !ba.isChunked(blobDigest) {
+		return ba.base.Put(ctx, blobDigest, b)
+	}
+
+	r := b.ToReader()
+	defer r.Close()
+
+	// Split the object into chunks and upload them in parallel.
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	setErr := func(err error) {
+		errLock.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		errLock.Unlock()
+	}
+	semaphore := make(chan struct{}, ba.concurrency)
+	var outputFiles []*remoteexecution.OutputFile
+ChunkLoop:
+	for remaining := blobDigest.GetSizeBytes(); remaining > 0; {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctxWithCancel.Done():
+			break ChunkLoop
+		}
+
+		chunkSizeBytes := ba.chunkSizeBytes
+		if chunkSizeBytes > remaining {
+			chunkSizeBytes = remaining
+		}
+		remaining -= chunkSizeBytes
+		data := make([]byte, chunkSizeBytes)
+		if _, err := io.ReadFull(r, data); err != nil {
+			<-semaphore
+			setErr(err)
+			break
+		}
+		generator := blobDigest.NewGenerator()
+		generator.Write(data)
+		chunkDigest := generator.Sum()
+		outputFiles = append(outputFiles, &remoteexecution.OutputFile{
+			Path:   fmt.Sprintf("%d", len(outputFiles)),
+			Digest: chunkDigest.GetPartialDigest(),
+		})
+
+		wg.Add(1)
+		go func(chunkIndex int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := ba.base.Put(ctxWithCancel, chunkDigest, buffer.NewValidatedBufferFromByteSlice(data)); err != nil {
+				setErr(util.StatusWrapf(err, "Failed to store chunk %d", chunkIndex))
+			}
+		}(len(outputFiles) - 1)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if ctx.Err() != nil {
+		return util.StatusFromContext(ctx)
+	}
+
+	// Only store the manifest after all chunks have been stored, so
+	// that incomplete objects never become visible.
+	if err := ba.manifests.Put(ctx, blobDigest.WithoutInstance(), buffer.NewACBufferFromActionResult(
+		&remoteexecution.ActionResult{OutputFiles: outputFiles},
+		buffer.UserProvided)); err != nil {
+		return util.StatusWrap(err, "Failed to store manifest")
+	}
+	return nil
+}
+
+func (ba *chunkingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Objects that are chunked are only present if their manifest
+	// and all of their chunks are present. Load the manifests in
+	// parallel, as every manifest requires a separate round trip.
+	digestsToCheck := digest.NewSetBuilder()
+	var chunkedDigests []digest.Digest
+	for _, blobDigest := range digests.Items() {
+		if ba.isChunked(blobDigest) {
+			chunkedDigests = append(chunkedDigests, blobDigest)
+		} else {
+			digestsToCheck.Add(blobDigest)
+		}
+	}
+
+	chunkDigestsList := make([][]digest.Digest, len(chunkedDigests))
+	errs := make([]error, len(chunkedDigests))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, ba.concurrency)
+	for i, blobDigest := range chunkedDigests {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, blobDigest digest.Digest) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			chunkDigestsList[i], errs[i] = ba.getChunkDigests(ctx, blobDigest)
+		}(i, blobDigest)
+	}
+	wg.Wait()
+
+	chunkDigestsPerObject := map[digest.Digest][]digest.Digest{}
+	for i, blobDigest := range chunkedDigests {
+		if err := errs[i]; err == nil {
+			for _, chunkDigest := range chunkDigestsList[i] {
+				digestsToCheck.Add(chunkDigest)
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return digest.EmptySet, util.StatusWrapf(err, "Object %s", blobDigest)
+		}
+		chunkDigestsPerObject[blobDigest] = chunkDigestsList[i]
+	}
+
+	missingInBackend, err := ba.base.FindMissing(ctx, digestsToCheck.Build())
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	isMissingInBackend := map[digest.Digest]bool{}
+	for _, blobDigest := range missingInBackend.Items() {
+		isMissingInBackend[blobDigest] = true
+	}
+
+	missing := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if !ba.isChunked(blobDigest) {
+			if isMissingInBackend[blobDigest] {
+				missing.Add(blobDigest)
+			}
+			continue
+		}
+		chunkDigests := chunkDigestsPerObject[blobDigest]
+		if chunkDigests == nil {
+			// Manifest is absent.
+			missing.Add(blobDigest)
+			continue
+		}
+		for _, chunkDigest := range chunkDigests {
+			if isMissingInBackend[chunkDigest] {
+				missing.Add(blobDigest)
+				break
+			}
+		}
+	}
+	return missing.Build(), nil
+}
+
+// concatenatingChunkReader is a ChunkReader that returns the contents
+// of a sequence of chunks stored in a backend.
+type concatenatingChunkReader struct {
+	ctx          context.Context
+	base         BlobAccess
+	chunkDigests []digest.Digest
+
+	current    buffer.ChunkReader
+	chunkIndex int
+}
+
+func (r *concatenatingChunkReader) Read() ([]byte, error) {
+	for {
+		if r.current == nil {
+			if r.chunkIndex >= len(r.chunkDigests) {
+				return nil, io.EOF
+			}
+			r.current = r.base.Get(r.ctx, r.chunkDigests[r.chunkIndex]).ToChunkReader(0, chunkingBlobAccessReadChunkSizeBytes)
+		}
+		data, err := r.current.Read()
+		if err == nil {
+			return data, nil
+		}
+		r.current.Close()
+		r.current = nil
+		if err != io.EOF {
+			return nil, util.StatusWrapf(err, "Failed to read chunk %d", r.chunkIndex)
+		}
+		r.chunkIndex++
+	}
+}
+
+func (r *concatenatingChunkReader) Close() {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+}
@@ -0,0 +1,150 @@
+package blobstore_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChunkingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	manifestsBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewChunkingBlobAccess(baseBlobAccess, manifestsBlobAccess, 10, 5, 2, 1000)
+
+	smallDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	largeDigest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	chunk1Digest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	chunk2Digest := digest.MustNewDigest("instance", "b81a02d933daa824c9b06de373b77d70", 5)
+	chunk3Digest := digest.MustNewDigest("instance", "8277e0910d750195b448797616e091ad", 1)
+	largeManifestDigest := digest.MustNewDigest("", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	manifest := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "0", Digest: chunk1Digest.GetPartialDigest()},
+			{Path: "1", Digest: chunk2Digest.GetPartialDigest()},
+			{Path: "2", Digest: chunk3Digest.GetPartialDigest()},
+		},
+	}
+
+	t.Run("SmallGet", func(t *testing.T) {
+		// Small objects should be forwarded as is.
+		baseBlobAccess.EXPECT().Get(ctx, smallDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, smallDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("LargePut", func(t *testing.T) {
+		var lock sync.Mutex
+		chunks := map[digest.Digest]string{}
+		baseBlobAccess.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				lock.Lock()
+				chunks[blobDigest] = string(data)
+				lock.Unlock()
+				return nil
+			}).Times(3)
+		manifestsBlobAccess.EXPECT().Put(ctx, largeManifestDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				actionResult, err := b.ToActionResult(1000)
+				require.NoError(t, err)
+				require.True(t, proto.Equal(manifest, actionResult))
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+		require.Equal(t, map[digest.Digest]string{
+			chunk1Digest: "Hello",
+			chunk2Digest: " worl",
+			chunk3Digest: "d",
+		}, chunks)
+	})
+
+	t.Run("LargePutChunkFailure", func(t *testing.T) {
+		// No manifest should be written if storing a chunk fails.
+		baseBlobAccess.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				if blobDigest == chunk2Digest {
+					return status.Error(codes.Internal, "Server on fire")
+				}
+				return nil
+			}).MinTimes(2).MaxTimes(3)
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Failed to store chunk 1: Server on fire"),
+			blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("LargeGet", func(t *testing.T) {
+		manifestsBlobAccess.EXPECT().Get(ctx, largeManifestDigest).Return(buffer.NewACBufferFromActionResult(manifest, buffer.Irreparable))
+		baseBlobAccess.EXPECT().Get(ctx, chunk1Digest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		baseBlobAccess.EXPECT().Get(ctx, chunk2Digest).Return(buffer.NewValidatedBufferFromByteSlice([]byte(" worl")))
+		baseBlobAccess.EXPECT().Get(ctx, chunk3Digest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("d")))
+
+		data, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("LargeGetNotFound", func(t *testing.T) {
+		manifestsBlobAccess.EXPECT().Get(ctx, largeManifestDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("LargeGetInvalidManifest", func(t *testing.T) {
+		manifestsBlobAccess.EXPECT().Get(ctx, largeManifestDigest).Return(buffer.NewACBufferFromActionResult(
+			&remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "0", Digest: chunk1Digest.GetPartialDigest()},
+				},
+			},
+			buffer.Irreparable))
+
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Manifest contains chunks with a total size of 5 bytes, while 11 bytes were expected"), err)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// The large object should be reported as missing, as
+		// one of its chunks is absent.
+		manifestsBlobAccess.EXPECT().Get(ctx, largeManifestDigest).Return(buffer.NewACBufferFromActionResult(manifest, buffer.Irreparable))
+		baseBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(smallDigest).Add(chunk2Digest).Add(chunk3Digest).Build(),
+		).Return(digest.NewSetBuilder().Add(chunk3Digest).Build(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(smallDigest).Add(largeDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(largeDigest).Build(), missing)
+	})
+
+	t.Run("FindMissingNoManifest", func(t *testing.T) {
+		manifestsBlobAccess.EXPECT().Get(ctx, largeManifestDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest.EmptySet).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(largeDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(largeDigest).Build(), missing)
+	})
+}
@@ -0,0 +1,260 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	circuitBreakingBlobAccessPrometheusMetrics sync.Once
+
+	circuitBreakingBlobAccessStateTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "circuit_breaking_blob_access_state_transitions_total",
+			Help:      "Number of times the circuit breaker transitioned to a given state.",
+		},
+		[]string{"name", "state"})
+	circuitBreakingBlobAccessRejectedOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "circuit_breaking_blob_access_rejected_operations_total",
+			Help:      "Number of operations that failed immediately, due to the circuit breaker being open.",
+		},
+		[]string{"name", "operation"})
+)
+
+type circuitBreakerState int
+
+const (
+	circuitBreakerStateClosed circuitBreakerState = iota
+	circuitBreakerStateOpen
+	circuitBreakerStateHalfOpen
+)
+
+// CircuitBreakerConfiguration contains the parameters that determine
+// when the circuit breaker of CircuitBreakingBlobAccess trips.
+type CircuitBreakerConfiguration struct {
+	// The duration of the window over which the failure rate is
+	// computed.
+	Window time.Duration
+	// The minimum number of operations that need to be performed
+	// within a window before the circuit breaker may trip.
+	MinimumOperations int
+	// The fraction of operations within a window that need to fail
+	// for the circuit breaker to trip.
+	FailureRateThreshold float64
+	// If non-zero, FindMissing() calls that take longer than this
+	// duration are considered to have failed.
+	LatencyThreshold time.Duration
+	// The amount of time the circuit breaker remains open before a
+	// single probing operation is let through.
+	OpenDuration time.Duration
+}
+
+type circuitBreakingBlobAccess struct {
+	blobAccess    BlobAccess
+	clock         clock.Clock
+	configuration CircuitBreakerConfiguration
+
+	lock        sync.Mutex
+	state       circuitBreakerState
+	windowStart time.Time
+	operations  int
+	failures    int
+	openUntil   time.Time
+
+	closedTransitions   prometheus.Counter
+	openTransitions     prometheus.Counter
+	halfOpenTransitions prometheus.Counter
+	getRejected         prometheus.Counter
+	putRejected         prometheus.Counter
+	findMissingRejected prometheus.Counter
+}
+
+// NewCircuitBreakingBlobAccess creates a decorator for BlobAccess that
+// stops forwarding operations to the backend when it fails too often.
+// While the circuit breaker is open, operations fail immediately with
+// UNAVAILABLE. After a configured amount of time, a single operation is
+// let through to probe whether the backend has recovered.
+//
+// This decorator is useful in combination with ShardingBlobAccess.
+// Without it, a single unresponsive shard causes all FindMissing()
+// calls to block until they time out.
+//
+// Only errors that indicate that the backend is malfunctioning (e.g.,
+// UNAVAILABLE and DEADLINE_EXCEEDED) are counted as failures. Errors
+// such as NOT_FOUND are part of normal operation.
+func NewCircuitBreakingBlobAccess(blobAccess BlobAccess, clock clock.Clock, configuration CircuitBreakerConfiguration, name string) BlobAccess {
+	circuitBreakingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(circuitBreakingBlobAccessStateTransitions)
+		prometheus.MustRegister(circuitBreakingBlobAccessRejectedOperations)
+	})
+
+	return &circuitBreakingBlobAccess{
+		blobAccess:    blobAccess,
+		clock:         clock,
+		configuration: configuration,
+
+		closedTransitions:   circuitBreakingBlobAccessStateTransitions.WithLabelValues(name, "Closed"),
+		openTransitions:     circuitBreakingBlobAccessStateTransitions.WithLabelValues(name, "Open"),
+		halfOpenTransitions: circuitBreakingBlobAccessStateTransitions.WithLabelValues(name, "HalfOpen"),
+		getRejected:         circuitBreakingBlobAccessRejectedOperations.WithLabelValues(name, "Get"),
+		putRejected:         circuitBreakingBlobAccessRejectedOperations.WithLabelValues(name, "Put"),
+		findMissingRejected: circuitBreakingBlobAccessRejectedOperations.WithLabelValues(name, "FindMissing"),
+	}
+}
+
+// isCircuitBreakerFailure returns whether an error returned by the
+// backend indicates that the backend is malfunctioning.
+func isCircuitBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Internal, codes.Unavailable, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// allow returns whether an operation may be forwarded to the backend,
+// and whether the operation is used to probe the backend while the
+// circuit breaker is half open.
+func (ba *circuitBreakingBlobAccess) allow() (bool, bool) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	switch ba.state {
+	case circuitBreakerStateClosed:
+		return true, false
+	case circuitBreakerStateOpen:
+		if ba.clock.Now().Before(ba.openUntil) {
+			return false, false
+		}
+		// Let a single operation through to probe the backend.
+		ba.state = circuitBreakerStateHalfOpen
+		ba.halfOpenTransitions.Inc()
+		return true, true
+	default:
+		// A probing operation is already in flight.
+		return false, false
+	}
+}
+
+// report the outcome of an operation that was forwarded to the
+// backend.
+func (ba *circuitBreakingBlobAccess) report(probe bool, failed bool) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	now := ba.clock.Now()
+	switch ba.state {
+	case circuitBreakerStateClosed:
+		if now.Sub(ba.windowStart) >= ba.configuration.Window {
+			ba.resetWindow(now)
+		}
+		ba.operations++
+		if failed {
+			ba.failures++
+		}
+		if ba.operations >= ba.configuration.MinimumOperations &&
+			float64(ba.failures) >= ba.configuration.FailureRateThreshold*float64(ba.operations) {
+			ba.open(now)
+		}
+	case circuitBreakerStateHalfOpen:
+		// Ignore operations that were started before the
+		// circuit breaker tripped.
+		if !probe {
+			return
+		}
+		if failed {
+			ba.open(now)
+		} else {
+			ba.state = circuitBreakerStateClosed
+			ba.closedTransitions.Inc()
+			ba.resetWindow(now)
+		}
+	}
+}
+
+func (ba *circuitBreakingBlobAccess) resetWindow(now time.Time) {
+	ba.windowStart = now
+	ba.operations = 0
+	ba.failures = 0
+}
+
+func (ba *circuitBreakingBlobAccess) open(now time.Time) {
+	ba.state = circuitBreakerStateOpen
+	ba.openTransitions.Inc()
+	ba.openUntil = now.Add(ba.configuration.OpenDuration)
+}
+
+func newCircuitBreakerOpenError() error {
+	return status.Error(codes.Unavailable, "Circuit breaker is open, as the backend failed too often recently")
+}
+
+func (ba *circuitBreakingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	allowed, probe := ba.allow()
+	if !allowed {
+		ba.getRejected.Inc()
+		return buffer.NewBufferFromError(newCircuitBreakerOpenError())
+	}
+	return buffer.WithErrorHandler(
+		ba.blobAccess.Get(ctx, digest),
+		&circuitBreakingErrorHandler{
+			blobAccess: ba,
+			probe:      probe,
+		})
+}
+
+func (ba *circuitBreakingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	allowed, probe := ba.allow()
+	if !allowed {
+		ba.putRejected.Inc()
+		b.Discard()
+		return newCircuitBreakerOpenError()
+	}
+	err := ba.blobAccess.Put(ctx, digest, b)
+	ba.report(probe, isCircuitBreakerFailure(err))
+	return err
+}
+
+func (ba *circuitBreakingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	allowed, probe := ba.allow()
+	if !allowed {
+		ba.findMissingRejected.Inc()
+		return digest.EmptySet, newCircuitBreakerOpenError()
+	}
+	timeStart := ba.clock.Now()
+	missing, err := ba.blobAccess.FindMissing(ctx, digests)
+	latencyThreshold := ba.configuration.LatencyThreshold
+	ba.report(probe, isCircuitBreakerFailure(err) || (latencyThreshold > 0 && ba.clock.Now().Sub(timeStart) > latencyThreshold))
+	return missing, err
+}
+
+type circuitBreakingErrorHandler struct {
+	blobAccess *circuitBreakingBlobAccess
+	probe      bool
+	failed     bool
+}
+
+func (eh *circuitBreakingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if isCircuitBreakerFailure(err) {
+		eh.failed = true
+	}
+	return nil, err
+}
+
+func (eh *circuitBreakingErrorHandler) Done() {
+	eh.blobAccess.report(eh.probe, eh.failed)
+}
@@ -0,0 +1,100 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreakingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewCircuitBreakingBlobAccess(
+		baseBlobAccess,
+		clock,
+		blobstore.CircuitBreakerConfiguration{
+			Window:               time.Minute,
+			MinimumOperations:    2,
+			FailureRateThreshold: 0.6,
+			LatencyThreshold:     10 * time.Second,
+			OpenDuration:         30 * time.Second,
+		},
+		"TestCircuitBreakingBlobAccess")
+	blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := digest.NewSetBuilder().Add(blobDigest).Build()
+
+	// A single failure should not cause the circuit breaker to
+	// trip, as the minimum number of operations isn't reached.
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+	_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.Equal(t, status.Error(codes.Unavailable, "Server offline"), err)
+
+	// Errors such as NOT_FOUND are not counted as failures.
+	clock.EXPECT().Now().Return(time.Unix(1001, 0))
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+	_, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+
+	// A slow FindMissing() call brings the failure rate to 2/3,
+	// causing the circuit breaker to trip.
+	gomock.InOrder(
+		clock.EXPECT().Now().Return(time.Unix(1002, 0)),
+		clock.EXPECT().Now().Return(time.Unix(1013, 0)).Times(2))
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+	missing, err := blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+
+	// While open, operations should fail without contacting the
+	// backend.
+	clock.EXPECT().Now().Return(time.Unix(1020, 0))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	clock.EXPECT().Now().Return(time.Unix(1030, 0))
+	err = blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Once the open duration has passed, a single probing
+	// operation is let through. When it fails, the circuit
+	// breaker should open once again.
+	clock.EXPECT().Now().Return(time.Unix(1043, 0)).Times(2)
+	baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return status.Error(codes.DeadlineExceeded, "Request timed out")
+		})
+	err = blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	require.Equal(t, status.Error(codes.DeadlineExceeded, "Request timed out"), err)
+
+	clock.EXPECT().Now().Return(time.Unix(1050, 0))
+	_, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// A successful probe should close the circuit breaker.
+	clock.EXPECT().Now().Return(time.Unix(1074, 0)).Times(2)
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	clock.EXPECT().Now().Return(time.Unix(1075, 0))
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
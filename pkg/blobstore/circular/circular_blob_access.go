@@ -10,6 +10,7 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -55,6 +56,12 @@ type circularBlobAccess struct {
 // NewCircularBlobAccess creates a new circular storage backend. Instead
 // of writing data to storage directly, all three storage files are
 // injected through separate interfaces.
+//
+// Data is stored in FIFO order, meaning that objects are overwritten
+// regardless of how recently they were accessed. This may cause objects
+// to disappear while builds depending on them are still running. For
+// most purposes, LocalBlobAccess is a better alternative, as it copies
+// objects that are accessed into newer generations of blocks.
 func NewCircularBlobAccess(offsetStore OffsetStore, dataStore DataStore, stateStore StateStore, storageType blobstore.StorageType) blobstore.BlobAccess {
 	return &circularBlobAccess{
 		offsetStore: offsetStore,
@@ -67,6 +74,7 @@ func NewCircularBlobAccess(offsetStore OffsetStore, dataStore DataStore, stateSt
 func (ba *circularBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
 	ctx, span := trace.StartSpan(ctx, "circularBlobAccess.Get")
 	defer span.End()
+	util.AddRequestMetadataToSpan(ctx, span)
 
 	ba.lock.Lock()
 	span.Annotate(nil, "Lock obtained, calling GetCursors")
@@ -107,6 +115,7 @@ func (ba *circularBlobAccess) Put(ctx context.Context, digest digest.Digest, b b
 
 	ctx, span := trace.StartSpan(ctx, "circularBlobAccess.Put")
 	defer span.End()
+	util.AddRequestMetadataToSpan(ctx, span)
 
 	// Allocate space in the data store.
 	ba.lock.Lock()
@@ -3,30 +3,131 @@ package blobstore
 import (
 	"context"
 	"io"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
 
+	"cloud.google.com/go/storage"
+
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+var (
+	cloudBlobAccessPrometheusMetrics sync.Once
+
+	cloudBlobAccessAbortedWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "cloud_blob_access_aborted_writes_total",
+			Help:      "Number of writes of objects into cloud storage that were aborted before completion.",
+		},
+		[]string{"cause"})
+	cloudBlobAccessAbortedWritesCanceled = cloudBlobAccessAbortedWrites.WithLabelValues("Canceled")
+	cloudBlobAccessAbortedWritesFailed   = cloudBlobAccessAbortedWrites.WithLabelValues("Failed")
+)
+
+// defaultCloudWriteSizeBytes is the amount of data that is passed to
+// the writer of an object at once if no write size is configured. It
+// is identical to the buffer size used by io.Copy().
+const defaultCloudWriteSizeBytes = 32 * 1024
+
+// CloudStorageClassRule selects the storage class of objects written
+// by CloudBlobAccess. A rule matches an object if all of its
+// conditions are met.
+type CloudStorageClassRule struct {
+	// Name of the storage class to use (e.g., "STANDARD_IA" for S3
+	// or "NEARLINE" for GCS).
+	StorageClass string
+	// Only match objects of at least this size.
+	MinimumSizeBytes int64
+	// Only match objects of these kinds, as provided through
+	// PutHints. When empty, objects of any kind are matched.
+	Kinds []BlobKind
+	// Only match objects for which the priority provided through
+	// PutHints is at least this value. As lower values indicate a
+	// higher priority, this can be used to place objects of low
+	// importance in cheaper storage.
+	MinimumPriority int32
+}
+
+func (r *CloudStorageClassRule) matches(digest digest.Digest, hints PutHints) bool {
+	if digest.GetSizeBytes() < r.MinimumSizeBytes || hints.Priority < r.MinimumPriority {
+		return false
+	}
+	if len(r.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range r.Kinds {
+		if kind == hints.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// CloudWriterConfiguration contains options that CloudBlobAccess
+// applies when writing objects.
+type CloudWriterConfiguration struct {
+	// Value of the Cache-Control header to attach to objects.
+	CacheControl string
+	// Attach a Content-Type header to objects, so that they can be
+	// displayed properly when accessed directly (e.g., through a
+	// bucket browser). Logs are stored as plain text. All other
+	// objects are stored as binary data. When not set, the content
+	// type is detected by the storage backend.
+	SetContentType bool
+	// Rules for selecting storage classes. The storage class of the
+	// first matching rule is used. If no rules match, the bucket's
+	// default storage class is used. Storage classes are only
+	// supported for S3 and GCS.
+	StorageClassRules []CloudStorageClassRule
+	// Size of the chunks in which objects are uploaded. For S3 this
+	// corresponds to the part size of multipart uploads. For GCS
+	// this corresponds to the chunk size of resumable uploads. When
+	// zero, the storage backend's default is used.
+	BufferSizeBytes int
+	// Maximum amount of data that is read from the client and
+	// passed to the writer at once. Cancellation of the client's
+	// request is checked between writes. When zero, 32 KiB is used.
+	WriteSizeBytes int
+}
+
 type cloudBlobAccess struct {
-	bucket      *blob.Bucket
-	keyPrefix   string
-	storageType StorageType
+	bucket              *blob.Bucket
+	keyPrefix           string
+	storageType         StorageType
+	writerConfiguration CloudWriterConfiguration
 }
 
 // NewCloudBlobAccess creates a BlobAccess that uses a cloud-based blob storage
 // as a backend.
-func NewCloudBlobAccess(bucket *blob.Bucket, keyPrefix string, storageType StorageType) BlobAccess {
+//
+// Objects are stored under keys that are computed by prepending
+// keyPrefix to the key provided by the StorageType. Objects can be
+// stored in a directory hierarchy named after the instance name by
+// using NewInstanceNameDirectoryStorageType().
+func NewCloudBlobAccess(bucket *blob.Bucket, keyPrefix string, storageType StorageType, writerConfiguration CloudWriterConfiguration) BlobAccess {
+	cloudBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(cloudBlobAccessAbortedWrites)
+	})
+
 	return &cloudBlobAccess{
-		bucket:      bucket,
-		keyPrefix:   keyPrefix,
-		storageType: storageType,
+		bucket:              bucket,
+		keyPrefix:           keyPrefix,
+		storageType:         storageType,
+		writerConfiguration: writerConfiguration,
 	}
 }
 
@@ -35,40 +136,167 @@ func (ba *cloudBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer
 	result, err := ba.bucket.NewReader(ctx, key, nil)
 	if err != nil {
 		if gcerrors.Code(err) == gcerrors.NotFound {
-			err = status.Errorf(codes.NotFound, err.Error())
+			err = status.Error(codes.NotFound, err.Error())
 		}
 		return buffer.NewBufferFromError(err)
 	}
-	return ba.storageType.NewBufferFromReader(
+	return ba.storageType.NewBufferFromReaderAt(
 		digest,
-		result,
+		&cloudReaderAt{
+			ctx:    ctx,
+			bucket: ba.bucket,
+			key:    key,
+			r:      result,
+		},
+		result.Size(),
 		buffer.Reparable(digest, func() error {
 			return ba.bucket.Delete(ctx, key)
 		}))
 }
 
+// cloudReaderAt provides random access to an object stored in a
+// bucket. Data is read sequentially from a single reader for as long
+// as possible. Reads at other offsets cause a range read to be issued.
+// This ensures that reading an object in its entirety only requires a
+// single request, while reading a small part of a large object doesn't
+// require it to be downloaded entirely.
+type cloudReaderAt struct {
+	ctx    context.Context
+	bucket *blob.Bucket
+	key    string
+
+	lock sync.Mutex
+	r    *blob.Reader
+	off  int64
+}
+
+func (r *cloudReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.r == nil || r.off != off {
+		if r.r != nil {
+			r.r.Close()
+			r.r = nil
+		}
+		rangeReader, err := r.bucket.NewRangeReader(r.ctx, r.key, off, -1, nil)
+		if err != nil {
+			if gcerrors.Code(err) == gcerrors.NotFound {
+				err = status.Error(codes.NotFound, err.Error())
+			}
+			return 0, err
+		}
+		r.r = rangeReader
+		r.off = off
+	}
+
+	n, err := io.ReadFull(r.r, p)
+	r.off += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *cloudReaderAt) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.r == nil {
+		return nil
+	}
+	err := r.r.Close()
+	r.r = nil
+	return err
+}
+
 func (ba *cloudBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 	r := b.ToReader()
 	defer r.Close()
 
-	ctx, cancel := context.WithCancel(ctx)
-	w, err := ba.bucket.NewWriter(ctx, ba.getKey(digest), nil)
+	// Give the writer a context of its own that is detached from
+	// the client's request. Cancellation of the request is detected
+	// by cloudContextReader instead, so that the write is always
+	// aborted explicitly. This prevents requests issued by the
+	// storage backend from failing halfway. Cancelling the write
+	// prior to closing the writer prevents incomplete objects from
+	// being committed.
+	writerCtx, cancel := context.WithCancel(util.NewDetachedContext(ctx))
+	defer cancel()
+	w, err := ba.bucket.NewWriter(writerCtx, ba.getKey(digest), ba.getWriterOptions(ctx, digest))
 	if err != nil {
-		cancel()
 		return err
 	}
-	// In case of an error (e.g. network failure), we cancel before closing to
-	// request the write to be aborted.
-	if _, err = io.Copy(w, r); err != nil {
+
+	writeSizeBytes := ba.writerConfiguration.WriteSizeBytes
+	if writeSizeBytes <= 0 {
+		writeSizeBytes = defaultCloudWriteSizeBytes
+	}
+	if _, err := io.CopyBuffer(w, &cloudContextReader{ctx: ctx, r: r}, make([]byte, writeSizeBytes)); err != nil {
 		cancel()
 		w.Close()
+		if ctx.Err() != nil {
+			cloudBlobAccessAbortedWritesCanceled.Inc()
+			return util.StatusFromContext(ctx)
+		}
+		cloudBlobAccessAbortedWritesFailed.Inc()
+		return err
+	}
+
+	// Closing the writer flushes any buffered data and commits the
+	// object. Errors returned at this point mean that the object
+	// has not been written.
+	if err := w.Close(); err != nil {
+		if ctx.Err() != nil {
+			cloudBlobAccessAbortedWritesCanceled.Inc()
+			return util.StatusFromContext(ctx)
+		}
+		cloudBlobAccessAbortedWritesFailed.Inc()
 		return err
 	}
-	w.Close()
-	cancel()
 	return nil
 }
 
+// cloudContextReader is a decorator for io.Reader that stops returning
+// data once a context is cancelled. This ensures that writes of
+// objects into cloud storage are abandoned promptly when the client's
+// request is cancelled, as opposed to continuing until the client's
+// data is exhausted.
+type cloudContextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *cloudContextReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, util.StatusFromContext(r.ctx)
+	}
+	return r.r.Read(p)
+}
+
+func (ba *cloudBlobAccess) Delete(ctx context.Context, digest digest.Digest) error {
+	if err := ba.bucket.Delete(ctx, ba.getKey(digest)); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+func (ba *cloudBlobAccess) GetSignedURL(ctx context.Context, digest digest.Digest, expiry time.Duration) (string, error) {
+	url, err := ba.bucket.SignedURL(ctx, ba.getKey(digest), &blob.SignedURLOptions{
+		Expiry: expiry,
+	})
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.Unimplemented {
+			return "", status.Error(codes.Unimplemented, err.Error())
+		}
+		return "", err
+	}
+	return url, nil
+}
+
 func (ba *cloudBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
 	missing := digest.NewSetBuilder()
 	for _, blobDigest := range digests.Items() {
@@ -81,6 +309,65 @@ func (ba *cloudBlobAccess) FindMissing(ctx context.Context, digests digest.Set)
 	return missing.Build(), nil
 }
 
-func (ba *cloudBlobAccess) getKey(digest digest.Digest) string {
-	return ba.keyPrefix + ba.storageType.GetDigestKey(digest)
+func (ba *cloudBlobAccess) ListDigests(ctx context.Context, callback func(digest digest.Digest) error) error {
+	iterator := ba.bucket.List(&blob.ListOptions{Prefix: ba.keyPrefix})
+	for {
+		object, err := iterator.Next(ctx)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Skip objects that are not named after a digest, such
+		// as objects placed in the bucket by other software.
+		if !strings.HasPrefix(object.Key, ba.keyPrefix) {
+			continue
+		}
+		if blobDigest, err := ba.storageType.ParseDigestKey(object.Key[len(ba.keyPrefix):]); err == nil {
+			if err := callback(blobDigest); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// getWriterOptions computes the options that are used to write an
+// object, based on the configuration and the PutHints provided by the
+// caller.
+func (ba *cloudBlobAccess) getWriterOptions(ctx context.Context, digest digest.Digest) *blob.WriterOptions {
+	opts := &blob.WriterOptions{
+		BufferSize:   ba.writerConfiguration.BufferSizeBytes,
+		CacheControl: ba.writerConfiguration.CacheControl,
+	}
+	hints := GetPutHintsFromContext(ctx)
+	if ba.writerConfiguration.SetContentType {
+		switch hints.Kind {
+		case BlobKindLog:
+			opts.ContentType = "text/plain; charset=utf-8"
+		default:
+			opts.ContentType = "application/octet-stream"
+		}
+	}
+	for _, rule := range ba.writerConfiguration.StorageClassRules {
+		if rule.matches(digest, hints) {
+			storageClass := rule.StorageClass
+			opts.BeforeWrite = func(asFunc func(interface{}) bool) error {
+				var s3Input *s3manager.UploadInput
+				if asFunc(&s3Input) {
+					s3Input.StorageClass = aws.String(storageClass)
+				}
+				var gcsWriter *storage.Writer
+				if asFunc(&gcsWriter) {
+					gcsWriter.StorageClass = storageClass
+				}
+				return nil
+			}
+			break
+		}
+	}
+	return opts
+}
+
+func (ba *cloudBlobAccess) getKey(blobDigest digest.Digest) string {
+	return ba.keyPrefix + ba.storageType.GetDigestKey(blobDigest)
 }
@@ -0,0 +1,215 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"gocloud.dev/blob/memblob"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCloudBlobAccessPut(t *testing.T) {
+	ctx := context.Background()
+
+	bucket := memblob.OpenBucket(nil)
+	blobAccess := blobstore.NewCloudBlobAccess(bucket, "cas/", blobstore.CASStorageType, blobstore.CloudWriterConfiguration{
+		CacheControl:   "public, max-age=31536000, immutable",
+		SetContentType: true,
+		StorageClassRules: []blobstore.CloudStorageClassRule{
+			{
+				StorageClass: "STANDARD_IA",
+				Kinds:        []blobstore.BlobKind{blobstore.BlobKindTree},
+			},
+		},
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		// The Cache-Control header should be attached to all
+		// objects. Storage classes are ignored by backends that
+		// don't support them.
+		blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		require.NoError(t, blobAccess.Put(
+			blobstore.NewContextWithPutHints(ctx, blobstore.PutHints{Kind: blobstore.BlobKindTree}),
+			blobDigest,
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		attributes, err := bucket.Attributes(ctx, "cas/8b1a9953c4611296a827abf8c47804d7-5")
+		require.NoError(t, err)
+		require.Equal(t, "public, max-age=31536000, immutable", attributes.CacheControl)
+		require.Equal(t, "application/octet-stream", attributes.ContentType)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+	t.Run("Logs", func(t *testing.T) {
+		blobDigest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+		require.NoError(t, blobAccess.Put(
+			blobstore.NewContextWithPutHints(ctx, blobstore.PutHints{Kind: blobstore.BlobKindLog}),
+			blobDigest,
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		attributes, err := bucket.Attributes(ctx, "cas/3e25960a79dbc69b674cd4ec67a72c62-11")
+		require.NoError(t, err)
+		require.Equal(t, "text/plain; charset=utf-8", attributes.ContentType)
+	})
+}
+
+func TestCloudBlobAccessPutAborted(t *testing.T) {
+	ctx := context.Background()
+
+	bucket := memblob.OpenBucket(nil)
+	blobAccess := blobstore.NewCloudBlobAccess(bucket, "cas/", blobstore.CASStorageType, blobstore.CloudWriterConfiguration{
+		WriteSizeBytes: 2,
+	})
+	blobDigest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("Canceled", func(t *testing.T) {
+		// Writes for requests that are cancelled should be
+		// aborted, as opposed to committing incomplete objects.
+		ctxWithCancel, cancel := context.WithCancel(ctx)
+		cancel()
+		require.Equal(
+			t,
+			status.Error(codes.Canceled, "context canceled"),
+			blobAccess.Put(ctxWithCancel, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		exists, err := bucket.Exists(ctx, "cas/3e25960a79dbc69b674cd4ec67a72c62-11")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("ReadFailure", func(t *testing.T) {
+		// Data that doesn't match the digest should not be
+		// committed.
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Buffer is at least 12 bytes in size, while 11 bytes were expected"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewCASBufferFromReader(blobDigest, ioutil.NopCloser(bytes.NewBufferString("Hello world!")), buffer.UserProvided)))
+
+		exists, err := bucket.Exists(ctx, "cas/3e25960a79dbc69b674cd4ec67a72c62-11")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Objects larger than the write size should be written
+		// in their entirety.
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		data, err := bucket.ReadAll(ctx, "cas/3e25960a79dbc69b674cd4ec67a72c62-11")
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+}
+
+func TestCloudBlobAccessGet(t *testing.T) {
+	ctx := context.Background()
+
+	bucket := memblob.OpenBucket(nil)
+	require.NoError(t, bucket.WriteAll(ctx, "cas/3e25960a79dbc69b674cd4ec67a72c62-11", []byte("Hello world"), nil))
+	blobAccess := blobstore.NewCloudBlobAccess(bucket, "cas/", blobstore.CASStorageType, blobstore.CloudWriterConfiguration{})
+	blobDigest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := blobAccess.Get(ctx, digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(100)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Full", func(t *testing.T) {
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("ReadAt", func(t *testing.T) {
+		var p [3]byte
+		n, err := blobAccess.Get(ctx, blobDigest).ReadAt(p[:], 6)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		require.Equal(t, []byte("wor"), p[:])
+	})
+
+	t.Run("ChunkReaderAtOffset", func(t *testing.T) {
+		r := blobAccess.Get(ctx, blobDigest).ToChunkReader(6, 100)
+		chunk, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("world"), chunk)
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+		r.Close()
+	})
+}
+
+func TestCloudBlobAccessGetSignedURL(t *testing.T) {
+	ctx := context.Background()
+
+	// In-memory buckets are not capable of generating signed URLs.
+	bucket := memblob.OpenBucket(nil)
+	blobAccess := blobstore.NewCloudBlobAccess(bucket, "cas/", blobstore.CASStorageType, blobstore.CloudWriterConfiguration{})
+	_, err := blobAccess.(blobstore.SignedURLGenerator).GetSignedURL(
+		ctx,
+		digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5),
+		time.Hour)
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestCloudBlobAccessInstanceNameInKey(t *testing.T) {
+	ctx := context.Background()
+
+	bucket := memblob.OpenBucket(nil)
+	blobAccess := blobstore.NewCloudBlobAccess(bucket, "ac/", blobstore.NewInstanceNameDirectoryStorageType(blobstore.ACStorageType), blobstore.CloudWriterConfiguration{})
+	actionResult := &remoteexecution.ActionResult{ExitCode: 1}
+
+	t.Run("WithInstance", func(t *testing.T) {
+		blobDigest := digest.MustNewDigest("my/instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.UserProvided)))
+
+		exists, err := bucket.Exists(ctx, "ac/my/instance/8b1a9953c4611296a827abf8c47804d7-5")
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		storedActionResult, err := blobAccess.Get(ctx, blobDigest).ToActionResult(100)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, storedActionResult))
+	})
+
+	t.Run("WithoutInstance", func(t *testing.T) {
+		blobDigest := digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5)
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.UserProvided)))
+
+		exists, err := bucket.Exists(ctx, "ac/8b1a9953c4611296a827abf8c47804d7-5")
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+
+	t.Run("ListDigests", func(t *testing.T) {
+		// Keys should be converted back to digests, including
+		// the instance name. Objects that are not named after
+		// a digest should be skipped.
+		require.NoError(t, bucket.WriteAll(ctx, "ac/my/instance/README", []byte("Hello"), nil))
+
+		var digests []digest.Digest
+		require.NoError(t, blobAccess.(blobstore.DigestLister).ListDigests(ctx, func(blobDigest digest.Digest) error {
+			digests = append(digests, blobDigest)
+			return nil
+		}))
+		require.ElementsMatch(t, []digest.Digest{
+			digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5),
+			digest.MustNewDigest("my/instance", "8b1a9953c4611296a827abf8c47804d7", 5),
+		}, digests)
+	})
+}
@@ -115,12 +115,15 @@ func NewCompletenessCheckingBlobAccess(actionCache blobstore.BlobAccess, content
 	}
 }
 
-func (ba *completenessCheckingBlobAccess) checkCompleteness(ctx context.Context, baseDigest digest.Digest, actionResult *remoteexecution.ActionResult) error {
+// checkCompleteness checks whether all objects referenced by an
+// ActionResult are present in the Content Addressable Storage. It
+// returns NotFound in case one or more objects are absent or malformed.
+func checkCompleteness(ctx context.Context, contentAddressableStorage cas.ContentAddressableStorage, contentAddressableStorageBlobAccess blobstore.BlobAccess, batchSize int, baseDigest digest.Digest, actionResult *remoteexecution.ActionResult) error {
 	findMissingQueue := findMissingQueue{
 		context:                   ctx,
 		baseDigest:                baseDigest,
-		contentAddressableStorage: ba.contentAddressableStorageBlobAccess,
-		batchSize:                 ba.batchSize,
+		contentAddressableStorage: contentAddressableStorageBlobAccess,
+		batchSize:                 batchSize,
 		pending:                   digest.NewSetBuilder(),
 	}
 
@@ -154,7 +157,7 @@ func (ba *completenessCheckingBlobAccess) checkCompleteness(ctx context.Context,
 		if err != nil {
 			return err
 		}
-		tree, err := ba.contentAddressableStorage.GetTree(ctx, treeDigest)
+		tree, err := contentAddressableStorage.GetTree(ctx, treeDigest)
 		if err != nil {
 			return util.StatusWrapf(err, "Failed to fetch output directory %#v", outputDirectory.Path)
 		}
@@ -177,7 +180,7 @@ func (ba *completenessCheckingBlobAccess) Get(ctx context.Context, digest digest
 		b2.Discard()
 		return buffer.NewBufferFromError(err)
 	}
-	if err := ba.checkCompleteness(ctx, digest, actionResult); err != nil {
+	if err := checkCompleteness(ctx, ba.contentAddressableStorage, ba.contentAddressableStorageBlobAccess, ba.batchSize, digest, actionResult); err != nil {
 		b2.Discard()
 		return buffer.NewBufferFromError(err)
 	}
@@ -0,0 +1,66 @@
+package completenesschecking
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type validatingBlobAccess struct {
+	blobstore.BlobAccess
+	contentAddressableStorage           cas.ContentAddressableStorage
+	contentAddressableStorageBlobAccess blobstore.BlobAccess
+	batchSize                           int
+	maximumMessageSizeBytes             int
+	rejectNonZeroExitCode               bool
+}
+
+// NewValidatingBlobAccess creates a wrapper around an Action Cache (AC)
+// that validates ActionResult entries before they are written. Entries
+// are rejected if they are larger than the maximum message size, if
+// they contain malformed digests, or if they reference objects (output
+// files, Tree objects and logs) that are not present within the
+// Content Addressable Storage (CAS). Entries with a non-zero exit code
+// may optionally be rejected as well.
+//
+// This is the counterpart of CompletenessCheckingBlobAccess. Where
+// that type hides incomplete entries upon retrieval, this type
+// prevents misbehaving workers from filling the Action Cache with
+// unusable entries in the first place.
+func NewValidatingBlobAccess(actionCache blobstore.BlobAccess, contentAddressableStorage cas.ContentAddressableStorage, contentAddressableStorageBlobAccess blobstore.BlobAccess, batchSize int, maximumMessageSizeBytes int, rejectNonZeroExitCode bool) blobstore.BlobAccess {
+	return &validatingBlobAccess{
+		BlobAccess:                          actionCache,
+		contentAddressableStorage:           contentAddressableStorage,
+		contentAddressableStorageBlobAccess: contentAddressableStorageBlobAccess,
+		batchSize:                           batchSize,
+		maximumMessageSizeBytes:             maximumMessageSizeBytes,
+		rejectNonZeroExitCode:               rejectNonZeroExitCode,
+	}
+}
+
+func (ba *validatingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	actionResult, err := b.ToActionResult(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid action result")
+	}
+	if ba.rejectNonZeroExitCode && actionResult.ExitCode != 0 {
+		return status.Errorf(codes.InvalidArgument, "Action result has non-zero exit code %d", actionResult.ExitCode)
+	}
+	if err := checkCompleteness(ctx, ba.contentAddressableStorage, ba.contentAddressableStorageBlobAccess, ba.batchSize, digest, actionResult); err != nil {
+		// Absence of referenced objects is reported as a
+		// failed precondition, as is done by UpdateActionResult()
+		// for missing Commands and Actions.
+		if status.Code(err) == codes.NotFound {
+			return util.StatusWrapWithCode(err, codes.FailedPrecondition, "Action result is incomplete")
+		}
+		return err
+	}
+	return ba.BlobAccess.Put(ctx, digest, buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable))
+}
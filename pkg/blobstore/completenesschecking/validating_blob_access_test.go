@@ -0,0 +1,110 @@
+package completenesschecking_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/completenesschecking"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidatingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	contentAddressableStorageBlobAccess := mock.NewMockBlobAccess(ctrl)
+	validatingBlobAccess := completenesschecking.NewValidatingBlobAccess(
+		actionCache,
+		contentAddressableStorage,
+		contentAddressableStorageBlobAccess,
+		5,
+		1000,
+		true)
+
+	actionDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	outputFileDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{
+				Path: "bazel-out/foo.o",
+				Digest: &remoteexecution.Digest{
+					Hash:      "8b1a9953c4611296a827abf8c47804d7",
+					SizeBytes: 5,
+				},
+			},
+		},
+	}
+
+	t.Run("TooLarge", func(t *testing.T) {
+		err := validatingBlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(
+			&remoteexecution.ActionResult{
+				StdoutRaw: make([]byte, 2000),
+			},
+			buffer.Irreparable))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("NonZeroExitCode", func(t *testing.T) {
+		err := validatingBlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(
+			&remoteexecution.ActionResult{
+				ExitCode: 1,
+			},
+			buffer.Irreparable))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Action result has non-zero exit code 1"), err)
+	})
+
+	t.Run("MissingOutput", func(t *testing.T) {
+		contentAddressableStorageBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(outputFileDigest).Build(),
+		).Return(digest.NewSetBuilder().Add(outputFileDigest).Build(), nil)
+
+		err := validatingBlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable))
+		require.Equal(t, status.Error(codes.FailedPrecondition, "Action result is incomplete: Object 8b1a9953c4611296a827abf8c47804d7-5-hello referenced by the action result is not present in the Content Addressable Storage"), err)
+	})
+
+	t.Run("MissingTree", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().GetTree(ctx, outputFileDigest).Return(nil, status.Error(codes.NotFound, "Object not found"))
+
+		err := validatingBlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(
+			&remoteexecution.ActionResult{
+				OutputDirectories: []*remoteexecution.OutputDirectory{
+					{
+						Path: "bazel-out/foo",
+						TreeDigest: &remoteexecution.Digest{
+							Hash:      "8b1a9953c4611296a827abf8c47804d7",
+							SizeBytes: 5,
+						},
+					},
+				},
+			},
+			buffer.Irreparable))
+		require.Equal(t, status.Error(codes.FailedPrecondition, "Action result is incomplete: Failed to fetch output directory \"bazel-out/foo\": Object not found"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		contentAddressableStorageBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(outputFileDigest).Build(),
+		).Return(digest.EmptySet, nil)
+		actionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				storedActionResult, err := b.ToActionResult(1000)
+				require.NoError(t, err)
+				require.Equal(t, actionResult, storedActionResult)
+				return nil
+			})
+
+		require.NoError(t, validatingBlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable)))
+	})
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
@@ -12,17 +16,25 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/bloomfiltering"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/encrypting"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/sharding"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/striping"
+	"github.com/buildbarn/bb-storage/pkg/cas"
 	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
 	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	local_pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/proto/uncachedactionresult"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/google/uuid"
 
@@ -51,14 +63,90 @@ type blobAccessCreationOptions struct {
 	storageTypeName         string
 	keyFormat               digest.KeyFormat
 	maximumMessageSizeBytes int
+	labels                  *labelScope
+
+	// Path of the storage backend within the configuration, and a
+	// map in which every storage backend created is registered
+	// under its path. The map is only set when the caller is
+	// interested in inspecting individual storage backends.
+	path     string
+	backends map[string]blobstore.BlobAccess
+}
+
+// labelScope holds the storage backends that are declared using
+// 'with_labels'. Storage backends are created on first use, so that
+// their declaration order doesn't matter. Scopes are nested, meaning
+// that labels of enclosing scopes remain visible.
+type labelScope struct {
+	parent         *labelScope
+	options        *blobAccessCreationOptions
+	configurations map[string]*pb.BlobAccessConfiguration
+	instances      map[string]blobstore.BlobAccess
+	creating       map[string]bool
+}
+
+// getBlobAccess returns the storage backend associated with a label,
+// creating it if needed.
+func (ls *labelScope) getBlobAccess(label string) (blobstore.BlobAccess, error) {
+	for scope := ls; scope != nil; scope = scope.parent {
+		configuration, ok := scope.configurations[label]
+		if !ok {
+			continue
+		}
+		if instance, ok := scope.instances[label]; ok {
+			return instance, nil
+		}
+		if scope.creating[label] {
+			return nil, status.Errorf(codes.InvalidArgument, "Label %#v refers to itself, either directly or indirectly", label)
+		}
+		scope.creating[label] = true
+		labelOptions := *scope.options
+		labelOptions.path = fmt.Sprintf("%s.with_labels.labels[%#v]", scope.options.path, label)
+		instance, err := createBlobAccess(configuration, &labelOptions)
+		delete(scope.creating, label)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Label %#v", label)
+		}
+		scope.instances[label] = instance
+		return instance, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "Label %#v is not declared", label)
 }
 
 // CreateBlobAccessObjectsFromConfig creates a pair of BlobAccess
 // objects for the Content Addressable Storage and Action cache based on
 // a configuration file.
 func CreateBlobAccessObjectsFromConfig(configuration *pb.BlobstoreConfiguration, maximumMessageSizeBytes int) (blobstore.BlobAccess, blobstore.BlobAccess, error) {
+	return createBlobAccessObjects(configuration, maximumMessageSizeBytes, nil, nil)
+}
+
+// CreateBlobAccessObjectsAndBackendsFromConfig is identical to
+// CreateBlobAccessObjectsFromConfig, except that it also returns the
+// individual storage backends of which the Content Addressable Storage
+// and Action Cache are composed, keyed by their path in the
+// configuration (e.g., "content_addressable_storage.sharding.shards[0].backend").
+// These can be used to inspect the state of individual shards and
+// replicas for debugging purposes.
+func CreateBlobAccessObjectsAndBackendsFromConfig(configuration *pb.BlobstoreConfiguration, maximumMessageSizeBytes int) (blobstore.BlobAccess, blobstore.BlobAccess, map[string]blobstore.BlobAccess, map[string]blobstore.BlobAccess, error) {
+	contentAddressableStorageBackends := map[string]blobstore.BlobAccess{}
+	actionCacheBackends := map[string]blobstore.BlobAccess{}
+	contentAddressableStorage, actionCache, err := createBlobAccessObjects(configuration, maximumMessageSizeBytes, contentAddressableStorageBackends, actionCacheBackends)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return contentAddressableStorage, actionCache, contentAddressableStorageBackends, actionCacheBackends, nil
+}
+
+func createBlobAccessObjects(configuration *pb.BlobstoreConfiguration, maximumMessageSizeBytes int, contentAddressableStorageBackends map[string]blobstore.BlobAccess, actionCacheBackends map[string]blobstore.BlobAccess) (blobstore.BlobAccess, blobstore.BlobAccess, error) {
 	// Create two stores based on definitions in configuration.
-	contentAddressableStorage, err := CreateCASBlobAccessObjectFromConfig(configuration.ContentAddressableStorage, maximumMessageSizeBytes)
+	contentAddressableStorage, err := createBlobAccess(configuration.ContentAddressableStorage, &blobAccessCreationOptions{
+		storageType:             blobstore.CASStorageType,
+		storageTypeName:         "cas",
+		keyFormat:               digest.KeyWithoutInstance,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		path:                    "content_addressable_storage",
+		backends:                contentAddressableStorageBackends,
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -67,6 +155,8 @@ func CreateBlobAccessObjectsFromConfig(configuration *pb.BlobstoreConfiguration,
 		storageTypeName:         "ac",
 		keyFormat:               digest.KeyWithInstance,
 		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		path:                    "action_cache",
+		backends:                actionCacheBackends,
 	})
 	if err != nil {
 		return nil, nil, err
@@ -82,8 +172,73 @@ func CreateCASBlobAccessObjectFromConfig(configuration *pb.BlobAccessConfigurati
 		storageTypeName:         "cas",
 		keyFormat:               digest.KeyWithoutInstance,
 		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		path:                    "content_addressable_storage",
+	})
+}
+
+// CreateContentAddressableStorageFromConfig creates a
+// ContentAddressableStorage that provides higher level access to
+// objects stored in a BlobAccess, based on a configuration file. It is
+// intended to be used by clients of the Content Addressable Storage,
+// such as workers.
+func CreateContentAddressableStorageFromConfig(blobAccess blobstore.BlobAccess, configuration *pb.ContentAddressableStorageConfiguration, stagingDirectory filesystem.Directory, maximumMessageSizeBytes int) (cas.ContentAddressableStorage, error) {
+	contentAddressableStorage := cas.NewBlobAccessContentAddressableStorage(blobAccess, stagingDirectory, maximumMessageSizeBytes)
+	if configuration == nil {
+		return contentAddressableStorage, nil
+	}
+	if indexConfiguration := configuration.UncachedActionResultIndex; indexConfiguration != nil {
+		client, err := bb_grpc.NewGRPCClientFromConfiguration(indexConfiguration)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create UncachedActionResult index client")
+		}
+		contentAddressableStorage = cas.NewIndexingContentAddressableStorage(
+			contentAddressableStorage,
+			uncachedactionresult.NewUncachedActionResultIndexClient(client))
+	}
+	if hardlinkingConfiguration := configuration.Hardlinking; hardlinkingConfiguration != nil {
+		cacheDirectory, err := filesystem.NewLocalDirectory(hardlinkingConfiguration.CacheDirectoryPath)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to open hardlinking cache directory")
+		}
+		if err := cacheDirectory.RemoveAllChildren(); err != nil {
+			return nil, util.StatusWrap(err, "Failed to clear hardlinking cache directory")
+		}
+		evictionSet, err := eviction.NewSetFromConfiguration(hardlinkingConfiguration.CacheReplacementPolicy)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create eviction set for hardlinking cache directory")
+		}
+		contentAddressableStorage = cas.NewHardlinkingContentAddressableStorage(
+			contentAddressableStorage,
+			cacheDirectory,
+			int(hardlinkingConfiguration.MaximumFiles),
+			hardlinkingConfiguration.MaximumSizeBytes,
+			evictionSet)
+	}
+	return contentAddressableStorage, nil
+}
+
+// CreateFSACBlobAccessObjectFromConfig creates a BlobAccess object for
+// the File System Access Cache based on a configuration file.
+func CreateFSACBlobAccessObjectFromConfig(configuration *pb.BlobAccessConfiguration, maximumMessageSizeBytes int) (blobstore.BlobAccess, error) {
+	return createBlobAccess(configuration, &blobAccessCreationOptions{
+		storageType:             blobstore.FSACStorageType,
+		storageTypeName:         "fsac",
+		keyFormat:               digest.KeyWithInstance,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		path:                    "file_system_access_cache",
 	})
+}
 
+// CreateISCCBlobAccessObjectFromConfig creates a BlobAccess object for
+// the Initial Size Class Cache based on a configuration file.
+func CreateISCCBlobAccessObjectFromConfig(configuration *pb.BlobAccessConfiguration, maximumMessageSizeBytes int) (blobstore.BlobAccess, error) {
+	return createBlobAccess(configuration, &blobAccessCreationOptions{
+		storageType:             blobstore.ISCCStorageType,
+		storageTypeName:         "iscc",
+		keyFormat:               digest.KeyWithInstance,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		path:                    "initial_size_class_cache",
+	})
 }
 
 func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAccessCreationOptions) (blobstore.BlobAccess, error) {
@@ -93,6 +248,36 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		return nil, errors.New("Storage configuration not specified")
 	}
 	switch backend := configuration.Backend.(type) {
+	case *pb.BlobAccessConfiguration_Label:
+		// References to labels yield the storage backend as is,
+		// as it already provides its own metrics.
+		return options.labels.getBlobAccess(backend.Label)
+	case *pb.BlobAccessConfiguration_WithLabels:
+		labelOptions := *options
+		labelOptions.labels = &labelScope{
+			parent:         options.labels,
+			options:        &labelOptions,
+			configurations: backend.WithLabels.Labels,
+			instances:      map[string]blobstore.BlobAccess{},
+			creating:       map[string]bool{},
+		}
+		implementation, err := createNestedBlobAccess(backend.WithLabels.Backend, &labelOptions, "with_labels.backend")
+		if err != nil {
+			return nil, err
+		}
+		// Report unused labels, as these are likely the result
+		// of mistakes in the configuration.
+		labels := make([]string, 0, len(backend.WithLabels.Labels))
+		for label := range backend.WithLabels.Labels {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			if _, ok := labelOptions.labels.instances[label]; !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "Label %#v is declared, but never used", label)
+			}
+		}
+		return implementation, nil
 	case *pb.BlobAccessConfiguration_Circular:
 		backendType = "circular"
 
@@ -103,14 +288,27 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		}
 	case *pb.BlobAccessConfiguration_Cloud:
 		backendType = "cloud"
+		writerConfiguration, err := newCloudWriterConfiguration(backend.Cloud)
+		if err != nil {
+			return nil, err
+		}
+		storageType := options.storageType
+		if backend.Cloud.IncludeInstanceNameInKey {
+			storageType = blobstore.NewInstanceNameDirectoryStorageType(storageType)
+		}
 		switch backendConfig := backend.Cloud.Config.(type) {
 		case *pb.CloudBlobAccessConfiguration_Url:
+			if strings.HasPrefix(backendConfig.Url, "s3://") {
+				if err := checkS3BufferSize(writerConfiguration); err != nil {
+					return nil, err
+				}
+			}
 			ctx := context.Background()
 			bucket, err := blob.OpenBucket(ctx, backendConfig.Url)
 			if err != nil {
 				return nil, err
 			}
-			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
+			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, storageType, writerConfiguration)
 		case *pb.CloudBlobAccessConfiguration_Azure:
 			backendType = "azure"
 			credential, err := azureblob.NewCredential(azureblob.AccountName(backendConfig.Azure.AccountName), azureblob.AccountKey(backendConfig.Azure.AccountKey))
@@ -123,7 +321,7 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			if err != nil {
 				return nil, err
 			}
-			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
+			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, storageType, writerConfiguration)
 		case *pb.CloudBlobAccessConfiguration_Gcs:
 			backendType = "gcs"
 			var creds *google.Credentials
@@ -145,9 +343,12 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			if err != nil {
 				return nil, err
 			}
-			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
+			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, storageType, writerConfiguration)
 		case *pb.CloudBlobAccessConfiguration_S3:
 			backendType = "s3"
+			if err := checkS3BufferSize(writerConfiguration); err != nil {
+				return nil, err
+			}
 			cfg := aws.Config{
 				Endpoint:         &backendConfig.S3.Endpoint,
 				Region:           &backendConfig.S3.Region,
@@ -165,7 +366,7 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			if err != nil {
 				return nil, err
 			}
-			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
+			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, storageType, writerConfiguration)
 		default:
 			return nil, errors.New("Cloud configuration did not contain a backend")
 		}
@@ -183,18 +384,358 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			implementation = blobstore.NewActionCacheBlobAccess(client, options.maximumMessageSizeBytes)
 		case blobstore.CASStorageType:
 			implementation = blobstore.NewContentAddressableStorageBlobAccess(client, uuid.NewRandom, 65536)
+		case blobstore.FSACStorageType:
+			implementation = blobstore.NewFileSystemAccessCacheBlobAccess(client, options.maximumMessageSizeBytes)
+		case blobstore.ISCCStorageType:
+			implementation = blobstore.NewInitialSizeClassCacheBlobAccess(client, options.maximumMessageSizeBytes)
+		}
+	case *pb.BlobAccessConfiguration_BatchingGrpc:
+		backendType = "batching_grpc"
+		if options.storageType != blobstore.CASStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Batching GRPC backend can only be used for the Content Addressable Storage")
+		}
+		client, err := bb_grpc.NewGRPCClientFromConfiguration(backend.BatchingGrpc.Client)
+		if err != nil {
+			return nil, err
+		}
+		batchDelay, err := ptypes.Duration(backend.BatchingGrpc.BatchDelay)
+		if err != nil {
+			return nil, err
+		}
+		implementation = blobstore.NewBatchingContentAddressableStorageBlobAccess(
+			blobstore.NewContentAddressableStorageBlobAccess(client, uuid.NewRandom, 65536),
+			client,
+			clock.SystemClock,
+			backend.BatchingGrpc.MaximumBlobSizeBytes,
+			backend.BatchingGrpc.MaximumBatchSizeBytes,
+			batchDelay,
+			backend.BatchingGrpc.Client.GetForwardMetadata())
+	case *pb.BlobAccessConfiguration_Retrying:
+		backendType = "retrying"
+		base, err := createNestedBlobAccess(backend.Retrying.Backend, options, "retrying.backend")
+		if err != nil {
+			return nil, err
+		}
+		policies := map[codes.Code]blobstore.RetryPolicy{}
+		for _, policyConfiguration := range backend.Retrying.Policies {
+			if policyConfiguration.MaximumAttempts < 1 {
+				return nil, status.Error(codes.InvalidArgument, "Maximum number of attempts must be at least 1")
+			}
+			initialBackoff, err := ptypes.Duration(policyConfiguration.InitialBackoff)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain initial backoff")
+			}
+			maximumBackoff, err := ptypes.Duration(policyConfiguration.MaximumBackoff)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain maximum backoff")
+			}
+			if maximumBackoff <= 0 {
+				return nil, status.Error(codes.InvalidArgument, "Maximum backoff must be positive")
+			}
+			for _, code := range policyConfiguration.Codes {
+				policies[codes.Code(code)] = blobstore.RetryPolicy{
+					MaximumAttempts:   int(policyConfiguration.MaximumAttempts),
+					InitialBackoff:    initialBackoff,
+					BackoffMultiplier: policyConfiguration.BackoffMultiplier,
+					MaximumBackoff:    maximumBackoff,
+				}
+			}
+		}
+		var budget *blobstore.RetryBudget
+		if budgetConfiguration := backend.Retrying.RetryBudget; budgetConfiguration != nil {
+			budget = blobstore.NewRetryBudget(budgetConfiguration.TokensPerRequest, budgetConfiguration.MaximumTokens)
+		}
+		implementation = blobstore.NewRetryingBlobAccess(
+			base,
+			clock.SystemClock,
+			policies,
+			budget,
+			backend.Retrying.MaximumPutRetrySizeBytes,
+			options.storageTypeName)
+	case *pb.BlobAccessConfiguration_CircuitBreaking:
+		backendType = "circuit_breaking"
+		base, err := createNestedBlobAccess(backend.CircuitBreaking.Backend, options, "circuit_breaking.backend")
+		if err != nil {
+			return nil, err
+		}
+		failureRateThreshold := backend.CircuitBreaking.FailureRateThreshold
+		if failureRateThreshold <= 0 || failureRateThreshold > 1 {
+			return nil, status.Errorf(codes.InvalidArgument, "Failure rate threshold must lie in (0.0, 1.0]")
+		}
+		window, err := ptypes.Duration(backend.CircuitBreaking.Window)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain window")
+		}
+		var latencyThreshold time.Duration
+		if backend.CircuitBreaking.LatencyThreshold != nil {
+			latencyThreshold, err = ptypes.Duration(backend.CircuitBreaking.LatencyThreshold)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain latency threshold")
+			}
+		}
+		openDuration, err := ptypes.Duration(backend.CircuitBreaking.OpenDuration)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain open duration")
+		}
+		implementation = blobstore.NewCircuitBreakingBlobAccess(
+			base,
+			clock.SystemClock,
+			blobstore.CircuitBreakerConfiguration{
+				Window:               window,
+				MinimumOperations:    int(backend.CircuitBreaking.MinimumOperations),
+				FailureRateThreshold: failureRateThreshold,
+				LatencyThreshold:     latencyThreshold,
+				OpenDuration:         openDuration,
+			},
+			options.storageTypeName)
+	case *pb.BlobAccessConfiguration_DeadlineEnforcing:
+		backendType = "deadline_enforcing"
+		base, err := createNestedBlobAccess(backend.DeadlineEnforcing.Backend, options, "deadline_enforcing.backend")
+		if err != nil {
+			return nil, err
+		}
+		var configuration blobstore.DeadlineConfiguration
+		if backend.DeadlineEnforcing.GetTimeout != nil {
+			configuration.GetTimeout, err = ptypes.Duration(backend.DeadlineEnforcing.GetTimeout)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain get timeout")
+			}
+		}
+		if backend.DeadlineEnforcing.GetTimeoutPerByte != nil {
+			configuration.GetTimeoutPerByte, err = ptypes.Duration(backend.DeadlineEnforcing.GetTimeoutPerByte)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain get timeout per byte")
+			}
+		}
+		if backend.DeadlineEnforcing.PutTimeout != nil {
+			configuration.PutTimeout, err = ptypes.Duration(backend.DeadlineEnforcing.PutTimeout)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain put timeout")
+			}
+		}
+		if backend.DeadlineEnforcing.FindMissingTimeout != nil {
+			configuration.FindMissingTimeout, err = ptypes.Duration(backend.DeadlineEnforcing.FindMissingTimeout)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain find missing timeout")
+			}
+		}
+		implementation = blobstore.NewDeadlineEnforcingBlobAccess(base, clock.SystemClock, configuration)
+	case *pb.BlobAccessConfiguration_SingleFlight:
+		backendType = "single_flight"
+		if options.storageType != blobstore.CASStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Single flight can only be used for the Content Addressable Storage")
+		}
+		base, err := createNestedBlobAccess(backend.SingleFlight.Backend, options, "single_flight.backend")
+		if err != nil {
+			return nil, err
+		}
+		joinWindow, err := ptypes.Duration(backend.SingleFlight.JoinWindow)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain join window")
+		}
+		implementation = blobstore.NewSingleFlightBlobAccess(
+			base,
+			clock.SystemClock,
+			backend.SingleFlight.MinimumSizeBytes,
+			joinWindow,
+			options.storageTypeName)
+	case *pb.BlobAccessConfiguration_WriteOnce:
+		backendType = "write_once"
+		if options.storageType != blobstore.ACStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Write once can only be used for the Action Cache")
+		}
+		base, err := createNestedBlobAccess(backend.WriteOnce.Backend, options, "write_once.backend")
+		if err != nil {
+			return nil, err
+		}
+		implementation = blobstore.NewWriteOnceBlobAccess(
+			base,
+			options.maximumMessageSizeBytes,
+			backend.WriteOnce.RejectDivergentWrites,
+			options.storageTypeName)
+	case *pb.BlobAccessConfiguration_Chunking:
+		backendType = "chunking"
+		if options.storageType != blobstore.CASStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Chunking can only be used for the Content Addressable Storage")
+		}
+		if backend.Chunking.ChunkSizeBytes <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Chunk size must be positive")
+		}
+		if backend.Chunking.Concurrency <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Concurrency must be positive")
+		}
+		base, err := createNestedBlobAccess(backend.Chunking.Backend, options, "chunking.backend")
+		if err != nil {
+			return nil, err
+		}
+		manifestsOptions := *options
+		manifestsOptions.storageType = blobstore.ACStorageType
+		manifestsOptions.storageTypeName = "ac"
+		manifestsOptions.keyFormat = digest.KeyWithoutInstance
+		manifestsOptions.labels = nil
+		manifests, err := createNestedBlobAccess(backend.Chunking.Manifests, &manifestsOptions, "chunking.manifests")
+		if err != nil {
+			return nil, err
+		}
+		implementation = blobstore.NewChunkingBlobAccess(
+			base,
+			manifests,
+			backend.Chunking.MinimumSizeBytes,
+			backend.Chunking.ChunkSizeBytes,
+			int(backend.Chunking.Concurrency),
+			options.maximumMessageSizeBytes)
+	case *pb.BlobAccessConfiguration_Striping:
+		backendType = "striping"
+		if options.storageType != blobstore.CASStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Striping can only be used for the Content Addressable Storage")
+		}
+		if backend.Striping.ParityShards <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Number of parity shards must be positive")
+		}
+		if int(backend.Striping.ParityShards) >= len(backend.Striping.Shards) {
+			return nil, status.Error(codes.InvalidArgument, "Number of shards must exceed the number of parity shards")
+		}
+		if backend.Striping.StripeSizeBytes <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Stripe size must be positive")
+		}
+		base, err := createNestedBlobAccess(backend.Striping.Backend, options, "striping.backend")
+		if err != nil {
+			return nil, err
+		}
+		manifestsOptions := *options
+		manifestsOptions.storageType = blobstore.ACStorageType
+		manifestsOptions.storageTypeName = "ac"
+		manifestsOptions.keyFormat = digest.KeyWithoutInstance
+		manifestsOptions.labels = nil
+		manifests, err := createNestedBlobAccess(backend.Striping.Manifests, &manifestsOptions, "striping.manifests")
+		if err != nil {
+			return nil, err
+		}
+		shards := make([]blobstore.BlobAccess, 0, len(backend.Striping.Shards))
+		for i, shard := range backend.Striping.Shards {
+			shardBackend, err := createNestedBlobAccess(shard, options, fmt.Sprintf("striping.shards[%d]", i))
+			if err != nil {
+				return nil, err
+			}
+			shards = append(shards, shardBackend)
+		}
+		implementation, err = striping.NewStripingBlobAccess(
+			base,
+			manifests,
+			shards,
+			int(backend.Striping.ParityShards),
+			backend.Striping.MinimumSizeBytes,
+			backend.Striping.StripeSizeBytes,
+			options.maximumMessageSizeBytes)
+		if err != nil {
+			return nil, err
+		}
+	case *pb.BlobAccessConfiguration_Encrypting:
+		backendType = "encrypting"
+		if options.storageType != blobstore.CASStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Encryption can only be used for the Content Addressable Storage")
+		}
+		base, err := createNestedBlobAccess(backend.Encrypting.Backend, options, "encrypting.backend")
+		if err != nil {
+			return nil, err
+		}
+		manifestsOptions := *options
+		manifestsOptions.storageType = blobstore.ACStorageType
+		manifestsOptions.storageTypeName = "ac"
+		manifestsOptions.keyFormat = digest.KeyWithoutInstance
+		manifestsOptions.labels = nil
+		manifests, err := createNestedBlobAccess(backend.Encrypting.Manifests, &manifestsOptions, "encrypting.manifests")
+		if err != nil {
+			return nil, err
+		}
+		keyManager, err := encrypting.NewKeyManagerFromConfiguration(backend.Encrypting.KeyManager)
+		if err != nil {
+			return nil, err
+		}
+		implementation = encrypting.NewEncryptingBlobAccess(
+			base,
+			manifests,
+			keyManager,
+			options.maximumMessageSizeBytes,
+			options.maximumMessageSizeBytes)
+	case *pb.BlobAccessConfiguration_Chaos:
+		backendType = "chaos"
+		base, err := createNestedBlobAccess(backend.Chaos.Backend, options, "chaos.backend")
+		if err != nil {
+			return nil, err
+		}
+		if err := checkProbability("Truncation", backend.Chaos.TruncationProbability); err != nil {
+			return nil, err
+		}
+		if err := checkProbability("Corruption", backend.Chaos.CorruptionProbability); err != nil {
+			return nil, err
+		}
+		configuration := blobstore.ChaosConfiguration{
+			TruncationProbability: backend.Chaos.TruncationProbability,
+			CorruptionProbability: backend.Chaos.CorruptionProbability,
+		}
+		if configuration.Get, err = newFaultInjectionConfiguration(backend.Chaos.Get); err != nil {
+			return nil, util.StatusWrap(err, "Invalid get fault injection configuration")
+		}
+		if configuration.Put, err = newFaultInjectionConfiguration(backend.Chaos.Put); err != nil {
+			return nil, util.StatusWrap(err, "Invalid put fault injection configuration")
 		}
+		if configuration.FindMissing, err = newFaultInjectionConfiguration(backend.Chaos.FindMissing); err != nil {
+			return nil, util.StatusWrap(err, "Invalid find missing fault injection configuration")
+		}
+		implementation = blobstore.NewChaosBlobAccess(
+			base,
+			options.storageType,
+			clock.SystemClock,
+			configuration,
+			options.storageTypeName)
+	case *pb.BlobAccessConfiguration_Bundling:
+		backendType = "bundling"
+		if options.storageType != blobstore.CASStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Bundling can only be used for the Content Addressable Storage")
+		}
+		if backend.Bundling.BundleSizeBytes <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Bundle size must be positive")
+		}
+		base, err := createNestedBlobAccess(backend.Bundling.Backend, options, "bundling.backend")
+		if err != nil {
+			return nil, err
+		}
+		if backend.Bundling.IndexBucketCount <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Number of index buckets must be positive")
+		}
+		indexOptions := *options
+		indexOptions.storageType = blobstore.ACStorageType
+		indexOptions.storageTypeName = "ac"
+		indexOptions.keyFormat = digest.KeyWithoutInstance
+		indexOptions.labels = nil
+		index, err := createNestedBlobAccess(backend.Bundling.Index, &indexOptions, "bundling.index")
+		if err != nil {
+			return nil, err
+		}
+		// Sparse bundles are deleted after their contents have
+		// been compacted. Backends that do not support deletion
+		// cause compaction to be disabled.
+		implementation = blobstore.NewBundlingBlobAccess(
+			base,
+			base.(blobstore.BlobDeleter),
+			index,
+			backend.Bundling.MaximumBlobSizeBytes,
+			backend.Bundling.BundleSizeBytes,
+			uint32(backend.Bundling.IndexBucketCount),
+			options.maximumMessageSizeBytes,
+			options.storageTypeName)
 	case *pb.BlobAccessConfiguration_ReadCaching:
 		backendType = "read_caching"
-		slow, err := createBlobAccess(backend.ReadCaching.Slow, options)
+		slow, err := createNestedBlobAccess(backend.ReadCaching.Slow, options, "read_caching.slow")
 		if err != nil {
 			return nil, err
 		}
-		fast, err := createBlobAccess(backend.ReadCaching.Fast, options)
+		fast, err := createNestedBlobAccess(backend.ReadCaching.Fast, options, "read_caching.fast")
 		if err != nil {
 			return nil, err
 		}
-		implementation = blobstore.NewReadCachingBlobAccess(slow, fast)
+		implementation = blobstore.NewReadCachingBlobAccess(slow, fast, backend.ReadCaching.RefreshOnFindMissing)
 	case *pb.BlobAccessConfiguration_Redis:
 		backendType = "redis"
 
@@ -283,13 +824,13 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		backends := make([]blobstore.BlobAccess, 0, len(backend.Sharding.Shards))
 		weights := make([]uint32, 0, len(backend.Sharding.Shards))
 		hasUndrainedBackend := false
-		for _, shard := range backend.Sharding.Shards {
+		for i, shard := range backend.Sharding.Shards {
 			if shard.Backend == nil {
 				// Drained backend.
 				backends = append(backends, nil)
 			} else {
 				// Undrained backend.
-				backend, err := createBlobAccess(shard.Backend, options)
+				backend, err := createNestedBlobAccess(shard.Backend, options, fmt.Sprintf("sharding.shards[%d].backend", i))
 				if err != nil {
 					return nil, err
 				}
@@ -312,22 +853,26 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			backend.Sharding.HashInitialization)
 	case *pb.BlobAccessConfiguration_SizeDistinguishing:
 		backendType = "size_distinguishing"
-		small, err := createBlobAccess(backend.SizeDistinguishing.Small, options)
+		small, err := createNestedBlobAccess(backend.SizeDistinguishing.Small, options, "size_distinguishing.small")
 		if err != nil {
 			return nil, err
 		}
-		large, err := createBlobAccess(backend.SizeDistinguishing.Large, options)
+		large, err := createNestedBlobAccess(backend.SizeDistinguishing.Large, options, "size_distinguishing.large")
 		if err != nil {
 			return nil, err
 		}
-		implementation = blobstore.NewSizeDistinguishingBlobAccess(small, large, backend.SizeDistinguishing.CutoffSizeBytes)
+		implementation = blobstore.NewSizeDistinguishingBlobAccess(
+			small,
+			large,
+			backend.SizeDistinguishing.CutoffSizeBytes,
+			backend.SizeDistinguishing.CutoffSizeBytesPerInstance)
 	case *pb.BlobAccessConfiguration_Mirrored:
 		backendType = "mirrored"
-		backendA, err := createBlobAccess(backend.Mirrored.BackendA, options)
+		backendA, err := createNestedBlobAccess(backend.Mirrored.BackendA, options, "mirrored.backend_a")
 		if err != nil {
 			return nil, err
 		}
-		backendB, err := createBlobAccess(backend.Mirrored.BackendB, options)
+		backendB, err := createNestedBlobAccess(backend.Mirrored.BackendB, options, "mirrored.backend_b")
 		if err != nil {
 			return nil, err
 		}
@@ -341,27 +886,11 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		}
 		implementation = mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
 	case *pb.BlobAccessConfiguration_Local:
-		var digestLocationMap local.DigestLocationMap
-		switch options.storageType {
-		case blobstore.CASStorageType:
-			// Let the CAS use a single store for all
-			// objects, regardless of the instance name that
-			// was used to store them. There is no need to
-			// distinguish, due to objects being content
-			// addressed.
-			digestLocationMap = createDigestLocationMap(backend.Local)
-		case blobstore.ACStorageType:
-			// Let the AC use a single store per instance name.
-			maps := map[string]local.DigestLocationMap{}
-			for _, instance := range backend.Local.Instances {
-				maps[instance] = createDigestLocationMap(backend.Local)
-			}
-			digestLocationMap = local.NewPerInstanceDigestLocationMap(maps)
-		}
-
 		var sectorSizeBytes int
 		var blockSectorCount int64
 		var blockAllocator local.BlockAllocator
+		var persistentBlockAllocator local.PersistentBlockAllocator
+		var discardData, syncData func() error
 		switch dataBackend := backend.Local.DataBackend.(type) {
 		case *pb.LocalBlobAccessConfiguration_InMemory_:
 			backendType = "local_in_memory"
@@ -372,6 +901,9 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			// maximum storage density.
 			sectorSizeBytes = 1
 			blockSectorCount = dataBackend.InMemory.BlockSizeBytes
+			if blockSectorCount <= 0 {
+				return nil, status.Error(codes.InvalidArgument, "Block size must be positive")
+			}
 			blockAllocator = local.NewInMemoryBlockAllocator(int(dataBackend.InMemory.BlockSizeBytes))
 		case *pb.LocalBlobAccessConfiguration_BlockDevice_:
 			backendType = "local_block_device"
@@ -379,7 +911,7 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			// memory mapped. Automatically determine the
 			// block size based on the size of the block
 			// device and the number of blocks.
-			var f local.ReadWriterAt
+			var f blockDevice
 			var sectorCount int64
 			var err error
 			f, sectorSizeBytes, sectorCount, err = memoryMapBlockDevice(dataBackend.BlockDevice.Path)
@@ -387,31 +919,94 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 				return nil, util.StatusWrapf(err, "Failed to open block device %#v", dataBackend.BlockDevice.Path)
 			}
 			blockCount := dataBackend.BlockDevice.SpareBlocks + backend.Local.OldBlocks + backend.Local.CurrentBlocks + backend.Local.NewBlocks
+			if blockCount <= 0 {
+				return nil, status.Error(codes.InvalidArgument, "The total number of blocks must be positive")
+			}
 			blockSectorCount = sectorCount / int64(blockCount)
-			blockAllocator = local.NewPartitioningBlockAllocator(
+			if blockSectorCount <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "Block device %#v is too small to hold %d blocks", dataBackend.BlockDevice.Path, blockCount)
+			}
+			persistentBlockAllocator = local.NewPartitioningBlockAllocator(
 				f,
 				options.storageType,
 				sectorSizeBytes,
 				blockSectorCount,
 				int(blockCount))
+			blockAllocator = persistentBlockAllocator
+			// Ensure that no storage remains allocated for
+			// blocks that are not in use.
+			discardData = discardDataByPunchingHole(f, sectorCount*int64(sectorSizeBytes), dataBackend.BlockDevice.Path)
+			syncData = f.Sync
+		case *pb.LocalBlobAccessConfiguration_File_:
+			backendType = "local_file"
+			f, err := openSparseFile(dataBackend.File.Path, dataBackend.File.SizeBytes)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Failed to open data file %#v", dataBackend.File.Path)
+			}
+			// Use a sector size that is equal to the page size
+			// of most systems, so that holes may be punched
+			// at block boundaries.
+			sectorSizeBytes = sparseFileSectorSizeBytes
+			blockCount := dataBackend.File.SpareBlocks + backend.Local.OldBlocks + backend.Local.CurrentBlocks + backend.Local.NewBlocks
+			if blockCount <= 0 {
+				f.Close()
+				return nil, status.Error(codes.InvalidArgument, "The total number of blocks must be positive")
+			}
+			blockSectorCount = dataBackend.File.SizeBytes / int64(sectorSizeBytes) / int64(blockCount)
+			if blockSectorCount <= 0 {
+				f.Close()
+				return nil, status.Errorf(codes.InvalidArgument, "Data file size of %d bytes is too small to hold %d blocks of at least %d bytes", dataBackend.File.SizeBytes, blockCount, sectorSizeBytes)
+			}
+			persistentBlockAllocator = local.NewPartitioningBlockAllocator(
+				f,
+				options.storageType,
+				sectorSizeBytes,
+				blockSectorCount,
+				int(blockCount))
+			blockAllocator = persistentBlockAllocator
+			// Ensure that no disk space remains allocated for
+			// blocks that are not in use.
+			discardData = discardDataByPunchingHole(f, dataBackend.File.SizeBytes, dataBackend.File.Path)
+			syncData = f.Sync
 		}
 
-		var err error
-		implementation, err = local.NewLocalBlobAccess(
-			digestLocationMap,
-			blockAllocator,
-			options.storageTypeName,
-			sectorSizeBytes,
-			blockSectorCount,
-			int(backend.Local.OldBlocks),
-			int(backend.Local.CurrentBlocks),
-			int(backend.Local.NewBlocks))
-		if err != nil {
-			return nil, err
+		if backend.Local.Persistent == nil {
+			if discardData != nil {
+				if err := discardData(); err != nil {
+					return nil, err
+				}
+			}
+			digestLocationMap, err := createDigestLocationMap(options.storageType, backend.Local, rand.Uint64(), func(name string) (local.LocationRecordArray, error) {
+				return local.NewInMemoryLocationRecordArray(int(backend.Local.DigestLocationMapSize)), nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			implementation, err = local.NewLocalBlobAccess(
+				digestLocationMap,
+				blockAllocator,
+				options.storageTypeName,
+				sectorSizeBytes,
+				blockSectorCount,
+				int(backend.Local.OldBlocks),
+				int(backend.Local.CurrentBlocks),
+				int(backend.Local.NewBlocks))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			if persistentBlockAllocator == nil {
+				return nil, status.Error(codes.InvalidArgument, "Persistency can only be enabled when storing data on a block device or in a file")
+			}
+			var err error
+			implementation, err = createPersistentLocalBlobAccess(options, backend.Local, persistentBlockAllocator, sectorSizeBytes, blockSectorCount, discardData, syncData)
+			if err != nil {
+				return nil, err
+			}
 		}
 	case *pb.BlobAccessConfiguration_ExistenceCaching:
 		backendType = "existence_caching"
-		base, err := createBlobAccess(backend.ExistenceCaching.Backend, options)
+		base, err := createNestedBlobAccess(backend.ExistenceCaching.Backend, options, "existence_caching.backend")
 		if err != nil {
 			return nil, err
 		}
@@ -420,19 +1015,342 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			return nil, err
 		}
 		implementation = blobstore.NewExistenceCachingBlobAccess(base, existenceCache)
+	case *pb.BlobAccessConfiguration_NegativeCaching:
+		backendType = "negative_caching"
+		base, err := createNestedBlobAccess(backend.NegativeCaching.Backend, options, "negative_caching.backend")
+		if err != nil {
+			return nil, err
+		}
+		missingCache, err := digest.NewExistenceCacheFromConfiguration(backend.NegativeCaching.MissingCache, options.keyFormat, "NegativeCachingBlobAccess")
+		if err != nil {
+			return nil, err
+		}
+		implementation = blobstore.NewNegativeCachingBlobAccess(base, missingCache)
+	case *pb.BlobAccessConfiguration_BloomFiltering:
+		backendType = "bloom_filtering"
+		if !backend.BloomFiltering.WarmUp {
+			return nil, status.Error(codes.InvalidArgument, "Warming up must be enabled, as it is needed to populate the Bloom filter in case no valid copy is stored on disk")
+		}
+		base, err := createNestedBlobAccess(backend.BloomFiltering.Backend, options, "bloom_filtering.backend")
+		if err != nil {
+			return nil, err
+		}
+		// Backends that do not support listing objects cause
+		// warming up to fail with UNIMPLEMENTED.
+		lister := base.(blobstore.DigestLister)
+		bloomFilter, err := bloomfiltering.NewBloomFilterFromFalsePositiveRate(backend.BloomFiltering.ExpectedObjects, backend.BloomFiltering.FalsePositiveRate)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := bloomfiltering.NewExistenceFilter(bloomFilter, options.keyFormat, backend.BloomFiltering.PersistencePath, clock.SystemClock)
+		if err != nil {
+			return nil, err
+		}
+		if backend.BloomFiltering.PersistencePath != "" {
+			persistenceInterval, err := ptypes.Duration(backend.BloomFiltering.PersistenceInterval)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain persistence interval")
+			}
+			if persistenceInterval <= 0 {
+				return nil, status.Error(codes.InvalidArgument, "Persistence interval must be positive")
+			}
+			go filter.Run(context.Background(), persistenceInterval)
+		}
+		if !filter.IsReady() {
+			go func() {
+				if err := filter.WarmUp(context.Background(), lister); err != nil {
+					log.Printf("Failed to warm up Bloom filter of %#v: %s", options.path, err)
+				}
+			}()
+		}
+		implementation = bloomfiltering.NewBloomFilteringBlobAccess(base, filter)
 	default:
 		return nil, errors.New("Configuration did not contain a backend")
 	}
+	if options.backends != nil {
+		options.backends[options.path] = implementation
+	}
 	return blobstore.NewMetricsBlobAccess(implementation, clock.SystemClock, fmt.Sprintf("%s_%s", options.storageTypeName, backendType)), nil
 }
 
-func createDigestLocationMap(config *pb.LocalBlobAccessConfiguration) local.DigestLocationMap {
-	return local.NewHashingDigestLocationMap(
-		local.NewInMemoryLocationRecordArray(int(config.DigestLocationMapSize)),
-		int(config.DigestLocationMapSize),
-		rand.Uint64(),
-		config.DigestLocationMapMaximumGetAttempts,
-		int(config.DigestLocationMapMaximumPutAttempts))
+// blockDevice is a handle for a block device that is used by
+// LocalBlobAccess to store data.
+type blockDevice interface {
+	local.ReadWriterAt
+	local.HolePuncher
+	Sync() error
+}
+
+// discardDataByPunchingHole returns a function that discards all data
+// stored in a file or on a block device that is used by LocalBlobAccess
+// to store data. Platforms and devices that don't support punching
+// holes are permitted, as discarding data is merely an optimization.
+func discardDataByPunchingHole(holePuncher local.HolePuncher, sizeBytes int64, path string) func() error {
+	return func() error {
+		if err := holePuncher.PunchHole(0, sizeBytes); err != nil && status.Code(err) != codes.Unimplemented {
+			return util.StatusWrapf(err, "Failed to discard existing contents of %#v", path)
+		}
+		return nil
+	}
+}
+
+// sparseFileSectorSizeBytes is the sector size that is used when
+// storing data of LocalBlobAccess in a sparse file.
+const sparseFileSectorSizeBytes = 4096
+
+// openSparseFile opens a file that is used by LocalBlobAccess to store
+// data, creating it if needed.
+func openSparseFile(path string, sizeBytes int64) (filesystem.FileReadWriter, error) {
+	directory, err := filesystem.NewLocalDirectory(filepath.Dir(path))
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to open parent directory")
+	}
+	defer directory.Close()
+
+	f, err := directory.OpenReadWrite(filepath.Base(path), filesystem.CreateReuse(0600))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(sizeBytes); err != nil {
+		f.Close()
+		return nil, util.StatusWrap(err, "Failed to set file size")
+	}
+	return f, nil
+}
+
+// createPersistentLocalBlobAccess creates a LocalBlobAccess that stores
+// its digest-location map and the layout of its blocks on disk. If the
+// layout written by a previous run was created with the same
+// parameters, it is restored. Otherwise, all existing data is
+// discarded.
+func createPersistentLocalBlobAccess(options *blobAccessCreationOptions, config *pb.LocalBlobAccessConfiguration, blockAllocator local.PersistentBlockAllocator, sectorSizeBytes int, blockSectorCount int64, discardData func() error, syncData func() error) (blobstore.BlobAccess, error) {
+	stateDirectoryPath := config.Persistent.StateDirectoryPath
+	stateDirectory, err := filesystem.NewLocalDirectory(stateDirectoryPath)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to open state directory %#v", stateDirectoryPath)
+	}
+	defer stateDirectory.Close()
+
+	layoutParameters := &local_pb.LayoutParameters{
+		DigestLocationMapSize: config.DigestLocationMapSize,
+		Instances:             config.Instances,
+		SectorSizeBytes:       int32(sectorSizeBytes),
+		BlockSectorCount:      blockSectorCount,
+		OldBlocks:             config.OldBlocks,
+		CurrentBlocks:         config.CurrentBlocks,
+		NewBlocks:             config.NewBlocks,
+	}
+	persistentStateStore := local.NewFilePersistentStateStore(filepath.Join(stateDirectoryPath, "state"))
+	persistentState, err := persistentStateStore.ReadPersistentState()
+	if err == nil {
+		layoutParameters.HashInitialization = persistentState.LayoutParameters.GetHashInitialization()
+		if !proto.Equal(layoutParameters, persistentState.LayoutParameters) {
+			log.Printf("Discarding persistent state in %#v, as it was written with different parameters", stateDirectoryPath)
+			persistentState = nil
+		}
+	} else if status.Code(err) != codes.NotFound {
+		return nil, util.StatusWrapf(err, "Failed to read persistent state in %#v", stateDirectoryPath)
+	}
+
+	discardContents := persistentState == nil
+	if discardContents {
+		layoutParameters.HashInitialization = rand.Uint64()
+		persistentState = &local_pb.PersistentState{
+			LayoutParameters: layoutParameters,
+		}
+		if discardData != nil {
+			if err := discardData(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var digestLocationMapFiles []filesystem.FileReadWriter
+	digestLocationMap, err := createDigestLocationMap(options.storageType, config, layoutParameters.HashInitialization, func(name string) (local.LocationRecordArray, error) {
+		f, err := stateDirectory.OpenReadWrite(name, filesystem.CreateReuse(0600))
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to open digest-location map %#v", name)
+		}
+		if discardContents {
+			if err := f.Truncate(0); err != nil {
+				f.Close()
+				return nil, util.StatusWrapf(err, "Failed to discard digest-location map %#v", name)
+			}
+		}
+		if err := f.Truncate(config.DigestLocationMapSize * local.LocationRecordSize); err != nil {
+			f.Close()
+			return nil, util.StatusWrapf(err, "Failed to set size of digest-location map %#v", name)
+		}
+		digestLocationMapFiles = append(digestLocationMapFiles, f)
+		return local.NewFileLocationRecordArray(f), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	blobAccess, err := local.NewPersistentLocalBlobAccess(digestLocationMap, blockAllocator, persistentStateStore, persistentState, options.storageTypeName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The layout of blocks is written synchronously. Data and the
+	// digest-location map are written lazily, meaning they need to
+	// be flushed before terminating. Flush data first, so that the
+	// digest-location map never refers to data that is lost.
+	util.RegisterShutdownHook(func(ctx context.Context) error {
+		if err := syncData(); err != nil {
+			return util.StatusWrapf(err, "Failed to synchronize data of %#v", options.path)
+		}
+		for _, f := range digestLocationMapFiles {
+			if err := f.Sync(); err != nil {
+				return util.StatusWrapf(err, "Failed to synchronize digest-location map of %#v", options.path)
+			}
+		}
+		return nil
+	})
+	return blobAccess, nil
+}
+
+// createNestedBlobAccess creates a storage backend that is contained
+// in the configuration of another storage backend. Errors are prefixed
+// with the name of the field, so that it is easier to determine which
+// part of the configuration is invalid.
+func createNestedBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAccessCreationOptions, field string) (blobstore.BlobAccess, error) {
+	nestedOptions := *options
+	nestedOptions.path = options.path + "." + field
+	implementation, err := createBlobAccess(configuration, &nestedOptions)
+	if err != nil {
+		return nil, util.StatusWrap(err, field)
+	}
+	return implementation, nil
+}
+
+// createDigestLocationMap creates the digest-location map of a
+// LocalBlobAccess. The provided function is called to create the
+// LocationRecordArrays that back it, each having a unique name.
+func createDigestLocationMap(storageType blobstore.StorageType, config *pb.LocalBlobAccessConfiguration, hashInitialization uint64, createLocationRecordArray func(name string) (local.LocationRecordArray, error)) (local.DigestLocationMap, error) {
+	newHashingDigestLocationMap := func(name string) (local.DigestLocationMap, error) {
+		recordArray, err := createLocationRecordArray(name)
+		if err != nil {
+			return nil, err
+		}
+		return local.NewHashingDigestLocationMap(
+			recordArray,
+			int(config.DigestLocationMapSize),
+			hashInitialization,
+			config.DigestLocationMapMaximumGetAttempts,
+			int(config.DigestLocationMapMaximumPutAttempts)), nil
+	}
+
+	if storageType == blobstore.CASStorageType {
+		// Let the CAS use a single store for all objects,
+		// regardless of the instance name that was used to
+		// store them. There is no need to distinguish, due to
+		// objects being content addressed.
+		return newHashingDigestLocationMap("digest_location_map")
+	}
+
+	// Let the AC, FSAC and ISCC use a single store per instance
+	// name.
+	maps := map[string]local.DigestLocationMap{}
+	for i, instance := range config.Instances {
+		digestLocationMap, err := newHashingDigestLocationMap(fmt.Sprintf("digest_location_map.%d", i))
+		if err != nil {
+			return nil, err
+		}
+		maps[instance] = digestLocationMap
+	}
+	return local.NewPerInstanceDigestLocationMap(maps), nil
+}
+
+// minimumS3BufferSizeBytes is the minimum part size of S3 multipart
+// uploads.
+const minimumS3BufferSizeBytes = 5 * 1024 * 1024
+
+// checkS3BufferSize ensures that the buffer size of a cloud bucket is
+// permitted by S3. Without this check, uploads would only start to
+// fail at runtime.
+func checkS3BufferSize(writerConfiguration blobstore.CloudWriterConfiguration) error {
+	if bufferSizeBytes := writerConfiguration.BufferSizeBytes; bufferSizeBytes != 0 && bufferSizeBytes < minimumS3BufferSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "Buffer size is %d bytes, while S3 requires it to be at least %d bytes", bufferSizeBytes, minimumS3BufferSizeBytes)
+	}
+	return nil
+}
+
+// newCloudWriterConfiguration converts the options for writing objects
+// into a cloud bucket from the configuration file format.
+func newCloudWriterConfiguration(configuration *pb.CloudBlobAccessConfiguration) (blobstore.CloudWriterConfiguration, error) {
+	if configuration.BufferSizeBytes < 0 {
+		return blobstore.CloudWriterConfiguration{}, status.Error(codes.InvalidArgument, "Buffer size cannot be negative")
+	}
+	if configuration.WriteSizeBytes < 0 {
+		return blobstore.CloudWriterConfiguration{}, status.Error(codes.InvalidArgument, "Write size cannot be negative")
+	}
+	writerConfiguration := blobstore.CloudWriterConfiguration{
+		CacheControl:    configuration.CacheControl,
+		SetContentType:  configuration.SetContentType,
+		BufferSizeBytes: int(configuration.BufferSizeBytes),
+		WriteSizeBytes:  int(configuration.WriteSizeBytes),
+	}
+	for _, rule := range configuration.StorageClassRules {
+		kinds := make([]blobstore.BlobKind, 0, len(rule.Kinds))
+		for _, kind := range rule.Kinds {
+			kinds = append(kinds, blobKinds[kind])
+		}
+		writerConfiguration.StorageClassRules = append(writerConfiguration.StorageClassRules, blobstore.CloudStorageClassRule{
+			StorageClass:     rule.StorageClass,
+			MinimumSizeBytes: rule.MinimumSizeBytes,
+			Kinds:            kinds,
+			MinimumPriority:  rule.MinimumPriority,
+		})
+	}
+	return writerConfiguration, nil
+}
+
+// checkProbability ensures that a probability provided in the
+// configuration file lies in [0.0, 1.0].
+func checkProbability(name string, probability float64) error {
+	if !(probability >= 0 && probability <= 1) {
+		return status.Errorf(codes.InvalidArgument, "%s probability must lie in [0.0, 1.0], while %g was provided", name, probability)
+	}
+	return nil
+}
+
+// newFaultInjectionConfiguration converts the options for injecting
+// faults into a single type of operation from the configuration file
+// format.
+func newFaultInjectionConfiguration(configuration *pb.FaultInjectionConfiguration) (blobstore.FaultInjectionConfiguration, error) {
+	if configuration == nil {
+		return blobstore.FaultInjectionConfiguration{}, nil
+	}
+	if err := checkProbability("Latency", configuration.LatencyProbability); err != nil {
+		return blobstore.FaultInjectionConfiguration{}, err
+	}
+	if err := checkProbability("Error", configuration.ErrorProbability); err != nil {
+		return blobstore.FaultInjectionConfiguration{}, err
+	}
+	faultInjectionConfiguration := blobstore.FaultInjectionConfiguration{
+		LatencyProbability: configuration.LatencyProbability,
+		ErrorProbability:   configuration.ErrorProbability,
+		ErrorCode:          codes.Code(configuration.ErrorCode),
+	}
+	if configuration.Latency != nil {
+		var err error
+		faultInjectionConfiguration.Latency, err = ptypes.Duration(configuration.Latency)
+		if err != nil {
+			return blobstore.FaultInjectionConfiguration{}, util.StatusWrap(err, "Failed to obtain latency")
+		}
+	}
+	if faultInjectionConfiguration.ErrorProbability > 0 && faultInjectionConfiguration.ErrorCode == codes.OK {
+		return blobstore.FaultInjectionConfiguration{}, status.Error(codes.InvalidArgument, "Error code must be provided when injecting errors")
+	}
+	return faultInjectionConfiguration, nil
+}
+
+var blobKinds = map[pb.BlobKind]blobstore.BlobKind{
+	pb.BlobKind_BLOB_KIND_UNKNOWN:       blobstore.BlobKindUnknown,
+	pb.BlobKind_BLOB_KIND_ACTION_RESULT: blobstore.BlobKindActionResult,
+	pb.BlobKind_BLOB_KIND_TREE:          blobstore.BlobKindTree,
+	pb.BlobKind_BLOB_KIND_LOG:           blobstore.BlobKindLog,
 }
 
 func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, options *blobAccessCreationOptions) (blobstore.BlobAccess, error) {
@@ -463,9 +1381,10 @@ func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, option
 		offsetStore = circular.NewCachingOffsetStore(
 			circular.NewFileOffsetStore(offsetFile, config.OffsetFileSizeBytes),
 			uint(config.OffsetCacheSize))
-	case blobstore.ACStorageType:
+	case blobstore.ACStorageType, blobstore.FSACStorageType, blobstore.ISCCStorageType:
 		// Open an offset file for every instance. This is
-		// required for the Action Cache.
+		// required for all stores that are not content
+		// addressed.
 		offsetStores := map[string]circular.OffsetStore{}
 		for _, instance := range config.Instances {
 			offsetFile, err := circularDirectory.OpenReadWrite("offset."+instance, filesystem.CreateReuse(0644))
@@ -0,0 +1,570 @@
+package configuration_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/require"
+
+	status_pb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newLabel(label string) *pb.BlobAccessConfiguration {
+	return &pb.BlobAccessConfiguration{
+		Backend: &pb.BlobAccessConfiguration_Label{Label: label},
+	}
+}
+
+func newWithLabels(backend *pb.BlobAccessConfiguration, labels map[string]*pb.BlobAccessConfiguration) *pb.BlobAccessConfiguration {
+	return &pb.BlobAccessConfiguration{
+		Backend: &pb.BlobAccessConfiguration_WithLabels{
+			WithLabels: &pb.WithLabelsBlobAccessConfiguration{
+				Backend: backend,
+				Labels:  labels,
+			},
+		},
+	}
+}
+
+func TestCreateBlobAccessWithLabels(t *testing.T) {
+	errorBackend := &pb.BlobAccessConfiguration{
+		Backend: &pb.BlobAccessConfiguration_Error{
+			Error: &status_pb.Status{
+				Code:    int32(codes.Unavailable),
+				Message: "Backend offline",
+			},
+		},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		// A single label may be referred to multiple times,
+		// including by other labels.
+		blobAccess, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newWithLabels(
+				&pb.BlobAccessConfiguration{
+					Backend: &pb.BlobAccessConfiguration_ReadCaching{
+						ReadCaching: &pb.ReadCachingBlobAccessConfiguration{
+							Slow: newLabel("slow"),
+							Fast: newLabel("fast"),
+						},
+					},
+				},
+				map[string]*pb.BlobAccessConfiguration{
+					"fast": newLabel("slow"),
+					"slow": errorBackend,
+				}),
+			1<<20)
+		require.NoError(t, err)
+
+		_, err = blobAccess.Get(context.Background(), digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Backend offline"), err)
+	})
+
+	t.Run("NestedScopes", func(t *testing.T) {
+		// Labels of enclosing scopes should remain visible.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newWithLabels(
+				newWithLabels(
+					newLabel("inner"),
+					map[string]*pb.BlobAccessConfiguration{
+						"inner": newLabel("outer"),
+					}),
+				map[string]*pb.BlobAccessConfiguration{
+					"outer": errorBackend,
+				}),
+			1<<20)
+		require.NoError(t, err)
+	})
+
+	t.Run("Undeclared", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			&pb.BlobAccessConfiguration{
+				Backend: &pb.BlobAccessConfiguration_Mirrored{
+					Mirrored: &pb.MirroredBlobAccessConfiguration{
+						BackendA: errorBackend,
+						BackendB: newLabel("nonexistent"),
+					},
+				},
+			},
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "mirrored.backend_b: Label \"nonexistent\" is not declared"), err)
+	})
+
+	t.Run("Unused", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newWithLabels(
+				newLabel("used"),
+				map[string]*pb.BlobAccessConfiguration{
+					"used":   errorBackend,
+					"unused": errorBackend,
+				}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Label \"unused\" is declared, but never used"), err)
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newWithLabels(
+				newLabel("a"),
+				map[string]*pb.BlobAccessConfiguration{
+					"a": newLabel("b"),
+					"b": newLabel("a"),
+				}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "with_labels.backend: Label \"a\": Label \"b\": Label \"a\" refers to itself, either directly or indirectly"), err)
+	})
+}
+
+func TestCreateBlobAccessObjectsAndBackendsFromConfig(t *testing.T) {
+	errorBackend := &pb.BlobAccessConfiguration{
+		Backend: &pb.BlobAccessConfiguration_Error{
+			Error: &status_pb.Status{
+				Code:    int32(codes.Unavailable),
+				Message: "Backend offline",
+			},
+		},
+	}
+
+	// All storage backends should be registered under their path
+	// in the configuration. References to labels should not yield
+	// additional entries.
+	_, _, contentAddressableStorageBackends, actionCacheBackends, err := configuration.CreateBlobAccessObjectsAndBackendsFromConfig(
+		&pb.BlobstoreConfiguration{
+			ContentAddressableStorage: &pb.BlobAccessConfiguration{
+				Backend: &pb.BlobAccessConfiguration_Mirrored{
+					Mirrored: &pb.MirroredBlobAccessConfiguration{
+						BackendA: errorBackend,
+						BackendB: newWithLabels(
+							newLabel("replica"),
+							map[string]*pb.BlobAccessConfiguration{
+								"replica": errorBackend,
+							}),
+						ReplicatorAToB: &pb.BlobReplicatorConfiguration{
+							Mode: &pb.BlobReplicatorConfiguration_Local{Local: &empty.Empty{}},
+						},
+						ReplicatorBToA: &pb.BlobReplicatorConfiguration{
+							Mode: &pb.BlobReplicatorConfiguration_Local{Local: &empty.Empty{}},
+						},
+					},
+				},
+			},
+			ActionCache: errorBackend,
+		},
+		1<<20)
+	require.NoError(t, err)
+
+	var contentAddressableStoragePaths []string
+	for path := range contentAddressableStorageBackends {
+		contentAddressableStoragePaths = append(contentAddressableStoragePaths, path)
+	}
+	require.ElementsMatch(t, []string{
+		"content_addressable_storage",
+		"content_addressable_storage.mirrored.backend_a",
+		"content_addressable_storage.mirrored.backend_b.with_labels.labels[\"replica\"]",
+	}, contentAddressableStoragePaths)
+
+	var actionCachePaths []string
+	for path := range actionCacheBackends {
+		actionCachePaths = append(actionCachePaths, path)
+	}
+	require.Equal(t, []string{"action_cache"}, actionCachePaths)
+}
+
+func TestCreateBlobAccessBundling(t *testing.T) {
+	newCloud := func(url string) *pb.BlobAccessConfiguration {
+		return &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_Cloud{
+				Cloud: &pb.CloudBlobAccessConfiguration{
+					Config: &pb.CloudBlobAccessConfiguration_Url{Url: url},
+				},
+			},
+		}
+	}
+	newBundling := func(backend, index *pb.BlobAccessConfiguration) *pb.BlobAccessConfiguration {
+		return &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_Bundling{
+				Bundling: &pb.BundlingBlobAccessConfiguration{
+					Backend:              backend,
+					Index:                index,
+					MaximumBlobSizeBytes: 5,
+					BundleSizeBytes:      20,
+					IndexBucketCount:     4,
+				},
+			},
+		}
+	}
+
+	t.Run("DeletionUnsupported", func(t *testing.T) {
+		// Backends that do not support deleting objects may
+		// still be used. Compaction is disabled for these.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newBundling(
+				&pb.BlobAccessConfiguration{
+					Backend: &pb.BlobAccessConfiguration_Error{
+						Error: &status_pb.Status{
+							Code:    int32(codes.Unavailable),
+							Message: "Backend offline",
+						},
+					},
+				},
+				newCloud("mem://")),
+			1<<20)
+		require.NoError(t, err)
+	})
+
+	t.Run("NoIndexBuckets", func(t *testing.T) {
+		config := newBundling(newCloud("mem://"), newCloud("mem://"))
+		config.GetBundling().IndexBucketCount = 0
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(config, 1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Number of index buckets must be positive"), err)
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		// Write four objects, each of them ending up in a
+		// sparse bundle of their own. Once compacted, only a
+		// single bundle should remain in the backend, even
+		// though the backend is wrapped in decorators that
+		// provide metrics.
+		bundlesDirectory, err := ioutil.TempDir("", "bundles")
+		require.NoError(t, err)
+		defer os.RemoveAll(bundlesDirectory)
+		indexDirectory, err := ioutil.TempDir("", "index")
+		require.NoError(t, err)
+		defer os.RemoveAll(indexDirectory)
+
+		blobAccess, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newBundling(
+				newCloud("file://"+bundlesDirectory),
+				newCloud("file://"+indexDirectory)),
+			1<<20)
+		require.NoError(t, err)
+
+		ctx := context.Background()
+		helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		for _, blob := range []struct {
+			digest digest.Digest
+			data   string
+		}{
+			{helloDigest, "Hello"},
+			{digest.MustNewDigest("instance", "f5a7924e621e84c9280a9a27e1bcb7f6", 5), "World"},
+			{digest.MustNewDigest("instance", "4175b395e9905127e31472478d9cccad", 5), "Foo12"},
+			{digest.MustNewDigest("instance", "781e5d89210a0480f9364d30c77da847", 5), "Bar34"},
+		} {
+			require.NoError(t, blobAccess.Put(ctx, blob.digest, buffer.NewValidatedBufferFromByteSlice([]byte(blob.data))))
+		}
+
+		listBundles := func() []string {
+			var bundles []string
+			files, err := ioutil.ReadDir(bundlesDirectory)
+			require.NoError(t, err)
+			for _, file := range files {
+				if !strings.HasSuffix(file.Name(), ".attrs") {
+					bundles = append(bundles, file.Name())
+				}
+			}
+			return bundles
+		}
+		for deadline := time.Now().Add(10 * time.Second); len(listBundles()) != 1 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Equal(t, []string{"ee31a03b30bb752fb63201bad380874e-20"}, listBundles())
+
+		// Objects should be read from the compacted bundle.
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestCreateBlobAccessBloomFiltering(t *testing.T) {
+	newBloomFiltering := func(backend *pb.BlobAccessConfiguration, warmUp bool) *pb.BlobAccessConfiguration {
+		return &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_BloomFiltering{
+				BloomFiltering: &pb.BloomFilteringBlobAccessConfiguration{
+					Backend:           backend,
+					ExpectedObjects:   1000,
+					FalsePositiveRate: 0.01,
+					WarmUp:            warmUp,
+				},
+			},
+		}
+	}
+	cloudBackend := &pb.BlobAccessConfiguration{
+		Backend: &pb.BlobAccessConfiguration_Cloud{
+			Cloud: &pb.CloudBlobAccessConfiguration{
+				Config: &pb.CloudBlobAccessConfiguration_Url{Url: "mem://"},
+			},
+		},
+	}
+
+	t.Run("WarmUpDisabled", func(t *testing.T) {
+		// Without warming up, the Bloom filter would never
+		// become ready if no valid copy is stored on disk.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(newBloomFiltering(cloudBackend, false), 1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Warming up must be enabled, as it is needed to populate the Bloom filter in case no valid copy is stored on disk"), err)
+	})
+
+	t.Run("ListingUnsupported", func(t *testing.T) {
+		// Backends that do not support listing objects are only
+		// detected while warming up, as the decorators that
+		// provide metrics forward ListDigests() unconditionally.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newBloomFiltering(
+				&pb.BlobAccessConfiguration{
+					Backend: &pb.BlobAccessConfiguration_Error{
+						Error: &status_pb.Status{
+							Code:    int32(codes.Unavailable),
+							Message: "Backend offline",
+						},
+					},
+				},
+				true),
+			1<<20)
+		require.NoError(t, err)
+	})
+
+	t.Run("Label", func(t *testing.T) {
+		// Backends that support listing objects should also be
+		// usable when referenced through a label, even though
+		// they are wrapped in decorators that provide metrics.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newWithLabels(
+				newBloomFiltering(newLabel("cloud"), true),
+				map[string]*pb.BlobAccessConfiguration{
+					"cloud": cloudBackend,
+				}),
+			1<<20)
+		require.NoError(t, err)
+	})
+}
+
+func TestCreateBlobAccessRetrying(t *testing.T) {
+	newRetrying := func(policy *pb.RetryPolicyConfiguration) *pb.BlobAccessConfiguration {
+		return &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_Retrying{
+				Retrying: &pb.RetryingBlobAccessConfiguration{
+					Backend: &pb.BlobAccessConfiguration{
+						Backend: &pb.BlobAccessConfiguration_Error{
+							Error: &status_pb.Status{
+								Code:    int32(codes.Unavailable),
+								Message: "Backend offline",
+							},
+						},
+					},
+					Policies: []*pb.RetryPolicyConfiguration{policy},
+				},
+			},
+		}
+	}
+
+	t.Run("NoAttempts", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newRetrying(&pb.RetryPolicyConfiguration{
+				InitialBackoff: ptypes.DurationProto(time.Second),
+				MaximumBackoff: ptypes.DurationProto(time.Minute),
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Maximum number of attempts must be at least 1"), err)
+	})
+
+	t.Run("NoMaximumBackoff", func(t *testing.T) {
+		// A maximum backoff of zero would cause all retries to
+		// be performed without any delay.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newRetrying(&pb.RetryPolicyConfiguration{
+				MaximumAttempts: 3,
+				InitialBackoff:  ptypes.DurationProto(time.Second),
+				MaximumBackoff:  ptypes.DurationProto(0),
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Maximum backoff must be positive"), err)
+	})
+}
+
+func TestCreateBlobAccessCloud(t *testing.T) {
+	newCloud := func(cloud *pb.CloudBlobAccessConfiguration) *pb.BlobAccessConfiguration {
+		return &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_Cloud{
+				Cloud: cloud,
+			},
+		}
+	}
+
+	t.Run("NegativeBufferSize", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newCloud(&pb.CloudBlobAccessConfiguration{
+				Config:          &pb.CloudBlobAccessConfiguration_Url{Url: "mem://"},
+				BufferSizeBytes: -1,
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer size cannot be negative"), err)
+	})
+
+	t.Run("NegativeWriteSize", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newCloud(&pb.CloudBlobAccessConfiguration{
+				Config:         &pb.CloudBlobAccessConfiguration_Url{Url: "mem://"},
+				WriteSizeBytes: -1,
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Write size cannot be negative"), err)
+	})
+
+	t.Run("S3BufferSizeTooSmall", func(t *testing.T) {
+		// S3 rejects multipart uploads with parts smaller than
+		// 5 MiB. This should be detected upfront.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newCloud(&pb.CloudBlobAccessConfiguration{
+				Config: &pb.CloudBlobAccessConfiguration_S3{
+					S3: &pb.S3BlobAccessConfiguration{
+						Region: "eu-west-1",
+						Bucket: "buildbarn",
+					},
+				},
+				BufferSizeBytes: 1 << 20,
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer size is 1048576 bytes, while S3 requires it to be at least 5242880 bytes"), err)
+
+		_, err = configuration.CreateCASBlobAccessObjectFromConfig(
+			newCloud(&pb.CloudBlobAccessConfiguration{
+				Config:          &pb.CloudBlobAccessConfiguration_Url{Url: "s3://buildbarn"},
+				BufferSizeBytes: 1 << 20,
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer size is 1048576 bytes, while S3 requires it to be at least 5242880 bytes"), err)
+	})
+}
+
+func TestCreateBlobAccessChaos(t *testing.T) {
+	newChaos := func(chaos *pb.ChaosBlobAccessConfiguration) *pb.BlobAccessConfiguration {
+		chaos.Backend = &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_Error{
+				Error: &status_pb.Status{
+					Code:    int32(codes.Unavailable),
+					Message: "Backend offline",
+				},
+			},
+		}
+		return &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_Chaos{
+				Chaos: chaos,
+			},
+		}
+	}
+
+	t.Run("TruncationProbabilityTooHigh", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newChaos(&pb.ChaosBlobAccessConfiguration{
+				TruncationProbability: 1.5,
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Truncation probability must lie in [0.0, 1.0], while 1.5 was provided"), err)
+	})
+
+	t.Run("CorruptionProbabilityNegative", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newChaos(&pb.ChaosBlobAccessConfiguration{
+				CorruptionProbability: -0.1,
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Corruption probability must lie in [0.0, 1.0], while -0.1 was provided"), err)
+	})
+
+	t.Run("ErrorProbabilityTooHigh", func(t *testing.T) {
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newChaos(&pb.ChaosBlobAccessConfiguration{
+				Put: &pb.FaultInjectionConfiguration{
+					ErrorProbability: 2,
+				},
+			}),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid put fault injection configuration: Error probability must lie in [0.0, 1.0], while 2 was provided"), err)
+	})
+}
+
+func TestCreateBlobAccessLocalPersistent(t *testing.T) {
+	ctx := context.Background()
+
+	newLocal := func(dataBackend *pb.LocalBlobAccessConfiguration, stateDirectoryPath string, oldBlocks int32) *pb.BlobAccessConfiguration {
+		dataBackend.DigestLocationMapSize = 16
+		dataBackend.DigestLocationMapMaximumGetAttempts = 8
+		dataBackend.DigestLocationMapMaximumPutAttempts = 32
+		dataBackend.OldBlocks = oldBlocks
+		dataBackend.CurrentBlocks = 1
+		dataBackend.NewBlocks = 1
+		dataBackend.Persistent = &pb.LocalBlobAccessConfiguration_Persistent{
+			StateDirectoryPath: stateDirectoryPath,
+		}
+		return &pb.BlobAccessConfiguration{
+			Backend: &pb.BlobAccessConfiguration_Local{
+				Local: dataBackend,
+			},
+		}
+	}
+
+	t.Run("InMemory", func(t *testing.T) {
+		// Data stored in memory cannot be retained across
+		// restarts.
+		_, err := configuration.CreateCASBlobAccessObjectFromConfig(
+			newLocal(&pb.LocalBlobAccessConfiguration{
+				DataBackend: &pb.LocalBlobAccessConfiguration_InMemory_{
+					InMemory: &pb.LocalBlobAccessConfiguration_InMemory{
+						BlockSizeBytes: 1024,
+					},
+				},
+			}, "/nonexistent", 1),
+			1<<20)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Persistency can only be enabled when storing data on a block device or in a file"), err)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		directory, err := ioutil.TempDir("", "local")
+		require.NoError(t, err)
+		defer os.RemoveAll(directory)
+
+		newFileBacked := func(oldBlocks int32) *pb.BlobAccessConfiguration {
+			return newLocal(&pb.LocalBlobAccessConfiguration{
+				DataBackend: &pb.LocalBlobAccessConfiguration_File_{
+					File: &pb.LocalBlobAccessConfiguration_File{
+						Path:        directory + "/data",
+						SizeBytes:   16 * 4096,
+						SpareBlocks: 1,
+					},
+				},
+			}, directory, oldBlocks)
+		}
+		blobDigest := digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+		blobAccess, err := configuration.CreateCASBlobAccessObjectFromConfig(newFileBacked(1), 1<<20)
+		require.NoError(t, err)
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		// After a restart, data written previously should still
+		// be accessible.
+		blobAccess, err = configuration.CreateCASBlobAccessObjectFromConfig(newFileBacked(1), 1<<20)
+		require.NoError(t, err)
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		// Changing the layout of blocks should cause all data
+		// to be discarded.
+		blobAccess, err = configuration.CreateCASBlobAccessObjectFromConfig(newFileBacked(2), 1<<20)
+		require.NoError(t, err)
+		_, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
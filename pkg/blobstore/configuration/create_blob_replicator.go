@@ -6,6 +6,7 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -35,7 +36,9 @@ func CreateBlobReplicatorFromConfig(configuration *pb.BlobReplicatorConfiguratio
 		if err != nil {
 			return nil, err
 		}
-		return mirrored.NewQueuedBlobReplicator(source, base, existenceCache), nil
+		replicator := mirrored.NewQueuedBlobReplicator(source, base, existenceCache)
+		util.RegisterShutdownHook(replicator.Drain)
+		return replicator, nil
 	default:
 		return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported replicator")
 	}
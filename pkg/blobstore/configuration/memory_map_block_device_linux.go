@@ -7,9 +7,10 @@ import (
 	"syscall"
 	"unsafe"
 
-	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
-
 	"golang.org/x/sys/unix"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type memoryMap struct {
@@ -28,7 +29,7 @@ type memoryMap struct {
 //
 // Writes may only occur at sector boundaries, as unaligned writes would
 // cause unnecessary read operations against underlying storage.
-func memoryMapBlockDevice(path string) (local.ReadWriterAt, int, int64, error) {
+func memoryMapBlockDevice(path string) (blockDevice, int, int64, error) {
 	fd, err := unix.Open(path, unix.O_RDWR, 0)
 	if err != nil {
 		return nil, 0, 0, err
@@ -89,3 +90,18 @@ func (mm *memoryMap) WriteAt(p []byte, off int64) (int, error) {
 	// would trigger a page fault that causes data to be read.
 	return unix.Pwrite(mm.fd, p, off)
 }
+
+// PunchHole releases the storage backing a region of the block device
+// (e.g., by discarding it on solid state drives).
+func (mm *memoryMap) PunchHole(offset int64, size int64) error {
+	if err := unix.Fallocate(mm.fd, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, size); err == unix.EOPNOTSUPP {
+		return status.Error(codes.Unimplemented, "Punching holes is not supported by this block device")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (mm *memoryMap) Sync() error {
+	return unix.Fsync(mm.fd)
+}
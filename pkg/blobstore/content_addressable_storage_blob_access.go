@@ -2,7 +2,6 @@ package blobstore
 
 import (
 	"context"
-	"fmt"
 	"io"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -53,25 +52,22 @@ func (r *byteStreamChunkReader) Close() {
 	r.cancel()
 }
 
-func (ba *contentAddressableStorageBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
-	var readRequest bytestream.ReadRequest
-	if instance := digest.GetInstance(); instance == "" {
-		readRequest.ResourceName = fmt.Sprintf("blobs/%s/%d", digest.GetHashString(), digest.GetSizeBytes())
-	} else {
-		readRequest.ResourceName = fmt.Sprintf("%s/blobs/%s/%d", instance, digest.GetHashString(), digest.GetSizeBytes())
-	}
+func (ba *contentAddressableStorageBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
 	ctxWithCancel, cancel := context.WithCancel(ctx)
-	client, err := ba.byteStreamClient.Read(ctxWithCancel, &readRequest)
+	client, err := ba.byteStreamClient.Read(ctxWithCancel, &bytestream.ReadRequest{
+		ResourceName: blobDigest.GetByteStreamReadPath(digest.CompressorIdentity),
+	})
 	if err != nil {
+		cancel()
 		return buffer.NewBufferFromError(err)
 	}
-	return buffer.NewCASBufferFromChunkReader(digest, &byteStreamChunkReader{
+	return buffer.NewCASBufferFromChunkReader(blobDigest, &byteStreamChunkReader{
 		client: client,
 		cancel: cancel,
 	}, buffer.Irreparable)
 }
 
-func (ba *contentAddressableStorageBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+func (ba *contentAddressableStorageBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
 	r := b.ToChunkReader(0, ba.readChunkSize)
 	defer r.Close()
 
@@ -80,12 +76,7 @@ func (ba *contentAddressableStorageBlobAccess) Put(ctx context.Context, digest d
 		return err
 	}
 
-	var resourceName string
-	if instance := digest.GetInstance(); instance == "" {
-		resourceName = fmt.Sprintf("uploads/%s/blobs/%s/%d", uuid.Must(ba.uuidGenerator()), digest.GetHashString(), digest.GetSizeBytes())
-	} else {
-		resourceName = fmt.Sprintf("%s/uploads/%s/blobs/%s/%d", instance, uuid.Must(ba.uuidGenerator()), digest.GetHashString(), digest.GetSizeBytes())
-	}
+	resourceName := blobDigest.GetByteStreamWritePath(uuid.Must(ba.uuidGenerator()), digest.CompressorIdentity)
 
 	writeOffset := int64(0)
 	for {
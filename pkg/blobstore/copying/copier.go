@@ -0,0 +1,100 @@
+package copying
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Copier copies objects between two storage backends in bulk. It can,
+// for example, be used to migrate the contents of a Content Addressable
+// Storage from one type of storage backend to another without
+// downtime, by first copying all objects while clients continue using
+// the old backend.
+type Copier struct {
+	source      blobstore.BlobAccess
+	sink        blobstore.BlobAccess
+	concurrency int
+	dryRun      bool
+}
+
+// NewCopier creates a Copier that copies objects from a source to a
+// sink backend. No more than a fixed number of objects are copied
+// concurrently. In dry-run mode, objects that need to be copied are
+// determined, but not copied.
+func NewCopier(source blobstore.BlobAccess, sink blobstore.BlobAccess, concurrency int, dryRun bool) *Copier {
+	return &Copier{
+		source:      source,
+		sink:        sink,
+		concurrency: concurrency,
+		dryRun:      dryRun,
+	}
+}
+
+// CopyBatch copies a set of objects from the source to the sink
+// backend. Objects already present in the sink backend are not copied.
+// This makes it cheap to resume copying after an interruption.
+//
+// The set of objects that were copied (or would have been copied in
+// dry-run mode) is returned. Objects that are absent in the source
+// backend don't cause copying to fail. They are returned separately,
+// so that they can be reported.
+func (c *Copier) CopyBatch(ctx context.Context, digests digest.Set) (digest.Set, digest.Set, error) {
+	missing, err := c.sink.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, digest.EmptySet, util.StatusWrap(err, "Failed to determine which objects are missing in the sink")
+	}
+	if c.dryRun || missing.Empty() {
+		return missing, digest.EmptySet, nil
+	}
+
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	notFound := digest.NewSetBuilder()
+	semaphore := make(chan struct{}, c.concurrency)
+CopyLoop:
+	for _, blobDigest := range missing.Items() {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctxWithCancel.Done():
+			break CopyLoop
+		}
+		wg.Add(1)
+		go func(blobDigest digest.Digest) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := c.sink.Put(ctxWithCancel, blobDigest, c.source.Get(ctxWithCancel, blobDigest)); err != nil {
+				errLock.Lock()
+				if status.Code(err) == codes.NotFound {
+					notFound.Add(blobDigest)
+				} else if firstErr == nil {
+					firstErr = util.StatusWrapf(err, "Failed to copy object %s", blobDigest)
+					cancel()
+				}
+				errLock.Unlock()
+			}
+		}(blobDigest)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return digest.EmptySet, digest.EmptySet, firstErr
+	}
+	if ctx.Err() != nil {
+		return digest.EmptySet, digest.EmptySet, util.StatusFromContext(ctx)
+	}
+	skipped := notFound.Build()
+	copied, _, _ := digest.GetDifferenceAndIntersection(missing, skipped)
+	return copied, skipped, nil
+}
@@ -0,0 +1,99 @@
+package copying_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/copying"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCopier(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	source := mock.NewMockBlobAccess(ctrl)
+	sink := mock.NewMockBlobAccess(ctrl)
+	digest1 := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+	digest2 := digest.MustNewDigest("instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+	digests := digest.NewSetBuilder().Add(digest1).Add(digest2).Build()
+
+	t.Run("FindMissingFailure", func(t *testing.T) {
+		copier := copying.NewCopier(source, sink, 2, false)
+		sink.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, _, err := copier.CopyBatch(ctx, digests)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to determine which objects are missing in the sink: Server offline"), err)
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		// In dry-run mode, no objects should be copied.
+		copier := copying.NewCopier(source, sink, 2, true)
+		sink.EXPECT().FindMissing(ctx, digests).Return(digest.NewSetBuilder().Add(digest2).Build(), nil)
+
+		copied, skipped, err := copier.CopyBatch(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digest2).Build(), copied)
+		require.Equal(t, digest.EmptySet, skipped)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Only objects that are missing in the sink should be
+		// copied.
+		copier := copying.NewCopier(source, sink, 2, false)
+		sink.EXPECT().FindMissing(ctx, digests).Return(digest.NewSetBuilder().Add(digest2).Build(), nil)
+		source.EXPECT().Get(gomock.Any(), digest2).Return(buffer.NewValidatedBufferFromByteSlice([]byte("bar")))
+		sink.EXPECT().Put(gomock.Any(), digest2, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("bar"), data)
+				return nil
+			})
+
+		copied, skipped, err := copier.CopyBatch(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digest2).Build(), copied)
+		require.Equal(t, digest.EmptySet, skipped)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Objects that are absent in the source should be
+		// skipped, while other objects are still copied.
+		copier := copying.NewCopier(source, sink, 1, false)
+		sink.EXPECT().FindMissing(ctx, digests).Return(digests, nil)
+		source.EXPECT().Get(gomock.Any(), digest1).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		source.EXPECT().Get(gomock.Any(), digest2).Return(buffer.NewValidatedBufferFromByteSlice([]byte("bar")))
+		sink.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(100)
+				return err
+			}).Times(2)
+
+		copied, skipped, err := copier.CopyBatch(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digest2).Build(), copied)
+		require.Equal(t, digest.NewSetBuilder().Add(digest1).Build(), skipped)
+	})
+
+	t.Run("CopyFailure", func(t *testing.T) {
+		copier := copying.NewCopier(source, sink, 1, false)
+		sink.EXPECT().FindMissing(ctx, digests).Return(digest.NewSetBuilder().Add(digest1).Build(), nil)
+		source.EXPECT().Get(gomock.Any(), digest1).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Disk on fire")))
+		sink.EXPECT().Put(gomock.Any(), digest1, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(100)
+				return err
+			})
+
+		_, _, err := copier.CopyBatch(ctx, digests)
+		require.Equal(t, status.Error(codes.Internal, "Failed to copy object acbd18db4cc2f85cedef654fccc4a4d8-3-instance: Disk on fire"), err)
+	})
+}
@@ -0,0 +1,57 @@
+package copying
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	warmup_pb "github.com/buildbarn/bb-storage/pkg/proto/warmup"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+type warmUpServer struct {
+	warmer *Warmer
+}
+
+// NewWarmUpServer creates a gRPC service that lets clients copy
+// objects into a fast caching tier ahead of time using a Warmer.
+func NewWarmUpServer(warmer *Warmer) warmup_pb.WarmUpServer {
+	return &warmUpServer{
+		warmer: warmer,
+	}
+}
+
+func newDigestSetFromPartialDigests(instanceName string, partialDigests []*remoteexecution.Digest) (digest.Set, error) {
+	digests := digest.NewSetBuilder()
+	for _, partialDigest := range partialDigests {
+		blobDigest, err := digest.NewDigestFromPartialDigest(instanceName, partialDigest)
+		if err != nil {
+			return digest.EmptySet, err
+		}
+		digests.Add(blobDigest)
+	}
+	return digests.Build(), nil
+}
+
+func (s *warmUpServer) WarmUp(ctx context.Context, request *warmup_pb.WarmUpRequest) (*warmup_pb.WarmUpResponse, error) {
+	blobDigests, err := newDigestSetFromPartialDigests(request.InstanceName, request.BlobDigests)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid blob digest")
+	}
+	treeDigests, err := newDigestSetFromPartialDigests(request.InstanceName, request.TreeDigests)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid tree digest")
+	}
+	copied, err := s.warmer.WarmUp(ctx, blobDigests, treeDigests)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &warmup_pb.WarmUpResponse{
+		ObjectsCopied: int64(copied.Length()),
+	}
+	for _, blobDigest := range copied.Items() {
+		response.BytesCopied += blobDigest.GetSizeBytes()
+	}
+	return response, nil
+}
@@ -0,0 +1,137 @@
+package copying
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// Warmer copies objects from a slow storage backend into a fast
+// caching tier ahead of time. It can, for example, be used to pre-warm
+// edge caches before a release build is started, so that the build
+// doesn't need to wait for objects to be fetched from the slow backend.
+//
+// In addition to copying individual objects, Warmer is capable of
+// copying all files contained in REv2 Tree objects, which makes it
+// possible to warm up all outputs of an action.
+type Warmer struct {
+	copier                        *Copier
+	sinkContentAddressableStorage cas.ContentAddressableStorage
+	batchSize                     int
+}
+
+// NewWarmer creates a Warmer that copies objects using a Copier, in
+// batches of a fixed size. Tree objects are read from the sink after
+// being copied, using the provided ContentAddressableStorage.
+func NewWarmer(copier *Copier, sinkContentAddressableStorage cas.ContentAddressableStorage, batchSize int) *Warmer {
+	return &Warmer{
+		copier:                        copier,
+		sinkContentAddressableStorage: sinkContentAddressableStorage,
+		batchSize:                     batchSize,
+	}
+}
+
+// copyQueue is a helper for calling Copier.CopyBatch() in batches, as
+// opposed to calling it for individual digests.
+type copyQueue struct {
+	context   context.Context
+	copier    *Copier
+	batchSize int
+
+	pending digest.SetBuilder
+	copied  []digest.Set
+}
+
+func (q *copyQueue) add(blobDigest digest.Digest) error {
+	if q.pending.Length() >= q.batchSize {
+		if err := q.flush(); err != nil {
+			return err
+		}
+	}
+	q.pending.Add(blobDigest)
+	return nil
+}
+
+func (q *copyQueue) addDirectory(baseDigest digest.Digest, directory *remoteexecution.Directory) error {
+	if directory == nil {
+		return nil
+	}
+	for _, child := range directory.Files {
+		if child.Digest == nil {
+			continue
+		}
+		childDigest, err := baseDigest.NewDerivedDigest(child.Digest)
+		if err != nil {
+			return util.StatusWrapf(err, "Invalid digest for file %#v", child.Name)
+		}
+		if err := q.add(childDigest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *copyQueue) flush() error {
+	if q.pending.Length() == 0 {
+		return nil
+	}
+	copied, _, err := q.copier.CopyBatch(q.context, q.pending.Build())
+	if err != nil {
+		return err
+	}
+	q.copied = append(q.copied, copied)
+	q.pending = digest.NewSetBuilder()
+	return nil
+}
+
+// WarmUp copies a set of objects and all files contained in a set of
+// Tree objects from the source to the sink backend. Objects already
+// present in the sink backend are not copied, while objects absent in
+// the source backend are skipped. The set of objects that were copied
+// is returned.
+func (w *Warmer) WarmUp(ctx context.Context, blobDigests digest.Set, treeDigests digest.Set) (digest.Set, error) {
+	q := copyQueue{
+		context:   ctx,
+		copier:    w.copier,
+		batchSize: w.batchSize,
+		pending:   digest.NewSetBuilder(),
+	}
+
+	// Copy the Tree objects first, so that they can be read from
+	// the sink afterwards.
+	for _, treeDigest := range treeDigests.Items() {
+		if err := q.add(treeDigest); err != nil {
+			return digest.EmptySet, err
+		}
+	}
+	if err := q.flush(); err != nil {
+		return digest.EmptySet, err
+	}
+
+	for _, blobDigest := range blobDigests.Items() {
+		if err := q.add(blobDigest); err != nil {
+			return digest.EmptySet, err
+		}
+	}
+	for _, treeDigest := range treeDigests.Items() {
+		tree, err := w.sinkContentAddressableStorage.GetTree(ctx, treeDigest)
+		if err != nil {
+			return digest.EmptySet, util.StatusWrapf(err, "Failed to obtain tree %s", treeDigest)
+		}
+		if err := q.addDirectory(treeDigest, tree.Root); err != nil {
+			return digest.EmptySet, util.StatusWrapf(err, "Tree %s", treeDigest)
+		}
+		for _, child := range tree.Children {
+			if err := q.addDirectory(treeDigest, child); err != nil {
+				return digest.EmptySet, util.StatusWrapf(err, "Tree %s", treeDigest)
+			}
+		}
+	}
+	if err := q.flush(); err != nil {
+		return digest.EmptySet, err
+	}
+	return digest.GetUnion(q.copied), nil
+}
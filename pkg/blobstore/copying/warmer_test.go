@@ -0,0 +1,84 @@
+package copying_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/copying"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWarmer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	source := mock.NewMockBlobAccess(ctrl)
+	sink := mock.NewMockBlobAccess(ctrl)
+	sinkContentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	warmer := copying.NewWarmer(copying.NewCopier(source, sink, 2, false), sinkContentAddressableStorage, 2)
+
+	blobDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+	treeDigest := digest.MustNewDigest("instance", "f1a9a5d3b8e7c9f0e1c3a6d4b2e8f7a9", 120)
+	fileDigest1 := digest.MustNewDigest("instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+	fileDigest2 := digest.MustNewDigest("instance", "73feffa4b7f6bb68e44cf984c85f6e88", 3)
+	tree := &remoteexecution.Tree{
+		Root: &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{Name: "a", Digest: fileDigest1.GetPartialDigest()},
+			},
+		},
+		Children: []*remoteexecution.Directory{
+			{
+				Files: []*remoteexecution.FileNode{
+					{Name: "b", Digest: fileDigest2.GetPartialDigest()},
+					{Name: "c", Digest: fileDigest1.GetPartialDigest()},
+				},
+			},
+		},
+	}
+
+	expectCopy := func(blobDigest digest.Digest) {
+		source.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("xyz")))
+		sink.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(200)
+				return err
+			})
+	}
+
+	t.Run("GetTreeFailure", func(t *testing.T) {
+		sink.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(treeDigest).Build()).Return(digest.EmptySet, nil)
+		sinkContentAddressableStorage.EXPECT().GetTree(ctx, treeDigest).Return(nil, status.Error(codes.NotFound, "Object not found"))
+
+		_, err := warmer.WarmUp(ctx, digest.EmptySet, digest.NewSetBuilder().Add(treeDigest).Build())
+		require.Equal(t, status.Error(codes.NotFound, "Failed to obtain tree f1a9a5d3b8e7c9f0e1c3a6d4b2e8f7a9-120-instance: Object not found"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The Tree object should be copied before being read. The
+		// files contained in the tree should be copied in batches
+		// of two, together with the individually requested object.
+		gomock.InOrder(
+			sink.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(treeDigest).Build()).Return(digest.NewSetBuilder().Add(treeDigest).Build(), nil),
+			sinkContentAddressableStorage.EXPECT().GetTree(ctx, treeDigest).Return(tree, nil))
+		expectCopy(treeDigest)
+		sink.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(blobDigest).Add(fileDigest1).Build()).
+			Return(digest.NewSetBuilder().Add(fileDigest1).Build(), nil)
+		expectCopy(fileDigest1)
+		sink.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(fileDigest1).Add(fileDigest2).Build()).
+			Return(digest.NewSetBuilder().Add(fileDigest2).Build(), nil)
+		expectCopy(fileDigest2)
+
+		copied, err := warmer.WarmUp(ctx, digest.NewSetBuilder().Add(blobDigest).Build(), digest.NewSetBuilder().Add(treeDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(treeDigest).Add(fileDigest1).Add(fileDigest2).Build(), copied)
+	})
+}
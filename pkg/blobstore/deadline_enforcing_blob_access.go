@@ -0,0 +1,140 @@
+package blobstore
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DeadlineConfiguration contains the timeouts that are enforced by
+// DeadlineEnforcingBlobAccess. Timeouts that are zero are not
+// enforced.
+type DeadlineConfiguration struct {
+	// Timeout of Get() operations, regardless of the size of the
+	// object.
+	GetTimeout time.Duration
+	// Additional amount of time Get() operations may take for every
+	// byte of the object being read. This permits large objects to
+	// be read, while still catching transfers that are stuck.
+	GetTimeoutPerByte time.Duration
+	// Timeout of Put() operations.
+	PutTimeout time.Duration
+	// Timeout of FindMissing() operations.
+	FindMissingTimeout time.Duration
+}
+
+type deadlineEnforcingBlobAccess struct {
+	blobAccess    BlobAccess
+	clock         clock.Clock
+	configuration DeadlineConfiguration
+}
+
+// NewDeadlineEnforcingBlobAccess creates a decorator for BlobAccess
+// that places an upper bound on the amount of time operations may
+// take. Operations that don't complete in time fail with
+// DEADLINE_EXCEEDED.
+//
+// Clients such as Bazel may specify deadlines of their own, but storage
+// backends (e.g., readers of cloud storage providers) may not always
+// respect them. By using this decorator, stuck operations can be
+// interrupted early, allowing decorators such as RetryingBlobAccess to
+// retry them.
+//
+// For Get() operations, the timeout covers the entire transfer of the
+// object, up to the point where the caller has finished reading it.
+func NewDeadlineEnforcingBlobAccess(blobAccess BlobAccess, clock clock.Clock, configuration DeadlineConfiguration) BlobAccess {
+	return &deadlineEnforcingBlobAccess{
+		blobAccess:    blobAccess,
+		clock:         clock,
+		configuration: configuration,
+	}
+}
+
+// convertDeadlineExceeded converts errors that are caused by the
+// timeout of this decorator expiring to DEADLINE_EXCEEDED. Storage
+// backends may otherwise return errors such as CANCELLED or UNKNOWN.
+func convertDeadlineExceeded(parent context.Context, ctx context.Context, err error, operation string, timeout time.Duration) error {
+	if err != nil && parent.Err() == nil && ctx.Err() == context.DeadlineExceeded {
+		return status.Errorf(codes.DeadlineExceeded, "%s() did not complete within %s", operation, timeout)
+	}
+	return err
+}
+
+// getGetTimeout computes the timeout of a Get() operation for an
+// object of a given size. Timeouts that cannot be represented as a
+// time.Duration are clamped to the largest representable duration.
+func (ba *deadlineEnforcingBlobAccess) getGetTimeout(sizeBytes int64) time.Duration {
+	timeout := ba.configuration.GetTimeout
+	if perByte := ba.configuration.GetTimeoutPerByte; perByte > 0 && sizeBytes > 0 {
+		if timeout < 0 {
+			timeout = 0
+		}
+		if sizeBytes > int64(math.MaxInt64-timeout)/int64(perByte) {
+			return time.Duration(math.MaxInt64)
+		}
+		timeout += time.Duration(sizeBytes) * perByte
+	}
+	return timeout
+}
+
+func (ba *deadlineEnforcingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	timeout := ba.getGetTimeout(digest.GetSizeBytes())
+	if timeout <= 0 {
+		return ba.blobAccess.Get(ctx, digest)
+	}
+	ctxWithTimeout, cancel := ba.clock.NewContextWithTimeout(ctx, timeout)
+	return buffer.WithErrorHandler(
+		ba.blobAccess.Get(ctxWithTimeout, digest),
+		&deadlineEnforcingErrorHandler{
+			parent:  ctx,
+			context: ctxWithTimeout,
+			cancel:  cancel,
+			timeout: timeout,
+		})
+}
+
+func (ba *deadlineEnforcingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	timeout := ba.configuration.PutTimeout
+	if timeout <= 0 {
+		return ba.blobAccess.Put(ctx, digest, b)
+	}
+	ctxWithTimeout, cancel := ba.clock.NewContextWithTimeout(ctx, timeout)
+	defer cancel()
+	err := ba.blobAccess.Put(ctxWithTimeout, digest, b)
+	return convertDeadlineExceeded(ctx, ctxWithTimeout, err, "Put", timeout)
+}
+
+func (ba *deadlineEnforcingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	timeout := ba.configuration.FindMissingTimeout
+	if timeout <= 0 {
+		return ba.blobAccess.FindMissing(ctx, digests)
+	}
+	ctxWithTimeout, cancel := ba.clock.NewContextWithTimeout(ctx, timeout)
+	defer cancel()
+	missing, err := ba.blobAccess.FindMissing(ctxWithTimeout, digests)
+	return missing, convertDeadlineExceeded(ctx, ctxWithTimeout, err, "FindMissing", timeout)
+}
+
+type deadlineEnforcingErrorHandler struct {
+	parent  context.Context
+	context context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+}
+
+func (eh *deadlineEnforcingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	return nil, convertDeadlineExceeded(eh.parent, eh.context, err, "Get", eh.timeout)
+}
+
+func (eh *deadlineEnforcingErrorHandler) Done() {
+	// Release resources associated with the timeout once the
+	// caller is done reading the buffer.
+	eh.cancel()
+}
@@ -0,0 +1,105 @@
+package blobstore_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDeadlineEnforcingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewDeadlineEnforcingBlobAccess(
+		baseBlobAccess,
+		clock,
+		blobstore.DeadlineConfiguration{
+			GetTimeout:         10 * time.Second,
+			GetTimeoutPerByte:  time.Millisecond,
+			FindMissingTimeout: 5 * time.Second,
+		})
+	blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := digest.NewSetBuilder().Add(blobDigest).Build()
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		// The timeout of Get() should depend on the size of the
+		// object. The context should be cancelled once the
+		// caller is done reading.
+		ctxWithTimeout, cancel := context.WithCancel(ctx)
+		clock.EXPECT().NewContextWithTimeout(ctx, 10*time.Second+5*time.Millisecond).Return(ctxWithTimeout, cancel)
+		baseBlobAccess.EXPECT().Get(ctxWithTimeout, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		require.Equal(t, context.Canceled, ctxWithTimeout.Err())
+	})
+
+	t.Run("GetDeadlineExceeded", func(t *testing.T) {
+		// Errors caused by the timeout expiring should be
+		// converted to DEADLINE_EXCEEDED.
+		ctxWithTimeout, cancel := context.WithDeadline(ctx, time.Unix(0, 0))
+		clock.EXPECT().NewContextWithTimeout(ctx, 10*time.Second+5*time.Millisecond).Return(ctxWithTimeout, cancel)
+		baseBlobAccess.EXPECT().Get(ctxWithTimeout, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Canceled, "Context cancelled")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.DeadlineExceeded, "Get() did not complete within 10.005s"), err)
+	})
+
+	t.Run("GetTimeoutOverflow", func(t *testing.T) {
+		// The timeout of objects that are extremely large
+		// cannot be represented. Instead of letting the timeout
+		// overflow, it should be clamped to the largest
+		// representable duration.
+		largeDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 1<<62)
+		ctxWithTimeout, cancel := context.WithCancel(ctx)
+		clock.EXPECT().NewContextWithTimeout(ctx, time.Duration(math.MaxInt64)).Return(ctxWithTimeout, cancel)
+		baseBlobAccess.EXPECT().Get(ctxWithTimeout, largeDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("PutWithoutTimeout", func(t *testing.T) {
+		// No timeout is configured for Put().
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("FindMissingDeadlineExceeded", func(t *testing.T) {
+		ctxWithTimeout, cancel := context.WithDeadline(ctx, time.Unix(0, 0))
+		clock.EXPECT().NewContextWithTimeout(ctx, 5*time.Second).Return(ctxWithTimeout, cancel)
+		baseBlobAccess.EXPECT().FindMissing(ctxWithTimeout, digests).Return(digest.EmptySet, status.Error(codes.Unknown, "context deadline exceeded"))
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, status.Error(codes.DeadlineExceeded, "FindMissing() did not complete within 5s"), err)
+	})
+
+	t.Run("FindMissingOtherError", func(t *testing.T) {
+		// Errors unrelated to the timeout should be passed on.
+		ctxWithTimeout, cancel := context.WithCancel(ctx)
+		clock.EXPECT().NewContextWithTimeout(ctx, 5*time.Second).Return(ctxWithTimeout, cancel)
+		baseBlobAccess.EXPECT().FindMissing(ctxWithTimeout, digests).Return(digest.EmptySet, status.Error(codes.Internal, "Server on fire"))
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, status.Error(codes.Internal, "Server on fire"), err)
+	})
+}
@@ -0,0 +1,29 @@
+package encrypting
+
+import (
+	"io/ioutil"
+
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewKeyManagerFromConfiguration creates a KeyManager based on options
+// specified in a configuration file.
+func NewKeyManagerFromConfiguration(configuration *pb.KeyManagerConfiguration) (KeyManager, error) {
+	if configuration == nil {
+		return nil, status.Error(codes.InvalidArgument, "Key manager configuration not specified")
+	}
+	switch kind := configuration.KeyManager.(type) {
+	case *pb.KeyManagerConfiguration_LocalMasterKeyPath:
+		masterKey, err := ioutil.ReadFile(kind.LocalMasterKeyPath)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to read master key")
+		}
+		return NewLocalKeyManager(masterKey)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported key manager")
+	}
+}
@@ -0,0 +1,258 @@
+package encrypting
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dataKeySizeBytes is the size of the per-object data keys, causing
+// objects to be encrypted using AES-256-GCM.
+const dataKeySizeBytes = 32
+
+// envelopeMagic is written at the start of every envelope, so that
+// objects stored in a different format are rejected.
+var envelopeMagic = [8]byte{'B', 'B', 'E', 'N', 'C', 'R', 'Y', '1'}
+
+// maximumConcurrentManifestLookups is the maximum number of manifests
+// that FindMissing() loads from the backend in parallel.
+const maximumConcurrentManifestLookups = 16
+
+type encryptingBlobAccess struct {
+	base                     blobstore.BlobAccess
+	manifests                blobstore.BlobAccess
+	keyManager               KeyManager
+	maximumSizeBytes         int
+	maximumManifestSizeBytes int
+}
+
+// NewEncryptingBlobAccess creates a decorator for the Content
+// Addressable Storage that encrypts objects before they are written to
+// the backend, so that the backend never observes their contents.
+//
+// Every object is encrypted with AES-GCM using a data key that is
+// randomly generated. The data key is wrapped by a KeyManager and
+// stored alongside the ciphertext in an envelope. As the envelope no
+// longer matches the digest of the original object, it is stored in
+// the backend under its own digest. A manifest referring to the
+// envelope is stored in a separate backend, keyed by the digest of the
+// original object with its instance name cleared. As with NewChunkingBlobAccess(), manifests are
+// encoded as ActionResult messages.
+//
+// Objects are encrypted and decrypted in memory. Large objects may be
+// split up by placing NewChunkingBlobAccess() in front of this
+// decorator.
+func NewEncryptingBlobAccess(base, manifests blobstore.BlobAccess, keyManager KeyManager, maximumSizeBytes, maximumManifestSizeBytes int) blobstore.BlobAccess {
+	return &encryptingBlobAccess{
+		base:                     base,
+		manifests:                manifests,
+		keyManager:               keyManager,
+		maximumSizeBytes:         maximumSizeBytes,
+		maximumManifestSizeBytes: maximumManifestSizeBytes,
+	}
+}
+
+// getAdditionalData returns the data that is authenticated, but not
+// encrypted. By including the digest of the original object, an
+// envelope cannot be used in place of that of another object.
+func getAdditionalData(blobDigest digest.Digest) []byte {
+	return []byte(blobDigest.GetKey(digest.KeyWithoutInstance))
+}
+
+// getEnvelopeDigest loads the manifest of an object, returning the
+// digest of the envelope containing its encrypted contents.
+func (ba *encryptingBlobAccess) getEnvelopeDigest(ctx context.Context, blobDigest digest.Digest) (digest.Digest, error) {
+	manifest, err := ba.manifests.Get(ctx, blobDigest.WithoutInstance()).ToActionResult(ba.maximumManifestSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return digest.BadDigest, err
+		}
+		return digest.BadDigest, util.StatusWrap(err, "Failed to load manifest")
+	}
+	if len(manifest.OutputFiles) != 1 {
+		return digest.BadDigest, status.Errorf(codes.Internal, "Manifest contains %d envelopes, while 1 was expected", len(manifest.OutputFiles))
+	}
+	envelopeDigest, err := blobDigest.NewDerivedDigest(manifest.OutputFiles[0].Digest)
+	if err != nil {
+		return digest.BadDigest, util.StatusWrapWithCode(err, codes.Internal, "Manifest contains invalid envelope digest")
+	}
+	return envelopeDigest, nil
+}
+
+func (ba *encryptingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	envelopeDigest, err := ba.getEnvelopeDigest(ctx, blobDigest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	envelope, err := ba.base.Get(ctx, envelopeDigest).ToByteSlice(int(envelopeDigest.GetSizeBytes()))
+	if err != nil {
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to load envelope"))
+	}
+	plaintext, err := ba.open(ctx, blobDigest, envelope)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewCASBufferFromByteSlice(blobDigest, plaintext, buffer.Irreparable)
+}
+
+// open an envelope, returning the original contents of the object.
+func (ba *encryptingBlobAccess) open(ctx context.Context, blobDigest digest.Digest, envelope []byte) ([]byte, error) {
+	r := bytes.NewReader(envelope)
+	var header struct {
+		Magic               [8]byte
+		WrappedKeySizeBytes uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil || header.Magic != envelopeMagic || int64(header.WrappedKeySizeBytes) > int64(r.Len()) {
+		return nil, status.Error(codes.Internal, "Envelope has an invalid header")
+	}
+	wrappedKey := make([]byte, header.WrappedKeySizeBytes)
+	r.Read(wrappedKey)
+	dataKey, err := ba.keyManager.UnwrapKey(ctx, wrappedKey)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to unwrap data key")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	remainder := envelope[len(envelope)-r.Len():]
+	nonceSize := aead.NonceSize()
+	if len(remainder) < nonceSize {
+		return nil, status.Error(codes.Internal, "Envelope has an invalid header")
+	}
+	plaintext, err := aead.Open(nil, remainder[:nonceSize], remainder[nonceSize:], getAdditionalData(blobDigest))
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to decrypt envelope")
+	}
+	return plaintext, nil
+}
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Invalid data key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Invalid data key")
+	}
+	return aead, nil
+}
+
+func (ba *encryptingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	plaintext, err := b.ToByteSlice(ba.maximumSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	// Generate a data key and a nonce, and wrap the data key.
+	dataKey := make([]byte, dataKeySizeBytes)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to generate data key")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	wrappedKey, err := ba.keyManager.WrapKey(ctx, dataKey)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to wrap data key")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to generate nonce")
+	}
+
+	var envelope bytes.Buffer
+	envelope.Write(envelopeMagic[:])
+	binary.Write(&envelope, binary.LittleEndian, uint32(len(wrappedKey)))
+	envelope.Write(wrappedKey)
+	envelope.Write(nonce)
+	envelope.Write(aead.Seal(nil, nonce, plaintext, getAdditionalData(blobDigest)))
+
+	generator := blobDigest.NewGenerator()
+	generator.Write(envelope.Bytes())
+	envelopeDigest := generator.Sum()
+	if err := ba.base.Put(ctx, envelopeDigest, buffer.NewValidatedBufferFromByteSlice(envelope.Bytes())); err != nil {
+		return util.StatusWrap(err, "Failed to store envelope")
+	}
+
+	// Only store the manifest after the envelope has been stored,
+	// so that incomplete objects never become visible.
+	if err := ba.manifests.Put(ctx, blobDigest.WithoutInstance(), buffer.NewACBufferFromActionResult(
+		&remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "envelope", Digest: envelopeDigest.GetPartialDigest()},
+			},
+		},
+		buffer.UserProvided)); err != nil {
+		return util.StatusWrap(err, "Failed to store manifest")
+	}
+	return nil
+}
+
+func (ba *encryptingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Objects are only present if both their manifest and envelope
+	// are present. Load the manifests in parallel, as every manifest
+	// requires a separate round trip.
+	blobDigests := digests.Items()
+	envelopeDigestsList := make([]digest.Digest, len(blobDigests))
+	errs := make([]error, len(blobDigests))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maximumConcurrentManifestLookups)
+	for i, blobDigest := range blobDigests {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, blobDigest digest.Digest) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			envelopeDigestsList[i], errs[i] = ba.getEnvelopeDigest(ctx, blobDigest)
+		}(i, blobDigest)
+	}
+	wg.Wait()
+
+	missing := digest.NewSetBuilder()
+	envelopeDigests := digest.NewSetBuilder()
+	objectsByEnvelope := map[digest.Digest][]digest.Digest{}
+	for i, blobDigest := range blobDigests {
+		if err := errs[i]; err == nil {
+			envelopeDigest := envelopeDigestsList[i]
+			envelopeDigests.Add(envelopeDigest)
+			objectsByEnvelope[envelopeDigest] = append(objectsByEnvelope[envelopeDigest], blobDigest)
+		} else if status.Code(err) == codes.NotFound {
+			missing.Add(blobDigest)
+		} else {
+			return digest.EmptySet, util.StatusWrapf(err, "Object %s", blobDigest)
+		}
+	}
+
+	if envelopeDigestsSet := envelopeDigests.Build(); !envelopeDigestsSet.Empty() {
+		missingEnvelopes, err := ba.base.FindMissing(ctx, envelopeDigestsSet)
+		if err != nil {
+			return digest.EmptySet, err
+		}
+		for _, envelopeDigest := range missingEnvelopes.Items() {
+			for _, blobDigest := range objectsByEnvelope[envelopeDigest] {
+				missing.Add(blobDigest)
+			}
+		}
+	}
+	return missing.Build(), nil
+}
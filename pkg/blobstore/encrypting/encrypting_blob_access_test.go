@@ -0,0 +1,109 @@
+package encrypting_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/encrypting"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEncryptingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	manifestsBlobAccess := mock.NewMockBlobAccess(ctrl)
+	keyManager, err := encrypting.NewLocalKeyManager([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+	blobAccess := encrypting.NewEncryptingBlobAccess(baseBlobAccess, manifestsBlobAccess, keyManager, 1000, 1000)
+
+	blobDigest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	otherDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// Store an object, capturing the envelope and manifest.
+	var envelopeDigest digest.Digest
+	var envelope []byte
+	var manifest *remoteexecution.ActionResult
+	baseBlobAccess.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(1000)
+			require.NoError(t, err)
+			envelopeDigest = blobDigest
+			envelope = data
+			return nil
+		})
+	manifestsBlobAccess.EXPECT().Put(ctx, blobDigest.WithoutInstance(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			actionResult, err := b.ToActionResult(1000)
+			require.NoError(t, err)
+			manifest = actionResult
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+	t.Run("Envelope", func(t *testing.T) {
+		// The backend should not observe the plaintext. The
+		// manifest should refer to the envelope.
+		require.False(t, bytes.Contains(envelope, []byte("Hello world")))
+		require.Len(t, manifest.OutputFiles, 1)
+		require.True(t, proto.Equal(envelopeDigest.GetPartialDigest(), manifest.OutputFiles[0].Digest))
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		manifestsBlobAccess.EXPECT().Get(ctx, blobDigest.WithoutInstance()).Return(buffer.NewACBufferFromActionResult(manifest, buffer.UserProvided))
+		baseBlobAccess.EXPECT().Get(ctx, envelopeDigest).Return(buffer.NewValidatedBufferFromByteSlice(envelope))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(1000)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		manifestsBlobAccess.EXPECT().Get(ctx, blobDigest.WithoutInstance()).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(1000)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("GetSwappedEnvelope", func(t *testing.T) {
+		// An envelope should not be usable in place of that of a
+		// different object.
+		manifestsBlobAccess.EXPECT().Get(ctx, otherDigest.WithoutInstance()).Return(buffer.NewACBufferFromActionResult(manifest, buffer.UserProvided))
+		baseBlobAccess.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewValidatedBufferFromByteSlice(envelope))
+
+		_, err := blobAccess.Get(ctx, otherDigest).ToByteSlice(1000)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("GetWrongMasterKey", func(t *testing.T) {
+		otherKeyManager, err := encrypting.NewLocalKeyManager([]byte("fedcba9876543210fedcba9876543210"))
+		require.NoError(t, err)
+		otherBlobAccess := encrypting.NewEncryptingBlobAccess(baseBlobAccess, manifestsBlobAccess, otherKeyManager, 1000, 1000)
+		manifestsBlobAccess.EXPECT().Get(ctx, blobDigest.WithoutInstance()).Return(buffer.NewACBufferFromActionResult(manifest, buffer.UserProvided))
+		baseBlobAccess.EXPECT().Get(ctx, envelopeDigest).Return(buffer.NewValidatedBufferFromByteSlice(envelope))
+
+		_, err = otherBlobAccess.Get(ctx, blobDigest).ToByteSlice(1000)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		manifestsBlobAccess.EXPECT().Get(ctx, blobDigest.WithoutInstance()).Return(buffer.NewACBufferFromActionResult(manifest, buffer.UserProvided))
+		manifestsBlobAccess.EXPECT().Get(ctx, otherDigest.WithoutInstance()).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(envelopeDigest).Build()).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(blobDigest).Add(otherDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(otherDigest).Build(), missing)
+	})
+}
@@ -0,0 +1,68 @@
+package encrypting
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KeyManager is used by EncryptingBlobAccess to protect the data keys
+// with which objects are encrypted. Data keys are wrapped using a
+// master key that is managed by the KeyManager, so that the master key
+// itself never needs to be stored alongside the data.
+//
+// Implementations of this interface may call into an external Key
+// Management Service (KMS), in which case the master key never leaves
+// the KMS.
+type KeyManager interface {
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrappedKey []byte) ([]byte, error)
+}
+
+type localKeyManager struct {
+	masterKey cipher.AEAD
+}
+
+// NewLocalKeyManager creates a KeyManager that wraps data keys using a
+// master key that is held in memory, using AES-GCM. The master key
+// must be 16, 24 or 32 bytes in size.
+func NewLocalKeyManager(masterKey []byte) (KeyManager, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid master key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid master key")
+	}
+	return &localKeyManager{
+		masterKey: aead,
+	}, nil
+}
+
+func (km *localKeyManager) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, km.masterKey.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to generate nonce")
+	}
+	return km.masterKey.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (km *localKeyManager) UnwrapKey(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	nonceSize := km.masterKey.NonceSize()
+	if len(wrappedKey) < nonceSize {
+		return nil, status.Error(codes.InvalidArgument, "Wrapped key is too short")
+	}
+	dataKey, err := km.masterKey.Open(nil, wrappedKey[:nonceSize], wrappedKey[nonceSize:], nil)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unwrap key")
+	}
+	return dataKey, nil
+}
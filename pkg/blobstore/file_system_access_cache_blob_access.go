@@ -0,0 +1,62 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/fsac"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fileSystemAccessCacheBlobAccess struct {
+	fileSystemAccessCacheClient fsac.FileSystemAccessCacheClient
+	maximumMessageSizeBytes     int
+}
+
+// NewFileSystemAccessCacheBlobAccess creates a BlobAccess handle that
+// relays any requests to a gRPC service that implements the
+// fsac.FileSystemAccessCache service.
+func NewFileSystemAccessCacheBlobAccess(client *grpc.ClientConn, maximumMessageSizeBytes int) BlobAccess {
+	return &fileSystemAccessCacheBlobAccess{
+		fileSystemAccessCacheClient: fsac.NewFileSystemAccessCacheClient(client),
+		maximumMessageSizeBytes:     maximumMessageSizeBytes,
+	}
+}
+
+func (ba *fileSystemAccessCacheBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	profile, err := ba.fileSystemAccessCacheClient.GetFileSystemAccessProfile(ctx, &fsac.GetFileSystemAccessProfileRequest{
+		InstanceName:        digest.GetInstance(),
+		ReducedActionDigest: digest.GetPartialDigest(),
+	})
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewProtoBufferFromProto(profile, buffer.Irreparable)
+}
+
+func (ba *fileSystemAccessCacheBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	data, err := b.ToByteSlice(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return err
+	}
+	var profile fsac.FileSystemAccessProfile
+	if err := proto.Unmarshal(data, &profile); err != nil {
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal message")
+	}
+	_, err = ba.fileSystemAccessCacheClient.UpdateFileSystemAccessProfile(ctx, &fsac.UpdateFileSystemAccessProfileRequest{
+		InstanceName:            digest.GetInstance(),
+		ReducedActionDigest:     digest.GetPartialDigest(),
+		FileSystemAccessProfile: &profile,
+	})
+	return err
+}
+
+func (ba *fileSystemAccessCacheBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	return digest.EmptySet, status.Error(codes.Unimplemented, "File System Access Cache does not support bulk existence checking")
+}
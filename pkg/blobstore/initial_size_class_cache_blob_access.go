@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/iscc"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type initialSizeClassCacheBlobAccess struct {
+	initialSizeClassCacheClient iscc.InitialSizeClassCacheClient
+	maximumMessageSizeBytes     int
+}
+
+// NewInitialSizeClassCacheBlobAccess creates a BlobAccess handle that
+// relays any requests to a gRPC service that implements the
+// iscc.InitialSizeClassCache service.
+//
+// Calls to Put() replace the statistics stored by the remote service,
+// similar to other storage backends. The caller is responsible for
+// merging statistics (e.g., InitialSizeClassCacheServer).
+func NewInitialSizeClassCacheBlobAccess(client *grpc.ClientConn, maximumMessageSizeBytes int) BlobAccess {
+	return &initialSizeClassCacheBlobAccess{
+		initialSizeClassCacheClient: iscc.NewInitialSizeClassCacheClient(client),
+		maximumMessageSizeBytes:     maximumMessageSizeBytes,
+	}
+}
+
+func (ba *initialSizeClassCacheBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	stats, err := ba.initialSizeClassCacheClient.GetPreviousExecutionStats(ctx, &iscc.GetPreviousExecutionStatsRequest{
+		InstanceName:        digest.GetInstance(),
+		ReducedActionDigest: digest.GetPartialDigest(),
+	})
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewProtoBufferFromProto(stats, buffer.Irreparable)
+}
+
+func (ba *initialSizeClassCacheBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	data, err := b.ToByteSlice(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return err
+	}
+	var stats iscc.PreviousExecutionStats
+	if err := proto.Unmarshal(data, &stats); err != nil {
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal message")
+	}
+	_, err = ba.initialSizeClassCacheClient.UpdatePreviousExecutionStats(ctx, &iscc.UpdatePreviousExecutionStatsRequest{
+		InstanceName:           digest.GetInstance(),
+		ReducedActionDigest:    digest.GetPartialDigest(),
+		PreviousExecutionStats: &stats,
+		Replace:                true,
+	})
+	return err
+}
+
+func (ba *initialSizeClassCacheBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	return digest.EmptySet, status.Error(codes.Unimplemented, "Initial Size Class Cache does not support bulk existence checking")
+}
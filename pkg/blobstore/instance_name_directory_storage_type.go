@@ -0,0 +1,45 @@
+package blobstore
+
+import (
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type instanceNameDirectoryStorageType struct {
+	StorageType
+}
+
+// NewInstanceNameDirectoryStorageType creates a decorator for
+// StorageType that places keys in a directory hierarchy named after the
+// instance name (e.g., "${instanceName}/${hash}-${sizeBytes}"). Keys of
+// digests with an empty instance name are placed at the top level.
+//
+// As the instance name is always made part of the key, this disables
+// deduplication of objects across instances for the Content
+// Addressable Storage.
+func NewInstanceNameDirectoryStorageType(base StorageType) StorageType {
+	return instanceNameDirectoryStorageType{
+		StorageType: base,
+	}
+}
+
+func (f instanceNameDirectoryStorageType) GetDigestKey(blobDigest digest.Digest) string {
+	key := blobDigest.GetKey(digest.KeyWithoutInstance)
+	if instance := blobDigest.GetInstance(); instance != "" {
+		return instance + "/" + key
+	}
+	return key
+}
+
+func (f instanceNameDirectoryStorageType) ParseDigestKey(key string) (digest.Digest, error) {
+	instance := ""
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		instance, key = key[:i], key[i+1:]
+	}
+	blobDigest, err := digest.NewDigestFromKey(key, digest.KeyWithoutInstance)
+	if err != nil {
+		return digest.BadDigest, err
+	}
+	return digest.NewDigest(instance, blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+}
@@ -0,0 +1,218 @@
+package leasing
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	blobLeaseManagerPrometheusMetrics sync.Once
+
+	blobLeaseManagerLeasedBlobs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "blob_lease_manager_leased_blobs",
+			Help:      "Number of blobs on which a lease is currently held.",
+		},
+		[]string{"name"})
+	blobLeaseManagerBlobsRefreshed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "blob_lease_manager_blobs_refreshed_total",
+			Help:      "Number of times leased blobs were refreshed by calling FindMissing() against the storage backend.",
+		},
+		[]string{"name"})
+	blobLeaseManagerLeasesEnded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "blob_lease_manager_leases_ended_total",
+			Help:      "Number of leases on blobs that ended, either due to expiration or due to the blob going missing.",
+		},
+		[]string{"name", "reason"})
+)
+
+type leasedBlob struct {
+	digest     digest.Digest
+	expiration time.Time
+}
+
+// BlobLeaseManager keeps track of leases on blobs. As long as a lease
+// on a blob has not expired, the blob is periodically refreshed by
+// calling FindMissing() against the storage backend.
+//
+// Storage backends such as LocalBlobAccess move blobs that are at risk
+// of being evicted to the front of the queue when they are reported
+// through FindMissing(). By refreshing leased blobs at an interval that
+// is shorter than the time it takes for blobs to be evicted, blobs that
+// are inputs of actions that are currently running (e.g., on workers
+// that have a lease on them) remain present.
+//
+// Leases are not enforced by the eviction path of storage backends.
+// Blobs may still be evicted if the refresh interval is too long or if
+// storage backends don't refresh blobs in FindMissing(). Leases on such
+// blobs end once they are found to be missing.
+type BlobLeaseManager struct {
+	blobAccess           blobstore.BlobAccess
+	clock                clock.Clock
+	maximumLeaseDuration time.Duration
+	maximumLeasedBlobs   int
+
+	lock   sync.Mutex
+	leases map[string]leasedBlob
+
+	leasedBlobs          prometheus.Gauge
+	blobsRefreshed       prometheus.Counter
+	leasesEndedExpired   prometheus.Counter
+	leasesEndedBlobsLost prometheus.Counter
+}
+
+// NewBlobLeaseManager creates a BlobLeaseManager that refreshes leased
+// blobs stored in a BlobAccess. Leases may not exceed a maximum
+// duration, and the total number of leased blobs is bounded to limit
+// memory usage.
+func NewBlobLeaseManager(blobAccess blobstore.BlobAccess, clock clock.Clock, maximumLeaseDuration time.Duration, maximumLeasedBlobs int, name string) *BlobLeaseManager {
+	blobLeaseManagerPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(blobLeaseManagerLeasedBlobs)
+		prometheus.MustRegister(blobLeaseManagerBlobsRefreshed)
+		prometheus.MustRegister(blobLeaseManagerLeasesEnded)
+	})
+
+	return &BlobLeaseManager{
+		blobAccess:           blobAccess,
+		clock:                clock,
+		maximumLeaseDuration: maximumLeaseDuration,
+		maximumLeasedBlobs:   maximumLeasedBlobs,
+
+		leases: map[string]leasedBlob{},
+
+		leasedBlobs:          blobLeaseManagerLeasedBlobs.WithLabelValues(name),
+		blobsRefreshed:       blobLeaseManagerBlobsRefreshed.WithLabelValues(name),
+		leasesEndedExpired:   blobLeaseManagerLeasesEnded.WithLabelValues(name, "Expired"),
+		leasesEndedBlobsLost: blobLeaseManagerLeasesEnded.WithLabelValues(name, "BlobLost"),
+	}
+}
+
+// AcquireLeases acquires leases on a set of blobs for a given amount of
+// time. Leases on blobs that are already leased are extended. Blobs
+// that are not present in storage are returned. No leases are acquired
+// on these.
+func (lm *BlobLeaseManager) AcquireLeases(ctx context.Context, digests digest.Set, duration time.Duration) (digest.Set, error) {
+	if duration <= 0 {
+		return digest.EmptySet, status.Error(codes.InvalidArgument, "Lease duration must be positive")
+	}
+	if duration > lm.maximumLeaseDuration {
+		duration = lm.maximumLeaseDuration
+	}
+
+	// Calling FindMissing() already causes the blobs to be
+	// refreshed for the first time.
+	missing, err := lm.blobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Failed to determine presence of blobs")
+	}
+	present, _, _ := digest.GetDifferenceAndIntersection(digests, missing)
+	expiration := lm.clock.Now().Add(duration)
+
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+
+	// Check whether there is enough space to store all new leases
+	// before making any changes, so that leases are either acquired
+	// on all blobs or on none of them.
+	newLeases := 0
+	for _, blobDigest := range present.Items() {
+		if _, ok := lm.leases[blobDigest.GetKey(digest.KeyWithInstance)]; !ok {
+			newLeases++
+		}
+	}
+	if len(lm.leases)+newLeases > lm.maximumLeasedBlobs {
+		return digest.EmptySet, status.Errorf(codes.ResourceExhausted, "Cannot hold leases on more than %d blobs", lm.maximumLeasedBlobs)
+	}
+
+	for _, blobDigest := range present.Items() {
+		key := blobDigest.GetKey(digest.KeyWithInstance)
+		if lease, ok := lm.leases[key]; !ok || lease.expiration.Before(expiration) {
+			lm.leases[key] = leasedBlob{
+				digest:     blobDigest,
+				expiration: expiration,
+			}
+		}
+	}
+	lm.leasedBlobs.Set(float64(len(lm.leases)))
+	return missing, nil
+}
+
+// Refresh all blobs for which a lease is held, and remove leases that
+// have expired. Leases on blobs that are no longer present in storage
+// are removed as well.
+func (lm *BlobLeaseManager) Refresh(ctx context.Context) error {
+	now := lm.clock.Now()
+	leased := digest.NewSetBuilder()
+	lm.lock.Lock()
+	for key, lease := range lm.leases {
+		if lease.expiration.After(now) {
+			leased.Add(lease.digest)
+		} else {
+			delete(lm.leases, key)
+			lm.leasesEndedExpired.Inc()
+		}
+	}
+	lm.leasedBlobs.Set(float64(len(lm.leases)))
+	lm.lock.Unlock()
+
+	digests := leased.Build()
+	if digests.Empty() {
+		return nil
+	}
+	missing, err := lm.blobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to refresh leased blobs")
+	}
+	lm.blobsRefreshed.Add(float64(digests.Length() - missing.Length()))
+
+	// Leased blobs that have disappeared can no longer be protected.
+	lm.lock.Lock()
+	for _, blobDigest := range missing.Items() {
+		key := blobDigest.GetKey(digest.KeyWithInstance)
+		if _, ok := lm.leases[key]; ok {
+			delete(lm.leases, key)
+			lm.leasesEndedBlobsLost.Inc()
+		}
+	}
+	lm.leasedBlobs.Set(float64(len(lm.leases)))
+	lm.lock.Unlock()
+	return nil
+}
+
+// Run calls Refresh() at a fixed interval. The interval should be
+// shorter than the amount of time it takes for blobs to be evicted
+// from storage after being refreshed. This function returns when the
+// provided context is cancelled.
+func (lm *BlobLeaseManager) Run(ctx context.Context, refreshInterval time.Duration) {
+	for {
+		timer, t := lm.clock.NewTimer(refreshInterval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		if err := lm.Refresh(ctx); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package leasing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/leasing"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBlobLeaseManager(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	leaseManager := leasing.NewBlobLeaseManager(blobAccess, clock, time.Hour, 2, "TestBlobLeaseManager")
+
+	digest1 := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+	digest2 := digest.MustNewDigest("instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+	digest3 := digest.MustNewDigest("instance", "73feffa4b7f6bb68e44cf984c85f6e88", 3)
+
+	t.Run("InvalidDuration", func(t *testing.T) {
+		_, err := leaseManager.AcquireLeases(ctx, digest.NewSetBuilder().Add(digest1).Build(), 0)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Lease duration must be positive"), err)
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Build()).
+			Return(digest.EmptySet, status.Error(codes.Internal, "Server on fire"))
+
+		_, err := leaseManager.AcquireLeases(ctx, digest.NewSetBuilder().Add(digest1).Build(), time.Minute)
+		require.Equal(t, status.Error(codes.Internal, "Failed to determine presence of blobs: Server on fire"), err)
+	})
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		// Acquire leases on two blobs, one of them being absent.
+		// Only the blob that is present should be leased.
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build()).
+			Return(digest.NewSetBuilder().Add(digest2).Build(), nil)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+
+		missing, err := leaseManager.AcquireLeases(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build(), time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digest2).Build(), missing)
+
+		// Acquire a long-lived lease on another blob. The
+		// duration should be capped to the maximum.
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest3).Build()).
+			Return(digest.EmptySet, nil)
+		clock.EXPECT().Now().Return(time.Unix(1010, 0))
+
+		missing, err = leaseManager.AcquireLeases(ctx, digest.NewSetBuilder().Add(digest3).Build(), 24*time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+
+		// No more than two blobs may be leased. Failing to
+		// acquire a lease on one blob should not cause leases
+		// on other blobs in the same request to be acquired or
+		// extended.
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build()).
+			Return(digest.EmptySet, nil)
+		clock.EXPECT().Now().Return(time.Unix(1020, 0))
+
+		_, err = leaseManager.AcquireLeases(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build(), time.Hour)
+		require.Equal(t, status.Error(codes.ResourceExhausted, "Cannot hold leases on more than 2 blobs"), err)
+
+		// Refreshing should call FindMissing() on both leased
+		// blobs.
+		clock.EXPECT().Now().Return(time.Unix(1030, 0))
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Add(digest3).Build()).
+			Return(digest.EmptySet, nil)
+		require.NoError(t, leaseManager.Refresh(ctx))
+
+		// Once the lease on the first blob expires, it should
+		// no longer be refreshed.
+		clock.EXPECT().Now().Return(time.Unix(1060, 0))
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest3).Build()).
+			Return(digest.EmptySet, nil)
+		require.NoError(t, leaseManager.Refresh(ctx))
+
+		// Leases on blobs that have gone missing should be
+		// dropped.
+		clock.EXPECT().Now().Return(time.Unix(1100, 0))
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest3).Build()).
+			Return(digest.NewSetBuilder().Add(digest3).Build(), nil)
+		require.NoError(t, leaseManager.Refresh(ctx))
+
+		clock.EXPECT().Now().Return(time.Unix(1110, 0))
+		require.NoError(t, leaseManager.Refresh(ctx))
+	})
+}
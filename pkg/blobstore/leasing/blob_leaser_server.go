@@ -0,0 +1,50 @@
+package leasing
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	lease_pb "github.com/buildbarn/bb-storage/pkg/proto/lease"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+)
+
+type blobLeaserServer struct {
+	leaseManager *BlobLeaseManager
+}
+
+// NewBlobLeaserServer creates a gRPC stub for the BlobLeaser service
+// that forwards all calls to BlobLeaseManager.
+func NewBlobLeaserServer(leaseManager *BlobLeaseManager) lease_pb.BlobLeaserServer {
+	return blobLeaserServer{
+		leaseManager: leaseManager,
+	}
+}
+
+func (ls blobLeaserServer) AcquireBlobLeases(ctx context.Context, request *lease_pb.AcquireBlobLeasesRequest) (*lease_pb.AcquireBlobLeasesResponse, error) {
+	digests := digest.NewSetBuilder()
+	for i, blobDigest := range request.BlobDigests {
+		d, err := digest.NewDigestFromPartialDigest(request.InstanceName, blobDigest)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Digest at index %d", i)
+		}
+		digests.Add(d)
+	}
+	duration, err := ptypes.Duration(request.Duration)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to obtain lease duration")
+	}
+
+	missing, err := ls.leaseManager.AcquireLeases(ctx, digests.Build(), duration)
+	if err != nil {
+		return nil, err
+	}
+	partialDigests := make([]*remoteexecution.Digest, 0, missing.Length())
+	for _, blobDigest := range missing.Items() {
+		partialDigests = append(partialDigests, blobDigest.GetPartialDigest())
+	}
+	return &lease_pb.AcquireBlobLeasesResponse{
+		MissingBlobDigests: partialDigests,
+	}, nil
+}
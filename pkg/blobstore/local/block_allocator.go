@@ -19,3 +19,19 @@ type Block interface {
 type BlockAllocator interface {
 	NewBlock() (Block, error)
 }
+
+// PersistentBlockAllocator is a BlockAllocator whose blocks retain
+// their contents across restarts. Every block is identified by an
+// index, which LocalBlobAccess stores as part of its persistent state.
+// This allows it to reattach to blocks allocated by a previous run.
+type PersistentBlockAllocator interface {
+	BlockAllocator
+
+	// NewBlockWithIndex is identical to NewBlock(), except that it
+	// also returns the index of the block that was allocated.
+	NewBlockWithIndex() (Block, int, error)
+
+	// NewBlockAtIndex allocates the block at a given index, so
+	// that data stored within it by a previous run may be accessed.
+	NewBlockAtIndex(index int) (Block, error)
+}
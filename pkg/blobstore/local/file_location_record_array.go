@@ -0,0 +1,82 @@
+package local
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+)
+
+// LocationRecordSize is the size of a single LocationRecord when
+// stored by the LocationRecordArray returned by
+// NewFileLocationRecordArray.
+const LocationRecordSize = sha256.Size + 4 + 8 + 8 + 8 + 4
+
+type fileLocationRecordArray struct {
+	f ReadWriterAt
+}
+
+// NewFileLocationRecordArray creates a LocationRecordArray that stores
+// its data in a file or on a block device. This makes it possible to
+// retain the digest-location map across restarts.
+//
+// Every record is stored with a checksum. Records that are corrupted
+// or have never been written (e.g., the file is sparse) are treated as
+// if they were zero initialized, meaning that they don't refer to any
+// valid location. Because LocationRecordArray cannot return errors,
+// I/O errors are logged and cause records to be treated in the same
+// way. This causes the corresponding objects to be reported as absent.
+func NewFileLocationRecordArray(f ReadWriterAt) LocationRecordArray {
+	return &fileLocationRecordArray{
+		f: f,
+	}
+}
+
+func locationRecordChecksum(data []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(data)
+	return h.Sum32()
+}
+
+func (lra *fileLocationRecordArray) Get(index int) LocationRecord {
+	var data [LocationRecordSize]byte
+	if _, err := lra.f.ReadAt(data[:], int64(index)*LocationRecordSize); err != nil {
+		log.Printf("Failed to read location record at index %d: %s", index, err)
+		return LocationRecord{}
+	}
+	checksumOffset := LocationRecordSize - 4
+	if locationRecordChecksum(data[:checksumOffset]) != binary.LittleEndian.Uint32(data[checksumOffset:]) {
+		return LocationRecord{}
+	}
+
+	var record LocationRecord
+	copy(record.Key.Digest[:], data[:])
+	offset := sha256.Size
+	record.Key.Attempt = binary.LittleEndian.Uint32(data[offset:])
+	offset += 4
+	record.Location.BlockID = int(binary.LittleEndian.Uint64(data[offset:]))
+	offset += 8
+	record.Location.OffsetBytes = int64(binary.LittleEndian.Uint64(data[offset:]))
+	offset += 8
+	record.Location.SizeBytes = int64(binary.LittleEndian.Uint64(data[offset:]))
+	return record
+}
+
+func (lra *fileLocationRecordArray) Put(index int, locationRecord LocationRecord) {
+	var data [LocationRecordSize]byte
+	copy(data[:], locationRecord.Key.Digest[:])
+	offset := sha256.Size
+	binary.LittleEndian.PutUint32(data[offset:], locationRecord.Key.Attempt)
+	offset += 4
+	binary.LittleEndian.PutUint64(data[offset:], uint64(locationRecord.Location.BlockID))
+	offset += 8
+	binary.LittleEndian.PutUint64(data[offset:], uint64(locationRecord.Location.OffsetBytes))
+	offset += 8
+	binary.LittleEndian.PutUint64(data[offset:], uint64(locationRecord.Location.SizeBytes))
+	offset += 8
+	binary.LittleEndian.PutUint32(data[offset:], locationRecordChecksum(data[:offset]))
+
+	if _, err := lra.f.WriteAt(data[:], int64(index)*LocationRecordSize); err != nil {
+		log.Printf("Failed to write location record at index %d: %s", index, err)
+	}
+}
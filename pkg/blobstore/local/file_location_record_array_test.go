@@ -0,0 +1,70 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFileLocationRecordArray(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	f := mock.NewMockFileReadWriter(ctrl)
+	array := local.NewFileLocationRecordArray(f)
+
+	// Records that have never been written should be treated as
+	// being zero initialized, as their checksum doesn't match.
+	f.EXPECT().ReadAt(gomock.Any(), int64(123*local.LocationRecordSize)).
+		DoAndReturn(func(p []byte, off int64) (int, error) {
+			return len(p), nil
+		})
+	require.Equal(t, local.LocationRecord{}, array.Get(123))
+
+	// Records that are written should be readable.
+	record := local.LocationRecord{
+		Key: local.NewLocationRecordKey(
+			digest.MustNewDigest(
+				"hello",
+				"3e25960a79dbc69b674cd4ec67a72c62",
+				123)),
+		Location: local.Location{
+			BlockID:     483,
+			OffsetBytes: 32984729387,
+			SizeBytes:   58974582,
+		},
+	}
+	var stored []byte
+	f.EXPECT().WriteAt(gomock.Any(), int64(123*local.LocationRecordSize)).
+		DoAndReturn(func(p []byte, off int64) (int, error) {
+			require.Len(t, p, local.LocationRecordSize)
+			stored = append([]byte{}, p...)
+			return len(p), nil
+		})
+	array.Put(123, record)
+	f.EXPECT().ReadAt(gomock.Any(), int64(123*local.LocationRecordSize)).
+		DoAndReturn(func(p []byte, off int64) (int, error) {
+			return copy(p, stored), nil
+		})
+	require.Equal(t, record, array.Get(123))
+
+	// Corrupted records should be ignored.
+	stored[10] ^= 1
+	f.EXPECT().ReadAt(gomock.Any(), int64(123*local.LocationRecordSize)).
+		DoAndReturn(func(p []byte, off int64) (int, error) {
+			return copy(p, stored), nil
+		})
+	require.Equal(t, local.LocationRecord{}, array.Get(123))
+
+	// I/O errors should also cause records to be ignored.
+	f.EXPECT().ReadAt(gomock.Any(), int64(123*local.LocationRecordSize)).
+		Return(0, status.Error(codes.Internal, "Disk on fire"))
+	require.Equal(t, local.LocationRecord{}, array.Get(123))
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	local_pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"google.golang.org/grpc/codes"
@@ -44,15 +46,25 @@ var (
 // The reference count stored by sharedBlock is not updated atomically.
 // It can only be mutated safely by locking the containing
 // localBlobAccess.
+//
+// For blocks obtained from a PersistentBlockAllocator, the index of
+// the block is retained, so that it can be stored in the persistent
+// state. For other blocks, it is set to -1. The time at which the
+// block was allocated is retained, as all objects contained in the
+// block were written or refreshed after that point in time.
 type sharedBlock struct {
-	b        Block
-	refcount uint64
+	b              Block
+	index          int
+	allocationTime float64
+	refcount       uint64
 }
 
-func newSharedBlock(b Block) *sharedBlock {
+func newSharedBlock(b Block, index int, allocationTime float64) *sharedBlock {
 	return &sharedBlock{
-		b:        b,
-		refcount: 1,
+		b:              b,
+		index:          index,
+		allocationTime: allocationTime,
+		refcount:       1,
 	}
 }
 
@@ -95,16 +107,28 @@ type oldBlock struct {
 }
 
 type newBlock struct {
-	block  *sharedBlock
-	offset int64
+	block           *sharedBlock
+	offset          int64
+	allocationLimit int64
 }
 
+// allocationLimitIncrementDivisor controls by how much the allocation
+// limit of a "new" block is raised at a time, as a fraction of the
+// block size. Every time it is raised, the persistent state needs to
+// be written.
+const allocationLimitIncrementDivisor = 16
+
 type localBlobAccess struct {
 	sectorSizeBytes       int
 	blockSectorCount      int64
 	blockAllocator        BlockAllocator
 	desiredNewBlocksCount int
 
+	// Only set when the layout of blocks is stored persistently.
+	persistentBlockAllocator PersistentBlockAllocator
+	persistentStateStore     PersistentStateStore
+	layoutParameters         *local_pb.LayoutParameters
+
 	lock                        sync.Mutex
 	refreshLock                 sync.Mutex
 	digestLocationMap           DigestLocationMap
@@ -189,6 +213,62 @@ func unixTime() float64 {
 // would increase redundancy in the data stored. The "current" group
 // should likely be two or three times as large as the "old" group.
 func NewLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator BlockAllocator, name string, sectorSizeBytes int, blockSectorCount int64, oldBlocksCount int, currentBlocksCount int, newBlocksCount int) (blobstore.BlobAccess, error) {
+	ba := newLocalBlobAccess(digestLocationMap, blockAllocator, name, sectorSizeBytes, blockSectorCount, oldBlocksCount, currentBlocksCount, newBlocksCount)
+	if err := ba.allocateInitialBlocks(oldBlocksCount, currentBlocksCount+newBlocksCount); err != nil {
+		return nil, err
+	}
+	return ba, nil
+}
+
+// NewPersistentLocalBlobAccess is identical to NewLocalBlobAccess,
+// except that the layout of blocks is written to a
+// PersistentStateStore. Combined with a digest-location map that is
+// stored on disk, this allows data to remain accessible across
+// restarts.
+//
+// The provided PersistentState contains the parameters of the layout,
+// and any blocks that were in use by a previous run. These blocks are
+// reattached. If no blocks are provided, all blocks are allocated
+// from scratch.
+//
+// To ensure that locations stored in the digest-location map never
+// refer to data that is overwritten after a restart, the persistent
+// state is written before any data is stored beyond the allocation
+// limit of a "new" block, and before the storage of blocks that are
+// discarded is released. As allocation limits are raised in steps of
+// 1/16th of a block, the persistent state is written about 16 times
+// for every block that is filled. After a restart, allocation resumes
+// at the allocation limit.
+func NewPersistentLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator PersistentBlockAllocator, persistentStateStore PersistentStateStore, persistentState *local_pb.PersistentState, name string) (blobstore.BlobAccess, error) {
+	layoutParameters := persistentState.LayoutParameters
+	oldBlocksCount := int(layoutParameters.OldBlocks)
+	currentBlocksCount := int(layoutParameters.CurrentBlocks)
+	newBlocksCount := int(layoutParameters.NewBlocks)
+	ba := newLocalBlobAccess(digestLocationMap, blockAllocator, name, int(layoutParameters.SectorSizeBytes), layoutParameters.BlockSectorCount, oldBlocksCount, currentBlocksCount, newBlocksCount)
+	ba.persistentBlockAllocator = blockAllocator
+	ba.persistentStateStore = persistentStateStore
+	ba.layoutParameters = layoutParameters
+
+	if len(persistentState.CurrentBlocks)+len(persistentState.NewBlocks) == 0 {
+		// No blocks were in use by a previous run.
+		if err := ba.allocateInitialBlocks(oldBlocksCount, currentBlocksCount+newBlocksCount); err != nil {
+			return nil, err
+		}
+	} else if err := ba.reattachBlocks(persistentState, oldBlocksCount, currentBlocksCount+newBlocksCount); err != nil {
+		ba.releaseBlocks()
+		return nil, err
+	}
+
+	// Write the state immediately, so that the blocks that are
+	// allocated are known to later runs.
+	if err := ba.writePersistentState(); err != nil {
+		ba.releaseBlocks()
+		return nil, util.StatusWrap(err, "Failed to write persistent state")
+	}
+	return ba, nil
+}
+
+func newLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator BlockAllocator, name string, sectorSizeBytes int, blockSectorCount int64, oldBlocksCount int, currentBlocksCount int, newBlocksCount int) *localBlobAccess {
 	localBlobAccessPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(localBlobAccessLastRemovedOldBlockInsertionTime)
 		prometheus.MustRegister(localBlobAccessOldBlobRotationToNew)
@@ -210,32 +290,189 @@ func NewLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator Bloc
 		oldBlobRotationToNewGet:          localBlobAccessOldBlobRotationToNew.WithLabelValues(name, "Get"),
 		oldBlobRotationToNewFindMissing:  localBlobAccessOldBlobRotationToNew.WithLabelValues(name, "FindMissing"),
 	}
+	ba.lastRemovedOldBlockInsertionTime.Set(unixTime())
+	return ba
+}
 
-	// Insert placeholders for the initial set of "old" blocks.
+// addOldBlockPlaceholders inserts placeholders for "old" blocks that
+// don't contain any data.
+func (ba *localBlobAccess) addOldBlockPlaceholders(count int) {
 	now := unixTime()
-	ba.lastRemovedOldBlockInsertionTime.Set(now)
-	for i := 0; i < oldBlocksCount; i++ {
+	for i := 0; i < count; i++ {
 		ba.oldBlocks = append(ba.oldBlocks, oldBlock{
-			block:         newSharedBlock(deadBlock{}),
+			block:         newSharedBlock(deadBlock{}, -1, 0),
 			insertionTime: now,
 		})
 	}
+}
 
-	// Allocate initial set of "new" blocks.
-	for i := 0; i < currentBlocksCount+newBlocksCount; i++ {
-		block, err := blockAllocator.NewBlock()
+// allocateInitialBlocks sets up the blocks of a LocalBlobAccess that
+// does not contain any data.
+func (ba *localBlobAccess) allocateInitialBlocks(oldBlocksCount int, newBlocksCount int) error {
+	ba.addOldBlockPlaceholders(oldBlocksCount)
+	for i := 0; i < newBlocksCount; i++ {
+		block, err := ba.allocateBlock()
 		if err != nil {
-			for _, newBlock := range ba.newBlocks {
-				newBlock.block.release()
-			}
-			return nil, err
+			ba.releaseBlocks()
+			return err
 		}
 		ba.newBlocks = append(ba.newBlocks, newBlock{
-			block: newSharedBlock(block),
+			block: block,
 		})
 	}
 	ba.startAllocatingFromBlock(0)
-	return ba, nil
+	return nil
+}
+
+// reattachBlocks sets up the blocks of a LocalBlobAccess according to
+// the persistent state written by a previous run.
+func (ba *localBlobAccess) reattachBlocks(persistentState *local_pb.PersistentState, oldBlocksCount int, nonOldBlocksCount int) error {
+	if len(persistentState.OldBlocks) > oldBlocksCount ||
+		len(persistentState.CurrentBlocks)+len(persistentState.NewBlocks) != nonOldBlocksCount ||
+		len(persistentState.NewBlocks) < ba.desiredNewBlocksCount {
+		return status.Error(codes.InvalidArgument, "Number of blocks in persistent state does not match the configuration")
+	}
+	if persistentState.OldestBlockId < 1 {
+		return status.Errorf(codes.InvalidArgument, "Invalid oldest block ID %d", persistentState.OldestBlockId)
+	}
+	ba.locationValidator = LocationValidator{
+		OldestBlockID: int(persistentState.OldestBlockId),
+		NewestBlockID: int(persistentState.OldestBlockId) + oldBlocksCount + nonOldBlocksCount - 1,
+	}
+
+	ba.addOldBlockPlaceholders(oldBlocksCount - len(persistentState.OldBlocks))
+	for _, blockState := range persistentState.OldBlocks {
+		block, err := ba.reattachBlock(blockState)
+		if err != nil {
+			return err
+		}
+		ba.oldBlocks = append(ba.oldBlocks, oldBlock{
+			block:         block,
+			insertionTime: blockState.InsertionTime,
+		})
+	}
+	for _, blockState := range persistentState.CurrentBlocks {
+		block, err := ba.reattachBlock(blockState)
+		if err != nil {
+			return err
+		}
+		ba.currentBlocks = append(ba.currentBlocks, block)
+	}
+	for _, blockState := range persistentState.NewBlocks {
+		allocationLimit := blockState.AllocationLimitSectors
+		if allocationLimit < 0 || allocationLimit > ba.blockSectorCount {
+			return status.Errorf(codes.InvalidArgument, "Block at index %d has invalid allocation limit %d", blockState.BlockIndex, allocationLimit)
+		}
+		block, err := ba.reattachBlock(blockState)
+		if err != nil {
+			return err
+		}
+		ba.newBlocks = append(ba.newBlocks, newBlock{
+			block:           block,
+			offset:          allocationLimit,
+			allocationLimit: allocationLimit,
+		})
+	}
+	ba.startAllocatingFromBlock(0)
+	return nil
+}
+
+func (ba *localBlobAccess) reattachBlock(blockState *local_pb.BlockState) (*sharedBlock, error) {
+	index := int(blockState.BlockIndex)
+	block, err := ba.persistentBlockAllocator.NewBlockAtIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	return newSharedBlock(block, index, blockState.AllocationTime), nil
+}
+
+// releaseBlocks releases all blocks. It is called when initialization
+// fails.
+func (ba *localBlobAccess) releaseBlocks() {
+	for _, oldBlock := range ba.oldBlocks {
+		oldBlock.block.release()
+	}
+	for _, currentBlock := range ba.currentBlocks {
+		currentBlock.release()
+	}
+	for _, newBlock := range ba.newBlocks {
+		newBlock.block.release()
+	}
+}
+
+// allocateBlock allocates a new block, using the
+// PersistentBlockAllocator if the layout of blocks is stored
+// persistently.
+func (ba *localBlobAccess) allocateBlock() (*sharedBlock, error) {
+	if ba.persistentBlockAllocator == nil {
+		block, err := ba.blockAllocator.NewBlock()
+		if err != nil {
+			return nil, err
+		}
+		return newSharedBlock(block, -1, unixTime()), nil
+	}
+	block, index, err := ba.persistentBlockAllocator.NewBlockWithIndex()
+	if err != nil {
+		return nil, err
+	}
+	return newSharedBlock(block, index, unixTime()), nil
+}
+
+// writePersistentState writes the current layout of blocks to the
+// PersistentStateStore, if any. Because data may only be stored in
+// blocks once they are part of the persistent state, this function is
+// called while holding the lock.
+func (ba *localBlobAccess) writePersistentState() error {
+	if ba.persistentStateStore == nil {
+		return nil
+	}
+	persistentState := local_pb.PersistentState{
+		LayoutParameters: ba.layoutParameters,
+		OldestBlockId:    int64(ba.locationValidator.OldestBlockID),
+	}
+	for _, oldBlock := range ba.oldBlocks {
+		// Omit placeholders.
+		if oldBlock.block.index >= 0 {
+			persistentState.OldBlocks = append(persistentState.OldBlocks, &local_pb.BlockState{
+				BlockIndex:     int32(oldBlock.block.index),
+				InsertionTime:  oldBlock.insertionTime,
+				AllocationTime: oldBlock.block.allocationTime,
+			})
+		}
+	}
+	for _, currentBlock := range ba.currentBlocks {
+		persistentState.CurrentBlocks = append(persistentState.CurrentBlocks, &local_pb.BlockState{
+			BlockIndex:     int32(currentBlock.index),
+			AllocationTime: currentBlock.allocationTime,
+		})
+	}
+	for _, newBlock := range ba.newBlocks {
+		persistentState.NewBlocks = append(persistentState.NewBlocks, &local_pb.BlockState{
+			BlockIndex:             int32(newBlock.block.index),
+			AllocationLimitSectors: newBlock.allocationLimit,
+			AllocationTime:         newBlock.block.allocationTime,
+		})
+	}
+	return ba.persistentStateStore.WritePersistentState(&persistentState)
+}
+
+// raiseAllocationLimit ensures that the allocation limit of a "new"
+// block that is stored in the persistent state lies at or beyond a
+// given offset, so that data may be written up to that offset.
+func (ba *localBlobAccess) raiseAllocationLimit(nb *newBlock, offset int64) error {
+	if ba.persistentStateStore == nil || offset <= nb.allocationLimit {
+		return nil
+	}
+	previousAllocationLimit := nb.allocationLimit
+	nb.allocationLimit = offset + ba.blockSectorCount/allocationLimitIncrementDivisor
+	if nb.allocationLimit > ba.blockSectorCount {
+		nb.allocationLimit = ba.blockSectorCount
+	}
+	if err := ba.writePersistentState(); err != nil {
+		nb.allocationLimit = previousAllocationLimit
+		return util.StatusWrap(err, "Failed to write persistent state")
+	}
+	return nil
 }
 
 // getBlock returns the block associated with a numerical block ID.
@@ -285,22 +522,32 @@ func (ba *localBlobAccess) allocateSpace(sizeBytes int64) (*sharedBlock, Locatio
 			ba.newBlocks = append([]newBlock{}, ba.newBlocks[1:]...)
 		} else {
 			// The initialization phase is way behind us.
-			block, err := ba.blockAllocator.NewBlock()
+			block, err := ba.allocateBlock()
 			if err != nil {
 				return nil, Location{}, err
 			}
 			ba.lastRemovedOldBlockInsertionTime.Set(ba.oldBlocks[0].insertionTime)
-			ba.oldBlocks[0].block.release()
+			removedBlock := ba.oldBlocks[0].block
 			ba.oldBlocks = append(append([]oldBlock{}, ba.oldBlocks[1:]...), oldBlock{
 				block:         ba.currentBlocks[0],
 				insertionTime: unixTime(),
 			})
 			ba.currentBlocks = append(append([]*sharedBlock{}, ba.currentBlocks[1:]...), ba.newBlocks[0].block)
 			ba.newBlocks = append(append([]newBlock{}, ba.newBlocks[1:]...), newBlock{
-				block: newSharedBlock(block),
+				block: block,
 			})
 			ba.locationValidator.OldestBlockID++
 			ba.locationValidator.NewestBlockID++
+
+			// Only release the storage of the removed block
+			// after the persistent state no longer refers
+			// to it. Otherwise a later run may reattach to
+			// a block whose storage has been reused.
+			err = ba.writePersistentState()
+			removedBlock.release()
+			if err != nil {
+				return nil, Location{}, util.StatusWrap(err, "Failed to write persistent state")
+			}
 		}
 		ba.startAllocatingFromBlock(0)
 	}
@@ -310,6 +557,9 @@ func (ba *localBlobAccess) allocateSpace(sizeBytes int64) (*sharedBlock, Locatio
 		if ba.allocationAttemptsRemaining > 0 {
 			newBlock := &ba.newBlocks[ba.allocationBlockIndex]
 			if offset := newBlock.offset; ba.blockSectorCount-offset >= sectors {
+				if err := ba.raiseAllocationLimit(newBlock, offset+sectors); err != nil {
+					return nil, Location{}, err
+				}
 				ba.allocationAttemptsRemaining--
 				newBlock.offset += sectors
 				return newBlock.block, Location{
@@ -436,7 +686,7 @@ func (ba *localBlobAccess) FindMissing(ctx context.Context, digests digest.Set)
 			return digest.EmptySet, err
 		}
 	}
-	if len(old) == 0 {
+	if len(old) == 0 || blobstore.IsRefreshingDisabled(ctx) {
 		return missing.Build(), nil
 	}
 
@@ -491,3 +741,43 @@ func (ba *localBlobAccess) FindMissing(ctx context.Context, digests digest.Set)
 	}
 	return missing.Build(), nil
 }
+
+func (ba *localBlobAccess) StatBlob(ctx context.Context, digest digest.Digest) (blobstore.BlobInfo, error) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	// Objects are only refreshed by copying them out of "old"
+	// blocks. The time at which the block containing the object
+	// was allocated is therefore a lower bound on when the object
+	// was last written or refreshed.
+	location, err := ba.digestLocationMap.Get(digest, &ba.locationValidator)
+	if err != nil {
+		return blobstore.BlobInfo{}, err
+	}
+	block, _ := ba.getBlock(location.BlockID)
+	blobInfo := blobstore.BlobInfo{SizeBytes: location.SizeBytes}
+	if block.allocationTime > 0 {
+		blobInfo.LastAccessTime = time.Unix(0, 0).Add(time.Duration(block.allocationTime * float64(time.Second)))
+	}
+	return blobInfo, nil
+}
+
+func (ba *localBlobAccess) GetUtilization() (int64, int64) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	// "Old" and "current" blocks are full, except for the
+	// placeholders that are created during initialization.
+	var usedSectors int64
+	for _, oldBlock := range ba.oldBlocks {
+		if _, ok := oldBlock.block.b.(deadBlock); !ok {
+			usedSectors += ba.blockSectorCount
+		}
+	}
+	usedSectors += int64(len(ba.currentBlocks)) * ba.blockSectorCount
+	for _, newBlock := range ba.newBlocks {
+		usedSectors += newBlock.offset
+	}
+	totalBlocks := len(ba.oldBlocks) + len(ba.currentBlocks) + len(ba.newBlocks)
+	return usedSectors * int64(ba.sectorSizeBytes), int64(totalBlocks) * ba.blockSectorCount * int64(ba.sectorSizeBytes)
+}
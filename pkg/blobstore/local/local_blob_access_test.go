@@ -3,13 +3,20 @@ package local_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	local_pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestLocalBlobAccessAllocationPattern(t *testing.T) {
@@ -48,4 +55,271 @@ func TestLocalBlobAccessAllocationPattern(t *testing.T) {
 	}
 }
 
+func TestLocalBlobAccessGetUtilization(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+	blockAllocator := mock.NewMockBlockAllocator(ctrl)
+	block1 := mock.NewMockBlock(ctrl)
+	block2 := mock.NewMockBlock(ctrl)
+	blockAllocator.EXPECT().NewBlock().Return(block1, nil)
+	blockAllocator.EXPECT().NewBlock().Return(block2, nil)
+	blobAccess, err := local.NewLocalBlobAccess(digestLocationMap, blockAllocator, "cas", 4, 16, 2, 1, 1)
+	require.NoError(t, err)
+	utilizationReporter := blobAccess.(blobstore.UtilizationReporter)
+
+	// Placeholders for "old" blocks should not be counted as
+	// being in use.
+	usedBytes, capacityBytes := utilizationReporter.GetUtilization()
+	require.Equal(t, int64(0), usedBytes)
+	require.Equal(t, int64(256), capacityBytes)
+
+	// Storing an object should increase utilization by the number
+	// of sectors it occupies.
+	blobDigest := digest.MustNewDigest("example", "8b1a9953c4611296a827abf8c47804d7", 5)
+	block1.EXPECT().Put(int64(0), gomock.Any()).Return(nil)
+	digestLocationMap.EXPECT().Put(blobDigest, gomock.Any(), local.Location{
+		BlockID:     3,
+		OffsetBytes: 0,
+		SizeBytes:   5,
+	})
+	require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	usedBytes, capacityBytes = utilizationReporter.GetUtilization()
+	require.Equal(t, int64(8), usedBytes)
+	require.Equal(t, int64(256), capacityBytes)
+}
+
+// expectPersistentState adds an expectation for a persistent state to
+// be written.
+func expectPersistentState(t *testing.T, persistentStateStore *mock.MockPersistentStateStore, expectedState *local_pb.PersistentState) {
+	persistentStateStore.EXPECT().WritePersistentState(gomock.Any()).DoAndReturn(
+		func(persistentState *local_pb.PersistentState) error {
+			// Blocks allocated during the test have an
+			// allocation time that is not deterministic.
+			// Only compare allocation times if provided.
+			persistentState = proto.Clone(persistentState).(*local_pb.PersistentState)
+			clearAllocationTimes(expectedState.OldBlocks, persistentState.OldBlocks)
+			clearAllocationTimes(expectedState.CurrentBlocks, persistentState.CurrentBlocks)
+			clearAllocationTimes(expectedState.NewBlocks, persistentState.NewBlocks)
+			require.True(t, proto.Equal(expectedState, persistentState), "Got %s", persistentState)
+			return nil
+		})
+}
+
+func clearAllocationTimes(expectedBlocks []*local_pb.BlockState, blocks []*local_pb.BlockState) {
+	for i := 0; i < len(expectedBlocks) && i < len(blocks); i++ {
+		if expectedBlocks[i].AllocationTime == 0 {
+			blocks[i].AllocationTime = 0
+		}
+	}
+}
+
+func TestLocalBlobAccessPersistentState(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	layoutParameters := &local_pb.LayoutParameters{
+		HashInitialization: 0x970aef1f90c7f916,
+		SectorSizeBytes:    1,
+		BlockSectorCount:   16,
+		OldBlocks:          2,
+		CurrentBlocks:      1,
+		NewBlocks:          1,
+	}
+	blobDigest := digest.MustNewDigest("example", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Initial", func(t *testing.T) {
+		digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+		blockAllocator := mock.NewMockPersistentBlockAllocator(ctrl)
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+		// Without any blocks in the persistent state, all
+		// blocks should be allocated from scratch. The
+		// resulting layout should be written immediately.
+		block1 := mock.NewMockBlock(ctrl)
+		block2 := mock.NewMockBlock(ctrl)
+		blockAllocator.EXPECT().NewBlockWithIndex().Return(block1, 5, nil)
+		blockAllocator.EXPECT().NewBlockWithIndex().Return(block2, 7, nil)
+		expectPersistentState(t, persistentStateStore, &local_pb.PersistentState{
+			LayoutParameters: layoutParameters,
+			OldestBlockId:    1,
+			NewBlocks: []*local_pb.BlockState{
+				{BlockIndex: 5},
+				{BlockIndex: 7},
+			},
+		})
+		blobAccess, err := local.NewPersistentLocalBlobAccess(
+			digestLocationMap,
+			blockAllocator,
+			persistentStateStore,
+			&local_pb.PersistentState{LayoutParameters: layoutParameters},
+			"cas")
+		require.NoError(t, err)
+
+		// Storing data beyond the allocation limit of a block
+		// requires the allocation limit to be raised first.
+		expectPersistentState(t, persistentStateStore, &local_pb.PersistentState{
+			LayoutParameters: layoutParameters,
+			OldestBlockId:    1,
+			NewBlocks: []*local_pb.BlockState{
+				{BlockIndex: 5, AllocationLimitSectors: 6},
+				{BlockIndex: 7},
+			},
+		})
+		block1.EXPECT().Put(int64(0), gomock.Any()).Return(nil)
+		digestLocationMap.EXPECT().Put(blobDigest, gomock.Any(), local.Location{
+			BlockID:     3,
+			OffsetBytes: 0,
+			SizeBytes:   5,
+		})
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		// Storing data below the allocation limit should not
+		// cause the persistent state to be written.
+		block1.EXPECT().Put(int64(5), gomock.Any()).Return(nil)
+		digestLocationMap.EXPECT().Put(blobDigest, gomock.Any(), local.Location{
+			BlockID:     3,
+			OffsetBytes: 5,
+			SizeBytes:   1,
+		})
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("!"))))
+	})
+
+	t.Run("Reattach", func(t *testing.T) {
+		digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+		blockAllocator := mock.NewMockPersistentBlockAllocator(ctrl)
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+		// Blocks stored in the persistent state should be
+		// reattached, while placeholders are inserted for
+		// missing "old" blocks.
+		persistentState := &local_pb.PersistentState{
+			LayoutParameters: layoutParameters,
+			OldestBlockId:    10,
+			OldBlocks: []*local_pb.BlockState{
+				{BlockIndex: 3, InsertionTime: 1600000000},
+			},
+			CurrentBlocks: []*local_pb.BlockState{
+				{BlockIndex: 4, AllocationTime: 1500000000},
+			},
+			NewBlocks: []*local_pb.BlockState{
+				{BlockIndex: 6, AllocationLimitSectors: 8},
+			},
+		}
+		block3 := mock.NewMockBlock(ctrl)
+		block4 := mock.NewMockBlock(ctrl)
+		block6 := mock.NewMockBlock(ctrl)
+		blockAllocator.EXPECT().NewBlockAtIndex(3).Return(block3, nil)
+		blockAllocator.EXPECT().NewBlockAtIndex(4).Return(block4, nil)
+		blockAllocator.EXPECT().NewBlockAtIndex(6).Return(block6, nil)
+		expectPersistentState(t, persistentStateStore, persistentState)
+		blobAccess, err := local.NewPersistentLocalBlobAccess(
+			digestLocationMap,
+			blockAllocator,
+			persistentStateStore,
+			persistentState,
+			"cas")
+		require.NoError(t, err)
+
+		// Data stored in reattached blocks should be accessible.
+		digestLocationMap.EXPECT().Get(blobDigest, gomock.Any()).Return(local.Location{
+			BlockID:     12,
+			OffsetBytes: 3,
+			SizeBytes:   5,
+		}, nil)
+		block4.EXPECT().Get(blobDigest, int64(3), int64(5)).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		// The allocation time of the block should be reported
+		// as a lower bound of the last access time.
+		digestLocationMap.EXPECT().Get(blobDigest, gomock.Any()).Return(local.Location{
+			BlockID:     12,
+			OffsetBytes: 3,
+			SizeBytes:   5,
+		}, nil)
+		blobInfo, err := blobAccess.(blobstore.BlobStatter).StatBlob(ctx, blobDigest)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), blobInfo.SizeBytes)
+		require.True(t, time.Unix(1500000000, 0).Equal(blobInfo.LastAccessTime))
+
+		// Allocation should resume at the allocation limit, as
+		// data may have been written up to that point.
+		expectPersistentState(t, persistentStateStore, &local_pb.PersistentState{
+			LayoutParameters: layoutParameters,
+			OldestBlockId:    10,
+			OldBlocks: []*local_pb.BlockState{
+				{BlockIndex: 3, InsertionTime: 1600000000},
+			},
+			CurrentBlocks: []*local_pb.BlockState{
+				{BlockIndex: 4, AllocationTime: 1500000000},
+			},
+			NewBlocks: []*local_pb.BlockState{
+				{BlockIndex: 6, AllocationLimitSectors: 14},
+			},
+		})
+		block6.EXPECT().Put(int64(8), gomock.Any()).Return(nil)
+		digestLocationMap.EXPECT().Put(blobDigest, gomock.Any(), local.Location{
+			BlockID:     13,
+			OffsetBytes: 8,
+			SizeBytes:   5,
+		})
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("ReattachFailure", func(t *testing.T) {
+		digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+		blockAllocator := mock.NewMockPersistentBlockAllocator(ctrl)
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+		// If one of the blocks cannot be reattached, blocks
+		// that were reattached should be released.
+		block3 := mock.NewMockBlock(ctrl)
+		blockAllocator.EXPECT().NewBlockAtIndex(3).Return(block3, nil)
+		blockAllocator.EXPECT().NewBlockAtIndex(4).Return(nil, status.Error(codes.InvalidArgument, "Block at index 4 does not exist or is already in use"))
+		block3.EXPECT().Release()
+		_, err := local.NewPersistentLocalBlobAccess(
+			digestLocationMap,
+			blockAllocator,
+			persistentStateStore,
+			&local_pb.PersistentState{
+				LayoutParameters: layoutParameters,
+				OldestBlockId:    10,
+				CurrentBlocks: []*local_pb.BlockState{
+					{BlockIndex: 3},
+				},
+				NewBlocks: []*local_pb.BlockState{
+					{BlockIndex: 4},
+				},
+			},
+			"cas")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Block at index 4 does not exist or is already in use"), err)
+	})
+
+	t.Run("BlockCountMismatch", func(t *testing.T) {
+		digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+		blockAllocator := mock.NewMockPersistentBlockAllocator(ctrl)
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+		_, err := local.NewPersistentLocalBlobAccess(
+			digestLocationMap,
+			blockAllocator,
+			persistentStateStore,
+			&local_pb.PersistentState{
+				LayoutParameters: layoutParameters,
+				OldestBlockId:    10,
+				CurrentBlocks: []*local_pb.BlockState{
+					{BlockIndex: 3},
+					{BlockIndex: 4},
+				},
+			},
+			"cas")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Number of blocks in persistent state does not match the configuration"), err)
+	})
+}
+
 // TODO: Make unit testing coverage more complete.
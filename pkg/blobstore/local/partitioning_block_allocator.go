@@ -3,6 +3,7 @@ package local
 import (
 	"fmt"
 	"io"
+	"log"
 	"sync"
 	"sync/atomic"
 
@@ -56,13 +57,22 @@ type ReadWriterAt interface {
 	io.WriterAt
 }
 
+// HolePuncher is an optional interface that may be implemented by a
+// ReadWriterAt. It allows the storage backing a region to be returned
+// to the operating system (e.g., for a sparse file).
+type HolePuncher interface {
+	PunchHole(offset int64, size int64) error
+}
+
 type partitioningBlockAllocator struct {
-	f               ReadWriterAt
-	storageType     blobstore.StorageType
-	sectorSizeBytes int
+	f                ReadWriterAt
+	storageType      blobstore.StorageType
+	sectorSizeBytes  int
+	blockSectorCount int64
 
 	lock        sync.Mutex
 	freeOffsets []int64
+	holePuncher HolePuncher
 }
 
 // NewPartitioningBlockAllocator implements a BlockAllocator that can be
@@ -77,7 +87,18 @@ type partitioningBlockAllocator struct {
 // This implementation also ensures that writes against underlying
 // storage are all performed at sector boundaries and sizes. This
 // ensures that no unnecessary reads are performed.
-func NewPartitioningBlockAllocator(f ReadWriterAt, storageType blobstore.StorageType, sectorSizeBytes int, blockSectorCount int64, blockCount int) BlockAllocator {
+//
+// If the ReadWriterAt implements HolePuncher, the storage backing
+// blocks is returned to the operating system when blocks are released.
+// This prevents sparse files from retaining space for data that has
+// been evicted. If punching holes turns out to be unsupported (e.g.,
+// by the operating system or the block device), no further attempts
+// are made to do so.
+//
+// Blocks are identified by their position within the ReadWriterAt.
+// When the ReadWriterAt is backed by persistent storage, blocks
+// allocated by a previous run may be reattached by index.
+func NewPartitioningBlockAllocator(f ReadWriterAt, storageType blobstore.StorageType, sectorSizeBytes int, blockSectorCount int64, blockCount int) PersistentBlockAllocator {
 	partitioningBlockAllocatorPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(partitioningBlockAllocatorAllocations)
 		prometheus.MustRegister(partitioningBlockAllocatorReleases)
@@ -87,10 +108,12 @@ func NewPartitioningBlockAllocator(f ReadWriterAt, storageType blobstore.Storage
 	})
 
 	pa := &partitioningBlockAllocator{
-		f:               f,
-		storageType:     storageType,
-		sectorSizeBytes: sectorSizeBytes,
+		f:                f,
+		storageType:      storageType,
+		sectorSizeBytes:  sectorSizeBytes,
+		blockSectorCount: blockSectorCount,
 	}
+	pa.holePuncher, _ = f.(HolePuncher)
 	for i := 0; i < blockCount; i++ {
 		pa.freeOffsets = append(pa.freeOffsets, int64(i)*blockSectorCount)
 	}
@@ -98,20 +121,43 @@ func NewPartitioningBlockAllocator(f ReadWriterAt, storageType blobstore.Storage
 }
 
 func (pa *partitioningBlockAllocator) NewBlock() (Block, error) {
+	block, _, err := pa.NewBlockWithIndex()
+	return block, err
+}
+
+func (pa *partitioningBlockAllocator) NewBlockWithIndex() (Block, int, error) {
 	pa.lock.Lock()
 	defer pa.lock.Unlock()
 
 	if len(pa.freeOffsets) == 0 {
-		return nil, status.Error(codes.ResourceExhausted, "No unused blocks available")
+		return nil, 0, status.Error(codes.ResourceExhausted, "No unused blocks available")
+	}
+	offset := pa.freeOffsets[0]
+	pa.freeOffsets = pa.freeOffsets[1:]
+	return pa.newBlockAtOffset(offset), int(offset / pa.blockSectorCount), nil
+}
+
+func (pa *partitioningBlockAllocator) NewBlockAtIndex(index int) (Block, error) {
+	pa.lock.Lock()
+	defer pa.lock.Unlock()
+
+	offset := int64(index) * pa.blockSectorCount
+	for i, freeOffset := range pa.freeOffsets {
+		if freeOffset == offset {
+			pa.freeOffsets = append(pa.freeOffsets[:i], pa.freeOffsets[i+1:]...)
+			return pa.newBlockAtOffset(offset), nil
+		}
 	}
-	block := &partitioningBlock{
+	return nil, status.Errorf(codes.InvalidArgument, "Block at index %d does not exist or is already in use", index)
+}
+
+func (pa *partitioningBlockAllocator) newBlockAtOffset(offset int64) Block {
+	partitioningBlockAllocatorAllocations.Inc()
+	return &partitioningBlock{
 		blockAllocator: pa,
-		offset:         pa.freeOffsets[0],
+		offset:         offset,
 		usecount:       1,
 	}
-	pa.freeOffsets = pa.freeOffsets[1:]
-	partitioningBlockAllocatorAllocations.Inc()
-	return block, nil
 }
 
 type partitioningBlock struct {
@@ -128,6 +174,22 @@ func (pb *partitioningBlock) Release() {
 		// storage to be reused for new data.
 		pa := pb.blockAllocator
 		pa.lock.Lock()
+		holePuncher := pa.holePuncher
+		pa.lock.Unlock()
+		if holePuncher != nil {
+			sectorSizeBytes := int64(pa.sectorSizeBytes)
+			if err := holePuncher.PunchHole(pb.offset*sectorSizeBytes, pa.blockSectorCount*sectorSizeBytes); status.Code(err) == codes.Unimplemented {
+				pa.lock.Lock()
+				if pa.holePuncher != nil {
+					log.Print("Disabling releasing storage of blocks: ", err)
+					pa.holePuncher = nil
+				}
+				pa.lock.Unlock()
+			} else if err != nil {
+				log.Printf("Failed to release storage of block at offset %d: %s", pb.offset*sectorSizeBytes, err)
+			}
+		}
+		pa.lock.Lock()
 		pa.freeOffsets = append(pa.freeOffsets, pb.offset)
 		pa.lock.Unlock()
 		partitioningBlockAllocatorReleases.Inc()
@@ -143,7 +205,7 @@ func (pb *partitioningBlock) Get(digest digest.Digest, offsetBytes int64, sizeBy
 	// trivial. The repair function may run in the foreground. This
 	// could cause a deadlock against the locking in LocalBlobAccess
 	// itself.
-	return pb.blockAllocator.storageType.NewBufferFromReader(
+	return pb.blockAllocator.storageType.NewBufferFromReaderAt(
 		digest,
 		&partitioningBlockReader{
 			SectionReader: *io.NewSectionReader(
@@ -152,6 +214,7 @@ func (pb *partitioningBlock) Get(digest digest.Digest, offsetBytes int64, sizeBy
 				sizeBytes),
 			block: pb,
 		},
+		sizeBytes,
 		buffer.Irreparable)
 }
 
@@ -178,8 +241,10 @@ func (pb *partitioningBlock) Put(offsetBytes int64, b buffer.Buffer) error {
 }
 
 // partitioningBlockReader reads a blob from underlying storage at the
-// right offset. When released, it drops the use count on the containing
-// block, so that can be freed when unreferenced.
+// right offset. It permits random access, so that parts of a blob can
+// be read without reading the blob in its entirety. When released, it
+// drops the use count on the containing block, so that can be freed
+// when unreferenced.
 type partitioningBlockReader struct {
 	io.SectionReader
 	block *partitioningBlock
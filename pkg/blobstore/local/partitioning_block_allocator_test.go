@@ -53,12 +53,14 @@ func TestPartitioningBlockAllocator(t *testing.T) {
 	require.Equal(t, err, status.Error(codes.ResourceExhausted, "No unused blocks available"))
 
 	// The blob may still be consumed with the block being released.
-	// It should have started at offset 700.
+	// It should have started at offset 700. Once consumed, the
+	// storage backing the block should be released.
 	f.EXPECT().ReadAt(gomock.Any(), int64(725)).DoAndReturn(
 		func(p []byte, off int64) (int, error) {
 			copy(p, "Hello")
 			return 5, nil
 		})
+	f.EXPECT().PunchHole(int64(700), int64(100))
 	data, err := b.ToByteSlice(100)
 	require.NoError(t, err)
 	require.Equal(t, []byte("Hello"), data)
@@ -76,6 +78,7 @@ func TestPartitioningBlockAllocator(t *testing.T) {
 	// leveling of the storage backend.
 	order := []int{2, 8, 4, 9, 3}
 	for _, i := range order {
+		f.EXPECT().PunchHole(int64(100*i), int64(100))
 		blocks[i].Release()
 	}
 	for _, i := range order {
@@ -86,3 +89,63 @@ func TestPartitioningBlockAllocator(t *testing.T) {
 		require.NoError(t, blocks[i].Put(83, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
 	}
 }
+
+func TestPartitioningBlockAllocatorAtIndex(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	f := mock.NewMockFileReadWriter(ctrl)
+	pa := local.NewPartitioningBlockAllocator(f, blobstore.CASStorageType, 1, 100, 4)
+
+	// Blocks allocated by a previous run should be reattachable by
+	// index, so that the data stored in them remains accessible.
+	block2, err := pa.NewBlockAtIndex(2)
+	require.NoError(t, err)
+	f.EXPECT().ReadAt(gomock.Any(), int64(217)).DoAndReturn(
+		func(p []byte, off int64) (int, error) {
+			copy(p, "Hello")
+			return 5, nil
+		})
+	data, err := block2.Get(
+		digest.MustNewDigest("some-instance", "8b1a9953c4611296a827abf8c47804d7", 5),
+		17,
+		5).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	// Blocks that are already in use or don't exist cannot be
+	// reattached.
+	_, err = pa.NewBlockAtIndex(2)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Block at index 2 does not exist or is already in use"), err)
+	_, err = pa.NewBlockAtIndex(4)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Block at index 4 does not exist or is already in use"), err)
+
+	// Allocating blocks regularly should skip the block that was
+	// reattached, while reporting their indices.
+	for _, expectedIndex := range []int{0, 1, 3} {
+		_, index, err := pa.NewBlockWithIndex()
+		require.NoError(t, err)
+		require.Equal(t, expectedIndex, index)
+	}
+	_, _, err = pa.NewBlockWithIndex()
+	require.Equal(t, status.Error(codes.ResourceExhausted, "No unused blocks available"), err)
+}
+
+func TestPartitioningBlockAllocatorHolePunchingUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	f := mock.NewMockFileReadWriter(ctrl)
+	pa := local.NewPartitioningBlockAllocator(f, blobstore.CASStorageType, 1, 100, 2)
+
+	// If punching holes is not supported, the allocator should
+	// only attempt to do so once.
+	block1, err := pa.NewBlock()
+	require.NoError(t, err)
+	block2, err := pa.NewBlock()
+	require.NoError(t, err)
+	f.EXPECT().PunchHole(int64(0), int64(100)).
+		Return(status.Error(codes.Unimplemented, "Punching holes is not supported on this platform"))
+	block1.Release()
+	block2.Release()
+}
@@ -0,0 +1,77 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+
+	local_pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PersistentStateStore is used by LocalBlobAccess to store the layout
+// of its blocks, so that it can reattach to them after restarts.
+type PersistentStateStore interface {
+	ReadPersistentState() (*local_pb.PersistentState, error)
+	WritePersistentState(persistentState *local_pb.PersistentState) error
+}
+
+type filePersistentStateStore struct {
+	path string
+}
+
+// NewFilePersistentStateStore creates a PersistentStateStore that
+// stores the state of LocalBlobAccess in a file. The file is replaced
+// atomically, so that it is never left in a partially written state.
+func NewFilePersistentStateStore(path string) PersistentStateStore {
+	return &filePersistentStateStore{
+		path: path,
+	}
+}
+
+func (pss *filePersistentStateStore) ReadPersistentState() (*local_pb.PersistentState, error) {
+	data, err := ioutil.ReadFile(pss.path)
+	if os.IsNotExist(err) {
+		return nil, status.Error(codes.NotFound, "No persistent state has been written yet")
+	} else if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to read persistent state file")
+	}
+	var persistentState local_pb.PersistentState
+	if err := proto.Unmarshal(data, &persistentState); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal persistent state")
+	}
+	return &persistentState, nil
+}
+
+func (pss *filePersistentStateStore) WritePersistentState(persistentState *local_pb.PersistentState) error {
+	data, err := proto.Marshal(persistentState)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal persistent state")
+	}
+
+	// Synchronize the file before renaming it. Otherwise the file
+	// may end up being empty after a crash.
+	temporaryPath := pss.path + ".tmp"
+	f, err := os.OpenFile(temporaryPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create persistent state file")
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write persistent state file")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize persistent state file")
+	}
+	if err := f.Close(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to close persistent state file")
+	}
+	if err := os.Rename(temporaryPath, pss.path); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to replace persistent state file")
+	}
+	return nil
+}
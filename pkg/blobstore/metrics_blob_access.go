@@ -51,16 +51,30 @@ type metricsBlobAccess struct {
 	blobAccess BlobAccess
 	clock      clock.Clock
 
+	// Optional interfaces implemented by the backend, or nil if
+	// unsupported.
+	deleter             BlobDeleter
+	lister              DigestLister
+	statter             BlobStatter
+	utilizationReporter UtilizationReporter
+	signedURLGenerator  SignedURLGenerator
+
 	getBlobSizeBytes           prometheus.Observer
 	getDurationSeconds         prometheus.ObserverVec
 	putBlobSizeBytes           prometheus.Observer
 	putDurationSeconds         prometheus.ObserverVec
 	findMissingBatchSize       prometheus.Observer
 	findMissingDurationSeconds prometheus.ObserverVec
+	deleteDurationSeconds      prometheus.ObserverVec
 }
 
 // NewMetricsBlobAccess creates an adapter for BlobAccess that adds
 // basic instrumentation in the form of Prometheus metrics.
+//
+// The adapter implements all of the optional interfaces declared in
+// this package, forwarding calls to the backend. Calls against
+// backends that do not implement the corresponding interface fail with
+// UNIMPLEMENTED.
 func NewMetricsBlobAccess(blobAccess BlobAccess, clock clock.Clock, name string) BlobAccess {
 	blobAccessOperationsPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(blobAccessOperationsBlobSizeBytes)
@@ -68,7 +82,7 @@ func NewMetricsBlobAccess(blobAccess BlobAccess, clock clock.Clock, name string)
 		prometheus.MustRegister(blobAccessOperationsDurationSeconds)
 	})
 
-	return &metricsBlobAccess{
+	ba := &metricsBlobAccess{
 		blobAccess: blobAccess,
 		clock:      clock,
 
@@ -78,7 +92,15 @@ func NewMetricsBlobAccess(blobAccess BlobAccess, clock clock.Clock, name string)
 		putDurationSeconds:         blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Put"}),
 		findMissingBatchSize:       blobAccessOperationsFindMissingBatchSize.WithLabelValues(name),
 		findMissingDurationSeconds: blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "FindMissing"}),
+		deleteDurationSeconds:      blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Delete"}),
 	}
+	ba.deleter, _ = blobAccess.(BlobDeleter)
+	ba.lister, _ = blobAccess.(DigestLister)
+	ba.statter, _ = blobAccess.(BlobStatter)
+	ba.utilizationReporter, _ = blobAccess.(UtilizationReporter)
+	ba.signedURLGenerator, _ = blobAccess.(SignedURLGenerator)
+
+	return ba
 }
 
 func (ba *metricsBlobAccess) updateDurationSeconds(vec prometheus.ObserverVec, code codes.Code, timeStart time.Time) {
@@ -130,6 +152,50 @@ func (ba *metricsBlobAccess) FindMissing(ctx context.Context, digests digest.Set
 	return digests, err
 }
 
+func (ba *metricsBlobAccess) Delete(ctx context.Context, digest digest.Digest) error {
+	if ba.deleter == nil {
+		return status.Error(codes.Unimplemented, "Backend does not support deleting objects")
+	}
+	timeStart := ba.clock.Now()
+	err := ba.deleter.Delete(ctx, digest)
+	ba.updateDurationSeconds(ba.deleteDurationSeconds, status.Code(err), timeStart)
+	return err
+}
+
+// ListDigests is forwarded without gathering metrics, as it is only
+// called sporadically.
+func (ba *metricsBlobAccess) ListDigests(ctx context.Context, callback func(digest digest.Digest) error) error {
+	if ba.lister == nil {
+		return status.Error(codes.Unimplemented, "Backend does not support listing objects")
+	}
+	return ba.lister.ListDigests(ctx, callback)
+}
+
+// StatBlob is forwarded without gathering metrics, as it is only
+// called by administrative tooling.
+func (ba *metricsBlobAccess) StatBlob(ctx context.Context, digest digest.Digest) (BlobInfo, error) {
+	if ba.statter == nil {
+		return BlobInfo{}, status.Error(codes.Unimplemented, "Backend does not support inspecting objects")
+	}
+	return ba.statter.StatBlob(ctx, digest)
+}
+
+// GetUtilization reports zero capacity for backends that do not have
+// a fixed capacity.
+func (ba *metricsBlobAccess) GetUtilization() (int64, int64) {
+	if ba.utilizationReporter == nil {
+		return 0, 0
+	}
+	return ba.utilizationReporter.GetUtilization()
+}
+
+func (ba *metricsBlobAccess) GetSignedURL(ctx context.Context, digest digest.Digest, expiry time.Duration) (string, error) {
+	if ba.signedURLGenerator == nil {
+		return "", status.Error(codes.Unimplemented, "Backend does not support generating signed URLs")
+	}
+	return ba.signedURLGenerator.GetSignedURL(ctx, digest, expiry)
+}
+
 type metricsErrorHandler struct {
 	blobAccess *metricsBlobAccess
 	timeStart  time.Time
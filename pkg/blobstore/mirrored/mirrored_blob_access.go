@@ -127,6 +127,9 @@ func (ba *mirroredBlobAccess) FindMissing(ctx context.Context, digests digest.Se
 
 	// Determine inconsistencies between both backends.
 	missingFromA, missingFromBoth, missingFromB := digest.GetDifferenceAndIntersection(resultsA.missing, resultsB.missing)
+	if blobstore.IsRefreshingDisabled(ctx) {
+		return missingFromBoth, nil
+	}
 	mirroredBlobAccessFindMissingSynchronizationsFromAToB.Observe(float64(missingFromB.Length()))
 	mirroredBlobAccessFindMissingSynchronizationsFromBToA.Observe(float64(missingFromA.Length()))
 
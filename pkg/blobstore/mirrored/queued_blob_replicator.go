@@ -7,13 +7,27 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// QueuedBlobReplicator is a BlobReplicator that processes requests
+// one at a time. It can be drained before the process terminates.
+type QueuedBlobReplicator interface {
+	BlobReplicator
+
+	// Drain waits for the replication that is in progress to
+	// complete. Requests issued afterwards fail.
+	Drain(ctx context.Context) error
+}
+
 type queuedBlobReplicator struct {
 	source         blobstore.BlobAccess
 	base           BlobReplicator
 	existenceCache *digest.ExistenceCache
 	wait           chan struct{}
+	drained        chan struct{}
 }
 
 // NewQueuedBlobReplicator creates a decorator for BlobReplicator that
@@ -24,12 +38,13 @@ type queuedBlobReplicator struct {
 // not guarantee fairness. Should all requests be processed in FIFO
 // order? Alternatively, should we replicate objects with most waiters
 // first?
-func NewQueuedBlobReplicator(source blobstore.BlobAccess, base BlobReplicator, existenceCache *digest.ExistenceCache) BlobReplicator {
+func NewQueuedBlobReplicator(source blobstore.BlobAccess, base BlobReplicator, existenceCache *digest.ExistenceCache) QueuedBlobReplicator {
 	q := &queuedBlobReplicator{
 		source:         source,
 		base:           base,
 		existenceCache: existenceCache,
 		wait:           make(chan struct{}, 1),
+		drained:        make(chan struct{}),
 	}
 	q.wait <- struct{}{}
 	return q
@@ -64,6 +79,8 @@ func (br *queuedBlobReplicator) ReplicateMultiple(ctx context.Context, digests d
 	// Queue the request.
 	select {
 	case <-br.wait:
+	case <-br.drained:
+		return status.Error(codes.Unavailable, "Replicator has been drained, as the process is shutting down")
 	case <-ctx.Done():
 		return util.StatusFromContext(ctx)
 	}
@@ -80,3 +97,15 @@ func (br *queuedBlobReplicator) ReplicateMultiple(ctx context.Context, digests d
 	br.wait <- struct{}{}
 	return err
 }
+
+func (br *queuedBlobReplicator) Drain(ctx context.Context) error {
+	// Hold on to the token permanently, so that no further
+	// replications are started.
+	select {
+	case <-br.wait:
+		close(br.drained)
+		return nil
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	}
+}
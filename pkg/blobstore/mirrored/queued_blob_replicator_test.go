@@ -164,4 +164,15 @@ func TestQueuedBlobReplicatorReplicateMultiple(t *testing.T) {
 			status.Error(codes.Internal, "Server on fire"),
 			replicator.ReplicateMultiple(ctx, helloDigests))
 	})
+	t.Run("Drained", func(t *testing.T) {
+		// Once drained, no further replications should be
+		// started.
+		require.NoError(t, replicator.Drain(ctx))
+
+		clock.EXPECT().Now().Return(time.Unix(1300, 0))
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Replicator has been drained, as the process is shutting down"),
+			replicator.ReplicateMultiple(ctx, helloDigests))
+	})
 }
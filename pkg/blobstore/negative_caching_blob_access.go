@@ -0,0 +1,91 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type negativeCachingBlobAccess struct {
+	BlobAccess
+	missingCache *digest.ExistenceCache
+}
+
+// NewNegativeCachingBlobAccess creates a decorator for BlobAccess that
+// caches knowledge of which objects are absent. It is the counterpart
+// of ExistenceCachingBlobAccess.
+//
+// During incremental uploads, clients tend to call FindMissing() for
+// the same set of absent objects repeatedly, until they have finished
+// uploading them. For backends with a high per-request latency (e.g.,
+// S3), it is wasteful to confirm their absence every time.
+//
+// Entries are removed from the cache when objects are written through
+// this decorator. Objects written into the backend through other means
+// will only be announced as present once the cache entry expires.
+func NewNegativeCachingBlobAccess(base BlobAccess, missingCache *digest.ExistenceCache) BlobAccess {
+	return &negativeCachingBlobAccess{
+		BlobAccess:   base,
+		missingCache: missingCache,
+	}
+}
+
+func (ba *negativeCachingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	digests := digest.NewSetBuilder().Add(blobDigest).Build()
+	if ba.missingCache.RemoveExisting(digests).Empty() {
+		return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Object %s was recently reported as missing", blobDigest))
+	}
+	return buffer.WithErrorHandler(
+		ba.BlobAccess.Get(ctx, blobDigest),
+		&negativeCachingErrorHandler{
+			missingCache: ba.missingCache,
+			digests:      digests,
+		})
+}
+
+func (ba *negativeCachingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, blobDigest, b); err != nil {
+		return err
+	}
+
+	// Only invalidate the cache entry after the write completes.
+	// Doing it up front would allow a concurrent FindMissing() to
+	// insert it again before the object is present.
+	ba.missingCache.Invalidate(digest.NewSetBuilder().Add(blobDigest).Build())
+	return nil
+}
+
+func (ba *negativeCachingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Determine which digests don't need to be checked, because
+	// they were reported as missing recently.
+	maybePresent := ba.missingCache.RemoveExisting(digests)
+	knownMissing, _, _ := digest.GetDifferenceAndIntersection(digests, maybePresent)
+
+	// Check existence of the remaining digests.
+	missing, err := ba.BlobAccess.FindMissing(ctx, maybePresent)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+
+	// Insert the digests that were missing for future calls.
+	ba.missingCache.Add(missing)
+	return digest.GetUnion([]digest.Set{knownMissing, missing}), nil
+}
+
+type negativeCachingErrorHandler struct {
+	missingCache *digest.ExistenceCache
+	digests      digest.Set
+}
+
+func (eh *negativeCachingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if status.Code(err) == codes.NotFound {
+		eh.missingCache.Add(eh.digests)
+	}
+	return nil, err
+}
+
+func (eh *negativeCachingErrorHandler) Done() {}
@@ -0,0 +1,107 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNegativeCachingBlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewNegativeCachingBlobAccess(
+		baseBlobAccess,
+		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, time.Minute, eviction.NewLRUSet()))
+
+	existingDigest := digest.MustNewDigest("instance", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	nonExistingDigest := digest.MustNewDigest("instance", "78ae647dc5544d227130a0682a51e30bc7777fbb6d8a8f17007463a3ecd1d524", 5)
+	bothDigests := digest.NewSetBuilder().Add(existingDigest).Add(nonExistingDigest).Build()
+	existingDigests := digest.NewSetBuilder().Add(existingDigest).Build()
+	nonExistingDigests := digest.NewSetBuilder().Add(nonExistingDigest).Build()
+
+	// As the cache is empty upon initialization, the first request
+	// should cause both digests to be queried on the backend.
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).Times(2)
+	baseBlobAccess.EXPECT().FindMissing(ctx, bothDigests).Return(nonExistingDigests, nil)
+	missing, err := blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, nonExistingDigests, missing)
+
+	// The missing object should be cached for up to a minute,
+	// causing FindMissing() on the backend to be called with the
+	// existing one.
+	clock.EXPECT().Now().Return(time.Unix(1060, 0)).Times(2)
+	baseBlobAccess.EXPECT().FindMissing(ctx, existingDigests).Return(digest.EmptySet, nil)
+	missing, err = blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, nonExistingDigests, missing)
+
+	// Get() calls against the missing object should also fail
+	// without contacting the backend.
+	clock.EXPECT().Now().Return(time.Unix(1060, 0))
+	_, err = blobAccess.Get(ctx, nonExistingDigest).ToByteSlice(100)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Uploading the object through the decorator should invalidate
+	// the cache entry.
+	baseBlobAccess.EXPECT().Put(ctx, nonExistingDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, nonExistingDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	clock.EXPECT().Now().Return(time.Unix(1060, 0)).Times(2)
+	baseBlobAccess.EXPECT().FindMissing(ctx, bothDigests).Return(digest.EmptySet, nil)
+	missing, err = blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+}
+
+func TestNegativeCachingBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewNegativeCachingBlobAccess(
+		baseBlobAccess,
+		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, time.Minute, eviction.NewLRUSet()))
+	blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// NotFound errors returned by the backend should be cached.
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).Times(2)
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+	_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+
+	clock.EXPECT().Now().Return(time.Unix(1030, 0))
+	_, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Other errors should not be cached.
+	clock.EXPECT().Now().Return(time.Unix(1061, 0))
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Disk on fire")))
+	_, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+
+	clock.EXPECT().Now().Return(time.Unix(1062, 0))
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
@@ -0,0 +1,61 @@
+package blobstore
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/fsac"
+	"github.com/buildbarn/bb-storage/pkg/proto/iscc"
+	"github.com/golang/protobuf/proto"
+)
+
+// protoStorageType is a StorageType for stores that hold Protobuf
+// messages that are keyed by a digest and an instance name, but are not
+// content addressed. Buffers are validated by unmarshaling them into a
+// message of the expected type.
+type protoStorageType struct {
+	newMessage func() proto.Message
+}
+
+func (f protoStorageType) GetDigestKey(blobDigest digest.Digest) string {
+	return blobDigest.GetKey(digest.KeyWithInstance)
+}
+
+func (f protoStorageType) ParseDigestKey(key string) (digest.Digest, error) {
+	return digest.NewDigestFromKey(key, digest.KeyWithInstance)
+}
+
+func (f protoStorageType) NewBufferFromByteSlice(digest digest.Digest, data []byte, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	return buffer.NewProtoBufferFromByteSlice(f.newMessage(), data, repairStrategy)
+}
+
+func (f protoStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	return buffer.NewProtoBufferFromReader(f.newMessage(), r, repairStrategy)
+}
+
+func (f protoStorageType) NewBufferFromReaderAt(digest digest.Digest, r buffer.ReadAtCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	return buffer.NewProtoBufferFromReader(
+		f.newMessage(),
+		&sectionReadCloser{
+			SectionReader: io.NewSectionReader(r, 0, sizeBytes),
+			Closer:        r,
+		},
+		repairStrategy)
+}
+
+var (
+	// FSACStorageType is capable of creating identifiers and
+	// buffers for objects stored in the File System Access Cache
+	// (FSAC).
+	FSACStorageType StorageType = &protoStorageType{
+		newMessage: func() proto.Message { return &fsac.FileSystemAccessProfile{} },
+	}
+
+	// ISCCStorageType is capable of creating identifiers and
+	// buffers for objects stored in the Initial Size Class Cache
+	// (ISCC).
+	ISCCStorageType StorageType = &protoStorageType{
+		newMessage: func() proto.Message { return &iscc.PreviousExecutionStats{} },
+	}
+)
@@ -0,0 +1,53 @@
+package blobstore
+
+import (
+	"context"
+)
+
+// BlobKind describes what an object that is written is used for.
+// Storage backends may use this to store objects differently (e.g.,
+// using a cheaper storage class for objects that are seldom read).
+type BlobKind int
+
+const (
+	// BlobKindUnknown is used for objects for which the caller
+	// provided no hints.
+	BlobKindUnknown BlobKind = iota
+	// BlobKindActionResult is used for ActionResult messages stored
+	// in the Action Cache.
+	BlobKindActionResult
+	// BlobKindTree is used for Tree objects describing output
+	// directories of actions.
+	BlobKindTree
+	// BlobKindLog is used for the standard output and standard
+	// error output of actions.
+	BlobKindLog
+)
+
+// PutHints contains optional information on objects that are written
+// through BlobAccess.Put(). As the BlobAccess interface does not
+// permit passing this information explicitly, callers may attach it to
+// the Context object using NewContextWithPutHints().
+type PutHints struct {
+	// What the object is used for.
+	Kind BlobKind
+	// The priority provided by the client, as described by REAPI's
+	// ResultsCachePolicy. Generally, a lower value means a longer
+	// retention time. Zero indicates the default priority.
+	Priority int32
+}
+
+type putHintsKey struct{}
+
+// NewContextWithPutHints attaches PutHints to a Context object, so
+// that they may be inspected by storage backends.
+func NewContextWithPutHints(ctx context.Context, hints PutHints) context.Context {
+	return context.WithValue(ctx, putHintsKey{}, hints)
+}
+
+// GetPutHintsFromContext returns the PutHints attached to a Context
+// object. If none are attached, the zero value is returned.
+func GetPutHintsFromContext(ctx context.Context) PutHints {
+	hints, _ := ctx.Value(putHintsKey{}).(PutHints)
+	return hints
+}
@@ -5,14 +5,16 @@ import (
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type readCachingBlobAccess struct {
-	slow BlobAccess
-	fast BlobAccess
+	slow                 BlobAccess
+	fast                 BlobAccess
+	refreshOnFindMissing bool
 }
 
 // NewReadCachingBlobAccess turns a fast data store into a read cache
@@ -20,10 +22,19 @@ type readCachingBlobAccess struct {
 // store directly. The slow data store is only accessed for reading in
 // case the fast data store does not contain the blob. The blob is then
 // streamed into the fast data store.
-func NewReadCachingBlobAccess(slow BlobAccess, fast BlobAccess) BlobAccess {
+//
+// If refreshOnFindMissing is set, FindMissing() is also called against
+// the fast data store, causing objects to be refreshed in case it is a
+// local storage backend that is about to evict them. Objects that are
+// absent in the slow data store, but still present in the fast data
+// store, are replicated back to the slow data store. This ensures that
+// objects reported as present remain available for the duration of the
+// build, at the cost of additional load on the fast data store.
+func NewReadCachingBlobAccess(slow BlobAccess, fast BlobAccess, refreshOnFindMissing bool) BlobAccess {
 	return &readCachingBlobAccess{
-		slow: slow,
-		fast: fast,
+		slow:                 slow,
+		fast:                 fast,
+		refreshOnFindMissing: refreshOnFindMissing,
 	}
 }
 
@@ -42,7 +53,42 @@ func (ba *readCachingBlobAccess) Put(ctx context.Context, digest digest.Digest,
 }
 
 func (ba *readCachingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
-	return ba.slow.FindMissing(ctx, digests)
+	if !ba.refreshOnFindMissing || IsRefreshingDisabled(ctx) {
+		return ba.slow.FindMissing(ctx, digests)
+	}
+
+	missingFromSlow, err := ba.slow.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Slow backend")
+	}
+	missingFromFast, err := ba.fast.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Fast backend")
+	}
+
+	// Objects that are only present in the fast backend are
+	// replicated back to the slow backend, so that they can be
+	// reported as present.
+	onlyInFast, missingFromBoth, _ := digest.GetDifferenceAndIntersection(missingFromSlow, missingFromFast)
+	if onlyInFast.Empty() {
+		return missingFromBoth, nil
+	}
+	missing := digest.NewSetBuilder()
+	for _, blobDigest := range missingFromBoth.Items() {
+		missing.Add(blobDigest)
+	}
+	for _, blobDigest := range onlyInFast.Items() {
+		if err := ba.slow.Put(ctx, blobDigest, ba.fast.Get(ctx, blobDigest)); err != nil {
+			if status.Code(err) == codes.NotFound {
+				// Object disappeared from the fast
+				// backend in the meantime.
+				missing.Add(blobDigest)
+			} else {
+				return digest.EmptySet, util.StatusWrapf(err, "Failed to replicate blob %s to slow backend", blobDigest)
+			}
+		}
+	}
+	return missing.Build(), nil
 }
 
 type readCachingErrorHandler struct {
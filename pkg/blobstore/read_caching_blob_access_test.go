@@ -21,7 +21,7 @@ func TestReadCachingBlobAccessGet(t *testing.T) {
 
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
-	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess)
+	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, false)
 	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
 
 	t.Run("Fast", func(t *testing.T) {
@@ -98,7 +98,7 @@ func TestReadCachingBlobAccessPut(t *testing.T) {
 
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
-	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess)
+	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, false)
 	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
 	buffer := buffer.NewValidatedBufferFromByteSlice([]byte("Hello, world"))
 
@@ -118,7 +118,7 @@ func TestReadCachingBlobAccessFindMissing(t *testing.T) {
 
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
-	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess)
+	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, false)
 	digests := digest.NewSetBuilder().
 		Add(digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)).
 		Add(digest.MustNewDigest("default", "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)).
@@ -134,3 +134,68 @@ func TestReadCachingBlobAccessFindMissing(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, digests, missing)
 }
+
+func TestReadCachingBlobAccessFindMissingWithRefresh(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
+	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, true)
+	digestA := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	digestB := digest.MustNewDigest("default", "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)
+	digestC := digest.MustNewDigest("default", "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", 4)
+	digests := digest.NewSetBuilder().Add(digestA).Add(digestB).Add(digestC).Build()
+
+	t.Run("SlowFailure", func(t *testing.T) {
+		slowBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, status.Error(codes.Unavailable, "Slow backend: Server offline"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Blob A is present in both backends. Blob B is only
+		// present in the fast backend, meaning it should be
+		// replicated to the slow backend. Blob C is absent.
+		slowBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.NewSetBuilder().Add(digestB).Add(digestC).Build(), nil)
+		fastBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.NewSetBuilder().Add(digestC).Build(), nil)
+		fastBlobAccess.EXPECT().Get(ctx, digestB).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye")))
+		slowBlobAccess.EXPECT().Put(ctx, digestB, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Goodbye"), data)
+				return nil
+			})
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digestC).Build(), missing)
+	})
+
+	t.Run("ReplicationFailure", func(t *testing.T) {
+		slowBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.NewSetBuilder().Add(digestB).Build(), nil)
+		fastBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+		fastBlobAccess.EXPECT().Get(ctx, digestB).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye")))
+		slowBlobAccess.EXPECT().Put(ctx, digestB, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Internal, "Disk on fire")
+			})
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, status.Error(codes.Internal, "Failed to replicate blob 82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9-7-default to slow backend: Disk on fire"), err)
+	})
+
+	t.Run("RefreshingDisabled", func(t *testing.T) {
+		// Callers that only wish to observe which objects are
+		// present should not cause objects to be refreshed.
+		ctxWithoutRefreshing := blobstore.NewContextWithoutRefreshing(ctx)
+		slowBlobAccess.EXPECT().FindMissing(ctxWithoutRefreshing, digests).Return(digest.NewSetBuilder().Add(digestB).Build(), nil)
+
+		missing, err := blobAccess.FindMissing(ctxWithoutRefreshing, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digestB).Build(), missing)
+	})
+}
@@ -64,6 +64,16 @@ func (ba *redisBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer
 		}))
 }
 
+func (ba *redisBlobAccess) Delete(ctx context.Context, digest digest.Digest) error {
+	if err := util.StatusFromContext(ctx); err != nil {
+		return err
+	}
+	if err := ba.redisClient.Del(ba.storageType.GetDigestKey(digest)).Err(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to delete blob")
+	}
+	return ba.waitIfReplicationEnabled()
+}
+
 func (ba *redisBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 	if err := util.StatusFromContext(ctx); err != nil {
 		b.Discard()
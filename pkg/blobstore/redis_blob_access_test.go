@@ -26,7 +26,7 @@ func TestRedisBlobAccessContextCanceled(t *testing.T) {
 	cancel()
 	blobDigest := digest.MustNewDigest("example", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
 
-	// Calls to Get(), Put() and FindMissing() should not yield
+	// Calls to Get(), Put(), FindMissing() and Delete() should not yield
 	// calls into the Redis client if the context associated with
 	// the call is canceled.
 	//
@@ -42,4 +42,7 @@ func TestRedisBlobAccessContextCanceled(t *testing.T) {
 
 	_, err = blobAccess.FindMissing(canceledCtx, digest.EmptySet)
 	require.Equal(t, err, status.Error(codes.Canceled, "context canceled"))
+
+	err = blobAccess.(blobstore.BlobDeleter).Delete(canceledCtx, blobDigest)
+	require.Equal(t, err, status.Error(codes.Canceled, "context canceled"))
 }
@@ -0,0 +1,27 @@
+package blobstore
+
+import (
+	"context"
+)
+
+type withoutRefreshingKey struct{}
+
+// NewContextWithoutRefreshing attaches a flag to a Context object that
+// indicates that FindMissing() is only called to observe which objects
+// are present, as opposed to ensuring that they remain present.
+// Storage backends that normally refresh or replicate objects reported
+// as present (e.g., LocalBlobAccess) leave them untouched, so that they
+// are still evicted as usual.
+//
+// This flag is not propagated to storage backends that are accessed
+// over gRPC.
+func NewContextWithoutRefreshing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutRefreshingKey{}, true)
+}
+
+// IsRefreshingDisabled returns whether NewContextWithoutRefreshing()
+// has been used to create a Context object.
+func IsRefreshingDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(withoutRefreshingKey{}).(bool)
+	return disabled
+}
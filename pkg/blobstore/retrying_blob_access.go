@@ -0,0 +1,267 @@
+package blobstore
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	retryingBlobAccessPrometheusMetrics sync.Once
+
+	retryingBlobAccessRetriesPerformed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "retrying_blob_access_retries_performed_total",
+			Help:      "Number of times operations were retried, due to them failing with a retryable error.",
+		},
+		[]string{"name", "operation", "grpc_code"})
+	retryingBlobAccessRetriesExhausted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "retrying_blob_access_retries_exhausted_total",
+			Help:      "Number of times operations failed with a retryable error, but were not retried, due to the maximum number of attempts being reached or the retry budget being depleted.",
+		},
+		[]string{"name", "operation", "grpc_code", "reason"})
+)
+
+// RetryPolicy describes how operations failing with a given error
+// code should be retried.
+type RetryPolicy struct {
+	// The maximum number of attempts, including the initial one.
+	MaximumAttempts int
+	// The amount of time to wait before performing the first retry.
+	InitialBackoff time.Duration
+	// The amount of time to wait is multiplied by this factor after
+	// every retry.
+	BackoffMultiplier float64
+	// Upper bound on the amount of time to wait between attempts.
+	MaximumBackoff time.Duration
+}
+
+// RetryBudget limits the number of retries to a fraction of the number
+// of operations performed. This prevents retries from amplifying the
+// load on a storage backend that is already overloaded.
+//
+// The budget is implemented as a token bucket. Every operation adds a
+// fractional token to the bucket, while every retry removes a whole
+// token. Retries are not performed when the bucket is empty.
+type RetryBudget struct {
+	lock             sync.Mutex
+	tokens           float64
+	maximumTokens    float64
+	tokensPerRequest float64
+}
+
+// NewRetryBudget creates a RetryBudget that is initially full.
+func NewRetryBudget(tokensPerRequest float64, maximumTokens float64) *RetryBudget {
+	return &RetryBudget{
+		tokens:           maximumTokens,
+		maximumTokens:    maximumTokens,
+		tokensPerRequest: tokensPerRequest,
+	}
+}
+
+func (rb *RetryBudget) deposit() {
+	rb.lock.Lock()
+	rb.tokens = math.Min(rb.tokens+rb.tokensPerRequest, rb.maximumTokens)
+	rb.lock.Unlock()
+}
+
+func (rb *RetryBudget) withdraw() bool {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if rb.tokens < 1 {
+		return false
+	}
+	rb.tokens--
+	return true
+}
+
+type retryingBlobAccess struct {
+	blobAccess               BlobAccess
+	clock                    clock.Clock
+	policies                 map[codes.Code]RetryPolicy
+	budget                   *RetryBudget
+	maximumPutRetrySizeBytes int64
+
+	getRetriesPerformed         *prometheus.CounterVec
+	getRetriesExhausted         *prometheus.CounterVec
+	putRetriesPerformed         *prometheus.CounterVec
+	putRetriesExhausted         *prometheus.CounterVec
+	findMissingRetriesPerformed *prometheus.CounterVec
+	findMissingRetriesExhausted *prometheus.CounterVec
+}
+
+// NewRetryingBlobAccess creates a decorator for BlobAccess that retries
+// operations that fail with transient errors (e.g., UNAVAILABLE or
+// DEADLINE_EXCEEDED), using exponential backoff with jitter.
+//
+// Errors are classified by their gRPC status code. Only operations
+// failing with codes for which a policy is provided are retried. If a
+// RetryBudget is provided, the total number of retries is bounded.
+//
+// Get() operations are retried transparently, even when failing
+// halfway through the transfer. Put() operations can only be retried if
+// the object is small enough to be held in memory, as the buffer
+// provided by the caller can only be consumed once.
+func NewRetryingBlobAccess(blobAccess BlobAccess, clock clock.Clock, policies map[codes.Code]RetryPolicy, budget *RetryBudget, maximumPutRetrySizeBytes int64, name string) BlobAccess {
+	retryingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(retryingBlobAccessRetriesPerformed)
+		prometheus.MustRegister(retryingBlobAccessRetriesExhausted)
+	})
+
+	return &retryingBlobAccess{
+		blobAccess:               blobAccess,
+		clock:                    clock,
+		policies:                 policies,
+		budget:                   budget,
+		maximumPutRetrySizeBytes: maximumPutRetrySizeBytes,
+
+		getRetriesPerformed:         retryingBlobAccessRetriesPerformed.MustCurryWith(map[string]string{"name": name, "operation": "Get"}),
+		getRetriesExhausted:         retryingBlobAccessRetriesExhausted.MustCurryWith(map[string]string{"name": name, "operation": "Get"}),
+		putRetriesPerformed:         retryingBlobAccessRetriesPerformed.MustCurryWith(map[string]string{"name": name, "operation": "Put"}),
+		putRetriesExhausted:         retryingBlobAccessRetriesExhausted.MustCurryWith(map[string]string{"name": name, "operation": "Put"}),
+		findMissingRetriesPerformed: retryingBlobAccessRetriesPerformed.MustCurryWith(map[string]string{"name": name, "operation": "FindMissing"}),
+		findMissingRetriesExhausted: retryingBlobAccessRetriesExhausted.MustCurryWith(map[string]string{"name": name, "operation": "FindMissing"}),
+	}
+}
+
+func (ba *retryingBlobAccess) newRetrier(retriesPerformed *prometheus.CounterVec, retriesExhausted *prometheus.CounterVec) *retrier {
+	if ba.budget != nil {
+		ba.budget.deposit()
+	}
+	return &retrier{
+		blobAccess:       ba,
+		attempts:         1,
+		retriesPerformed: retriesPerformed,
+		retriesExhausted: retriesExhausted,
+	}
+}
+
+func (ba *retryingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.WithErrorHandler(
+		ba.blobAccess.Get(ctx, digest),
+		&retryingErrorHandler{
+			blobAccess: ba,
+			retrier:    ba.newRetrier(ba.getRetriesPerformed, ba.getRetriesExhausted),
+			context:    ctx,
+			digest:     digest,
+		})
+}
+
+func (ba *retryingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	r := ba.newRetrier(ba.putRetriesPerformed, ba.putRetriesExhausted)
+	sizeBytes, err := b.GetSizeBytes()
+	if err != nil {
+		b.Discard()
+		return err
+	}
+	if sizeBytes > ba.maximumPutRetrySizeBytes {
+		return ba.blobAccess.Put(ctx, digest, b)
+	}
+
+	// Load the object into memory, so that it may be written
+	// into the backend multiple times.
+	data, err := b.ToByteSlice(int(sizeBytes))
+	if err != nil {
+		return err
+	}
+	for {
+		err := ba.blobAccess.Put(ctx, digest, buffer.NewValidatedBufferFromByteSlice(data))
+		if err == nil {
+			return nil
+		}
+		if err := r.waitForRetry(ctx, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (ba *retryingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	r := ba.newRetrier(ba.findMissingRetriesPerformed, ba.findMissingRetriesExhausted)
+	for {
+		missing, err := ba.blobAccess.FindMissing(ctx, digests)
+		if err == nil {
+			return missing, nil
+		}
+		if err := r.waitForRetry(ctx, err); err != nil {
+			return digest.EmptySet, err
+		}
+	}
+}
+
+// retrier keeps track of the number of attempts performed by a single
+// operation.
+type retrier struct {
+	blobAccess       *retryingBlobAccess
+	attempts         int
+	retriesPerformed *prometheus.CounterVec
+	retriesExhausted *prometheus.CounterVec
+}
+
+// waitForRetry determines whether an operation that failed with an
+// error should be retried. If so, it waits for the backoff duration
+// and returns nil. Otherwise, the original error is returned.
+func (r *retrier) waitForRetry(ctx context.Context, err error) error {
+	code := status.Code(err)
+	policy, ok := r.blobAccess.policies[code]
+	if !ok {
+		return err
+	}
+	if r.attempts >= policy.MaximumAttempts {
+		r.retriesExhausted.WithLabelValues(code.String(), "MaximumAttempts").Inc()
+		return err
+	}
+	if budget := r.blobAccess.budget; budget != nil && !budget.withdraw() {
+		r.retriesExhausted.WithLabelValues(code.String(), "RetryBudget").Inc()
+		return err
+	}
+
+	// Wait for a random duration between half the backoff and the
+	// full backoff, to prevent clients from retrying in lockstep.
+	backoff := float64(policy.InitialBackoff) * math.Pow(policy.BackoffMultiplier, float64(r.attempts-1))
+	if maximumBackoff := float64(policy.MaximumBackoff); backoff > maximumBackoff {
+		backoff = maximumBackoff
+	}
+	halfBackoff := int64(backoff / 2)
+	timer, t := r.blobAccess.clock.NewTimer(time.Duration(halfBackoff + rand.Int63n(halfBackoff+1)))
+	select {
+	case <-t:
+	case <-ctx.Done():
+		timer.Stop()
+		return err
+	}
+
+	r.attempts++
+	r.retriesPerformed.WithLabelValues(code.String()).Inc()
+	return nil
+}
+
+type retryingErrorHandler struct {
+	blobAccess *retryingBlobAccess
+	retrier    *retrier
+	context    context.Context
+	digest     digest.Digest
+}
+
+func (eh *retryingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if err := eh.retrier.waitForRetry(eh.context, err); err != nil {
+		return nil, err
+	}
+	return eh.blobAccess.blobAccess.Get(eh.context, eh.digest), nil
+}
+
+func (eh *retryingErrorHandler) Done() {}
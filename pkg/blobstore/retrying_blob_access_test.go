@@ -0,0 +1,182 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// expectRetryTimer lets the next backoff of RetryingBlobAccess
+// complete immediately, while checking that its duration lies within
+// the expected bounds.
+func expectRetryTimer(ctrl *gomock.Controller, clock *mock.MockClock, minimum time.Duration, maximum time.Duration) *gomock.Call {
+	return clock.EXPECT().NewTimer(gomock.Any()).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+		if d < minimum || d > maximum {
+			panic("Backoff out of bounds")
+		}
+		t := make(chan time.Time, 1)
+		t <- time.Unix(1000, 0)
+		return mock.NewMockTimer(ctrl), t
+	})
+}
+
+func TestRetryingBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewRetryingBlobAccess(
+		baseBlobAccess,
+		clock,
+		map[codes.Code]blobstore.RetryPolicy{
+			codes.Unavailable: {
+				MaximumAttempts:   3,
+				InitialBackoff:    time.Second,
+				BackoffMultiplier: 2,
+				MaximumBackoff:    time.Minute,
+			},
+		},
+		nil,
+		100,
+		"TestRetryingBlobAccessGet")
+	blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("SuccessAfterRetries", func(t *testing.T) {
+		// Transient errors should be retried with exponential
+		// backoff.
+		gomock.InOrder(
+			baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline"))),
+			baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline"))),
+			baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		gomock.InOrder(
+			expectRetryTimer(ctrl, clock, 500*time.Millisecond, time.Second),
+			expectRetryTimer(ctrl, clock, time.Second, 2*time.Second))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		// The maximum number of attempts should be respected.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline"))).Times(3)
+		expectRetryTimer(ctrl, clock, 0, 2*time.Second)
+		expectRetryTimer(ctrl, clock, 0, 2*time.Second)
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+
+	t.Run("NonRetryableError", func(t *testing.T) {
+		// Errors for which no policy exists should be returned
+		// immediately.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+	})
+}
+
+func TestRetryingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewRetryingBlobAccess(
+		baseBlobAccess,
+		clock,
+		map[codes.Code]blobstore.RetryPolicy{
+			codes.DeadlineExceeded: {
+				MaximumAttempts:   10,
+				InitialBackoff:    time.Second,
+				BackoffMultiplier: 2,
+				MaximumBackoff:    time.Minute,
+			},
+		},
+		blobstore.NewRetryBudget(0.5, 1),
+		4,
+		"TestRetryingBlobAccessPut")
+
+	t.Run("RetryBudget", func(t *testing.T) {
+		// The first operation may use the single token that is
+		// present in the budget. The object's contents should
+		// be provided to the backend again.
+		blobDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+		gomock.InOrder(
+			baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+					b.Discard()
+					return status.Error(codes.DeadlineExceeded, "Request timed out")
+				}),
+			baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+					data, err := b.ToByteSlice(100)
+					require.NoError(t, err)
+					require.Equal(t, []byte("foo"), data)
+					return status.Error(codes.DeadlineExceeded, "Request timed out")
+				}))
+		expectRetryTimer(ctrl, clock, 500*time.Millisecond, time.Second)
+
+		// The budget is depleted after a single retry.
+		err := blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("foo")))
+		require.Equal(t, status.Error(codes.DeadlineExceeded, "Request timed out"), err)
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		// Objects exceeding the maximum size can't be retried,
+		// as that would require holding them in memory.
+		blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.DeadlineExceeded, "Request timed out")
+			})
+
+		err := blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		require.Equal(t, status.Error(codes.DeadlineExceeded, "Request timed out"), err)
+	})
+}
+
+func TestRetryingBlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewRetryingBlobAccess(
+		baseBlobAccess,
+		clock,
+		map[codes.Code]blobstore.RetryPolicy{
+			codes.Unavailable: {
+				MaximumAttempts:   2,
+				InitialBackoff:    time.Second,
+				BackoffMultiplier: 2,
+				MaximumBackoff:    time.Minute,
+			},
+		},
+		nil,
+		0,
+		"TestRetryingBlobAccessFindMissing")
+	digests := digest.NewSetBuilder().Add(digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)).Build()
+
+	gomock.InOrder(
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline")),
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digests, nil))
+	expectRetryTimer(ctrl, clock, 500*time.Millisecond, time.Second)
+
+	missing, err := blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+	require.Equal(t, digests, missing)
+}
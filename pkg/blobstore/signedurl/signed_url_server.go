@@ -0,0 +1,91 @@
+package signedurl
+
+import (
+	"context"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	signedurl_pb "github.com/buildbarn/bb-storage/pkg/proto/signedurl"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type signedURLServer struct {
+	blobAccess    blobstore.BlobAccess
+	generator     blobstore.SignedURLGenerator
+	maximumExpiry time.Duration
+}
+
+// NewSignedURLServer creates a gRPC stub for the SignedURLGenerator
+// service. The presence of objects is determined by calling
+// FindMissing() against a BlobAccess, after which signed URLs are
+// obtained from a storage backend that is capable of generating them.
+//
+// The BlobAccess should refer to the same storage backend as the
+// SignedURLGenerator. Objects that are only present in other backends
+// (e.g., a caching tier in front of it) would otherwise yield signed
+// URLs that cannot be downloaded.
+func NewSignedURLServer(blobAccess blobstore.BlobAccess, generator blobstore.SignedURLGenerator, maximumExpiry time.Duration) signedurl_pb.SignedURLGeneratorServer {
+	return &signedURLServer{
+		blobAccess:    blobAccess,
+		generator:     generator,
+		maximumExpiry: maximumExpiry,
+	}
+}
+
+func (s *signedURLServer) GetSignedURLs(ctx context.Context, request *signedurl_pb.GetSignedURLsRequest) (*signedurl_pb.GetSignedURLsResponse, error) {
+	digests := digest.NewSetBuilder()
+	for i, blobDigest := range request.BlobDigests {
+		d, err := digest.NewDigestFromPartialDigest(request.InstanceName, blobDigest)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Digest at index %d", i)
+		}
+		digests.Add(d)
+	}
+	expiry := s.maximumExpiry
+	if request.Expiry != nil {
+		requestedExpiry, err := ptypes.Duration(request.Expiry)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain expiry")
+		}
+		if requestedExpiry <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Expiry must be positive")
+		}
+		if requestedExpiry < expiry {
+			expiry = requestedExpiry
+		}
+	}
+
+	allDigests := digests.Build()
+	missing, err := s.blobAccess.FindMissing(ctx, allDigests)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to determine presence of blobs")
+	}
+	present, _, _ := digest.GetDifferenceAndIntersection(allDigests, missing)
+
+	signedURLs := make([]*signedurl_pb.SignedURL, 0, present.Length())
+	for _, blobDigest := range present.Items() {
+		url, err := s.generator.GetSignedURL(ctx, blobDigest, expiry)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to generate signed URL for blob %s", blobDigest)
+		}
+		signedURLs = append(signedURLs, &signedurl_pb.SignedURL{
+			Digest: blobDigest.GetPartialDigest(),
+			Url:    url,
+		})
+	}
+	missingDigests := make([]*remoteexecution.Digest, 0, missing.Length())
+	for _, blobDigest := range missing.Items() {
+		missingDigests = append(missingDigests, blobDigest.GetPartialDigest())
+	}
+	return &signedurl_pb.GetSignedURLsResponse{
+		SignedUrls:         signedURLs,
+		MissingBlobDigests: missingDigests,
+		Expiry:             ptypes.DurationProto(expiry),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package signedurl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/signedurl"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	signedurl_pb "github.com/buildbarn/bb-storage/pkg/proto/signedurl"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSignedURLServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	generator := mock.NewMockSignedURLGenerator(ctrl)
+	server := signedurl.NewSignedURLServer(blobAccess, generator, time.Hour)
+
+	digest1 := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+	digest2 := digest.MustNewDigest("instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+
+	t.Run("InvalidDigest", func(t *testing.T) {
+		_, err := server.GetSignedURLs(ctx, &signedurl_pb.GetSignedURLsRequest{
+			InstanceName: "instance",
+			BlobDigests: []*remoteexecution.Digest{
+				{Hash: "Hello", SizeBytes: 3},
+			},
+		})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("InvalidExpiry", func(t *testing.T) {
+		_, err := server.GetSignedURLs(ctx, &signedurl_pb.GetSignedURLsRequest{
+			InstanceName: "instance",
+			BlobDigests:  []*remoteexecution.Digest{digest1.GetPartialDigest()},
+			Expiry:       ptypes.DurationProto(0),
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Expiry must be positive"), err)
+	})
+
+	t.Run("GeneratorFailure", func(t *testing.T) {
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Build()).Return(digest.EmptySet, nil)
+		generator.EXPECT().GetSignedURL(ctx, digest1, time.Hour).Return("", status.Error(codes.Unimplemented, "Signed URLs not supported"))
+
+		_, err := server.GetSignedURLs(ctx, &signedurl_pb.GetSignedURLsRequest{
+			InstanceName: "instance",
+			BlobDigests:  []*remoteexecution.Digest{digest1.GetPartialDigest()},
+		})
+		require.Equal(t, status.Error(codes.Unimplemented, "Failed to generate signed URL for blob acbd18db4cc2f85cedef654fccc4a4d8-3-instance: Signed URLs not supported"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Signed URLs should only be generated for blobs that
+		// are present. Expiry should be capped.
+		blobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build()).
+			Return(digest.NewSetBuilder().Add(digest2).Build(), nil)
+		generator.EXPECT().GetSignedURL(ctx, digest1, time.Hour).Return("https://example.com/acbd18db4cc2f85cedef654fccc4a4d8-3?signature=1234", nil)
+
+		response, err := server.GetSignedURLs(ctx, &signedurl_pb.GetSignedURLsRequest{
+			InstanceName: "instance",
+			BlobDigests: []*remoteexecution.Digest{
+				digest1.GetPartialDigest(),
+				digest2.GetPartialDigest(),
+			},
+			Expiry: ptypes.DurationProto(24 * time.Hour),
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&signedurl_pb.GetSignedURLsResponse{
+			SignedUrls: []*signedurl_pb.SignedURL{
+				{
+					Digest: digest1.GetPartialDigest(),
+					Url:    "https://example.com/acbd18db4cc2f85cedef654fccc4a4d8-3?signature=1234",
+				},
+			},
+			MissingBlobDigests: []*remoteexecution.Digest{digest2.GetPartialDigest()},
+			Expiry:             ptypes.DurationProto(time.Hour),
+		}, response))
+	})
+}
@@ -0,0 +1,127 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	singleFlightBlobAccessPrometheusMetrics sync.Once
+
+	singleFlightBlobAccessGetsDeduplicated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "single_flight_blob_access_gets_deduplicated_total",
+			Help:      "Number of Get() calls that were served by joining a Get() call for the same object that was already in flight.",
+		},
+		[]string{"name"})
+)
+
+type singleFlightGet struct {
+	// Spare clone of the buffer returned by the backend. It is
+	// cloned once more for every caller that joins.
+	buffer buffer.Buffer
+}
+
+type singleFlightBlobAccess struct {
+	BlobAccess
+	clock            clock.Clock
+	minimumSizeBytes int64
+	joinWindow       time.Duration
+
+	lock     sync.Mutex
+	inFlight map[string]*singleFlightGet
+
+	getsDeduplicated prometheus.Counter
+}
+
+// NewSingleFlightBlobAccess creates a decorator for BlobAccess that
+// deduplicates concurrent Get() calls for the same object. Instead of
+// requesting the object from the backend for every caller, the
+// response of a single Get() call is streamed to all callers using
+// Buffer.CloneStream().
+//
+// This is useful when large numbers of workers start up at the same
+// time, all requesting the same large objects (e.g., toolchains).
+//
+// Callers may only join a Get() call within a fixed window of time
+// after it has been issued, as the transfer may only start once the
+// set of callers is known. This delays all Get() calls by the length
+// of that window, which is why deduplication is only performed for
+// objects of a minimum size. All callers receive data at the pace of
+// the slowest caller.
+//
+// As Get() calls for objects in the Action Cache may not be cloned in
+// a streaming fashion, this decorator may only be used for the Content
+// Addressable Storage.
+func NewSingleFlightBlobAccess(base BlobAccess, clock clock.Clock, minimumSizeBytes int64, joinWindow time.Duration, name string) BlobAccess {
+	singleFlightBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(singleFlightBlobAccessGetsDeduplicated)
+	})
+
+	return &singleFlightBlobAccess{
+		BlobAccess:       base,
+		clock:            clock,
+		minimumSizeBytes: minimumSizeBytes,
+		joinWindow:       joinWindow,
+
+		inFlight: map[string]*singleFlightGet{},
+
+		getsDeduplicated: singleFlightBlobAccessGetsDeduplicated.WithLabelValues(name),
+	}
+}
+
+func (ba *singleFlightBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if blobDigest.GetSizeBytes() < ba.minimumSizeBytes {
+		return ba.BlobAccess.Get(ctx, blobDigest)
+	}
+
+	// Join a Get() call that is already in flight.
+	key := blobDigest.GetKey(digest.KeyWithInstance)
+	ba.lock.Lock()
+	if g, ok := ba.inFlight[key]; ok {
+		b1, b2 := g.buffer.CloneStream()
+		g.buffer = b2
+		ba.lock.Unlock()
+		ba.getsDeduplicated.Inc()
+		return b1
+	}
+	ba.lock.Unlock()
+
+	// No Get() call in flight. Issue a new one. This call is shared
+	// with other callers, meaning that it should not be interrupted
+	// when the current caller's context is cancelled.
+	b := ba.BlobAccess.Get(util.NewDetachedContext(ctx), blobDigest)
+
+	ba.lock.Lock()
+	if _, ok := ba.inFlight[key]; ok {
+		// Another caller issued a Get() call at the same time.
+		ba.lock.Unlock()
+		return b
+	}
+	b1, b2 := b.CloneStream()
+	g := &singleFlightGet{buffer: b2}
+	ba.inFlight[key] = g
+	ba.lock.Unlock()
+
+	// Stop accepting new callers once the window has passed. The
+	// spare clone needs to be discarded to let the transfer start.
+	_, t := ba.clock.NewTimer(ba.joinWindow)
+	go func() {
+		<-t
+		ba.lock.Lock()
+		delete(ba.inFlight, key)
+		spare := g.buffer
+		ba.lock.Unlock()
+		spare.Discard()
+	}()
+	return b1
+}
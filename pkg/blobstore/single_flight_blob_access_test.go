@@ -0,0 +1,75 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFlightBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewSingleFlightBlobAccess(baseBlobAccess, clock, 5, 100*time.Millisecond, "TestSingleFlightBlobAccessGet")
+
+	t.Run("Small", func(t *testing.T) {
+		// Objects below the minimum size should not be
+		// deduplicated.
+		smallDigest := digest.MustNewDigest("instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+		baseBlobAccess.EXPECT().Get(ctx, smallDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("foo"))).Times(2)
+
+		for i := 0; i < 2; i++ {
+			data, err := blobAccess.Get(ctx, smallDigest).ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("foo"), data)
+		}
+	})
+
+	t.Run("Deduplicated", func(t *testing.T) {
+		// Two calls issued within the join window should share
+		// a single call against the backend.
+		blobDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		baseBlobAccess.EXPECT().Get(gomock.Any(), blobDigest).Return(
+			buffer.NewCASBufferFromReader(blobDigest, ioutil.NopCloser(bytes.NewBufferString("Hello")), buffer.Irreparable))
+		timerChannel := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(100*time.Millisecond).Return(nil, timerChannel)
+
+		b1 := blobAccess.Get(ctx, blobDigest)
+		b2 := blobAccess.Get(ctx, blobDigest)
+		timerChannel <- time.Unix(1000, 0)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		for _, b := range []buffer.Buffer{b1, b2} {
+			go func(b buffer.Buffer) {
+				defer wg.Done()
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+			}(b)
+		}
+		wg.Wait()
+
+		// Once the join window has passed, subsequent calls
+		// should contact the backend once again.
+		baseBlobAccess.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		timerChannel <- time.Unix(1001, 0)
+		clock.EXPECT().NewTimer(100*time.Millisecond).Return(nil, timerChannel)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
@@ -8,9 +8,10 @@ import (
 )
 
 type sizeDistinguishingBlobAccess struct {
-	smallBlobAccess BlobAccess
-	largeBlobAccess BlobAccess
-	cutoffSizeBytes int64
+	smallBlobAccess            BlobAccess
+	largeBlobAccess            BlobAccess
+	cutoffSizeBytes            int64
+	cutoffSizeBytesPerInstance map[string]int64
 }
 
 // NewSizeDistinguishingBlobAccess creates a BlobAccess that splits up
@@ -18,16 +19,30 @@ type sizeDistinguishingBlobAccess struct {
 // specified in the digest. Backends tend to have different performance
 // characteristics based on blob size. This adapter may be used to
 // optimize performance based on that.
-func NewSizeDistinguishingBlobAccess(smallBlobAccess BlobAccess, largeBlobAccess BlobAccess, cutoffSizeBytes int64) BlobAccess {
+//
+// The cutoff size may be overridden for individual instance names, as
+// the distribution of object sizes may differ between projects.
+func NewSizeDistinguishingBlobAccess(smallBlobAccess BlobAccess, largeBlobAccess BlobAccess, cutoffSizeBytes int64, cutoffSizeBytesPerInstance map[string]int64) BlobAccess {
 	return &sizeDistinguishingBlobAccess{
-		smallBlobAccess: smallBlobAccess,
-		largeBlobAccess: largeBlobAccess,
-		cutoffSizeBytes: cutoffSizeBytes,
+		smallBlobAccess:            smallBlobAccess,
+		largeBlobAccess:            largeBlobAccess,
+		cutoffSizeBytes:            cutoffSizeBytes,
+		cutoffSizeBytesPerInstance: cutoffSizeBytesPerInstance,
 	}
 }
 
+// isSmall returns whether requests for an object should be sent to
+// the backend for small blobs.
+func (ba *sizeDistinguishingBlobAccess) isSmall(digest digest.Digest) bool {
+	cutoffSizeBytes, ok := ba.cutoffSizeBytesPerInstance[digest.GetInstance()]
+	if !ok {
+		cutoffSizeBytes = ba.cutoffSizeBytes
+	}
+	return digest.GetSizeBytes() <= cutoffSizeBytes
+}
+
 func (ba *sizeDistinguishingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
-	if digest.GetSizeBytes() <= ba.cutoffSizeBytes {
+	if ba.isSmall(digest) {
 		return ba.smallBlobAccess.Get(ctx, digest)
 	}
 	return ba.largeBlobAccess.Get(ctx, digest)
@@ -36,7 +51,7 @@ func (ba *sizeDistinguishingBlobAccess) Get(ctx context.Context, digest digest.D
 func (ba *sizeDistinguishingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 	// Use the size that's in the digest; not the size provided. We
 	// can't re-obtain that in the other operations.
-	if digest.GetSizeBytes() <= ba.cutoffSizeBytes {
+	if ba.isSmall(digest) {
 		return ba.smallBlobAccess.Put(ctx, digest, b)
 	}
 	return ba.largeBlobAccess.Put(ctx, digest, b)
@@ -57,7 +72,7 @@ func (ba *sizeDistinguishingBlobAccess) FindMissing(ctx context.Context, digests
 	smallDigests := digest.NewSetBuilder()
 	largeDigests := digest.NewSetBuilder()
 	for _, digest := range digests.Items() {
-		if digest.GetSizeBytes() <= ba.cutoffSizeBytes {
+		if ba.isSmall(digest) {
 			smallDigests.Add(digest)
 		} else {
 			largeDigests.Add(digest)
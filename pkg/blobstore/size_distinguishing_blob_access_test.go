@@ -0,0 +1,55 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeDistinguishingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	smallBlobAccess := mock.NewMockBlobAccess(ctrl)
+	largeBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewSizeDistinguishingBlobAccess(
+		smallBlobAccess,
+		largeBlobAccess,
+		4,
+		map[string]int64{"large-cutoff": 10})
+
+	// Digests of size 5 exceed the default cutoff, but not the one
+	// of instance "large-cutoff".
+	defaultDigest := digest.MustNewDigest("default", "8b1a9953c4611296a827abf8c47804d7", 5)
+	overriddenDigest := digest.MustNewDigest("large-cutoff", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Get", func(t *testing.T) {
+		largeBlobAccess.EXPECT().Get(ctx, defaultDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err := blobAccess.Get(ctx, defaultDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		smallBlobAccess.EXPECT().Get(ctx, overriddenDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err = blobAccess.Get(ctx, overriddenDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// Digests should be split up across both backends.
+		defaultDigests := digest.NewSetBuilder().Add(defaultDigest).Build()
+		overriddenDigests := digest.NewSetBuilder().Add(overriddenDigest).Build()
+		largeBlobAccess.EXPECT().FindMissing(ctx, defaultDigests).Return(defaultDigests, nil)
+		smallBlobAccess.EXPECT().FindMissing(ctx, overriddenDigests).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.GetUnion([]digest.Set{defaultDigests, overriddenDigests}))
+		require.NoError(t, err)
+		require.Equal(t, defaultDigests, missing)
+	})
+}
@@ -16,6 +16,10 @@ type StorageType interface {
 	// identifier for a blob. This function is, for example, used to
 	// determine the name of keys in S3 and Redis.
 	GetDigestKey(digest digest.Digest) string
+	// ParseDigestKey is the inverse of GetDigestKey(). It can be
+	// used to obtain the digests of blobs when iterating over the
+	// keys in a store.
+	ParseDigestKey(key string) (digest.Digest, error)
 
 	// NewBufferFromByteSlice creates a buffer from a byte slice
 	// that is either suitable for storage in the CAS or AC.
@@ -23,4 +27,10 @@ type StorageType interface {
 	// NewBufferFromByteSlice creates a buffer from a reader that is
 	// either suitable for storage in the CAS or AC.
 	NewBufferFromReader(digest digest.Digest, r io.ReadCloser, repairStrategy buffer.RepairStrategy) buffer.Buffer
+	// NewBufferFromReaderAt creates a buffer from a reader that
+	// supports random access and is either suitable for storage in
+	// the CAS or AC. The size of the object needs to be provided
+	// explicitly, as the size stored in the digest of an AC entry
+	// corresponds to that of the Action message.
+	NewBufferFromReaderAt(digest digest.Digest, r buffer.ReadAtCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer
 }
@@ -0,0 +1,195 @@
+package striping
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Log and exponent tables for GF(2^8), using the primitive polynomial
+// x^8 + x^4 + x^3 + x^2 + 1. The exponent table is doubled in size, so
+// that the sum of two logarithms can be used as an index without
+// reducing it modulo 255.
+var (
+	gfLog [256]uint8
+	gfExp [510]uint8
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = uint8(x)
+		gfExp[i+255] = uint8(x)
+		gfLog[x] = uint8(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b uint8) uint8 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a uint8) uint8 {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfMulAdd computes dst ^= c * src for every byte in src.
+func gfMulAdd(dst []byte, c uint8, src []byte) {
+	if c == 0 {
+		return
+	}
+	logC := int(gfLog[c])
+	for i, v := range src {
+		if v != 0 {
+			dst[i] ^= gfExp[logC+int(gfLog[v])]
+		}
+	}
+}
+
+// maximumShards is the maximum number of data and parity shards
+// combined that can be supported by a Reed-Solomon code over GF(2^8).
+const maximumShards = 256
+
+// reedSolomonCode is a systematic Reed-Solomon erasure code. Data
+// shards are stored as is, while parity shards are computed by
+// multiplying the data shards with a Cauchy matrix. As every square
+// submatrix of a Cauchy matrix is invertible, any combination of data
+// and parity shards whose count equals the number of data shards is
+// sufficient to reconstruct the data.
+type reedSolomonCode struct {
+	dataShards int
+	// parityMatrix[i][j] is the coefficient of data shard j in
+	// parity shard i.
+	parityMatrix [][]uint8
+}
+
+func newReedSolomonCode(dataShards, parityShards int) (*reedSolomonCode, error) {
+	if dataShards <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Number of data shards must be positive")
+	}
+	if parityShards < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Number of parity shards cannot be negative")
+	}
+	if dataShards+parityShards > maximumShards {
+		return nil, status.Errorf(codes.InvalidArgument, "The total number of shards cannot exceed %d", maximumShards)
+	}
+	parityMatrix := make([][]uint8, parityShards)
+	for i := range parityMatrix {
+		parityMatrix[i] = make([]uint8, dataShards)
+		for j := range parityMatrix[i] {
+			parityMatrix[i][j] = gfInv(uint8(dataShards+i) ^ uint8(j))
+		}
+	}
+	return &reedSolomonCode{
+		dataShards:   dataShards,
+		parityMatrix: parityMatrix,
+	}, nil
+}
+
+// getRow returns the row of the encoding matrix that corresponds to a
+// shard. For data shards, this is a row of the identity matrix.
+func (c *reedSolomonCode) getRow(shard int) []uint8 {
+	if shard < c.dataShards {
+		row := make([]uint8, c.dataShards)
+		row[shard] = 1
+		return row
+	}
+	return c.parityMatrix[shard-c.dataShards]
+}
+
+// encode computes the parity shards for a set of data shards. All data
+// shards must have the same size.
+func (c *reedSolomonCode) encode(data [][]byte) [][]byte {
+	parity := make([][]byte, len(c.parityMatrix))
+	for i, row := range c.parityMatrix {
+		parity[i] = make([]byte, len(data[0]))
+		for j, coefficient := range row {
+			gfMulAdd(parity[i], coefficient, data[j])
+		}
+	}
+	return parity
+}
+
+// reconstructData recomputes data shards that are missing. The shards
+// slice contains all data shards, followed by all parity shards.
+// Missing shards are nil. All shards that are present must have the
+// same size.
+func (c *reedSolomonCode) reconstructData(shards [][]byte) error {
+	var missing []int
+	for i := 0; i < c.dataShards; i++ {
+		if shards[i] == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// Pick the first shards that are present, and invert the
+	// submatrix of the encoding matrix corresponding to them.
+	present := make([]int, 0, c.dataShards)
+	for i := range shards {
+		if shards[i] != nil {
+			present = append(present, i)
+			if len(present) == c.dataShards {
+				break
+			}
+		}
+	}
+	if len(present) < c.dataShards {
+		return status.Errorf(codes.Unavailable, "Only %d of %d required shards are available", len(present), c.dataShards)
+	}
+	matrix := make([][]uint8, c.dataShards)
+	for i, shard := range present {
+		matrix[i] = append([]uint8(nil), c.getRow(shard)...)
+	}
+	inverse := invertMatrix(matrix)
+
+	size := len(shards[present[0]])
+	for _, shard := range missing {
+		data := make([]byte, size)
+		for j, coefficient := range inverse[shard] {
+			gfMulAdd(data, coefficient, shards[present[j]])
+		}
+		shards[shard] = data
+	}
+	return nil
+}
+
+// invertMatrix inverts a square matrix over GF(2^8) using Gauss-Jordan
+// elimination. The matrix must be invertible. The matrix provided is
+// overwritten.
+func invertMatrix(matrix [][]uint8) [][]uint8 {
+	n := len(matrix)
+	inverse := make([][]uint8, n)
+	for i := range inverse {
+		inverse[i] = make([]uint8, n)
+		inverse[i][i] = 1
+	}
+	for column := 0; column < n; column++ {
+		pivot := column
+		for matrix[pivot][column] == 0 {
+			pivot++
+		}
+		matrix[column], matrix[pivot] = matrix[pivot], matrix[column]
+		inverse[column], inverse[pivot] = inverse[pivot], inverse[column]
+
+		scale := gfInv(matrix[column][column])
+		for j := 0; j < n; j++ {
+			matrix[column][j] = gfMul(matrix[column][j], scale)
+			inverse[column][j] = gfMul(inverse[column][j], scale)
+		}
+		for row := 0; row < n; row++ {
+			if factor := matrix[row][column]; row != column && factor != 0 {
+				gfMulAdd(matrix[row], factor, matrix[column])
+				gfMulAdd(inverse[row], factor, inverse[column])
+			}
+		}
+	}
+	return inverse
+}
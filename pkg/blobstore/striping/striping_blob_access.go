@@ -0,0 +1,447 @@
+package striping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximumConcurrentManifestLookups is the maximum number of manifests
+// that FindMissing() loads from the backend in parallel.
+const maximumConcurrentManifestLookups = 16
+
+type stripingBlobAccess struct {
+	backend                  blobstore.BlobAccess
+	manifests                blobstore.BlobAccess
+	shards                   []blobstore.BlobAccess
+	code                     *reedSolomonCode
+	minimumSizeBytes         int64
+	stripeSizeBytes          int64
+	maximumManifestSizeBytes int
+}
+
+// NewStripingBlobAccess creates a decorator for the Content Addressable
+// Storage that stores objects above a given size across a set of
+// shards, using Reed-Solomon erasure coding. Objects are split up into
+// stripes of a fixed size. Every stripe is split up into data
+// fragments, for which a number of parity fragments is computed. Each
+// fragment is stored in a separate shard, keyed by its own digest.
+//
+// Objects can be retrieved as long as any combination of data and
+// parity fragments equal to the number of data shards is available.
+// This means that Get() calls continue to succeed while up to
+// parityShards shards are unavailable, while only requiring a fraction
+// of the storage space that mirroring would take.
+//
+// A manifest listing the digests of all fragments is stored in a
+// separate backend, keyed by the digest of the original object with
+// its instance name cleared. As with NewChunkingBlobAccess(), manifests
+// are encoded as ActionResult messages. Objects below the minimum size are stored in a separate
+// backend as is.
+func NewStripingBlobAccess(backend, manifests blobstore.BlobAccess, shards []blobstore.BlobAccess, parityShards int, minimumSizeBytes, stripeSizeBytes int64, maximumManifestSizeBytes int) (blobstore.BlobAccess, error) {
+	code, err := newReedSolomonCode(len(shards)-parityShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &stripingBlobAccess{
+		backend:                  backend,
+		manifests:                manifests,
+		shards:                   shards,
+		code:                     code,
+		minimumSizeBytes:         minimumSizeBytes,
+		stripeSizeBytes:          stripeSizeBytes,
+		maximumManifestSizeBytes: maximumManifestSizeBytes,
+	}, nil
+}
+
+func (ba *stripingBlobAccess) isStriped(blobDigest digest.Digest) bool {
+	return blobDigest.GetSizeBytes() >= ba.minimumSizeBytes
+}
+
+// getStripes loads the manifest of a striped object, returning the
+// digests of the fragments of every stripe.
+func (ba *stripingBlobAccess) getStripes(ctx context.Context, blobDigest digest.Digest) ([][]digest.Digest, error) {
+	manifest, err := ba.manifests.Get(ctx, blobDigest.WithoutInstance()).ToActionResult(ba.maximumManifestSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, err
+		}
+		return nil, util.StatusWrap(err, "Failed to load manifest")
+	}
+	shardsCount := len(ba.shards)
+	if len(manifest.OutputFiles)%shardsCount != 0 {
+		return nil, status.Errorf(codes.Internal, "Manifest contains %d fragments, which is not a multiple of the number of shards %d", len(manifest.OutputFiles), shardsCount)
+	}
+
+	stripes := make([][]digest.Digest, 0, len(manifest.OutputFiles)/shardsCount)
+	totalSizeBytes := int64(0)
+	for i := 0; i < len(manifest.OutputFiles); i += shardsCount {
+		stripeIndex := len(stripes)
+		fragmentDigests := make([]digest.Digest, 0, shardsCount)
+		for j, outputFile := range manifest.OutputFiles[i : i+shardsCount] {
+			fragmentDigest, err := blobDigest.NewDerivedDigest(outputFile.Digest)
+			if err != nil {
+				return nil, util.StatusWrapfWithCode(err, codes.Internal, "Manifest contains invalid digest for fragment %d of stripe %d", j, stripeIndex)
+			}
+			fragmentDigests = append(fragmentDigests, fragmentDigest)
+		}
+
+		// All fragments should have the size of the first data
+		// fragment, except trailing data fragments.
+		fragmentSizeBytes := fragmentDigests[0].GetSizeBytes()
+		for j, fragmentDigest := range fragmentDigests {
+			if sizeBytes := fragmentDigest.GetSizeBytes(); sizeBytes > fragmentSizeBytes || (j >= ba.code.dataShards && sizeBytes != fragmentSizeBytes) {
+				return nil, status.Errorf(codes.Internal, "Fragment %d of stripe %d has size %d, while the stripe has a fragment size of %d bytes", j, stripeIndex, sizeBytes, fragmentSizeBytes)
+			}
+			if j < ba.code.dataShards {
+				totalSizeBytes += fragmentDigest.GetSizeBytes()
+			}
+		}
+		stripes = append(stripes, fragmentDigests)
+	}
+	if totalSizeBytes != blobDigest.GetSizeBytes() {
+		return nil, status.Errorf(codes.Internal, "Manifest contains data fragments with a total size of %d bytes, while %d bytes were expected", totalSizeBytes, blobDigest.GetSizeBytes())
+	}
+	return stripes, nil
+}
+
+func (ba *stripingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if !ba.isStriped(blobDigest) {
+		return ba.backend.Get(ctx, blobDigest)
+	}
+	stripes, err := ba.getStripes(ctx, blobDigest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewCASBufferFromChunkReader(
+		blobDigest,
+		&stripeReader{
+			ctx:        ctx,
+			blobAccess: ba,
+			stripes:    stripes,
+		},
+		buffer.Irreparable)
+}
+
+// fetchFragments loads a subset of the fragments of a stripe from
+// their shards in parallel. Fragments that could not be loaded are
+// left nil. The first error encountered is returned.
+func (ba *stripingBlobAccess) fetchFragments(ctx context.Context, fragmentDigests []digest.Digest, fragments [][]byte, indices []int) error {
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	for _, i := range indices {
+		sizeBytes := fragmentDigests[i].GetSizeBytes()
+		if sizeBytes == 0 {
+			// Empty fragments are not stored.
+			fragments[i] = []byte{}
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := ba.shards[i].Get(ctx, fragmentDigests[i]).ToByteSlice(int(sizeBytes))
+			if err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = util.StatusWrapf(err, "Fragment %d", i)
+				}
+				errLock.Unlock()
+				return
+			}
+			fragments[i] = data
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// getStripe loads the data fragments of a single stripe, falling back
+// to reconstructing them from parity fragments in case one or more
+// data fragments cannot be loaded.
+func (ba *stripingBlobAccess) getStripe(ctx context.Context, fragmentDigests []digest.Digest) ([]byte, error) {
+	dataShards := ba.code.dataShards
+	fragments := make([][]byte, len(fragmentDigests))
+	dataIndices := make([]int, 0, dataShards)
+	for i := 0; i < dataShards; i++ {
+		dataIndices = append(dataIndices, i)
+	}
+	if err := ba.fetchFragments(ctx, fragmentDigests, fragments, dataIndices); err != nil {
+		parityIndices := make([]int, 0, len(fragmentDigests)-dataShards)
+		for i := dataShards; i < len(fragmentDigests); i++ {
+			parityIndices = append(parityIndices, i)
+		}
+		ba.fetchFragments(ctx, fragmentDigests, fragments, parityIndices)
+		present := 0
+		for _, fragment := range fragments {
+			if fragment != nil {
+				present++
+			}
+		}
+		if present < dataShards {
+			return nil, util.StatusWrapf(err, "Only %d of %d required fragments could be loaded", present, dataShards)
+		}
+
+		// Data fragments at the end of the stripe may be
+		// smaller than the others. Pad them with zeros, as that
+		// is how they were encoded.
+		fragmentSizeBytes := int(fragmentDigests[0].GetSizeBytes())
+		for i, fragment := range fragments {
+			if fragment != nil && len(fragment) < fragmentSizeBytes {
+				padded := make([]byte, fragmentSizeBytes)
+				copy(padded, fragment)
+				fragments[i] = padded
+			}
+		}
+		if err := ba.code.reconstructData(fragments); err != nil {
+			return nil, err
+		}
+	}
+
+	data := make([]byte, 0, int(fragmentDigests[0].GetSizeBytes())*dataShards)
+	for i, fragment := range fragments[:dataShards] {
+		data = append(data, fragment[:fragmentDigests[i].GetSizeBytes()]...)
+	}
+	return data, nil
+}
+
+func (ba *stripingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if !ba.isStriped(blobDigest) {
+		return ba.backend.Put(ctx, blobDigest, b)
+	}
+
+	r := b.ToReader()
+	defer r.Close()
+
+	// Process the object one stripe at a time, so that memory usage
+	// is bounded by the stripe size.
+	dataShards := ba.code.dataShards
+	var outputFiles []*remoteexecution.OutputFile
+	for remaining := blobDigest.GetSizeBytes(); remaining > 0; {
+		stripeSizeBytes := ba.stripeSizeBytes
+		if stripeSizeBytes > remaining {
+			stripeSizeBytes = remaining
+		}
+		remaining -= stripeSizeBytes
+		data := make([]byte, stripeSizeBytes)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+
+		// Split the stripe into equally sized data fragments,
+		// only padding for the purpose of computing parity.
+		fragmentSizeBytes := (stripeSizeBytes + int64(dataShards) - 1) / int64(dataShards)
+		fragments := make([][]byte, 0, len(ba.shards))
+		paddedFragments := make([][]byte, 0, dataShards)
+		for i := int64(0); i < int64(dataShards); i++ {
+			start, end := i*fragmentSizeBytes, (i+1)*fragmentSizeBytes
+			if start > stripeSizeBytes {
+				start = stripeSizeBytes
+			}
+			if end > stripeSizeBytes {
+				end = stripeSizeBytes
+			}
+			fragment := data[start:end]
+			fragments = append(fragments, fragment)
+			if int64(len(fragment)) < fragmentSizeBytes {
+				padded := make([]byte, fragmentSizeBytes)
+				copy(padded, fragment)
+				fragment = padded
+			}
+			paddedFragments = append(paddedFragments, fragment)
+		}
+		fragments = append(fragments, ba.code.encode(paddedFragments)...)
+
+		stripeIndex := len(outputFiles) / len(ba.shards)
+		fragmentDigests := make([]digest.Digest, 0, len(fragments))
+		for i, fragment := range fragments {
+			generator := blobDigest.NewGenerator()
+			generator.Write(fragment)
+			fragmentDigest := generator.Sum()
+			fragmentDigests = append(fragmentDigests, fragmentDigest)
+			outputFiles = append(outputFiles, &remoteexecution.OutputFile{
+				Path:   fmt.Sprintf("%d/%d", stripeIndex, i),
+				Digest: fragmentDigest.GetPartialDigest(),
+			})
+		}
+		if err := ba.putFragments(ctx, fragmentDigests, fragments); err != nil {
+			return util.StatusWrapf(err, "Failed to store stripe %d", stripeIndex)
+		}
+	}
+
+	// Only store the manifest after all fragments have been stored,
+	// so that incomplete objects never become visible.
+	if err := ba.manifests.Put(ctx, blobDigest.WithoutInstance(), buffer.NewACBufferFromActionResult(
+		&remoteexecution.ActionResult{OutputFiles: outputFiles},
+		buffer.UserProvided)); err != nil {
+		return util.StatusWrap(err, "Failed to store manifest")
+	}
+	return nil
+}
+
+// putFragments stores all fragments of a stripe in their shards in
+// parallel. Writes are not permitted to succeed partially, as that
+// would reduce the number of shard failures that can be tolerated.
+func (ba *stripingBlobAccess) putFragments(ctx context.Context, fragmentDigests []digest.Digest, fragments [][]byte) error {
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	for i, fragment := range fragments {
+		if len(fragment) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, fragment []byte) {
+			defer wg.Done()
+			if err := ba.shards[i].Put(ctxWithCancel, fragmentDigests[i], buffer.NewValidatedBufferFromByteSlice(fragment)); err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = util.StatusWrapf(err, "Fragment %d", i)
+					cancel()
+				}
+				errLock.Unlock()
+			}
+		}(i, fragment)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (ba *stripingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Striped objects are only present if their manifest is present,
+	// and enough fragments of every stripe are present to
+	// reconstruct it.
+	backendDigests := digest.NewSetBuilder()
+	shardDigests := make([]digest.SetBuilder, len(ba.shards))
+	for i := range shardDigests {
+		shardDigests[i] = digest.NewSetBuilder()
+	}
+	var stripedDigests []digest.Digest
+	for _, blobDigest := range digests.Items() {
+		if ba.isStriped(blobDigest) {
+			stripedDigests = append(stripedDigests, blobDigest)
+		} else {
+			backendDigests.Add(blobDigest)
+		}
+	}
+
+	// Load the manifests in parallel, as every manifest requires a
+	// separate round trip.
+	stripesList := make([][][]digest.Digest, len(stripedDigests))
+	errs := make([]error, len(stripedDigests))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maximumConcurrentManifestLookups)
+	for i, blobDigest := range stripedDigests {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, blobDigest digest.Digest) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			stripesList[i], errs[i] = ba.getStripes(ctx, blobDigest)
+		}(i, blobDigest)
+	}
+	wg.Wait()
+
+	stripesPerObject := map[digest.Digest][][]digest.Digest{}
+	for i, blobDigest := range stripedDigests {
+		if err := errs[i]; err == nil {
+			for _, fragmentDigests := range stripesList[i] {
+				for j, fragmentDigest := range fragmentDigests {
+					if fragmentDigest.GetSizeBytes() > 0 {
+						shardDigests[j].Add(fragmentDigest)
+					}
+				}
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return digest.EmptySet, util.StatusWrapf(err, "Object %s", blobDigest)
+		}
+		stripesPerObject[blobDigest] = stripesList[i]
+	}
+
+	missing := digest.NewSetBuilder()
+	if backendDigestsSet := backendDigests.Build(); !backendDigestsSet.Empty() {
+		missingInBackend, err := ba.backend.FindMissing(ctx, backendDigestsSet)
+		if err != nil {
+			return digest.EmptySet, err
+		}
+		for _, blobDigest := range missingInBackend.Items() {
+			missing.Add(blobDigest)
+		}
+	}
+
+	// Shards that are unavailable are treated as if none of their
+	// fragments are present.
+	missingInShards := make([]map[digest.Digest]bool, len(ba.shards))
+	for i, shard := range ba.shards {
+		shardMissing := map[digest.Digest]bool{}
+		if shardDigestsSet := shardDigests[i].Build(); !shardDigestsSet.Empty() {
+			missingInShard, err := shard.FindMissing(ctx, shardDigestsSet)
+			if err != nil {
+				missingInShard = shardDigestsSet
+			}
+			for _, fragmentDigest := range missingInShard.Items() {
+				shardMissing[fragmentDigest] = true
+			}
+		}
+		missingInShards[i] = shardMissing
+	}
+
+	for blobDigest, stripes := range stripesPerObject {
+		if stripes == nil {
+			// Manifest is absent.
+			missing.Add(blobDigest)
+			continue
+		}
+		for _, fragmentDigests := range stripes {
+			present := 0
+			for i, fragmentDigest := range fragmentDigests {
+				if !missingInShards[i][fragmentDigest] {
+					present++
+				}
+			}
+			if present < ba.code.dataShards {
+				missing.Add(blobDigest)
+				break
+			}
+		}
+	}
+	return missing.Build(), nil
+}
+
+// stripeReader is a ChunkReader that returns the contents of a
+// sequence of stripes stored in the shards.
+type stripeReader struct {
+	ctx        context.Context
+	blobAccess *stripingBlobAccess
+	stripes    [][]digest.Digest
+
+	stripeIndex int
+}
+
+func (r *stripeReader) Read() ([]byte, error) {
+	if r.stripeIndex >= len(r.stripes) {
+		return nil, io.EOF
+	}
+	data, err := r.blobAccess.getStripe(r.ctx, r.stripes[r.stripeIndex])
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to read stripe %d", r.stripeIndex)
+	}
+	r.stripeIndex++
+	return data, nil
+}
+
+func (r *stripeReader) Close() {}
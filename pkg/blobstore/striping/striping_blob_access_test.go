@@ -0,0 +1,202 @@
+package striping_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/striping"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeShard is a simple in-memory storage backend that can be marked
+// unavailable, used to simulate shard outages.
+type fakeShard struct {
+	lock        sync.Mutex
+	objects     map[digest.Digest][]byte
+	unavailable bool
+}
+
+func newFakeShard(ctrl *gomock.Controller) (*fakeShard, blobstore.BlobAccess) {
+	s := &fakeShard{objects: map[digest.Digest][]byte{}}
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if s.unavailable {
+				return buffer.NewBufferFromError(status.Error(codes.Unavailable, "Shard is offline"))
+			}
+			data, ok := s.objects[blobDigest]
+			if !ok {
+				return buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))
+			}
+			return buffer.NewValidatedBufferFromByteSlice(data)
+		}).AnyTimes()
+	blobAccess.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(10000)
+			if err != nil {
+				return err
+			}
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if s.unavailable {
+				return status.Error(codes.Unavailable, "Shard is offline")
+			}
+			s.objects[blobDigest] = data
+			return nil
+		}).AnyTimes()
+	blobAccess.EXPECT().FindMissing(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if s.unavailable {
+				return digest.EmptySet, status.Error(codes.Unavailable, "Shard is offline")
+			}
+			missing := digest.NewSetBuilder()
+			for _, blobDigest := range digests.Items() {
+				if _, ok := s.objects[blobDigest]; !ok {
+					missing.Add(blobDigest)
+				}
+			}
+			return missing.Build(), nil
+		}).AnyTimes()
+	return s, blobAccess
+}
+
+func (s *fakeShard) setUnavailable(unavailable bool) {
+	s.lock.Lock()
+	s.unavailable = unavailable
+	s.lock.Unlock()
+}
+
+func TestStripingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	backend := mock.NewMockBlobAccess(ctrl)
+	manifestsShard, manifests := newFakeShard(ctrl)
+	var fakeShards []*fakeShard
+	var shards []blobstore.BlobAccess
+	for i := 0; i < 5; i++ {
+		fakeShard, shard := newFakeShard(ctrl)
+		fakeShards = append(fakeShards, fakeShard)
+		shards = append(shards, shard)
+	}
+	blobAccess, err := striping.NewStripingBlobAccess(backend, manifests, shards, 2, 10, 8, 10000)
+	require.NoError(t, err)
+
+	smallDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	largeDigest := digest.MustNewDigest("instance", "41038b940ea14434f69a3cdb02d9d4f8", 21)
+	largeData := []byte("Hello world, striped!")
+
+	t.Run("SmallPut", func(t *testing.T) {
+		// Small objects should be forwarded as is.
+		backend.EXPECT().Put(ctx, smallDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, smallDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("LargeGetMissing", func(t *testing.T) {
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("LargePutShardFailure", func(t *testing.T) {
+		// No manifest should be written if storing a fragment
+		// fails, so that the object does not become visible.
+		fakeShards[4].setUnavailable(true)
+		err := blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice(largeData))
+		require.Equal(t, codes.Unavailable, status.Code(err))
+		fakeShards[4].setUnavailable(false)
+		require.Empty(t, manifestsShard.objects)
+	})
+
+	t.Run("LargePut", func(t *testing.T) {
+		require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice(largeData)))
+
+		// Every fragment should have been stored in its own
+		// shard. The data fragments should contain the original
+		// data, split up into stripes of 8 bytes.
+		// The manifest should be keyed without the instance
+		// name, as the CAS is not partitioned by instance name.
+		require.Len(t, manifestsShard.objects, 1)
+		require.Contains(t, manifestsShard.objects, largeDigest.WithoutInstance())
+		var dataFragments []string
+		for _, fakeShard := range fakeShards[:3] {
+			for _, data := range fakeShard.objects {
+				dataFragments = append(dataFragments, string(data))
+			}
+		}
+		require.ElementsMatch(t, []string{"Hel", "lo ", "wo", "rld", ", s", "tr", "ip", "ed", "!"}, dataFragments)
+		for _, fakeShard := range fakeShards[3:] {
+			require.Len(t, fakeShard.objects, 3)
+		}
+	})
+
+	t.Run("LargeGet", func(t *testing.T) {
+		data, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, largeData, data)
+	})
+
+	t.Run("LargeGetReconstructed", func(t *testing.T) {
+		// Up to two shards may be unavailable, regardless of
+		// whether they hold data or parity fragments.
+		for _, unavailable := range [][]int{{0}, {1, 2}, {0, 4}, {2, 3}} {
+			for _, i := range unavailable {
+				fakeShards[i].setUnavailable(true)
+			}
+			data, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, largeData, data)
+
+			missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(largeDigest).Build())
+			require.NoError(t, err)
+			require.Equal(t, digest.EmptySet, missing)
+
+			for _, i := range unavailable {
+				fakeShards[i].setUnavailable(false)
+			}
+		}
+	})
+
+	t.Run("LargeGetTooManyFailures", func(t *testing.T) {
+		for _, i := range []int{0, 1, 4} {
+			fakeShards[i].setUnavailable(true)
+		}
+		defer func() {
+			for _, i := range []int{0, 1, 4} {
+				fakeShards[i].setUnavailable(false)
+			}
+		}()
+
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(largeDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(largeDigest).Build(), missing)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		otherDigest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+		backend.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(smallDigest).Build()).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(smallDigest).Add(largeDigest).Add(otherDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(otherDigest).Build(), missing)
+	})
+}
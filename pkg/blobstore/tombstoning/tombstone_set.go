@@ -0,0 +1,185 @@
+package tombstoning
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TombstoneSet is a set of digests of Actions whose entries in the
+// Action Cache have been invalidated. The set is stored in a file, so
+// that tombstones remain in effect after restarts.
+//
+// The file contains one digest per line, in the form of
+// "${hash}-${size_bytes}-${instance_name}". As tombstones are only
+// added manually, the set is expected to be small. The file is
+// therefore rewritten in its entirety upon every change.
+type TombstoneSet struct {
+	path string
+
+	// Serializes changes to the set, so that the file can be
+	// written without blocking lookups.
+	saveLock sync.Mutex
+
+	lock       sync.RWMutex
+	tombstones map[digest.Digest]struct{}
+}
+
+// NewTombstoneSetFromFile creates a TombstoneSet that is backed by a
+// file. Tombstones already present in the file are loaded. The file is
+// created upon the first change if it does not exist.
+func NewTombstoneSetFromFile(path string) (*TombstoneSet, error) {
+	ts := &TombstoneSet{
+		path:       path,
+		tombstones: map[digest.Digest]struct{}{},
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ts, nil
+	} else if err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to open tombstone file %#v", path)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		blobDigest, err := parseDigestKey(line)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid tombstone on line %d of %#v", lineNumber, path)
+		}
+		ts.tombstones[blobDigest] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to read tombstone file %#v", path)
+	}
+	return ts, nil
+}
+
+// parseDigestKey converts a digest key obtained through
+// Digest.GetKey(digest.KeyWithInstance) back to a Digest.
+func parseDigestKey(key string) (digest.Digest, error) {
+	fields := strings.SplitN(key, "-", 3)
+	if len(fields) != 3 {
+		return digest.BadDigest, status.Error(codes.InvalidArgument, "Digest must have the form ${hash}-${size_bytes}-${instance_name}")
+	}
+	sizeBytes, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid digest size: %s", err)
+	}
+	return digest.NewDigest(fields[2], fields[0], sizeBytes)
+}
+
+// Contains returns whether a tombstone exists for a given Action.
+func (ts *TombstoneSet) Contains(actionDigest digest.Digest) bool {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+	_, ok := ts.tombstones[actionDigest]
+	return ok
+}
+
+// Add a tombstone for a given Action.
+func (ts *TombstoneSet) Add(actionDigest digest.Digest) error {
+	if ts.Contains(actionDigest) {
+		return nil
+	}
+
+	ts.saveLock.Lock()
+	defer ts.saveLock.Unlock()
+
+	ts.lock.Lock()
+	if _, ok := ts.tombstones[actionDigest]; ok {
+		ts.lock.Unlock()
+		return nil
+	}
+	ts.tombstones[actionDigest] = struct{}{}
+	digests := ts.getSortedDigests()
+	ts.lock.Unlock()
+
+	if err := ts.save(digests); err != nil {
+		ts.lock.Lock()
+		delete(ts.tombstones, actionDigest)
+		ts.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Remove the tombstone for a given Action, if any. As this function is
+// called for every ActionResult that is written, it only acquires an
+// exclusive lock if a tombstone for the Action exists.
+func (ts *TombstoneSet) Remove(actionDigest digest.Digest) error {
+	if !ts.Contains(actionDigest) {
+		return nil
+	}
+
+	ts.saveLock.Lock()
+	defer ts.saveLock.Unlock()
+
+	ts.lock.Lock()
+	if _, ok := ts.tombstones[actionDigest]; !ok {
+		ts.lock.Unlock()
+		return nil
+	}
+	delete(ts.tombstones, actionDigest)
+	digests := ts.getSortedDigests()
+	ts.lock.Unlock()
+
+	if err := ts.save(digests); err != nil {
+		ts.lock.Lock()
+		ts.tombstones[actionDigest] = struct{}{}
+		ts.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// List all tombstones, sorted by digest.
+func (ts *TombstoneSet) List() []digest.Digest {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+	return ts.getSortedDigests()
+}
+
+func (ts *TombstoneSet) getSortedDigests() []digest.Digest {
+	digests := make([]digest.Digest, 0, len(ts.tombstones))
+	for blobDigest := range ts.tombstones {
+		digests = append(digests, blobDigest)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i].GetKey(digest.KeyWithInstance) < digests[j].GetKey(digest.KeyWithInstance)
+	})
+	return digests
+}
+
+// save atomically replaces the contents of the tombstone file, so that
+// it is never left in a partially written state. This function must be
+// called with saveLock held.
+func (ts *TombstoneSet) save(digests []digest.Digest) error {
+	var sb strings.Builder
+	for _, blobDigest := range digests {
+		sb.WriteString(blobDigest.GetKey(digest.KeyWithInstance))
+		sb.WriteByte('\n')
+	}
+	temporaryPath := ts.path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, []byte(sb.String()), 0644); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write tombstone file")
+	}
+	if err := os.Rename(temporaryPath, ts.path); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to replace tombstone file")
+	}
+	return nil
+}
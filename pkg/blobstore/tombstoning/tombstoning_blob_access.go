@@ -0,0 +1,67 @@
+package tombstoning
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type tombstoningBlobAccess struct {
+	blobstore.BlobAccess
+	tombstones *TombstoneSet
+}
+
+// NewTombstoningBlobAccess creates a decorator for the Action Cache
+// that hides entries for which a tombstone exists. This can be used to
+// invalidate ActionResults that are known to be bad (e.g., because
+// they were produced by a non-deterministic action), even if the
+// backend does not support removing individual objects.
+//
+// Writing a new ActionResult through this decorator removes the
+// tombstone, so that results of subsequent executions of the action
+// become visible.
+func NewTombstoningBlobAccess(base blobstore.BlobAccess, tombstones *TombstoneSet) blobstore.BlobAccess {
+	return &tombstoningBlobAccess{
+		BlobAccess: base,
+		tombstones: tombstones,
+	}
+}
+
+func (ba *tombstoningBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if ba.tombstones.Contains(blobDigest) {
+		return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Object %s has been tombstoned", blobDigest))
+	}
+	return ba.BlobAccess.Get(ctx, blobDigest)
+}
+
+func (ba *tombstoningBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, blobDigest, b); err != nil {
+		return err
+	}
+	if err := ba.tombstones.Remove(blobDigest); err != nil {
+		return util.StatusWrapf(err, "Failed to remove tombstone for object %s", blobDigest)
+	}
+	return nil
+}
+
+func (ba *tombstoningBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, err := ba.BlobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+
+	// Announce tombstoned objects as missing.
+	tombstoned := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if ba.tombstones.Contains(blobDigest) {
+			tombstoned.Add(blobDigest)
+		}
+	}
+	return digest.GetUnion([]digest.Set{missing, tombstoned.Build()}), nil
+}
@@ -0,0 +1,89 @@
+package tombstoning_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/tombstoning"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTombstoningBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	directory, err := ioutil.TempDir("", "tombstoning")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "tombstones")
+
+	tombstones, err := tombstoning.NewTombstoneSetFromFile(path)
+	require.NoError(t, err)
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := tombstoning.NewTombstoningBlobAccess(baseBlobAccess, tombstones)
+
+	poisonedDigest := digest.MustNewDigest("my-instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+	healthyDigest := digest.MustNewDigest("my-instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+	require.NoError(t, tombstones.Add(poisonedDigest))
+
+	t.Run("Get", func(t *testing.T) {
+		// Tombstoned objects should not be requested from the
+		// backend.
+		_, err := blobAccess.Get(ctx, poisonedDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object acbd18db4cc2f85cedef654fccc4a4d8-3-my-instance has been tombstoned"), err)
+
+		baseBlobAccess.EXPECT().Get(ctx, healthyDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("bar")))
+		data, err := blobAccess.Get(ctx, healthyDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("bar"), data)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		digests := digest.NewSetBuilder().Add(poisonedDigest).Add(healthyDigest).Build()
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(poisonedDigest).Build(), missing)
+	})
+
+	t.Run("Persistence", func(t *testing.T) {
+		// Tombstones should be restored after a restart.
+		reloaded, err := tombstoning.NewTombstoneSetFromFile(path)
+		require.NoError(t, err)
+		require.Equal(t, []digest.Digest{poisonedDigest}, reloaded.List())
+	})
+
+	t.Run("PutRemovesTombstone", func(t *testing.T) {
+		// Failing writes should leave the tombstone in place.
+		baseBlobAccess.EXPECT().Put(ctx, poisonedDigest, gomock.Any()).Return(status.Error(codes.Unavailable, "Server offline"))
+		require.Equal(t, status.Error(codes.Unavailable, "Server offline"), blobAccess.Put(ctx, poisonedDigest, buffer.NewValidatedBufferFromByteSlice([]byte("foo"))))
+		require.True(t, tombstones.Contains(poisonedDigest))
+
+		// Successful writes should make the object visible
+		// again, also after a restart.
+		baseBlobAccess.EXPECT().Put(ctx, poisonedDigest, gomock.Any()).Return(nil)
+		require.NoError(t, blobAccess.Put(ctx, poisonedDigest, buffer.NewValidatedBufferFromByteSlice([]byte("foo"))))
+		require.False(t, tombstones.Contains(poisonedDigest))
+
+		reloaded, err := tombstoning.NewTombstoneSetFromFile(path)
+		require.NoError(t, err)
+		require.Empty(t, reloaded.List())
+	})
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		require.NoError(t, ioutil.WriteFile(path, []byte("acbd18db4cc2f85cedef654fccc4a4d8-3-my-instance\nhello\n"), 0644))
+		_, err := tombstoning.NewTombstoneSetFromFile(path)
+		require.Equal(t, status.Errorf(codes.InvalidArgument, "Invalid tombstone on line 2 of %#v: Digest must have the form ${hash}-${size_bytes}-${instance_name}", path), err)
+	})
+}
@@ -0,0 +1,96 @@
+package blobstore
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	writeOnceBlobAccessPrometheusMetrics sync.Once
+
+	writeOnceBlobAccessDivergentWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "write_once_blob_access_divergent_writes_total",
+			Help:      "Number of Put() calls for which an object with different contents was already stored.",
+		},
+		[]string{"name"})
+)
+
+type writeOnceBlobAccess struct {
+	BlobAccess
+	maximumMessageSizeBytes int
+	rejectDivergentWrites   bool
+
+	divergentWrites prometheus.Counter
+}
+
+// NewWriteOnceBlobAccess creates a decorator for an Action Cache that
+// detects Put() calls for keys for which a different ActionResult is
+// already stored. For hermetic actions, every execution should yield
+// the same ActionResult. Divergence thus indicates that an action is
+// non-deterministic, or that workers are configured inconsistently.
+//
+// ExecutionMetadata is ignored when comparing ActionResults, as it
+// contains timestamps and worker names that differ between
+// executions, even for hermetic actions.
+//
+// Divergent writes are either rejected with FAILED_PRECONDITION, or
+// logged and allowed to overwrite the existing ActionResult. Both
+// cases are counted in a Prometheus metric.
+func NewWriteOnceBlobAccess(base BlobAccess, maximumMessageSizeBytes int, rejectDivergentWrites bool, name string) BlobAccess {
+	writeOnceBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(writeOnceBlobAccessDivergentWrites)
+	})
+
+	return &writeOnceBlobAccess{
+		BlobAccess:              base,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		rejectDivergentWrites:   rejectDivergentWrites,
+
+		divergentWrites: writeOnceBlobAccessDivergentWrites.WithLabelValues(name),
+	}
+}
+
+// stripExecutionMetadata returns a copy of an ActionResult that only
+// contains the fields that are expected to be identical across
+// executions of the same hermetic action.
+func stripExecutionMetadata(actionResult *remoteexecution.ActionResult) *remoteexecution.ActionResult {
+	stripped := proto.Clone(actionResult).(*remoteexecution.ActionResult)
+	stripped.ExecutionMetadata = nil
+	return stripped
+}
+
+func (ba *writeOnceBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	newActionResult, err := b.ToActionResult(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	existingActionResult, err := ba.BlobAccess.Get(ctx, blobDigest).ToActionResult(ba.maximumMessageSizeBytes)
+	if err == nil {
+		if !proto.Equal(stripExecutionMetadata(existingActionResult), stripExecutionMetadata(newActionResult)) {
+			ba.divergentWrites.Inc()
+			if ba.rejectDivergentWrites {
+				return status.Errorf(codes.FailedPrecondition, "A different action result for action %s is already stored", blobDigest)
+			}
+			log.Printf("Action result for action %s diverges from the one already stored%s", blobDigest, util.DescribeRequestMetadata(ctx))
+		}
+	} else if status.Code(err) != codes.NotFound {
+		return util.StatusWrap(err, "Failed to obtain existing action result")
+	}
+
+	return ba.BlobAccess.Put(ctx, blobDigest, buffer.NewACBufferFromActionResult(newActionResult, buffer.Irreparable))
+}
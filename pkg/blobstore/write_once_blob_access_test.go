@@ -0,0 +1,88 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteOnceBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewWriteOnceBlobAccess(baseBlobAccess, 1000, true, "TestWriteOnceBlobAccessPut")
+
+	actionDigest := digest.MustNewDigest("instance", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	actionResult := &remoteexecution.ActionResult{
+		StdoutRaw: []byte("Hello"),
+		ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+			Worker: "worker1",
+		},
+	}
+
+	t.Run("GetFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Server on fire")))
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Failed to obtain existing action result: Server on fire"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable)))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Writes for keys that are absent should be forwarded.
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		baseBlobAccess.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				storedActionResult, err := b.ToActionResult(1000)
+				require.NoError(t, err)
+				require.Equal(t, actionResult, storedActionResult)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable)))
+	})
+
+	t.Run("Identical", func(t *testing.T) {
+		// Differences in execution metadata should be ignored.
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromActionResult(
+			&remoteexecution.ActionResult{
+				StdoutRaw: []byte("Hello"),
+				ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+					Worker: "worker2",
+				},
+			},
+			buffer.Irreparable))
+		baseBlobAccess.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable)))
+	})
+
+	t.Run("Divergent", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromActionResult(
+			&remoteexecution.ActionResult{
+				StdoutRaw: []byte("Goodbye"),
+			},
+			buffer.Irreparable))
+
+		require.Equal(
+			t,
+			status.Error(codes.FailedPrecondition, "A different action result for action d41d8cd98f00b204e9800998ecf8427e-123-instance is already stored"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable)))
+	})
+}
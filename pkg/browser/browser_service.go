@@ -0,0 +1,410 @@
+package browser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BrowserService implements a web UI for inspecting the contents of
+// the Content Addressable Storage and the Action Cache. It can render
+// Action, ActionResult, Command, Directory and Tree messages, and
+// display files (e.g., logs of build actions) in a paginated way.
+//
+// Unlike bb-browser, this service does not require any additional
+// processes to be run, as it fetches objects from the storage backends
+// of the process it is embedded in.
+type BrowserService struct {
+	contentAddressableStorage           cas.ContentAddressableStorage
+	contentAddressableStorageBlobAccess blobstore.BlobAccess
+	actionCache                         blobstore.BlobAccess
+	maximumMessageSizeBytes             int
+	pageSizeBytes                       int
+	templates                           map[string]*template.Template
+}
+
+// NewBrowserService creates a BrowserService and registers its
+// handlers on a router. Files in the Content Addressable Storage are
+// displayed in pages of a fixed size.
+func NewBrowserService(contentAddressableStorage cas.ContentAddressableStorage, contentAddressableStorageBlobAccess blobstore.BlobAccess, actionCache blobstore.BlobAccess, maximumMessageSizeBytes int, pageSizeBytes int, router *mux.Router) *BrowserService {
+	s := &BrowserService{
+		contentAddressableStorage:           contentAddressableStorage,
+		contentAddressableStorageBlobAccess: contentAddressableStorageBlobAccess,
+		actionCache:                         actionCache,
+		maximumMessageSizeBytes:             maximumMessageSizeBytes,
+		pageSizeBytes:                       pageSizeBytes,
+	}
+	s.templates = newTemplates(template.FuncMap{
+		"digest_url": getDigestURL,
+		"offset_url": func(p *filePage, offset int64) string {
+			return p.getOffsetURL(offset)
+		},
+		"subdirectory": func(p directoryPage, node *remoteexecution.DirectoryNode) string {
+			return p.getSubdirectoryURL(node)
+		},
+		"tree_url": func(p *treePage, path string) string {
+			return p.getPathURL(path)
+		},
+	})
+
+	const digestPattern = "{hash:[0-9a-f]+}-{sizeBytes:[0-9]+}/"
+	router.HandleFunc("/browser/", s.handleWelcome)
+	router.HandleFunc("/browser/action/"+digestPattern, s.handleAction)
+	router.HandleFunc("/browser/action_result/"+digestPattern, s.handleActionResult)
+	router.HandleFunc("/browser/command/"+digestPattern, s.handleCommand)
+	router.HandleFunc("/browser/directory/"+digestPattern, s.handleDirectory)
+	router.HandleFunc("/browser/file/"+digestPattern, s.handleFile)
+	router.HandleFunc("/browser/tree/"+digestPattern, s.handleTree)
+	return s
+}
+
+// getDigestURL returns the URL of the page that displays an object.
+func getDigestURL(kind string, instance string, blobDigest *remoteexecution.Digest) string {
+	if blobDigest == nil {
+		return ""
+	}
+	return fmt.Sprintf("/browser/%s/%s-%d/?instance=%s", kind, blobDigest.Hash, blobDigest.SizeBytes, url.QueryEscape(instance))
+}
+
+// getDigest extracts the digest of the object to display from the
+// request URL.
+func getDigest(req *http.Request) (digest.Digest, error) {
+	vars := mux.Vars(req)
+	sizeBytes, err := strconv.ParseInt(vars["sizeBytes"], 10, 64)
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid digest size: %s", err)
+	}
+	return digest.NewDigest(req.URL.Query().Get("instance"), vars["hash"], sizeBytes)
+}
+
+// renderError writes an error message, using an HTTP status code that
+// corresponds to the gRPC status code of the error.
+func renderError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func (s *BrowserService) renderPage(w http.ResponseWriter, name string, data interface{}) {
+	// Render into a buffer first, so that template errors don't
+	// lead to partially written pages.
+	var b bytes.Buffer
+	if err := s.templates[name].ExecuteTemplate(&b, "layout", data); err != nil {
+		log.Printf("Failed to render page %#v: %s", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+func (s *BrowserService) handleWelcome(w http.ResponseWriter, req *http.Request) {
+	s.renderPage(w, "welcome", nil)
+}
+
+type actionPage struct {
+	Instance          string
+	Digest            digest.Digest
+	Action            *remoteexecution.Action
+	Command           *remoteexecution.Command
+	ActionResult      *remoteexecution.ActionResult
+	ActionResultError string
+}
+
+func (s *BrowserService) handleAction(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	actionDigest, err := getDigest(req)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	action, err := s.contentAddressableStorage.GetAction(ctx, actionDigest)
+	if err != nil {
+		renderError(w, util.StatusWrap(err, "Failed to obtain Action"))
+		return
+	}
+	page := actionPage{
+		Instance: actionDigest.GetInstance(),
+		Digest:   actionDigest,
+		Action:   action,
+	}
+
+	// The Command and ActionResult are displayed on a best-effort
+	// basis, as they may have been evicted.
+	if commandDigest, err := actionDigest.NewDerivedDigest(action.CommandDigest); err == nil {
+		page.Command, _ = s.contentAddressableStorage.GetCommand(ctx, commandDigest)
+	}
+	page.ActionResult, err = s.getActionResult(ctx, actionDigest)
+	if status.Code(err) == codes.NotFound {
+		page.ActionResultError = "No result is present in the Action Cache"
+	} else if err != nil {
+		page.ActionResultError = err.Error()
+	}
+	s.renderPage(w, "action", &page)
+}
+
+func (s *BrowserService) getActionResult(ctx context.Context, actionDigest digest.Digest) (*remoteexecution.ActionResult, error) {
+	return s.actionCache.Get(ctx, actionDigest).ToActionResult(s.maximumMessageSizeBytes)
+}
+
+type actionResultPage struct {
+	Instance     string
+	Digest       digest.Digest
+	ActionResult *remoteexecution.ActionResult
+}
+
+func (s *BrowserService) handleActionResult(w http.ResponseWriter, req *http.Request) {
+	actionDigest, err := getDigest(req)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	actionResult, err := s.getActionResult(req.Context(), actionDigest)
+	if err != nil {
+		renderError(w, util.StatusWrap(err, "Failed to obtain ActionResult"))
+		return
+	}
+	s.renderPage(w, "action_result", &actionResultPage{
+		Instance:     actionDigest.GetInstance(),
+		Digest:       actionDigest,
+		ActionResult: actionResult,
+	})
+}
+
+type commandPage struct {
+	Instance string
+	Digest   digest.Digest
+	Command  *remoteexecution.Command
+}
+
+func (s *BrowserService) handleCommand(w http.ResponseWriter, req *http.Request) {
+	commandDigest, err := getDigest(req)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	command, err := s.contentAddressableStorage.GetCommand(req.Context(), commandDigest)
+	if err != nil {
+		renderError(w, util.StatusWrap(err, "Failed to obtain Command"))
+		return
+	}
+	s.renderPage(w, "command", &commandPage{
+		Instance: commandDigest.GetInstance(),
+		Digest:   commandDigest,
+		Command:  command,
+	})
+}
+
+// directoryPage is implemented by the data of pages that render a
+// Directory message, providing the targets of links to
+// subdirectories.
+type directoryPage interface {
+	getSubdirectoryURL(node *remoteexecution.DirectoryNode) string
+}
+
+type standaloneDirectoryPage struct {
+	Instance  string
+	Digest    digest.Digest
+	Directory *remoteexecution.Directory
+}
+
+func (p *standaloneDirectoryPage) getSubdirectoryURL(node *remoteexecution.DirectoryNode) string {
+	return getDigestURL("directory", p.Instance, node.Digest)
+}
+
+func (s *BrowserService) handleDirectory(w http.ResponseWriter, req *http.Request) {
+	directoryDigest, err := getDigest(req)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	directory, err := s.contentAddressableStorage.GetDirectory(req.Context(), directoryDigest)
+	if err != nil {
+		renderError(w, util.StatusWrap(err, "Failed to obtain Directory"))
+		return
+	}
+	s.renderPage(w, "directory", &standaloneDirectoryPage{
+		Instance:  directoryDigest.GetInstance(),
+		Digest:    directoryDigest,
+		Directory: directory,
+	})
+}
+
+type treePage struct {
+	Instance  string
+	Digest    digest.Digest
+	Path      string
+	Directory *remoteexecution.Directory
+}
+
+func (p *treePage) getPathURL(path string) string {
+	u := getDigestURL("tree", p.Instance, p.Digest.GetPartialDigest())
+	if path != "" {
+		u += "&path=" + url.QueryEscape(path)
+	}
+	return u
+}
+
+func (p *treePage) getSubdirectoryURL(node *remoteexecution.DirectoryNode) string {
+	return p.getPathURL(strings.TrimPrefix(p.Path+"/"+node.Name, "/"))
+}
+
+func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
+	treeDigest, err := getDigest(req)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	tree, err := s.contentAddressableStorage.GetTree(req.Context(), treeDigest)
+	if err != nil {
+		renderError(w, util.StatusWrap(err, "Failed to obtain Tree"))
+		return
+	}
+
+	// Index all children by digest, so that the directory
+	// corresponding to the requested path can be looked up.
+	children := map[digest.Digest]*remoteexecution.Directory{}
+	for _, child := range tree.Children {
+		data, err := proto.Marshal(child)
+		if err != nil {
+			renderError(w, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to marshal child directory"))
+			return
+		}
+		generator := treeDigest.NewGenerator()
+		if _, err := generator.Write(data); err != nil {
+			panic(err)
+		}
+		children[generator.Sum()] = child
+	}
+
+	directory := tree.Root
+	path := req.URL.Query().Get("path")
+	if path != "" {
+		for _, component := range strings.Split(path, "/") {
+			var childDigest *remoteexecution.Digest
+			for _, node := range directory.Directories {
+				if node.Name == component {
+					childDigest = node.Digest
+					break
+				}
+			}
+			if childDigest == nil {
+				renderError(w, status.Errorf(codes.NotFound, "Directory %#v not found", path))
+				return
+			}
+			d, err := treeDigest.NewDerivedDigest(childDigest)
+			if err != nil {
+				renderError(w, err)
+				return
+			}
+			child, ok := children[d]
+			if !ok {
+				renderError(w, status.Errorf(codes.InvalidArgument, "Tree does not contain directory %#v", path))
+				return
+			}
+			directory = child
+		}
+	}
+	s.renderPage(w, "tree", &treePage{
+		Instance:  treeDigest.GetInstance(),
+		Digest:    treeDigest,
+		Path:      path,
+		Directory: directory,
+	})
+}
+
+type filePage struct {
+	Instance       string
+	Digest         digest.Digest
+	Offset         int64
+	EndOffset      int64
+	PreviousOffset int64
+	HasPrevious    bool
+	HasNext        bool
+	Contents       []byte
+}
+
+func (p *filePage) getOffsetURL(offset int64) string {
+	u := getDigestURL("file", p.Instance, p.Digest.GetPartialDigest())
+	if offset < 0 {
+		return u + "&format=raw"
+	}
+	return u + "&offset=" + strconv.FormatInt(offset, 10)
+}
+
+func (s *BrowserService) handleFile(w http.ResponseWriter, req *http.Request) {
+	fileDigest, err := getDigest(req)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	query := req.URL.Query()
+	b := s.contentAddressableStorageBlobAccess.Get(req.Context(), fileDigest)
+
+	// Serve the file as is when downloading.
+	if query.Get("format") == "raw" {
+		r := b.ToReader()
+		defer r.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(fileDigest.GetSizeBytes(), 10))
+		io.Copy(w, r)
+		return
+	}
+
+	// Display a single page of the file.
+	var offset int64
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset < 0 || offset > fileDigest.GetSizeBytes() {
+			b.Discard()
+			renderError(w, status.Errorf(codes.InvalidArgument, "Invalid offset %#v", offsetStr))
+			return
+		}
+	}
+	contents := make([]byte, s.pageSizeBytes)
+	n, err := b.ReadAt(contents, offset)
+	if err != nil && err != io.EOF {
+		renderError(w, util.StatusWrap(err, "Failed to read file"))
+		return
+	}
+	previousOffset := offset - int64(s.pageSizeBytes)
+	if previousOffset < 0 {
+		previousOffset = 0
+	}
+	endOffset := offset + int64(n)
+	s.renderPage(w, "file", &filePage{
+		Instance:       fileDigest.GetInstance(),
+		Digest:         fileDigest,
+		Offset:         offset,
+		EndOffset:      endOffset,
+		PreviousOffset: previousOffset,
+		HasPrevious:    offset > 0,
+		HasNext:        endOffset < fileDigest.GetSizeBytes(),
+		Contents:       contents[:n],
+	})
+}
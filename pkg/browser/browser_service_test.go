@@ -0,0 +1,155 @@
+package browser_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/browser"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBrowserService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	router := mux.NewRouter()
+	browser.NewBrowserService(
+		cas.NewBlobAccessContentAddressableStorage(contentAddressableStorage, nil, 1<<20),
+		contentAddressableStorage,
+		actionCache,
+		1<<20,
+		4,
+		router)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	actionDigest := digest.MustNewDigest("my/instance", "acbd18db4cc2f85cedef654fccc4a4d8", 3)
+
+	t.Run("ActionNotFound", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), actionDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		w := get("/browser/action/acbd18db4cc2f85cedef654fccc4a4d8-3/?instance=my%2Finstance")
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Equal(t, "rpc error: code = NotFound desc = Failed to obtain Action: Object not found\n", w.Body.String())
+	})
+
+	t.Run("ActionSuccess", func(t *testing.T) {
+		// Missing Commands should not prevent the Action and
+		// ActionResult from being displayed.
+		action, err := proto.Marshal(&remoteexecution.Action{
+			CommandDigest: &remoteexecution.Digest{
+				Hash:      "37b51d194a7513e45b56f6524f2d51f2",
+				SizeBytes: 3,
+			},
+			InputRootDigest: &remoteexecution.Digest{
+				Hash:      "73feffa4b7f6bb68e44cf984c85f6e88",
+				SizeBytes: 3,
+			},
+		})
+		require.NoError(t, err)
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), actionDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice(action))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), digest.MustNewDigest("my/instance", "37b51d194a7513e45b56f6524f2d51f2", 3)).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		actionCache.EXPECT().Get(gomock.Any(), actionDigest).
+			Return(buffer.NewACBufferFromActionResult(&remoteexecution.ActionResult{
+				ExitCode: 42,
+				StdoutDigest: &remoteexecution.Digest{
+					Hash:      "8b1a9953c4611296a827abf8c47804d7",
+					SizeBytes: 5,
+				},
+			}, buffer.Irreparable))
+
+		w := get("/browser/action/acbd18db4cc2f85cedef654fccc4a4d8-3/?instance=my%2Finstance")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "<tr><th>Exit code</th><td>42</td></tr>")
+		require.Contains(t, w.Body.String(), `href="/browser/directory/73feffa4b7f6bb68e44cf984c85f6e88-3/?instance=my%2Finstance"`)
+		require.Contains(t, w.Body.String(), `href="/browser/file/8b1a9953c4611296a827abf8c47804d7-5/?instance=my%2Finstance"`)
+	})
+
+	t.Run("FilePagination", func(t *testing.T) {
+		// The last page of a file should link to the previous
+		// page, but not to the next.
+		fileDigest := digest.MustNewDigest("my/instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), fileDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		w := get("/browser/file/8b1a9953c4611296a827abf8c47804d7-5/?instance=my%2Finstance&offset=4")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "<pre>o</pre>")
+		require.Contains(t, w.Body.String(), "Previous page")
+		require.NotContains(t, w.Body.String(), "Next page")
+	})
+
+	t.Run("FileInvalidOffset", func(t *testing.T) {
+		fileDigest := digest.MustNewDigest("my/instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), fileDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		w := get("/browser/file/8b1a9953c4611296a827abf8c47804d7-5/?instance=my%2Finstance&offset=6")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("TreeSubdirectory", func(t *testing.T) {
+		// Subdirectories of a Tree should be resolved by
+		// looking up the child with the matching digest.
+		child := &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{
+					Name: "hello.txt",
+					Digest: &remoteexecution.Digest{
+						Hash:      "8b1a9953c4611296a827abf8c47804d7",
+						SizeBytes: 5,
+					},
+				},
+			},
+		}
+		childData, err := proto.Marshal(child)
+		require.NoError(t, err)
+		generator := actionDigest.NewGenerator()
+		_, err = generator.Write(childData)
+		require.NoError(t, err)
+		tree, err := proto.Marshal(&remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{
+					{
+						Name:   "subdir",
+						Digest: generator.Sum().GetPartialDigest(),
+					},
+				},
+			},
+			Children: []*remoteexecution.Directory{child},
+		})
+		require.NoError(t, err)
+		treeDigest := digest.MustNewDigest("my/instance", "37b51d194a7513e45b56f6524f2d51f2", 3)
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), treeDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice(tree)).Times(2)
+
+		w := get("/browser/tree/37b51d194a7513e45b56f6524f2d51f2-3/?instance=my%2Finstance")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `href="/browser/tree/37b51d194a7513e45b56f6524f2d51f2-3/?instance=my%2Finstance&amp;path=subdir"`)
+
+		w = get("/browser/tree/37b51d194a7513e45b56f6524f2d51f2-3/?instance=my%2Finstance&path=subdir")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `href="/browser/file/8b1a9953c4611296a827abf8c47804d7-5/?instance=my%2Finstance">hello.txt</a>`)
+	})
+}
@@ -0,0 +1,157 @@
+package browser
+
+import (
+	"html/template"
+)
+
+// The layout shared by all pages. Every page provides a "title" and a
+// "content" template.
+const layoutTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{template "title" .}} - Buildbarn</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<p><a href="/browser/">Buildbarn storage browser</a></p>
+<h1>{{template "title" .}}</h1>
+{{template "content" .}}
+</body>
+</html>
+`
+
+// Rendering of ActionResult messages, shared by the Action and
+// ActionResult pages.
+const actionResultTemplate = `{{define "action_result"}}
+<table>
+<tr><th>Exit code</th><td>{{.ActionResult.ExitCode}}</td></tr>
+{{with .ActionResult.ExecutionMetadata}}<tr><th>Worker</th><td>{{.Worker}}</td></tr>{{end}}
+<tr><th>Standard output</th><td>{{if .ActionResult.StdoutDigest}}<a href="{{digest_url "file" .Instance .ActionResult.StdoutDigest}}">{{.ActionResult.StdoutDigest.Hash}}</a>{{else}}<pre>{{printf "%s" .ActionResult.StdoutRaw}}</pre>{{end}}</td></tr>
+<tr><th>Standard error</th><td>{{if .ActionResult.StderrDigest}}<a href="{{digest_url "file" .Instance .ActionResult.StderrDigest}}">{{.ActionResult.StderrDigest.Hash}}</a>{{else}}<pre>{{printf "%s" .ActionResult.StderrRaw}}</pre>{{end}}</td></tr>
+</table>
+{{if .ActionResult.OutputFiles}}
+<h3>Output files</h3>
+<table>
+{{range .ActionResult.OutputFiles}}<tr><td>{{if .IsExecutable}}executable{{end}}</td><td><a href="{{digest_url "file" $.Instance .Digest}}">{{.Path}}</a></td><td>{{.Digest.SizeBytes}} bytes</td></tr>
+{{end}}</table>
+{{end}}
+{{if .ActionResult.OutputDirectories}}
+<h3>Output directories</h3>
+<table>
+{{range .ActionResult.OutputDirectories}}<tr><td><a href="{{digest_url "tree" $.Instance .TreeDigest}}">{{.Path}}</a></td></tr>
+{{end}}</table>
+{{end}}
+{{if or .ActionResult.OutputFileSymlinks .ActionResult.OutputDirectorySymlinks}}
+<h3>Output symbolic links</h3>
+<table>
+{{range .ActionResult.OutputFileSymlinks}}<tr><td>{{.Path}}</td><td>{{.Target}}</td></tr>
+{{end}}{{range .ActionResult.OutputDirectorySymlinks}}<tr><td>{{.Path}}</td><td>{{.Target}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}`
+
+// Rendering of Directory messages, shared by the Directory and Tree
+// pages. Links to subdirectories are rendered using the "subdirectory"
+// function, as their targets differ between these pages.
+const directoryTemplate = `{{define "directory"}}
+<table>
+{{range .Directory.Directories}}<tr><td>directory</td><td><a href="{{subdirectory $ .}}">{{.Name}}/</a></td><td></td></tr>
+{{end}}{{range .Directory.Files}}<tr><td>{{if .IsExecutable}}executable{{else}}file{{end}}</td><td><a href="{{digest_url "file" $.Instance .Digest}}">{{.Name}}</a></td><td>{{.Digest.SizeBytes}} bytes</td></tr>
+{{end}}{{range .Directory.Symlinks}}<tr><td>symlink</td><td>{{.Name}}</td><td>{{.Target}}</td></tr>
+{{end}}</table>
+{{end}}`
+
+var pageTemplates = map[string]string{
+	"welcome": `{{define "title"}}Welcome{{end}}
+{{define "content"}}
+<p>This page allows you to inspect the contents of the Content Addressable Storage and the Action Cache. Objects can be viewed by visiting one of the following URLs:</p>
+<ul>
+<li><code>/browser/action/${hash}-${size_bytes}/?instance=${instance_name}</code></li>
+<li><code>/browser/action_result/${hash}-${size_bytes}/?instance=${instance_name}</code></li>
+<li><code>/browser/command/${hash}-${size_bytes}/?instance=${instance_name}</code></li>
+<li><code>/browser/directory/${hash}-${size_bytes}/?instance=${instance_name}</code></li>
+<li><code>/browser/file/${hash}-${size_bytes}/?instance=${instance_name}</code></li>
+<li><code>/browser/tree/${hash}-${size_bytes}/?instance=${instance_name}</code></li>
+</ul>
+{{end}}`,
+
+	"action": `{{define "title"}}Action {{.Digest.GetHashString}}{{end}}
+{{define "content"}}
+<table>
+<tr><th>Command</th><td><a href="{{digest_url "command" .Instance .Action.CommandDigest}}">{{.Action.CommandDigest.Hash}}</a></td></tr>
+<tr><th>Input root</th><td><a href="{{digest_url "directory" .Instance .Action.InputRootDigest}}">{{.Action.InputRootDigest.Hash}}</a></td></tr>
+{{with .Action.Timeout}}<tr><th>Timeout</th><td>{{.Seconds}} seconds</td></tr>{{end}}
+<tr><th>Do not cache</th><td>{{.Action.DoNotCache}}</td></tr>
+</table>
+{{if .Command}}
+<h2>Command</h2>
+{{template "command" .}}
+{{end}}
+<h2>Result</h2>
+{{if .ActionResult}}{{template "action_result" .}}{{else}}<p class="error">{{.ActionResultError}}</p>{{end}}
+{{end}}`,
+
+	"action_result": `{{define "title"}}Action result {{.Digest.GetHashString}}{{end}}
+{{define "content"}}
+<p><a href="{{digest_url "action" .Instance .Digest.GetPartialDigest}}">View Action</a></p>
+{{template "action_result" .}}
+{{end}}`,
+
+	"command": `{{define "title"}}Command {{.Digest.GetHashString}}{{end}}
+{{define "content"}}{{template "command" .}}{{end}}`,
+
+	"directory": `{{define "title"}}Directory {{.Digest.GetHashString}}{{end}}
+{{define "content"}}{{template "directory" .}}{{end}}`,
+
+	"tree": `{{define "title"}}Tree {{.Digest.GetHashString}}{{end}}
+{{define "content"}}
+<p>Path: <a href="{{tree_url $ ""}}">/</a>{{.Path}}</p>
+{{template "directory" .}}
+{{end}}`,
+
+	"file": `{{define "title"}}File {{.Digest.GetHashString}}{{end}}
+{{define "content"}}
+<p>Showing bytes {{.Offset}} to {{.EndOffset}} of {{.Digest.GetSizeBytes}}.
+{{if .HasPrevious}}<a href="{{offset_url $ .PreviousOffset}}">Previous page</a>{{end}}
+{{if .HasNext}}<a href="{{offset_url $ .EndOffset}}">Next page</a>{{end}}
+<a href="{{offset_url $ -1}}">Download</a></p>
+<pre>{{printf "%s" .Contents}}</pre>
+{{end}}`,
+}
+
+// Rendering of Command messages, shared by the Action and Command
+// pages.
+const commandTemplate = `{{define "command"}}
+<table>
+<tr><th>Arguments</th><td><pre>{{range .Command.Arguments}}{{.}}
+{{end}}</pre></td></tr>
+<tr><th>Environment variables</th><td><pre>{{range .Command.EnvironmentVariables}}{{.Name}}={{.Value}}
+{{end}}</pre></td></tr>
+<tr><th>Working directory</th><td>{{.Command.WorkingDirectory}}</td></tr>
+<tr><th>Output files</th><td>{{range .Command.OutputFiles}}{{.}}<br>{{end}}</td></tr>
+<tr><th>Output directories</th><td>{{range .Command.OutputDirectories}}{{.}}<br>{{end}}</td></tr>
+{{with .Command.Platform}}<tr><th>Platform</th><td>{{range .Properties}}{{.Name}}={{.Value}}<br>{{end}}</td></tr>{{end}}
+</table>
+{{end}}`
+
+// newTemplates parses the templates of all pages, each combined with
+// the shared layout.
+func newTemplates(funcs template.FuncMap) map[string]*template.Template {
+	base := template.Must(template.New("layout").Funcs(funcs).Parse(layoutTemplate))
+	template.Must(base.Parse(actionResultTemplate))
+	template.Must(base.Parse(commandTemplate))
+	template.Must(base.Parse(directoryTemplate))
+
+	templates := map[string]*template.Template{}
+	for name, page := range pageTemplates {
+		templates[name] = template.Must(template.Must(base.Clone()).Parse(page))
+	}
+	return templates
+}
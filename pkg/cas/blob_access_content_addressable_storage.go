@@ -12,20 +12,35 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	cas_proto "github.com/buildbarn/bb-storage/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+
+	"google.golang.org/grpc/codes"
 )
 
 type blobAccessContentAddressableStorage struct {
 	blobAccess              blobstore.BlobAccess
+	stagingDirectory        filesystem.Directory
 	maximumMessageSizeBytes int
 }
 
 // NewBlobAccessContentAddressableStorage creates a
 // ContentAddressableStorage that reads and writes Content Addressable
 // Storage (CAS) objects from a BlobAccess based store.
-func NewBlobAccessContentAddressableStorage(blobAccess blobstore.BlobAccess, maximumMessageSizeBytes int) ContentAddressableStorage {
+//
+// As the digest of a file needs to be known before it can be uploaded,
+// PutFile() copies files that do not fit in a single message into a
+// staging directory while computing their digest. The upload is then
+// performed from the staging directory, so that the original file is
+// only read once. This is beneficial if reading the original file is
+// expensive (e.g., because it is backed by a FUSE or network file
+// system). If no staging directory is provided, such files are read
+// twice instead.
+func NewBlobAccessContentAddressableStorage(blobAccess blobstore.BlobAccess, stagingDirectory filesystem.Directory, maximumMessageSizeBytes int) ContentAddressableStorage {
 	return &blobAccessContentAddressableStorage{
 		blobAccess:              blobAccess,
+		stagingDirectory:        stagingDirectory,
 		maximumMessageSizeBytes: maximumMessageSizeBytes,
 	}
 }
@@ -126,31 +141,149 @@ func (cas *blobAccessContentAddressableStorage) PutFile(ctx context.Context, dir
 		return digest.BadDigest, err
 	}
 
-	// Walk through the file to compute the digest.
+	// Walk through the file to compute the digest. Files that fit
+	// in a single message are retained in memory while doing so.
+	// Larger files are copied into the staging directory, so that
+	// they don't need to be read a second time.
 	digestGenerator := parentDigest.NewGenerator()
-	sizeBytes, err := io.Copy(digestGenerator, io.NewSectionReader(file, 0, math.MaxInt64))
+	staging := stagingWriter{
+		directory:        cas.stagingDirectory,
+		maximumSizeBytes: cas.maximumMessageSizeBytes,
+	}
+	sizeBytes, err := io.Copy(io.MultiWriter(digestGenerator, &staging), io.NewSectionReader(file, 0, math.MaxInt64))
 	if err != nil {
 		file.Close()
+		staging.discard()
 		return digest.BadDigest, err
 	}
 	blobDigest := digestGenerator.Sum()
+	progress := getProgressFuncFromContext(ctx)
 
-	// Rewind and store it. Limit uploading to the size that was
-	// used to compute the digest. This ensures uploads succeed,
-	// even if more data gets appended in the meantime. This is not
-	// uncommon, especially for stdout and stderr logs.
+	if !staging.overflowed {
+		if err := file.Close(); err != nil {
+			return digest.BadDigest, err
+		}
+		if err := cas.blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(staging.data)); err != nil {
+			return digest.BadDigest, err
+		}
+		if progress != nil {
+			progress(sizeBytes)
+		}
+		return blobDigest, nil
+	}
+
+	// The data is hashed once more while being uploaded, so that the
+	// upload fails at the end if the data was modified in the
+	// meantime, as opposed to storing an object that does not match
+	// its digest.
+	var r io.ReadCloser
+	if staging.file != nil {
+		if err := file.Close(); err != nil {
+			staging.discard()
+			return digest.BadDigest, err
+		}
+		r = &stagingFileReadCloser{
+			SectionReader: *io.NewSectionReader(staging.file, 0, sizeBytes),
+			staging:       &staging,
+		}
+	} else {
+		// No staging directory is available. Rewind and store
+		// the original file. Limit uploading to the size that
+		// was used to compute the digest. This ensures uploads
+		// succeed, even if more data gets appended in the
+		// meantime. This is not uncommon, especially for stdout
+		// and stderr logs.
+		r = newSectionReadCloser(file, 0, sizeBytes)
+	}
+	if progress != nil {
+		r = &progressReadCloser{ReadCloser: r, progress: progress}
+	}
 	if err := cas.blobAccess.Put(
 		ctx,
 		blobDigest,
-		buffer.NewCASBufferFromReader(
-			blobDigest,
-			newSectionReadCloser(file, 0, sizeBytes),
-			buffer.UserProvided)); err != nil {
+		buffer.NewCASBufferFromReader(blobDigest, r, buffer.UserProvided)); err != nil {
 		return digest.BadDigest, err
 	}
 	return blobDigest, nil
 }
 
+// stagingWriter is an io.Writer that retains all data written to it
+// in memory, as long as it does not exceed a maximum size. Once
+// exceeded, data is written into a file in a staging directory
+// instead. It is used by PutFile() to prevent files from being read
+// twice.
+type stagingWriter struct {
+	directory        filesystem.Directory
+	maximumSizeBytes int
+
+	data       []byte
+	overflowed bool
+	name       string
+	file       filesystem.FileReadWriter
+	sizeBytes  int64
+}
+
+func (w *stagingWriter) Write(p []byte) (int, error) {
+	if !w.overflowed {
+		if len(w.data)+len(p) <= w.maximumSizeBytes {
+			w.data = append(w.data, p...)
+			w.sizeBytes += int64(len(p))
+			return len(p), nil
+		}
+		w.overflowed = true
+		if w.directory != nil {
+			id, err := uuid.NewRandom()
+			if err != nil {
+				return 0, util.StatusWrapWithCode(err, codes.Internal, "Failed to generate staging file name")
+			}
+			w.name = id.String()
+			f, err := w.directory.OpenReadWrite(w.name, filesystem.CreateExcl(0600))
+			if err != nil {
+				return 0, util.StatusWrap(err, "Failed to create staging file")
+			}
+			w.file = f
+			if len(w.data) > 0 {
+				if _, err := f.WriteAt(w.data, 0); err != nil {
+					return 0, util.StatusWrap(err, "Failed to write to staging file")
+				}
+			}
+		}
+		w.data = nil
+	}
+	if w.file != nil {
+		if _, err := w.file.WriteAt(p, w.sizeBytes); err != nil {
+			return 0, util.StatusWrap(err, "Failed to write to staging file")
+		}
+	}
+	w.sizeBytes += int64(len(p))
+	return len(p), nil
+}
+
+// discard the staging file, if one was created.
+func (w *stagingWriter) discard() error {
+	if w.file == nil {
+		return nil
+	}
+	err1 := w.file.Close()
+	err2 := w.directory.Remove(w.name)
+	w.file = nil
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// stagingFileReadCloser is an io.ReadCloser that reads the contents of
+// a staging file, removing it when closed.
+type stagingFileReadCloser struct {
+	io.SectionReader
+	staging *stagingWriter
+}
+
+func (r *stagingFileReadCloser) Close() error {
+	return r.staging.discard()
+}
+
 // newSectionReadCloser returns an io.ReadCloser that reads from r at a
 // given offset, but stops with EOF after n bytes. This function is
 // identical to io.NewSectionReader(), except that it provides an
@@ -165,12 +298,57 @@ func newSectionReadCloser(r filesystem.FileReader, off int64, n int64) io.ReadCl
 	}
 }
 
+// ProgressFunc is a callback that is invoked by PutFile() while a file
+// is being uploaded. It is provided the total number of bytes that
+// have been uploaded so far. It may be used to report the progress of
+// long running transfers (e.g., uploads of large output files).
+type ProgressFunc func(bytesUploaded int64)
+
+type progressFuncKey struct{}
+
+// NewContextWithProgressFunc attaches a ProgressFunc to a Context
+// object, so that PutFile() may report the progress of uploads.
+func NewContextWithProgressFunc(ctx context.Context, progress ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressFuncKey{}, progress)
+}
+
+func getProgressFuncFromContext(ctx context.Context) ProgressFunc {
+	progress, _ := ctx.Value(progressFuncKey{}).(ProgressFunc)
+	return progress
+}
+
+// progressReadCloser is a decorator for io.ReadCloser that invokes a
+// ProgressFunc after every read.
+type progressReadCloser struct {
+	io.ReadCloser
+	progress      ProgressFunc
+	bytesUploaded int64
+}
+
+func (r *progressReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.bytesUploaded += int64(n)
+		r.progress(r.bytesUploaded)
+	}
+	return n, err
+}
+
+// newContextWithBlobKind attaches the kind of the object that is
+// written to a Context object, while retaining any other PutHints
+// provided by the caller.
+func newContextWithBlobKind(ctx context.Context, kind blobstore.BlobKind) context.Context {
+	hints := blobstore.GetPutHintsFromContext(ctx)
+	hints.Kind = kind
+	return blobstore.NewContextWithPutHints(ctx, hints)
+}
+
 func (cas *blobAccessContentAddressableStorage) PutLog(ctx context.Context, log []byte, parentDigest digest.Digest) (digest.Digest, error) {
-	return cas.putBlob(ctx, log, parentDigest)
+	return cas.putBlob(newContextWithBlobKind(ctx, blobstore.BlobKindLog), log, parentDigest)
 }
 
 func (cas *blobAccessContentAddressableStorage) PutTree(ctx context.Context, tree *remoteexecution.Tree, parentDigest digest.Digest) (digest.Digest, error) {
-	return cas.putMessage(ctx, tree, parentDigest)
+	return cas.putMessage(newContextWithBlobKind(ctx, blobstore.BlobKindTree), tree, parentDigest)
 }
 
 func (cas *blobAccessContentAddressableStorage) PutUncachedActionResult(ctx context.Context, uncachedActionResult *cas_proto.UncachedActionResult, parentDigest digest.Digest) (digest.Digest, error) {
@@ -6,11 +6,16 @@ import (
 	"testing"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/cas"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestBlobAccessContentAddressableStoragePutFileSuccess(t *testing.T) {
@@ -22,7 +27,8 @@ func TestBlobAccessContentAddressableStoragePutFileSuccess(t *testing.T) {
 	directory.EXPECT().OpenRead("hello").Return(file, nil)
 
 	// Operations that should appear on the file that is being
-	// uploaded. A first pass is used to compute the file's digest.
+	// uploaded. As the file is too large to be retained in memory,
+	// a first pass is used to compute the file's digest.
 	// A second pass is used to upload the file's contents. The file
 	// may have grown in the meantime, but the second pass should
 	// not read beyond the part that was used for digest computation.
@@ -54,7 +60,91 @@ func TestBlobAccessContentAddressableStoragePutFileSuccess(t *testing.T) {
 			return nil
 		})
 
-	contentAddressableStorage := cas.NewBlobAccessContentAddressableStorage(blobAccess, 1000)
+	contentAddressableStorage := cas.NewBlobAccessContentAddressableStorage(blobAccess, nil, 10)
+	digest, err := contentAddressableStorage.PutFile(
+		ctx,
+		directory,
+		"hello",
+		digest.MustNewDigest("default-scheduler", "d41d8cd98f00b204e9800998ecf8427e", 123))
+	require.NoError(t, err)
+	require.Equal(t, digest, helloWorldDigest)
+}
+
+func TestBlobAccessContentAddressableStoragePutFileModified(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	directory := mock.NewMockDirectory(ctrl)
+	file := mock.NewMockFileReader(ctrl)
+	directory.EXPECT().OpenRead("hello").Return(file, nil)
+
+	// The file gets overwritten in between computing its digest and
+	// uploading it. The data should be hashed while being uploaded,
+	// so that the upload fails, instead of storing an object that
+	// does not match its digest.
+	gomock.InOrder(
+		file.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				copy(p, "Hello world")
+				return 11, io.EOF
+			}),
+		file.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				copy(p, "Hello World")
+				return 11, nil
+			}),
+		file.EXPECT().Close().Return(nil),
+	)
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	helloWorldDigest := digest.MustNewDigest("default-scheduler", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess.EXPECT().Put(ctx, helloWorldDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			_, err := b.ToByteSlice(100)
+			return err
+		})
+
+	contentAddressableStorage := cas.NewBlobAccessContentAddressableStorage(blobAccess, nil, 10)
+	_, err := contentAddressableStorage.PutFile(
+		ctx,
+		directory,
+		"hello",
+		digest.MustNewDigest("default-scheduler", "d41d8cd98f00b204e9800998ecf8427e", 123))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Buffer has checksum b10a8db164e0754105b7a99be72e3fe5, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+}
+
+func TestBlobAccessContentAddressableStoragePutFileSmall(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	directory := mock.NewMockDirectory(ctrl)
+	file := mock.NewMockFileReader(ctrl)
+	directory.EXPECT().OpenRead("hello").Return(file, nil)
+
+	// Files that fit in a single message should only be read once.
+	// Their contents are retained in memory while computing the
+	// digest.
+	gomock.InOrder(
+		file.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				require.Greater(t, len(p), 11)
+				copy(p, "Hello world")
+				return 11, io.EOF
+			}),
+		file.EXPECT().Close().Return(nil),
+	)
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	helloWorldDigest := digest.MustNewDigest("default-scheduler", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess.EXPECT().Put(ctx, helloWorldDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello world"), data)
+			return nil
+		})
+
+	contentAddressableStorage := cas.NewBlobAccessContentAddressableStorage(blobAccess, nil, 1000)
 	digest, err := contentAddressableStorage.PutFile(
 		ctx,
 		directory,
@@ -63,3 +153,101 @@ func TestBlobAccessContentAddressableStoragePutFileSuccess(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, digest, helloWorldDigest)
 }
+
+func TestBlobAccessContentAddressableStoragePutFileStaging(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	directory := mock.NewMockDirectory(ctrl)
+	file := mock.NewMockFileReader(ctrl)
+	directory.EXPECT().OpenRead("hello").Return(file, nil)
+	stagingDirectory := mock.NewMockDirectory(ctrl)
+	stagingFile := mock.NewMockFileReadWriter(ctrl)
+
+	// Files that are too large to be retained in memory should be
+	// copied into the staging directory while computing the
+	// digest. The upload should be performed from the staging
+	// file, meaning the original file is only read once.
+	var stagingFileName string
+	gomock.InOrder(
+		file.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				require.Greater(t, len(p), 11)
+				copy(p, "Hello world")
+				return 11, io.EOF
+			}),
+		stagingDirectory.EXPECT().OpenReadWrite(gomock.Any(), filesystem.CreateExcl(0600)).DoAndReturn(
+			func(name string, creationMode filesystem.CreationMode) (filesystem.FileReadWriter, error) {
+				stagingFileName = name
+				return stagingFile, nil
+			}),
+		stagingFile.EXPECT().WriteAt([]byte("Hello world"), int64(0)).Return(11, nil),
+		file.EXPECT().Close().Return(nil),
+		stagingFile.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				require.Len(t, p, 11)
+				copy(p, "Hello world")
+				return 11, nil
+			}),
+		stagingFile.EXPECT().Close().Return(nil),
+	)
+	stagingDirectory.EXPECT().Remove(gomock.Any()).DoAndReturn(
+		func(name string) error {
+			require.Equal(t, stagingFileName, name)
+			return nil
+		})
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	helloWorldDigest := digest.MustNewDigest("default-scheduler", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess.EXPECT().Put(gomock.Any(), helloWorldDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello world"), data)
+			return nil
+		})
+
+	// Progress of the upload should be reported to the caller.
+	var progress []int64
+	contentAddressableStorage := cas.NewBlobAccessContentAddressableStorage(blobAccess, stagingDirectory, 5)
+	digest, err := contentAddressableStorage.PutFile(
+		cas.NewContextWithProgressFunc(ctx, func(bytesUploaded int64) {
+			progress = append(progress, bytesUploaded)
+		}),
+		directory,
+		"hello",
+		digest.MustNewDigest("default-scheduler", "d41d8cd98f00b204e9800998ecf8427e", 123))
+	require.NoError(t, err)
+	require.Equal(t, digest, helloWorldDigest)
+	require.Equal(t, []int64{11}, progress)
+}
+
+func TestBlobAccessContentAddressableStoragePutLog(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	// Logs should be written with a hint indicating their kind, so
+	// that storage backends may store them differently. Any hints
+	// provided by the caller should be retained.
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	helloWorldDigest := digest.MustNewDigest("default-scheduler", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess.EXPECT().Put(gomock.Any(), helloWorldDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			require.Equal(t, blobstore.PutHints{
+				Kind:     blobstore.BlobKindLog,
+				Priority: 5,
+			}, blobstore.GetPutHintsFromContext(ctx))
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello world"), data)
+			return nil
+		})
+
+	contentAddressableStorage := cas.NewBlobAccessContentAddressableStorage(blobAccess, nil, 1000)
+	digest, err := contentAddressableStorage.PutLog(
+		blobstore.NewContextWithPutHints(ctx, blobstore.PutHints{Priority: 5}),
+		[]byte("Hello world"),
+		digest.MustNewDigest("default-scheduler", "d41d8cd98f00b204e9800998ecf8427e", 123))
+	require.NoError(t, err)
+	require.Equal(t, digest, helloWorldDigest)
+}
@@ -3,8 +3,6 @@ package cas
 import (
 	"context"
 	"io"
-	"strconv"
-	"strings"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
@@ -15,29 +13,6 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// parseResourceNameWrite parses resource name strings in one of the following two forms:
-//
-// - uploads/${uuid}/blobs/${hash}/${size}
-// - ${instance}/uploads/${uuid}/blobs/${hash}/${size}
-//
-// In the process, the hash, size and instance are extracted.
-func parseResourceNameWrite(resourceName string) (digest.Digest, error) {
-	fields := strings.FieldsFunc(resourceName, func(r rune) bool { return r == '/' })
-	l := len(fields)
-	if (l != 5 && l != 6) || fields[l-5] != "uploads" || fields[l-3] != "blobs" {
-		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
-	}
-	size, err := strconv.ParseInt(fields[l-1], 10, 64)
-	if err != nil {
-		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
-	}
-	instance := ""
-	if l == 6 {
-		instance = fields[0]
-	}
-	return digest.NewDigest(instance, fields[l-2], size)
-}
-
 type byteStreamServer struct {
 	blobAccess    blobstore.BlobAccess
 	readChunkSize int
@@ -57,12 +32,15 @@ func (s *byteStreamServer) Read(in *bytestream.ReadRequest, out bytestream.ByteS
 	if in.ReadLimit != 0 {
 		return status.Error(codes.Unimplemented, "This service does not support downloading partial files")
 	}
-	digest, err := digest.NewDigestFromBytestreamPath(in.ResourceName)
+	blobDigest, compressor, err := digest.NewDigestFromByteStreamReadResourceName(in.ResourceName)
 	if err != nil {
 		return err
 	}
+	if compressor != digest.CompressorIdentity {
+		return status.Error(codes.Unimplemented, "This service does not support downloading compressed files")
+	}
 
-	r := s.blobAccess.Get(out.Context(), digest).ToChunkReader(in.ReadOffset, s.readChunkSize)
+	r := s.blobAccess.Get(out.Context(), blobDigest).ToChunkReader(in.ReadOffset, s.readChunkSize)
 	defer r.Close()
 
 	for {
@@ -127,22 +105,25 @@ func (s *byteStreamServer) Write(stream bytestream.ByteStream_WriteServer) error
 	if err != nil {
 		return err
 	}
-	digest, err := parseResourceNameWrite(request.ResourceName)
+	blobDigest, compressor, err := digest.NewDigestFromByteStreamWriteResourceName(request.ResourceName)
 	if err != nil {
 		return err
 	}
+	if compressor != digest.CompressorIdentity {
+		return status.Error(codes.Unimplemented, "This service does not support uploading compressed files")
+	}
 	r := &byteStreamWriteServerChunkReader{stream: stream}
 	if err := r.setRequest(request); err != nil {
 		return err
 	}
 	if err := s.blobAccess.Put(
 		stream.Context(),
-		digest,
-		buffer.NewCASBufferFromChunkReader(digest, r, buffer.UserProvided)); err != nil {
+		blobDigest,
+		buffer.NewCASBufferFromChunkReader(blobDigest, r, buffer.UserProvided)); err != nil {
 		return err
 	}
 	return stream.SendAndClose(&bytestream.WriteResponse{
-		CommittedSize: digest.GetSizeBytes(),
+		CommittedSize: blobDigest.GetSizeBytes(),
 	})
 }
 
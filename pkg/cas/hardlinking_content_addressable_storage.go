@@ -0,0 +1,143 @@
+package cas
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+type hardlinkingContentAddressableStorage struct {
+	ContentAddressableStorage
+
+	cacheDirectory   filesystem.Directory
+	maximumFiles     int
+	maximumSizeBytes int64
+
+	lock                  sync.Mutex
+	filesPresentList      eviction.Set
+	filesPresentSize      map[string]int64
+	filesPresentTotalSize int64
+}
+
+// NewHardlinkingContentAddressableStorage creates a decorator for
+// ContentAddressableStorage that retains files fetched through
+// GetFile() in a local cache directory. When files with the same
+// contents are requested once again, they are hardlinked from the
+// cache directory, as opposed to being fetched from storage once more.
+// This reduces both network traffic and disk usage when populating
+// input roots containing many identical files (e.g., toolchains).
+//
+// As hardlinked files share their contents and permissions, callers
+// should not modify files returned by GetFile() in place.
+//
+// Files passed to PutFile() are not added to the cache, as they are
+// owned by the caller, which may still modify or delete them after
+// they have been uploaded.
+//
+// The cache directory, which must reside on the same file system as
+// the directories in which files are placed, is bounded both by the
+// number of files and by their total size. It should be empty when
+// this decorator is created.
+func NewHardlinkingContentAddressableStorage(base ContentAddressableStorage, cacheDirectory filesystem.Directory, maximumFiles int, maximumSizeBytes int64, evictionSet eviction.Set) ContentAddressableStorage {
+	return &hardlinkingContentAddressableStorage{
+		ContentAddressableStorage: base,
+
+		cacheDirectory:   cacheDirectory,
+		maximumFiles:     maximumFiles,
+		maximumSizeBytes: maximumSizeBytes,
+
+		filesPresentList: evictionSet,
+		filesPresentSize: map[string]int64{},
+	}
+}
+
+// makeSpace removes files from the cache directory until there is
+// enough space to store a file of a given size.
+func (cas *hardlinkingContentAddressableStorage) makeSpace(sizeBytes int64) error {
+	for len(cas.filesPresentSize) > 0 && (len(cas.filesPresentSize) >= cas.maximumFiles || cas.filesPresentTotalSize+sizeBytes > cas.maximumSizeBytes) {
+		// Remove a file from disk.
+		key := cas.filesPresentList.Peek()
+		if err := cas.cacheDirectory.Remove(key); err != nil && !os.IsNotExist(err) {
+			return util.StatusWrapf(err, "Failed to remove cached file %#v", key)
+		}
+
+		// Remove file from bookkeeping.
+		cas.filesPresentTotalSize -= cas.filesPresentSize[key]
+		delete(cas.filesPresentSize, key)
+		cas.filesPresentList.Remove()
+	}
+	return nil
+}
+
+func (cas *hardlinkingContentAddressableStorage) GetFile(ctx context.Context, blobDigest digest.Digest, directory filesystem.Directory, name string, isExecutable bool) error {
+	// Files are stored in the cache directory under their digest.
+	// Executable and non-executable copies of the same file need to
+	// be stored separately, as they share their permissions.
+	key := blobDigest.GetKey(digest.KeyWithoutInstance)
+	if isExecutable {
+		key += "+x"
+	} else {
+		key += "-x"
+	}
+
+	// If the file is present in the cache, hardlink it to the
+	// destination.
+	cas.lock.Lock()
+	if _, ok := cas.filesPresentSize[key]; ok {
+		cas.filesPresentList.Touch(key)
+		err := cas.cacheDirectory.Link(key, directory, name)
+		cas.lock.Unlock()
+		if err == nil {
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return util.StatusWrapf(err, "Failed to create hardlink to cached file %#v", key)
+		}
+		// The file has been removed from the cache directory
+		// externally. Fall back to fetching it from storage.
+	} else {
+		cas.lock.Unlock()
+	}
+
+	// Download the file at the intended location.
+	if err := cas.ContentAddressableStorage.GetFile(ctx, blobDigest, directory, name, isExecutable); err != nil {
+		return err
+	}
+
+	// Files that exceed the maximum size of the cache directory
+	// cannot be cached. Don't evict other files in an attempt to
+	// make space for them.
+	sizeBytes := blobDigest.GetSizeBytes()
+	if sizeBytes > cas.maximumSizeBytes {
+		return nil
+	}
+
+	// Hardlink the file into the cache directory, so that it may be
+	// reused by subsequent calls.
+	cas.lock.Lock()
+	defer cas.lock.Unlock()
+	if _, ok := cas.filesPresentSize[key]; ok {
+		// The file was already added to the cache directory by
+		// a concurrent call, or the cached copy was removed
+		// externally. In the latter case, restore it.
+		if err := directory.Link(name, cas.cacheDirectory, key); err != nil && !os.IsExist(err) {
+			return util.StatusWrapf(err, "Failed to restore cached file %#v", key)
+		}
+		return nil
+	}
+	if err := cas.makeSpace(sizeBytes); err != nil {
+		return err
+	}
+	if err := directory.Link(name, cas.cacheDirectory, key); err != nil {
+		return util.StatusWrapf(err, "Failed to add cached file %#v", key)
+	}
+	cas.filesPresentList.Insert(key)
+	cas.filesPresentSize[key] = sizeBytes
+	cas.filesPresentTotalSize += sizeBytes
+	return nil
+}
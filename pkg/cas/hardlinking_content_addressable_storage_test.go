@@ -0,0 +1,101 @@
+package cas_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHardlinkingContentAddressableStorageGetFile(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseContentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	cacheDirectory := mock.NewMockDirectory(ctrl)
+	contentAddressableStorage := cas.NewHardlinkingContentAddressableStorage(baseContentAddressableStorage, cacheDirectory, 2, 100, eviction.NewLRUSet())
+
+	directory := mock.NewMockDirectory(ctrl)
+	digest1 := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("instance", "6fc422233a40a75a1f028e11c3cd1140", 7)
+	digest3 := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 101)
+
+	t.Run("DownloadFailure", func(t *testing.T) {
+		baseContentAddressableStorage.EXPECT().GetFile(ctx, digest1, directory, "a", false).
+			Return(status.Error(codes.Internal, "Server on fire"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Server on fire"),
+			contentAddressableStorage.GetFile(ctx, digest1, directory, "a", false))
+	})
+
+	t.Run("Miss", func(t *testing.T) {
+		// Files that are not cached should be downloaded and
+		// hardlinked into the cache directory.
+		baseContentAddressableStorage.EXPECT().GetFile(ctx, digest1, directory, "a", false)
+		directory.EXPECT().Link("a", cacheDirectory, "8b1a9953c4611296a827abf8c47804d7-5-x")
+
+		require.NoError(t, contentAddressableStorage.GetFile(ctx, digest1, directory, "a", false))
+	})
+
+	t.Run("Hit", func(t *testing.T) {
+		// Subsequent requests should be served from the cache.
+		cacheDirectory.EXPECT().Link("8b1a9953c4611296a827abf8c47804d7-5-x", directory, "b")
+
+		require.NoError(t, contentAddressableStorage.GetFile(ctx, digest1, directory, "b", false))
+	})
+
+	t.Run("Executable", func(t *testing.T) {
+		// Executable copies of the same file should be cached
+		// separately.
+		baseContentAddressableStorage.EXPECT().GetFile(ctx, digest1, directory, "c", true)
+		directory.EXPECT().Link("c", cacheDirectory, "8b1a9953c4611296a827abf8c47804d7-5+x")
+
+		require.NoError(t, contentAddressableStorage.GetFile(ctx, digest1, directory, "c", true))
+	})
+
+	t.Run("CachedFileRemoved", func(t *testing.T) {
+		// If the cached copy has disappeared, the file should be
+		// downloaded once more and placed back in the cache.
+		gomock.InOrder(
+			cacheDirectory.EXPECT().Link("8b1a9953c4611296a827abf8c47804d7-5-x", directory, "d").Return(syscall.ENOENT),
+			baseContentAddressableStorage.EXPECT().GetFile(ctx, digest1, directory, "d", false),
+			directory.EXPECT().Link("d", cacheDirectory, "8b1a9953c4611296a827abf8c47804d7-5-x"))
+
+		require.NoError(t, contentAddressableStorage.GetFile(ctx, digest1, directory, "d", false))
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		// As the cache may only hold two files, adding a third
+		// file should cause the least recently used one to be
+		// removed.
+		gomock.InOrder(
+			baseContentAddressableStorage.EXPECT().GetFile(ctx, digest2, directory, "e", false),
+			cacheDirectory.EXPECT().Remove("8b1a9953c4611296a827abf8c47804d7-5+x"),
+			directory.EXPECT().Link("e", cacheDirectory, "6fc422233a40a75a1f028e11c3cd1140-7-x"))
+
+		require.NoError(t, contentAddressableStorage.GetFile(ctx, digest2, directory, "e", false))
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		// Files that are larger than the cache directory should
+		// be downloaded, but not cause any cached files to be
+		// evicted.
+		baseContentAddressableStorage.EXPECT().GetFile(ctx, digest3, directory, "f", false)
+
+		require.NoError(t, contentAddressableStorage.GetFile(ctx, digest3, directory, "f", false))
+
+		cacheDirectory.EXPECT().Link("8b1a9953c4611296a827abf8c47804d7-5-x", directory, "g")
+		require.NoError(t, contentAddressableStorage.GetFile(ctx, digest1, directory, "g", false))
+	})
+}
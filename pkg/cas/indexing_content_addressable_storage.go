@@ -0,0 +1,47 @@
+package cas
+
+import (
+	"context"
+	"log"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	cas_proto "github.com/buildbarn/bb-storage/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/proto/uncachedactionresult"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+type indexingContentAddressableStorage struct {
+	ContentAddressableStorage
+
+	index uncachedactionresult.UncachedActionResultIndexClient
+}
+
+// NewIndexingContentAddressableStorage creates a decorator for
+// ContentAddressableStorage that records the digests of all
+// UncachedActionResults written through PutUncachedActionResult() in
+// an UncachedActionResultIndex. This allows failed actions to be
+// listed afterwards.
+//
+// Recording is performed on a best-effort basis. Failures to record an
+// UncachedActionResult are logged, but not returned, as the
+// UncachedActionResult itself has been stored successfully.
+func NewIndexingContentAddressableStorage(base ContentAddressableStorage, index uncachedactionresult.UncachedActionResultIndexClient) ContentAddressableStorage {
+	return &indexingContentAddressableStorage{
+		ContentAddressableStorage: base,
+		index:                     index,
+	}
+}
+
+func (cas *indexingContentAddressableStorage) PutUncachedActionResult(ctx context.Context, uncachedActionResult *cas_proto.UncachedActionResult, parentDigest digest.Digest) (digest.Digest, error) {
+	blobDigest, err := cas.ContentAddressableStorage.PutUncachedActionResult(ctx, uncachedActionResult, parentDigest)
+	if err != nil {
+		return digest.BadDigest, err
+	}
+	if _, err := cas.index.RecordUncachedActionResult(ctx, &uncachedactionresult.RecordUncachedActionResultRequest{
+		InstanceName:               blobDigest.GetInstance(),
+		UncachedActionResultDigest: blobDigest.GetPartialDigest(),
+	}); err != nil {
+		log.Printf("Failed to record UncachedActionResult %s%s: %s", blobDigest, util.DescribeRequestMetadata(ctx), err)
+	}
+	return blobDigest, nil
+}
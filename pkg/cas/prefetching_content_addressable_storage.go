@@ -0,0 +1,153 @@
+package cas
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+)
+
+type prefetchingContentAddressableStorage struct {
+	ContentAddressableStorage
+	semaphore       chan struct{}
+	cacheSize       int
+	prefetchTimeout time.Duration
+
+	lock        sync.Mutex
+	directories map[string]*remoteexecution.Directory
+	evictionSet eviction.Set
+	pending     map[string]chan struct{}
+}
+
+// NewPrefetchingContentAddressableStorage creates a decorator for
+// ContentAddressableStorage that speculatively loads Directory
+// objects. Whenever a Directory is returned by GetDirectory(), Get
+// operations are issued against its child directories, so that they
+// are already available by the time the caller traverses into them.
+//
+// Consumers such as FUSE file systems and input root population code
+// tend to traverse directory hierarchies one directory at a time. For
+// storage backends with a high latency, this decorator may reduce the
+// time it takes to traverse large directory hierarchies.
+//
+// The number of concurrent prefetch operations is bounded. Prefetching
+// of child directories is skipped when this limit is reached. Objects
+// that are prefetched are retained in a bounded cache.
+//
+// Prefetch operations are not cancelled when the caller of
+// GetDirectory() goes away, as other callers may traverse into the
+// same directories. They are subject to a timeout instead, so that
+// they don't occupy the concurrency limit indefinitely.
+func NewPrefetchingContentAddressableStorage(base ContentAddressableStorage, maximumConcurrency int, cacheSize int, evictionSet eviction.Set, prefetchTimeout time.Duration) ContentAddressableStorage {
+	return &prefetchingContentAddressableStorage{
+		ContentAddressableStorage: base,
+		semaphore:                 make(chan struct{}, maximumConcurrency),
+		cacheSize:                 cacheSize,
+		prefetchTimeout:           prefetchTimeout,
+
+		directories: map[string]*remoteexecution.Directory{},
+		evictionSet: evictionSet,
+		pending:     map[string]chan struct{}{},
+	}
+}
+
+// getCachedDirectory returns a copy of a directory that was prefetched
+// previously. If a prefetch for the directory is still in progress,
+// this function waits for it to complete.
+func (cas *prefetchingContentAddressableStorage) getCachedDirectory(ctx context.Context, key string) (*remoteexecution.Directory, bool, error) {
+	cas.lock.Lock()
+	if wait, ok := cas.pending[key]; ok {
+		cas.lock.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, false, util.StatusFromContext(ctx)
+		}
+		cas.lock.Lock()
+	}
+	defer cas.lock.Unlock()
+
+	directory, ok := cas.directories[key]
+	if !ok {
+		return nil, false, nil
+	}
+	cas.evictionSet.Touch(key)
+	// Return a copy, as callers may modify the message.
+	return proto.Clone(directory).(*remoteexecution.Directory), true, nil
+}
+
+func (cas *prefetchingContentAddressableStorage) GetDirectory(ctx context.Context, directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
+	directory, ok, err := cas.getCachedDirectory(ctx, directoryDigest.GetKey(digest.KeyWithInstance))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		directory, err = cas.ContentAddressableStorage.GetDirectory(ctx, directoryDigest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cas.prefetchChildren(ctx, directoryDigest, directory)
+	return directory, nil
+}
+
+// prefetchChildren launches goroutines that load the child
+// directories of a directory into the cache.
+func (cas *prefetchingContentAddressableStorage) prefetchChildren(ctx context.Context, parentDigest digest.Digest, directory *remoteexecution.Directory) {
+	for _, child := range directory.Directories {
+		childDigest, err := parentDigest.NewDerivedDigest(child.Digest)
+		if err != nil {
+			// Let the caller report the error once it
+			// traverses into the directory.
+			continue
+		}
+		key := childDigest.GetKey(digest.KeyWithInstance)
+
+		cas.lock.Lock()
+		_, isCached := cas.directories[key]
+		_, isPending := cas.pending[key]
+		if isCached || isPending {
+			cas.lock.Unlock()
+			continue
+		}
+		select {
+		case cas.semaphore <- struct{}{}:
+		default:
+			// Too many prefetches in flight. Don't wait for
+			// them to complete, as that would slow down the
+			// caller.
+			cas.lock.Unlock()
+			return
+		}
+		wait := make(chan struct{})
+		cas.pending[key] = wait
+		cas.lock.Unlock()
+
+		go func() {
+			prefetchCtx, cancel := context.WithTimeout(util.NewDetachedContext(ctx), cas.prefetchTimeout)
+			childDirectory, err := cas.ContentAddressableStorage.GetDirectory(prefetchCtx, childDigest)
+			cancel()
+
+			cas.lock.Lock()
+			if err == nil && cas.cacheSize > 0 {
+				// Free up space to insert the directory.
+				if len(cas.directories) >= cas.cacheSize {
+					delete(cas.directories, cas.evictionSet.Peek())
+					cas.evictionSet.Remove()
+				}
+				cas.directories[key] = childDirectory
+				cas.evictionSet.Insert(key)
+			}
+			delete(cas.pending, key)
+			close(wait)
+			cas.lock.Unlock()
+
+			<-cas.semaphore
+		}()
+	}
+}
@@ -0,0 +1,96 @@
+package cas_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPrefetchingContentAddressableStorageGetDirectory(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseContentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	contentAddressableStorage := cas.NewPrefetchingContentAddressableStorage(baseContentAddressableStorage, 10, 10, eviction.NewLRUSet(), time.Minute)
+
+	rootDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	childDigest1 := digest.MustNewDigest("instance", "6fc422233a40a75a1f028e11c3cd1140", 7)
+	childDigest2 := digest.MustNewDigest("instance", "ebbbb099e9d2f7892d97ab3640ae8283", 9)
+	rootDirectory := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "a", Digest: childDigest1.GetPartialDigest()},
+			{Name: "b", Digest: childDigest2.GetPartialDigest()},
+		},
+	}
+	childDirectory1 := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: rootDigest.GetPartialDigest()},
+		},
+	}
+
+	// Fetching the root directory should cause both child
+	// directories to be prefetched. Each of them should only be
+	// requested from the backend once. Prefetching should not be
+	// interrupted by the caller going away, but should be subject
+	// to a timeout. As prefetching is performed asynchronously, the
+	// properties of the contexts are checked on the test's
+	// goroutine.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	type prefetchContextProperties struct {
+		err         error
+		hasDeadline bool
+	}
+	prefetchContexts := make(chan prefetchContextProperties, 2)
+	reportPrefetchContext := func(ctx context.Context) {
+		_, hasDeadline := ctx.Deadline()
+		prefetchContexts <- prefetchContextProperties{
+			err:         ctx.Err(),
+			hasDeadline: hasDeadline,
+		}
+	}
+	baseContentAddressableStorage.EXPECT().GetDirectory(canceledCtx, rootDigest).Return(rootDirectory, nil)
+	baseContentAddressableStorage.EXPECT().GetDirectory(gomock.Any(), childDigest1).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest) (*remoteexecution.Directory, error) {
+			reportPrefetchContext(ctx)
+			return childDirectory1, nil
+		})
+	baseContentAddressableStorage.EXPECT().GetDirectory(gomock.Any(), childDigest2).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest) (*remoteexecution.Directory, error) {
+			reportPrefetchContext(ctx)
+			return nil, status.Error(codes.Internal, "Server on fire")
+		})
+
+	directory, err := contentAddressableStorage.GetDirectory(canceledCtx, rootDigest)
+	require.NoError(t, err)
+	require.Equal(t, rootDirectory, directory)
+	for i := 0; i < 2; i++ {
+		properties := <-prefetchContexts
+		require.NoError(t, properties.err)
+		require.True(t, properties.hasDeadline)
+	}
+
+	// The first child should be served from the cache.
+	directory, err = contentAddressableStorage.GetDirectory(ctx, childDigest1)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(childDirectory1, directory))
+
+	// Prefetching the second child failed. This should cause it to
+	// be requested from the backend once again.
+	baseContentAddressableStorage.EXPECT().GetDirectory(ctx, childDigest2).Return(&remoteexecution.Directory{}, nil)
+	directory, err = contentAddressableStorage.GetDirectory(ctx, childDigest2)
+	require.NoError(t, err)
+	require.Equal(t, &remoteexecution.Directory{}, directory)
+}
@@ -0,0 +1,252 @@
+package cas
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	cas_proto "github.com/buildbarn/bb-storage/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/proto/uncachedactionresult"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type uncachedActionResultIndexEntry struct {
+	entry      *uncachedactionresult.UncachedActionResultEntry
+	recordTime time.Time
+}
+
+// UncachedActionResultIndexServer is a gRPC stub for the
+// UncachedActionResultIndex service. Recorded UncachedActionResults
+// are loaded from the Content Addressable Storage to validate them and
+// to extract a summary, which is kept in memory.
+//
+// Entries are discarded once they are older than the retention period.
+// The number of entries retained per instance name is bounded, so that
+// a large number of failures doesn't cause memory usage to grow
+// without bounds. Entries may be persisted in a file, so that they are
+// retained across restarts.
+type UncachedActionResultIndexServer struct {
+	contentAddressableStorage ContentAddressableStorage
+	clock                     clock.Clock
+	retention                 time.Duration
+	maximumEntriesPerInstance int
+	path                      string
+
+	lock sync.Mutex
+	// Entries per instance name, ordered from least to most recent.
+	entries map[string][]uncachedActionResultIndexEntry
+}
+
+// NewUncachedActionResultIndexServer creates an
+// UncachedActionResultIndexServer. If a path is provided, entries are
+// loaded from it, if it exists.
+func NewUncachedActionResultIndexServer(contentAddressableStorage ContentAddressableStorage, clock clock.Clock, retention time.Duration, maximumEntriesPerInstance int, path string) (*UncachedActionResultIndexServer, error) {
+	s := &UncachedActionResultIndexServer{
+		contentAddressableStorage: contentAddressableStorage,
+		clock:                     clock,
+		retention:                 retention,
+		maximumEntriesPerInstance: maximumEntriesPerInstance,
+		path:                      path,
+
+		entries: map[string][]uncachedActionResultIndexEntry{},
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to read index state file %#v", path)
+	}
+	var state uncachedactionresult.IndexState
+	if err := proto.Unmarshal(data, &state); err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to unmarshal index state file %#v", path)
+	}
+	for _, instance := range state.Instances {
+		entries := make([]uncachedActionResultIndexEntry, 0, len(instance.Entries))
+		for i, entry := range instance.Entries {
+			recordTime, err := ptypes.Timestamp(entry.RecordTime)
+			if err != nil {
+				return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid record time of entry at index %d of instance name %#v in index state file %#v", i, instance.InstanceName, path)
+			}
+			entries = append(entries, uncachedActionResultIndexEntry{
+				entry:      entry,
+				recordTime: recordTime,
+			})
+		}
+		// The maximum number of entries per instance name may
+		// have been lowered since the state was saved.
+		if len(entries) > maximumEntriesPerInstance {
+			entries = entries[len(entries)-maximumEntriesPerInstance:]
+		}
+		if len(entries) > 0 {
+			s.entries[instance.InstanceName] = entries
+		}
+	}
+	s.Prune()
+	return s, nil
+}
+
+// removeExpiredEntries discards entries for an instance name that are
+// past the retention period. This function must be called with the
+// lock held.
+func (s *UncachedActionResultIndexServer) removeExpiredEntries(instanceName string, now time.Time) []uncachedActionResultIndexEntry {
+	entries := s.entries[instanceName]
+	for len(entries) > 0 && !entries[0].recordTime.Add(s.retention).After(now) {
+		entries = entries[1:]
+	}
+	if len(entries) == 0 {
+		delete(s.entries, instanceName)
+		return nil
+	}
+	s.entries[instanceName] = entries
+	return entries
+}
+
+func (s *UncachedActionResultIndexServer) RecordUncachedActionResult(ctx context.Context, request *uncachedactionresult.RecordUncachedActionResultRequest) (*empty.Empty, error) {
+	uncachedActionResultDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.UncachedActionResultDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid UncachedActionResult digest")
+	}
+	uncachedActionResult, err := s.contentAddressableStorage.GetUncachedActionResult(ctx, uncachedActionResultDigest)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to obtain UncachedActionResult %s", uncachedActionResultDigest)
+	}
+
+	now := s.clock.Now()
+	recordTime, err := ptypes.TimestampProto(now)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to convert record time")
+	}
+	entry := uncachedActionResultIndexEntry{
+		entry: &uncachedactionresult.UncachedActionResultEntry{
+			UncachedActionResultDigest: uncachedActionResultDigest.GetPartialDigest(),
+			ActionDigest:               uncachedActionResult.ActionDigest,
+			ExitCode:                   uncachedActionResult.GetExecuteResponse().GetResult().GetExitCode(),
+			RecordTime:                 recordTime,
+		},
+		recordTime: now,
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries := append(s.removeExpiredEntries(request.InstanceName, now), entry)
+	if len(entries) > s.maximumEntriesPerInstance {
+		entries = entries[len(entries)-s.maximumEntriesPerInstance:]
+	}
+	s.entries[request.InstanceName] = entries
+	return &empty.Empty{}, nil
+}
+
+func (s *UncachedActionResultIndexServer) ListUncachedActionResults(ctx context.Context, request *uncachedactionresult.ListUncachedActionResultsRequest) (*uncachedactionresult.ListUncachedActionResultsResponse, error) {
+	if request.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Page size cannot be negative")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries := s.removeExpiredEntries(request.InstanceName, s.clock.Now())
+	count := len(entries)
+	if request.PageSize > 0 && count > int(request.PageSize) {
+		count = int(request.PageSize)
+	}
+	response := make([]*uncachedactionresult.UncachedActionResultEntry, 0, count)
+	for i := len(entries) - 1; len(response) < count; i-- {
+		response = append(response, entries[i].entry)
+	}
+	return &uncachedactionresult.ListUncachedActionResultsResponse{
+		Entries: response,
+	}, nil
+}
+
+func (s *UncachedActionResultIndexServer) GetUncachedActionResult(ctx context.Context, request *uncachedactionresult.GetUncachedActionResultRequest) (*cas_proto.UncachedActionResult, error) {
+	uncachedActionResultDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.UncachedActionResultDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid UncachedActionResult digest")
+	}
+	return s.contentAddressableStorage.GetUncachedActionResult(ctx, uncachedActionResultDigest)
+}
+
+// Prune discards entries of all instance names that are past the
+// retention period. Entries are also discarded when listed, but
+// calling this function periodically ensures that memory is released
+// for instance names that are no longer listed.
+func (s *UncachedActionResultIndexServer) Prune() {
+	now := s.clock.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for instanceName := range s.entries {
+		s.removeExpiredEntries(instanceName, now)
+	}
+}
+
+// Save the entries of the UncachedActionResultIndexServer to disk. The
+// file is replaced atomically, so that it is never left in a partially
+// written state.
+func (s *UncachedActionResultIndexServer) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.lock.Lock()
+	var state uncachedactionresult.IndexState
+	for instanceName, entries := range s.entries {
+		instance := &uncachedactionresult.IndexState_Instance{
+			InstanceName: instanceName,
+			Entries:      make([]*uncachedactionresult.UncachedActionResultEntry, 0, len(entries)),
+		}
+		for _, entry := range entries {
+			instance.Entries = append(instance.Entries, entry.entry)
+		}
+		state.Instances = append(state.Instances, instance)
+	}
+	data, err := proto.Marshal(&state)
+	s.lock.Unlock()
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal index state")
+	}
+
+	temporaryPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, data, 0644); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write index state file")
+	}
+	if err := os.Rename(temporaryPath, s.path); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to replace index state file")
+	}
+	return nil
+}
+
+// Run calls Prune() and Save() at a fixed interval. This function
+// returns when the provided context is cancelled, after saving the
+// entries one last time.
+func (s *UncachedActionResultIndexServer) Run(ctx context.Context, interval time.Duration) {
+	for {
+		timer, t := s.clock.NewTimer(interval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			if err := s.Save(); err != nil {
+				log.Print(err)
+			}
+			return
+		}
+		s.Prune()
+		if err := s.Save(); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package cas_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	cas_proto "github.com/buildbarn/bb-storage/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/proto/uncachedactionresult"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUncachedActionResultIndexServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	contentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	server, err := cas.NewUncachedActionResultIndexServer(contentAddressableStorage, clock, time.Hour, 2, "")
+	require.NoError(t, err)
+
+	digest1 := digest.MustNewDigest("instance", "3ba5eb0ae5b3ffdd3893038ac8e12804", 100)
+	digest2 := digest.MustNewDigest("instance", "38c5d2a7a4a07f0a23848a8fbd6b8fa2", 200)
+	digest3 := digest.MustNewDigest("instance", "f1a0d1d743f3ad3c177bf1ea1a04e0d4", 300)
+	actionDigest := &remoteexecution.Digest{Hash: "8b1a9953c4611296a827abf8c47804d7", SizeBytes: 5}
+	record := func(blobDigest digest.Digest, exitCode int32, now time.Time) {
+		contentAddressableStorage.EXPECT().GetUncachedActionResult(ctx, blobDigest).Return(&cas_proto.UncachedActionResult{
+			ActionDigest: actionDigest,
+			ExecuteResponse: &remoteexecution.ExecuteResponse{
+				Result: &remoteexecution.ActionResult{ExitCode: exitCode},
+			},
+		}, nil)
+		clock.EXPECT().Now().Return(now)
+		_, err := server.RecordUncachedActionResult(ctx, &uncachedactionresult.RecordUncachedActionResultRequest{
+			InstanceName:               "instance",
+			UncachedActionResultDigest: blobDigest.GetPartialDigest(),
+		})
+		require.NoError(t, err)
+	}
+	list := func(pageSize int32, now time.Time) []*uncachedactionresult.UncachedActionResultEntry {
+		clock.EXPECT().Now().Return(now)
+		response, err := server.ListUncachedActionResults(ctx, &uncachedactionresult.ListUncachedActionResultsRequest{
+			InstanceName: "instance",
+			PageSize:     pageSize,
+		})
+		require.NoError(t, err)
+		return response.Entries
+	}
+	entry := func(blobDigest digest.Digest, exitCode int32, seconds int64) *uncachedactionresult.UncachedActionResultEntry {
+		return &uncachedactionresult.UncachedActionResultEntry{
+			UncachedActionResultDigest: blobDigest.GetPartialDigest(),
+			ActionDigest:               actionDigest,
+			ExitCode:                   exitCode,
+			RecordTime:                 &timestamp.Timestamp{Seconds: seconds},
+		}
+	}
+
+	t.Run("RecordNotFound", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().GetUncachedActionResult(ctx, digest1).Return(nil, status.Error(codes.NotFound, "Object not found"))
+
+		_, err := server.RecordUncachedActionResult(ctx, &uncachedactionresult.RecordUncachedActionResultRequest{
+			InstanceName:               "instance",
+			UncachedActionResultDigest: digest1.GetPartialDigest(),
+		})
+		require.Equal(t, status.Error(codes.NotFound, "Failed to obtain UncachedActionResult 3ba5eb0ae5b3ffdd3893038ac8e12804-100-instance: Object not found"), err)
+	})
+
+	t.Run("ListEmpty", func(t *testing.T) {
+		require.Empty(t, list(0, time.Unix(1000, 0)))
+	})
+
+	t.Run("ListInvalidPageSize", func(t *testing.T) {
+		_, err := server.ListUncachedActionResults(ctx, &uncachedactionresult.ListUncachedActionResultsRequest{
+			InstanceName: "instance",
+			PageSize:     -1,
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Page size cannot be negative"), err)
+	})
+
+	t.Run("RecordAndList", func(t *testing.T) {
+		// Entries should be listed from most to least recent.
+		// As at most two entries are retained, the first entry
+		// should be discarded once the third one is recorded.
+		record(digest1, 1, time.Unix(1000, 0))
+		record(digest2, 2, time.Unix(1100, 0))
+		entries := list(0, time.Unix(1200, 0))
+		require.Len(t, entries, 2)
+		require.True(t, proto.Equal(entry(digest2, 2, 1100), entries[0]))
+		require.True(t, proto.Equal(entry(digest1, 1, 1000), entries[1]))
+
+		record(digest3, 3, time.Unix(1300, 0))
+		entries = list(1, time.Unix(1400, 0))
+		require.Len(t, entries, 1)
+		require.True(t, proto.Equal(entry(digest3, 3, 1300), entries[0]))
+		entries = list(0, time.Unix(1400, 0))
+		require.Len(t, entries, 2)
+		require.True(t, proto.Equal(entry(digest3, 3, 1300), entries[0]))
+		require.True(t, proto.Equal(entry(digest2, 2, 1100), entries[1]))
+
+		// Entries should disappear after the retention period.
+		entries = list(0, time.Unix(4750, 0))
+		require.Len(t, entries, 1)
+		require.True(t, proto.Equal(entry(digest3, 3, 1300), entries[0]))
+		require.Empty(t, list(0, time.Unix(4900, 0)))
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		uncachedActionResult := &cas_proto.UncachedActionResult{ActionDigest: actionDigest}
+		contentAddressableStorage.EXPECT().GetUncachedActionResult(ctx, digest1).Return(uncachedActionResult, nil)
+
+		response, err := server.GetUncachedActionResult(ctx, &uncachedactionresult.GetUncachedActionResultRequest{
+			InstanceName:               "instance",
+			UncachedActionResultDigest: digest1.GetPartialDigest(),
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(uncachedActionResult, response))
+	})
+}
+
+func TestUncachedActionResultIndexServerPersistence(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	stateDirectory, err := ioutil.TempDir("", "uncachedactionresult")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDirectory)
+	statePath := filepath.Join(stateDirectory, "index")
+
+	contentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	server, err := cas.NewUncachedActionResultIndexServer(contentAddressableStorage, clock, time.Hour, 10, statePath)
+	require.NoError(t, err)
+
+	digest1 := digest.MustNewDigest("instance1", "3ba5eb0ae5b3ffdd3893038ac8e12804", 100)
+	digest2 := digest.MustNewDigest("instance2", "38c5d2a7a4a07f0a23848a8fbd6b8fa2", 200)
+	actionDigest := &remoteexecution.Digest{Hash: "8b1a9953c4611296a827abf8c47804d7", SizeBytes: 5}
+	for i, blobDigest := range []digest.Digest{digest1, digest2} {
+		contentAddressableStorage.EXPECT().GetUncachedActionResult(ctx, blobDigest).Return(&cas_proto.UncachedActionResult{
+			ActionDigest: actionDigest,
+		}, nil)
+		clock.EXPECT().Now().Return(time.Unix(1000+int64(i)*1000, 0))
+		_, err := server.RecordUncachedActionResult(ctx, &uncachedactionresult.RecordUncachedActionResultRequest{
+			InstanceName:               blobDigest.GetInstance(),
+			UncachedActionResultDigest: blobDigest.GetPartialDigest(),
+		})
+		require.NoError(t, err)
+	}
+
+	// Entries should be retained across restarts. Entries that
+	// expired while the process was not running should be discarded
+	// upon startup.
+	require.NoError(t, server.Save())
+	clock.EXPECT().Now().Return(time.Unix(4700, 0))
+	server, err = cas.NewUncachedActionResultIndexServer(contentAddressableStorage, clock, time.Hour, 10, statePath)
+	require.NoError(t, err)
+
+	clock.EXPECT().Now().Return(time.Unix(4700, 0))
+	response, err := server.ListUncachedActionResults(ctx, &uncachedactionresult.ListUncachedActionResultsRequest{
+		InstanceName: "instance1",
+	})
+	require.NoError(t, err)
+	require.Empty(t, response.Entries)
+
+	clock.EXPECT().Now().Return(time.Unix(4700, 0))
+	response, err = server.ListUncachedActionResults(ctx, &uncachedactionresult.ListUncachedActionResultsRequest{
+		InstanceName: "instance2",
+	})
+	require.NoError(t, err)
+	require.Len(t, response.Entries, 1)
+	require.True(t, proto.Equal(&uncachedactionresult.UncachedActionResultEntry{
+		UncachedActionResultDigest: digest2.GetPartialDigest(),
+		ActionDigest:               actionDigest,
+		RecordTime:                 &timestamp.Timestamp{Seconds: 2000},
+	}, response.Entries[0]))
+
+	// Pruning should discard entries of all instance names, even if
+	// they are not listed.
+	clock.EXPECT().Now().Return(time.Unix(5700, 0))
+	server.Prune()
+	require.NoError(t, server.Save())
+	clock.EXPECT().Now().Return(time.Unix(5700, 0))
+	server, err = cas.NewUncachedActionResultIndexServer(contentAddressableStorage, clock, time.Hour, 10, statePath)
+	require.NoError(t, err)
+	clock.EXPECT().Now().Return(time.Unix(5700, 0))
+	response, err = server.ListUncachedActionResults(ctx, &uncachedactionresult.ListUncachedActionResultsRequest{
+		InstanceName: "instance2",
+	})
+	require.NoError(t, err)
+	require.Empty(t, response.Entries)
+}
@@ -0,0 +1,218 @@
+package digest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Compressor is a compression algorithm that may be used to transfer
+// objects through the ByteStream service, as described by the
+// "compressed-blobs" resource naming scheme of the Remote Execution
+// API.
+type Compressor int
+
+const (
+	// CompressorIdentity indicates that objects are transferred
+	// without compression, using the "blobs" resource naming scheme.
+	CompressorIdentity Compressor = iota
+	// CompressorZstd indicates that objects are transferred using
+	// Zstandard compression.
+	CompressorZstd
+	// CompressorDeflate indicates that objects are transferred using
+	// DEFLATE compression.
+	CompressorDeflate
+)
+
+var compressorNames = map[string]Compressor{
+	"zstd":    CompressorZstd,
+	"deflate": CompressorDeflate,
+}
+
+func (c Compressor) String() string {
+	switch c {
+	case CompressorIdentity:
+		return "identity"
+	case CompressorZstd:
+		return "zstd"
+	case CompressorDeflate:
+		return "deflate"
+	default:
+		return fmt.Sprintf("Compressor(%d)", int(c))
+	}
+}
+
+// reservedInstanceNameSegments contains the path segments that may not
+// be part of an instance name, as they would make resource names
+// ambiguous.
+var reservedInstanceNameSegments = map[string]bool{
+	"actionResults":    true,
+	"actions":          true,
+	"blobs":            true,
+	"capabilities":     true,
+	"compressed-blobs": true,
+	"operations":       true,
+	"uploads":          true,
+}
+
+// splitResourceName splits a resource name into path segments. For
+// compatibility with existing clients, empty path segments are
+// ignored.
+func splitResourceName(resourceName string) []string {
+	return strings.FieldsFunc(resourceName, func(r rune) bool { return r == '/' })
+}
+
+// parseInstanceName joins the leading path segments of a resource name
+// into an instance name, rejecting segments that are reserved.
+func parseInstanceName(fields []string) (string, error) {
+	for _, field := range fields {
+		if reservedInstanceNameSegments[field] {
+			return "", status.Errorf(codes.InvalidArgument, "Instance name contains reserved path segment %#v", field)
+		}
+	}
+	return strings.Join(fields, "/"), nil
+}
+
+// parseBlobPath parses the trailing part of a resource name, having
+// one of the following formats:
+//
+// - blobs/${hash}/${size}
+// - compressed-blobs/${compressor}/${hash}/${size}
+//
+// It returns the hash, size and compressor, and the number of path
+// segments consumed.
+func parseBlobPath(fields []string) (string, int64, Compressor, int, error) {
+	compressor := CompressorIdentity
+	var consumed int
+	switch {
+	case len(fields) >= 3 && fields[0] == "blobs":
+		consumed = 3
+	case len(fields) >= 4 && fields[0] == "compressed-blobs":
+		var ok bool
+		if compressor, ok = compressorNames[fields[1]]; !ok {
+			return "", 0, CompressorIdentity, 0, status.Errorf(codes.InvalidArgument, "Unsupported compressor %#v", fields[1])
+		}
+		consumed = 4
+	default:
+		return "", 0, CompressorIdentity, 0, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+	sizeBytes, err := strconv.ParseInt(fields[consumed-1], 10, 64)
+	if err != nil {
+		return "", 0, CompressorIdentity, 0, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+	return fields[consumed-2], sizeBytes, compressor, consumed, nil
+}
+
+// findSegment returns the index of the first path segment that matches
+// any of the provided values. The instance name cannot contain these,
+// meaning the first match marks the end of the instance name.
+func findSegment(fields []string, values ...string) int {
+	for i, field := range fields {
+		for _, value := range values {
+			if field == value {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// NewDigestFromByteStreamReadResourceName creates a Digest from a
+// resource name used by ByteStream.Read(), having one of the following
+// formats:
+//
+// - ${instance}/blobs/${hash}/${size}
+// - ${instance}/compressed-blobs/${compressor}/${hash}/${size}
+//
+// The instance name is optional and may contain slashes. In the case
+// of compressed objects, the hash and size correspond to the
+// uncompressed object.
+func NewDigestFromByteStreamReadResourceName(resourceName string) (Digest, Compressor, error) {
+	fields := splitResourceName(resourceName)
+	i := findSegment(fields, "blobs", "compressed-blobs")
+	if i < 0 {
+		return BadDigest, CompressorIdentity, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+	instance, err := parseInstanceName(fields[:i])
+	if err != nil {
+		return BadDigest, CompressorIdentity, err
+	}
+	hash, sizeBytes, compressor, consumed, err := parseBlobPath(fields[i:])
+	if err != nil {
+		return BadDigest, CompressorIdentity, err
+	}
+	if i+consumed != len(fields) {
+		return BadDigest, CompressorIdentity, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+	d, err := NewDigest(instance, hash, sizeBytes)
+	if err != nil {
+		return BadDigest, CompressorIdentity, err
+	}
+	return d, compressor, nil
+}
+
+// NewDigestFromByteStreamWriteResourceName creates a Digest from a
+// resource name used by ByteStream.Write(), having one of the
+// following formats:
+//
+// - ${instance}/uploads/${upload_id}/blobs/${hash}/${size}${metadata}
+// - ${instance}/uploads/${upload_id}/compressed-blobs/${compressor}/${hash}/${size}${metadata}
+//
+// The instance name is optional and may contain slashes. Trailing
+// metadata is optional and ignored. Though clients are advised to use
+// UUIDs as upload IDs, any non-empty path segment is accepted.
+func NewDigestFromByteStreamWriteResourceName(resourceName string) (Digest, Compressor, error) {
+	fields := splitResourceName(resourceName)
+	i := findSegment(fields, "uploads")
+	if i < 0 || i+1 >= len(fields) {
+		return BadDigest, CompressorIdentity, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+	instance, err := parseInstanceName(fields[:i])
+	if err != nil {
+		return BadDigest, CompressorIdentity, err
+	}
+	hash, sizeBytes, compressor, _, err := parseBlobPath(fields[i+2:])
+	if err != nil {
+		return BadDigest, CompressorIdentity, err
+	}
+	d, err := NewDigest(instance, hash, sizeBytes)
+	if err != nil {
+		return BadDigest, CompressorIdentity, err
+	}
+	return d, compressor, nil
+}
+
+// getBlobPath returns the part of a resource name that identifies the
+// object, excluding the instance name prefix.
+func (d Digest) getBlobPath(compressor Compressor) string {
+	if compressor == CompressorIdentity {
+		return fmt.Sprintf("blobs/%s/%d", d.GetHashString(), d.GetSizeBytes())
+	}
+	return fmt.Sprintf("compressed-blobs/%s/%s/%d", compressor, d.GetHashString(), d.GetSizeBytes())
+}
+
+func prependInstanceName(instance string, path string) string {
+	if instance == "" {
+		return path
+	}
+	return instance + "/" + path
+}
+
+// GetByteStreamReadPath returns the resource name that can be used to
+// read the object through ByteStream.Read(). It is the inverse of
+// NewDigestFromByteStreamReadResourceName().
+func (d Digest) GetByteStreamReadPath(compressor Compressor) string {
+	return prependInstanceName(d.GetInstance(), d.getBlobPath(compressor))
+}
+
+// GetByteStreamWritePath returns the resource name that can be used to
+// write the object through ByteStream.Write(). It is the inverse of
+// NewDigestFromByteStreamWriteResourceName().
+func (d Digest) GetByteStreamWritePath(uploadID uuid.UUID, compressor Compressor) string {
+	return prependInstanceName(d.GetInstance(), fmt.Sprintf("uploads/%s/%s", uploadID, d.getBlobPath(compressor)))
+}
@@ -0,0 +1,143 @@
+package digest_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewDigestFromByteStreamReadResourceName(t *testing.T) {
+	t.Run("NoInstanceName", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromByteStreamReadResourceName("blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+		require.Equal(t, digest.CompressorIdentity, compressor)
+	})
+
+	t.Run("MultiSegmentInstanceName", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromByteStreamReadResourceName("a/b/c/blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("a/b/c", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+		require.Equal(t, digest.CompressorIdentity, compressor)
+	})
+
+	t.Run("EmptySegments", func(t *testing.T) {
+		// Empty path segments are ignored for compatibility
+		// with existing clients.
+		d, _, err := digest.NewDigestFromByteStreamReadResourceName("//a//blobs//8b1a9953c4611296a827abf8c47804d7//5//")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromByteStreamReadResourceName("a/compressed-blobs/zstd/8b1a9953c4611296a827abf8c47804d7/5")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+		require.Equal(t, digest.CompressorZstd, compressor)
+	})
+
+	t.Run("UnsupportedCompressor", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamReadResourceName("compressed-blobs/lz4/8b1a9953c4611296a827abf8c47804d7/5")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported compressor \"lz4\""), err)
+	})
+
+	t.Run("ReservedInstanceNameSegment", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamReadResourceName("a/uploads/blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Instance name contains reserved path segment \"uploads\""), err)
+	})
+
+	t.Run("TrailingSegments", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamReadResourceName("blobs/8b1a9953c4611296a827abf8c47804d7/5/foo")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid resource naming scheme"), err)
+	})
+
+	t.Run("InvalidSize", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamReadResourceName("blobs/8b1a9953c4611296a827abf8c47804d7/five")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid resource naming scheme"), err)
+	})
+
+	t.Run("InvalidHash", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamReadResourceName("blobs/8b1a9953c4611296/5")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unknown digest hash length: 16 characters"), err)
+	})
+}
+
+func TestNewDigestFromByteStreamWriteResourceName(t *testing.T) {
+	t.Run("NoInstanceName", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromByteStreamWriteResourceName("uploads/d834d9c2-f3c9-4f30-a698-75fd4be9470d/blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+		require.Equal(t, digest.CompressorIdentity, compressor)
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromByteStreamWriteResourceName("a/b/uploads/d834d9c2-f3c9-4f30-a698-75fd4be9470d/compressed-blobs/deflate/8b1a9953c4611296a827abf8c47804d7/5/some/metadata")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("a/b", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+		require.Equal(t, digest.CompressorDeflate, compressor)
+	})
+
+	t.Run("NonUUIDUploadID", func(t *testing.T) {
+		// Upload IDs are not required to be UUIDs.
+		d, compressor, err := digest.NewDigestFromByteStreamWriteResourceName("a/uploads/hello/blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+		require.Equal(t, digest.CompressorIdentity, compressor)
+	})
+
+	t.Run("MissingUploadID", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamWriteResourceName("a/uploads//blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid resource naming scheme"), err)
+	})
+
+	t.Run("MissingUploads", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamWriteResourceName("a/blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid resource naming scheme"), err)
+	})
+
+	t.Run("ReservedInstanceNameSegment", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromByteStreamWriteResourceName("blobs/uploads/d834d9c2-f3c9-4f30-a698-75fd4be9470d/blobs/8b1a9953c4611296a827abf8c47804d7/5")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Instance name contains reserved path segment \"blobs\""), err)
+	})
+}
+
+func TestDigestGetByteStreamPath(t *testing.T) {
+	uploadID := uuid.MustParse("d834d9c2-f3c9-4f30-a698-75fd4be9470d")
+
+	for _, d := range []digest.Digest{
+		digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5),
+		digest.MustNewDigest("a/b", "8b1a9953c4611296a827abf8c47804d7", 5),
+	} {
+		for _, compressor := range []digest.Compressor{
+			digest.CompressorIdentity,
+			digest.CompressorZstd,
+			digest.CompressorDeflate,
+		} {
+			// Formatting and parsing resource names should be
+			// each other's inverse.
+			readDigest, readCompressor, err := digest.NewDigestFromByteStreamReadResourceName(d.GetByteStreamReadPath(compressor))
+			require.NoError(t, err)
+			require.Equal(t, d, readDigest)
+			require.Equal(t, compressor, readCompressor)
+
+			writeDigest, writeCompressor, err := digest.NewDigestFromByteStreamWriteResourceName(d.GetByteStreamWritePath(uploadID, compressor))
+			require.NoError(t, err)
+			require.Equal(t, d, writeDigest)
+			require.Equal(t, compressor, writeCompressor)
+		}
+	}
+
+	require.Equal(
+		t,
+		"a/b/compressed-blobs/zstd/8b1a9953c4611296a827abf8c47804d7/5",
+		digest.MustNewDigest("a/b", "8b1a9953c4611296a827abf8c47804d7", 5).GetByteStreamReadPath(digest.CompressorZstd))
+	require.Equal(
+		t,
+		"uploads/d834d9c2-f3c9-4f30-a698-75fd4be9470d/blobs/8b1a9953c4611296a827abf8c47804d7/5",
+		digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5).GetByteStreamWritePath(uploadID, digest.CompressorIdentity))
+}
@@ -143,21 +143,18 @@ func NewDigestFromPartialDigest(instance string, partialDigest *remoteexecution.
 //
 // This notation is used by Bazel to refer to files accessible through a
 // gRPC Bytestream service.
+//
+// Deprecated: Use NewDigestFromByteStreamReadResourceName(), which
+// also supports compressed objects.
 func NewDigestFromBytestreamPath(path string) (Digest, error) {
-	fields := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
-	l := len(fields)
-	if (l != 3 && l != 4) || fields[l-3] != "blobs" {
-		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
-	}
-	size, err := strconv.ParseInt(fields[l-1], 10, 64)
+	d, compressor, err := NewDigestFromByteStreamReadResourceName(path)
 	if err != nil {
-		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+		return BadDigest, err
 	}
-	instance := ""
-	if l == 4 {
-		instance = fields[0]
+	if compressor != CompressorIdentity {
+		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
 	}
-	return NewDigest(instance, fields[l-2], size)
+	return d, nil
 }
 
 // NewDerivedDigest creates a Digest object that uses the same instance
@@ -169,6 +166,15 @@ func (d Digest) NewDerivedDigest(partialDigest *remoteexecution.Digest) (Digest,
 	return NewDigestFromPartialDigest(d.GetInstance(), partialDigest)
 }
 
+// WithoutInstance returns a copy of the digest that has its instance
+// name cleared. This can be used to store metadata for objects in the
+// Content Addressable Storage, which is not partitioned by instance
+// name, in a backend that does take instance names into account.
+func (d Digest) WithoutInstance() Digest {
+	hashEnd, sizeBytes, _ := d.unpack()
+	return newDigestUnchecked("", d.value[:hashEnd], sizeBytes)
+}
+
 // GetPartialDigest encodes the digest into the format used by the remote
 // execution protocol, so that it may be stored in messages returned to
 // the client.
@@ -235,6 +241,32 @@ func (d Digest) GetKey(format KeyFormat) string {
 	}
 }
 
+// NewDigestFromKey is the inverse of Digest.GetKey(). It converts a
+// key of a given format back to a Digest object. Keys created using
+// KeyWithoutInstance yield digests with an empty instance name.
+func NewDigestFromKey(key string, format KeyFormat) (Digest, error) {
+	fields := strings.SplitN(key, "-", 3)
+	instance := ""
+	switch format {
+	case KeyWithoutInstance:
+		if len(fields) != 2 {
+			return BadDigest, status.Error(codes.InvalidArgument, "Key does not have format ${hash}-${size}")
+		}
+	case KeyWithInstance:
+		if len(fields) != 3 {
+			return BadDigest, status.Error(codes.InvalidArgument, "Key does not have format ${hash}-${size}-${instance}")
+		}
+		instance = fields[2]
+	default:
+		panic("Invalid digest key format")
+	}
+	sizeBytes, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return BadDigest, status.Errorf(codes.InvalidArgument, "Invalid digest size %#v", fields[1])
+	}
+	return NewDigest(instance, fields[0], sizeBytes)
+}
+
 func (d Digest) String() string {
 	return d.GetKey(KeyWithInstance)
 }
@@ -45,6 +45,19 @@ func TestDigestGetInstance(t *testing.T) {
 			123).GetInstance())
 }
 
+func TestDigestWithoutInstance(t *testing.T) {
+	require.Equal(
+		t,
+		digest.MustNewDigest(
+			"",
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			123),
+		digest.MustNewDigest(
+			"hello",
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			123).WithoutInstance())
+}
+
 func TestDigestGetHashBytes(t *testing.T) {
 	require.Equal(
 		t,
@@ -92,6 +105,36 @@ func TestDigestGetKey(t *testing.T) {
 		d.GetKey(digest.KeyWithInstance))
 }
 
+func TestNewDigestFromKey(t *testing.T) {
+	t.Run("WithoutInstance", func(t *testing.T) {
+		d, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-5", digest.KeyWithoutInstance)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+	})
+
+	t.Run("WithInstance", func(t *testing.T) {
+		// Instance names may themselves contain hyphens.
+		d, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-5-my-instance", digest.KeyWithInstance)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("my-instance", "8b1a9953c4611296a827abf8c47804d7", 5), d)
+	})
+
+	t.Run("MissingInstance", func(t *testing.T) {
+		_, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-5", digest.KeyWithInstance)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Key does not have format ${hash}-${size}-${instance}"), err)
+	})
+
+	t.Run("InvalidSize", func(t *testing.T) {
+		_, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-five", digest.KeyWithoutInstance)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid digest size \"five\""), err)
+	})
+
+	t.Run("InvalidHash", func(t *testing.T) {
+		_, err := digest.NewDigestFromKey("hello-5", digest.KeyWithoutInstance)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unknown digest hash length: 5 characters"), err)
+	})
+}
+
 func TestDigestString(t *testing.T) {
 	require.Equal(
 		t,
@@ -82,3 +82,20 @@ func (ec *ExistenceCache) Add(digests Set) {
 	}
 	ec.lock.Unlock()
 }
+
+// Invalidate digests stored in the cache, causing subsequent calls to
+// RemoveExisting() to no longer remove them.
+//
+// Entries are not removed from the underlying eviction set. Instead,
+// their insertion time is reset, so that they are treated as if they
+// had already expired.
+func (ec *ExistenceCache) Invalidate(digests Set) {
+	ec.lock.Lock()
+	for _, d := range digests.Items() {
+		key := d.GetKey(ec.keyFormat)
+		if _, ok := ec.insertionTimes[key]; ok {
+			ec.insertionTimes[key] = time.Time{}
+		}
+	}
+	ec.lock.Unlock()
+}
@@ -115,3 +115,38 @@ func TestExistenceCache(t *testing.T) {
 		allDigests,
 		existenceCache.RemoveExisting(allDigests))
 }
+
+func TestExistenceCacheInvalidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	clock := mock.NewMockClock(ctrl)
+	existenceCache := digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 2, time.Minute, eviction.NewLRUSet())
+
+	digest1 := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 5)
+	digest2 := digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)
+	bothDigests := digest.NewSetBuilder().Add(digest1).Add(digest2).Build()
+
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	existenceCache.Add(bothDigests)
+
+	// Invalidating an entry should cause it to no longer be
+	// removed, even though it has not expired yet.
+	existenceCache.Invalidate(digest.NewSetBuilder().Add(digest1).Build())
+	clock.EXPECT().Now().Return(time.Unix(1001, 0))
+	require.Equal(
+		t,
+		digest.NewSetBuilder().Add(digest1).Build(),
+		existenceCache.RemoveExisting(bothDigests))
+
+	// Adding the entry once again should make it valid again.
+	// Because it was never removed from the eviction set, this
+	// should not cause the other entry to be evicted.
+	clock.EXPECT().Now().Return(time.Unix(1002, 0))
+	existenceCache.Add(digest.NewSetBuilder().Add(digest1).Build())
+	clock.EXPECT().Now().Return(time.Unix(1003, 0))
+	require.Equal(
+		t,
+		digest.EmptySet,
+		existenceCache.RemoveExisting(bothDigests))
+}
@@ -74,8 +74,15 @@ type Directory interface {
 	// Open a file contained within the current directory for writing.
 	OpenWrite(name string, creationMode CreationMode) (FileWriter, error)
 
+	// Getxattr is the equivalent of getxattr(2). Symbolic links are
+	// not followed.
+	Getxattr(name string, attribute string) ([]byte, error)
 	// Link is the equivalent of os.Link().
 	Link(oldName string, newDirectory Directory, newName string) error
+	// Listxattr is the equivalent of listxattr(2), returning the
+	// names of the extended attributes of a file in sorted order.
+	// Symbolic links are not followed.
+	Listxattr(name string) ([]string, error)
 	// Lstat is the equivalent of os.Lstat().
 	Lstat(name string) (FileInfo, error)
 	// Mkdir is the equivalent of os.Mkdir().
@@ -91,6 +98,12 @@ type Directory interface {
 	// RemoveAllChildren empties out a directory, without removing
 	// the directory itself.
 	RemoveAllChildren() error
+	// Removexattr is the equivalent of removexattr(2). Symbolic
+	// links are not followed.
+	Removexattr(name string, attribute string) error
+	// Setxattr is the equivalent of setxattr(2). Symbolic links are
+	// not followed.
+	Setxattr(name string, attribute string, value []byte) error
 	// Symlink is the equivalent of os.Symlink().
 	Symlink(oldName string, newName string) error
 }
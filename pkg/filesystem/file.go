@@ -26,14 +26,23 @@ type FileReadWriter interface {
 	io.ReaderAt
 	io.WriterAt
 
+	PunchHole(offset int64, size int64) error
+	Sync() error
 	Truncate(size int64) error
 }
 
 // FileWriter is returned by Directory.OpenWrite(). It is a handle for a
 // file that permits data to be written to arbitrary locations.
+//
+// Handles for writable files permit the creation of sparse files.
+// Truncate() may be used to extend a file without allocating storage,
+// while PunchHole() returns the storage backing a region of the file to
+// the operating system. Reading from such a region yields zero bytes.
 type FileWriter interface {
 	io.Closer
 	io.WriterAt
 
+	PunchHole(offset int64, size int64) error
+	Sync() error
 	Truncate(size int64) error
 }
@@ -97,11 +97,49 @@ func (d *localDirectory) OpenRead(name string) (FileReader, error) {
 }
 
 func (d *localDirectory) OpenReadWrite(name string, creationMode CreationMode) (FileReadWriter, error) {
-	return d.open(name, creationMode, os.O_RDWR)
+	f, err := d.open(name, creationMode, os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	return localFile{File: f}, nil
 }
 
 func (d *localDirectory) OpenWrite(name string, creationMode CreationMode) (FileWriter, error) {
-	return d.open(name, creationMode, os.O_WRONLY)
+	f, err := d.open(name, creationMode, os.O_WRONLY)
+	if err != nil {
+		return nil, err
+	}
+	return localFile{File: f}, nil
+}
+
+// localFile is a handle for a writable file on the local file system.
+// It extends os.File with support for punching holes.
+type localFile struct {
+	*os.File
+}
+
+func (f localFile) PunchHole(offset int64, size int64) error {
+	defer runtime.KeepAlive(f.File)
+
+	return punchHole(int(f.Fd()), offset, size)
+}
+
+func (d *localDirectory) Getxattr(name string, attribute string) ([]byte, error) {
+	if err := validateFilename(name); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(d)
+
+	for l := 128; ; l *= 2 {
+		b := make([]byte, l)
+		n, err := getxattrAt(d.fd, name, attribute, b)
+		if err == unix.ERANGE {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return b[0:n], nil
+	}
 }
 
 func (d *localDirectory) Link(oldName string, newDirectory Directory, newName string) error {
@@ -121,6 +159,33 @@ func (d *localDirectory) Link(oldName string, newDirectory Directory, newName st
 	return unix.Linkat(d.fd, oldName, d2.fd, newName, 0)
 }
 
+func (d *localDirectory) Listxattr(name string) ([]string, error) {
+	if err := validateFilename(name); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(d)
+
+	for l := 128; ; l *= 2 {
+		b := make([]byte, l)
+		n, err := listxattrAt(d.fd, name, b)
+		if err == unix.ERANGE {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Attribute names are terminated by null bytes.
+		attributes := []string{}
+		for _, attribute := range strings.Split(string(b[0:n]), "\x00") {
+			if attribute != "" {
+				attributes = append(attributes, attribute)
+			}
+		}
+		sort.Strings(attributes)
+		return attributes, nil
+	}
+}
+
 func (d *localDirectory) lstat(name string) (FileType, deviceNumber, error) {
 	defer runtime.KeepAlive(d)
 
@@ -348,6 +413,24 @@ func (d *localDirectory) RemoveAll(name string) error {
 	}
 }
 
+func (d *localDirectory) Removexattr(name string, attribute string) error {
+	if err := validateFilename(name); err != nil {
+		return err
+	}
+	defer runtime.KeepAlive(d)
+
+	return removexattrAt(d.fd, name, attribute)
+}
+
+func (d *localDirectory) Setxattr(name string, attribute string, value []byte) error {
+	if err := validateFilename(name); err != nil {
+		return err
+	}
+	defer runtime.KeepAlive(d)
+
+	return setxattrAt(d.fd, name, attribute, value)
+}
+
 func (d *localDirectory) Symlink(oldName string, newName string) error {
 	if err := validateFilename(newName); err != nil {
 		return err
@@ -2,5 +2,61 @@
 
 package filesystem
 
+import (
+	"golang.org/x/sys/unix"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
 // deviceNumber is the equivalent of POSIX dev_t.
 type deviceNumber = int32
+
+func punchHole(fd int, offset int64, size int64) error {
+	return status.Error(codes.Unimplemented, "Punching holes is not supported on this platform")
+}
+
+// openForXattr opens a file, so that its extended attributes may be
+// accessed. Darwin provides neither *xattrat() system calls nor
+// O_PATH, meaning that the file needs to be opened for reading.
+// O_NOFOLLOW is used to prevent symbolic links from being followed,
+// while O_NONBLOCK prevents opening FIFOs from blocking.
+func openForXattr(dirfd int, name string) (int, error) {
+	return unix.Openat(dirfd, name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+}
+
+func getxattrAt(dirfd int, name string, attribute string, dest []byte) (int, error) {
+	fd, err := openForXattr(dirfd, name)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+	return unix.Fgetxattr(fd, attribute, dest)
+}
+
+func listxattrAt(dirfd int, name string, dest []byte) (int, error) {
+	fd, err := openForXattr(dirfd, name)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+	return unix.Flistxattr(fd, dest)
+}
+
+func removexattrAt(dirfd int, name string, attribute string) error {
+	fd, err := openForXattr(dirfd, name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	return unix.Fremovexattr(fd, attribute)
+}
+
+func setxattrAt(dirfd int, name string, attribute string, value []byte) error {
+	fd, err := openForXattr(dirfd, name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	return unix.Fsetxattr(fd, attribute, value, 0)
+}
@@ -2,5 +2,51 @@
 
 package filesystem
 
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
 // deviceNumber is the equivalent of POSIX dev_t.
 type deviceNumber = uint64
+
+// punchHole deallocates the storage backing a region of a file,
+// without changing the size of the file.
+func punchHole(fd int, offset int64, size int64) error {
+	if err := unix.Fallocate(fd, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, size); err == unix.EOPNOTSUPP {
+		return status.Error(codes.Unimplemented, "Punching holes is not supported by this file system")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// xattrPath returns a path through which the extended attributes of a
+// file in a directory may be accessed. Linux provides no *xattrat()
+// system calls. Instead of opening the file, which requires read
+// access and has side effects on FIFOs and devices, the file is
+// accessed relative to the directory through procfs. The l*xattr()
+// system calls are used, so that symbolic links are not followed.
+func xattrPath(dirfd int, name string) string {
+	return fmt.Sprintf("/proc/self/fd/%d/%s", dirfd, name)
+}
+
+func getxattrAt(dirfd int, name string, attribute string, dest []byte) (int, error) {
+	return unix.Lgetxattr(xattrPath(dirfd, name), attribute, dest)
+}
+
+func listxattrAt(dirfd int, name string, dest []byte) (int, error) {
+	return unix.Llistxattr(xattrPath(dirfd, name), dest)
+}
+
+func removexattrAt(dirfd int, name string, attribute string) error {
+	return unix.Lremovexattr(xattrPath(dirfd, name), attribute)
+}
+
+func setxattrAt(dirfd int, name string, attribute string, value []byte) error {
+	return unix.Lsetxattr(xattrPath(dirfd, name), attribute, value, 0)
+}
@@ -1,8 +1,10 @@
 package filesystem_test
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 
@@ -255,6 +257,32 @@ func TestLocalDirectoryOpenWriteSuccess(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestLocalDirectoryOpenReadWritePunchHole(t *testing.T) {
+	d := openTmpDir(t)
+	f, err := d.OpenReadWrite("file", filesystem.CreateExcl(0666))
+	require.NoError(t, err)
+
+	// Punching a hole should cause the data in that region to be
+	// replaced by zero bytes, without changing the file size.
+	n, err := f.WriteAt([]byte(strings.Repeat("A", 16384)), 0)
+	require.NoError(t, err)
+	require.Equal(t, 16384, n)
+	if err := f.PunchHole(4096, 8192); err == syscall.EOPNOTSUPP {
+		t.Skip("File system does not support punching holes")
+	} else {
+		require.NoError(t, err)
+	}
+
+	data := make([]byte, 16385)
+	n, err = f.ReadAt(data, 0)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 16384, n)
+	require.Equal(t, strings.Repeat("A", 4096)+strings.Repeat("\x00", 8192)+strings.Repeat("A", 4096), string(data[:n]))
+
+	require.NoError(t, f.Close())
+	require.NoError(t, d.Close())
+}
+
 func TestLocalDirectoryReadDir(t *testing.T) {
 	d := openTmpDir(t)
 
@@ -386,4 +414,54 @@ func TestLocalDirectorySymlinkSuccess(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestLocalDirectoryXattrBadName(t *testing.T) {
+	d := openTmpDir(t)
+
+	_, err := d.Getxattr("..", "user.foo")
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"..\""), err)
+	_, err = d.Listxattr("foo/bar")
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"foo/bar\""), err)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \".\""), d.Removexattr(".", "user.foo"))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"\""), d.Setxattr("", "user.foo", []byte("bar")))
+
+	require.NoError(t, d.Close())
+}
+
+func TestLocalDirectoryXattrNonExistent(t *testing.T) {
+	d := openTmpDir(t)
+	_, err := d.Getxattr("nonexistent", "user.foo")
+	require.True(t, os.IsNotExist(err))
+	require.True(t, os.IsNotExist(d.Setxattr("nonexistent", "user.foo", []byte("bar"))))
+	require.NoError(t, d.Close())
+}
+
+func TestLocalDirectoryXattrSuccess(t *testing.T) {
+	d := openTmpDir(t)
+	f, err := d.OpenWrite("file", filesystem.CreateExcl(0444))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Not all file systems support extended attributes.
+	if err := d.Setxattr("file", "user.foo", []byte("Hello")); err == syscall.ENOTSUP {
+		t.Skip("File system does not support extended attributes")
+	} else {
+		require.NoError(t, err)
+	}
+	require.NoError(t, d.Setxattr("file", "user.bar", []byte("World")))
+
+	value, err := d.Getxattr("file", "user.foo")
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), value)
+
+	attributes, err := d.Listxattr("file")
+	require.NoError(t, err)
+	require.Subset(t, attributes, []string{"user.bar", "user.foo"})
+
+	require.NoError(t, d.Removexattr("file", "user.foo"))
+	_, err = d.Getxattr("file", "user.foo")
+	require.Error(t, err)
+
+	require.NoError(t, d.Close())
+}
+
 // TODO(edsch): Add testing coverage for RemoveAll().
@@ -0,0 +1,68 @@
+package fsac
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/fsac"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fileSystemAccessCacheServer struct {
+	blobAccess              blobstore.BlobAccess
+	maximumMessageSizeBytes int
+}
+
+// NewFileSystemAccessCacheServer creates a gRPC service for serving the
+// contents of a File System Access Cache (FSAC). Profiles are stored in
+// a BlobAccess that is created with blobstore.FSACStorageType.
+func NewFileSystemAccessCacheServer(blobAccess blobstore.BlobAccess, maximumMessageSizeBytes int) fsac.FileSystemAccessCacheServer {
+	return &fileSystemAccessCacheServer{
+		blobAccess:              blobAccess,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+	}
+}
+
+func (s *fileSystemAccessCacheServer) GetFileSystemAccessProfile(ctx context.Context, request *fsac.GetFileSystemAccessProfileRequest) (*fsac.FileSystemAccessProfile, error) {
+	reducedActionDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.ReducedActionDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid reduced action digest")
+	}
+	data, err := s.blobAccess.Get(ctx, reducedActionDigest).ToByteSlice(s.maximumMessageSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	var profile fsac.FileSystemAccessProfile
+	if err := proto.Unmarshal(data, &profile); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to unmarshal message")
+	}
+	return &profile, nil
+}
+
+func (s *fileSystemAccessCacheServer) UpdateFileSystemAccessProfile(ctx context.Context, request *fsac.UpdateFileSystemAccessProfileRequest) (*empty.Empty, error) {
+	reducedActionDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.ReducedActionDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid reduced action digest")
+	}
+	profile := request.FileSystemAccessProfile
+	if profile == nil {
+		return nil, status.Error(codes.InvalidArgument, "No file system access profile provided")
+	}
+	if len(profile.BloomFilter) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Bloom filter is empty")
+	}
+	if profile.BloomFilterHashFunctions == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Bloom filter must use at least one hash function")
+	}
+	if err := s.blobAccess.Put(ctx, reducedActionDigest, buffer.NewProtoBufferFromProto(profile, buffer.UserProvided)); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,100 @@
+package fsac_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/fsac"
+	fsac_pb "github.com/buildbarn/bb-storage/pkg/proto/fsac"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFileSystemAccessCacheServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	server := fsac.NewFileSystemAccessCacheServer(blobAccess, 1000)
+
+	reducedActionDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	profile := &fsac_pb.FileSystemAccessProfile{
+		BloomFilter:              []byte{0x12, 0x34, 0x56},
+		BloomFilterHashFunctions: 3,
+	}
+	profileBytes, err := proto.Marshal(profile)
+	require.NoError(t, err)
+
+	t.Run("GetInvalidDigest", func(t *testing.T) {
+		_, err := server.GetFileSystemAccessProfile(ctx, &fsac_pb.GetFileSystemAccessProfileRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: &remoteexecution.Digest{Hash: "abc", SizeBytes: 5},
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid reduced action digest: Unknown digest hash length: 3 characters"), err)
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		blobAccess.EXPECT().Get(ctx, reducedActionDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := server.GetFileSystemAccessProfile(ctx, &fsac_pb.GetFileSystemAccessProfileRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: reducedActionDigest.GetPartialDigest(),
+		})
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		blobAccess.EXPECT().Get(ctx, reducedActionDigest).Return(buffer.NewValidatedBufferFromByteSlice(profileBytes))
+
+		response, err := server.GetFileSystemAccessProfile(ctx, &fsac_pb.GetFileSystemAccessProfileRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: reducedActionDigest.GetPartialDigest(),
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(profile, response))
+	})
+
+	t.Run("UpdateMissingProfile", func(t *testing.T) {
+		_, err := server.UpdateFileSystemAccessProfile(ctx, &fsac_pb.UpdateFileSystemAccessProfileRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: reducedActionDigest.GetPartialDigest(),
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "No file system access profile provided"), err)
+	})
+
+	t.Run("UpdateNoHashFunctions", func(t *testing.T) {
+		_, err := server.UpdateFileSystemAccessProfile(ctx, &fsac_pb.UpdateFileSystemAccessProfileRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: reducedActionDigest.GetPartialDigest(),
+			FileSystemAccessProfile: &fsac_pb.FileSystemAccessProfile{
+				BloomFilter: []byte{0x12, 0x34, 0x56},
+			},
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Bloom filter must use at least one hash function"), err)
+	})
+
+	t.Run("UpdateSuccess", func(t *testing.T) {
+		blobAccess.EXPECT().Put(ctx, reducedActionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(1000)
+				require.NoError(t, err)
+				require.Equal(t, profileBytes, data)
+				return nil
+			})
+
+		_, err := server.UpdateFileSystemAccessProfile(ctx, &fsac_pb.UpdateFileSystemAccessProfileRequest{
+			InstanceName:            "instance",
+			ReducedActionDigest:     reducedActionDigest.GetPartialDigest(),
+			FileSystemAccessProfile: profile,
+		})
+		require.NoError(t, err)
+	})
+}
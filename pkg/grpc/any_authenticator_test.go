@@ -25,37 +25,41 @@ func TestAnyAuthenticatorExample(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		// There is no need to check the third authentication
 		// backend if the second already returns success.
-		m0.EXPECT().Authenticate(ctx).Return(status.Error(codes.Unauthenticated, "No token present"))
-		m1.EXPECT().Authenticate(ctx).Return(nil)
+		m0.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "No token present"))
+		m1.EXPECT().Authenticate(ctx).Return("alice", nil)
 
-		require.NoError(t, a.Authenticate(ctx))
+		identity, err := a.Authenticate(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "alice", identity)
 	})
 
 	t.Run("AllUnauthenticated", func(t *testing.T) {
 		// A user is unauthenticated if all backends consider it
 		// being unauthenticated.
-		m0.EXPECT().Authenticate(ctx).Return(status.Error(codes.Unauthenticated, "No TLS used"))
-		m1.EXPECT().Authenticate(ctx).Return(status.Error(codes.Unauthenticated, "No token present"))
-		m2.EXPECT().Authenticate(ctx).Return(status.Error(codes.Unauthenticated, "Not an internal IP range"))
+		m0.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "No TLS used"))
+		m1.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "No token present"))
+		m2.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "Not an internal IP range"))
 
+		_, err := a.Authenticate(ctx)
 		require.Equal(
 			t,
 			status.Error(codes.Unauthenticated, "No TLS used, No token present, Not an internal IP range"),
-			a.Authenticate(ctx))
+			err)
 	})
 
 	t.Run("InternalError", func(t *testing.T) {
 		// If an internal error occurs, we should return it, as
 		// that may be the reason the user cannot be
 		// authenticated.
-		m0.EXPECT().Authenticate(ctx).Return(status.Error(codes.Unauthenticated, "No TLS used"))
-		m1.EXPECT().Authenticate(ctx).Return(status.Error(codes.Internal, "Failed to contact OAuth2 server"))
-		m2.EXPECT().Authenticate(ctx).Return(status.Error(codes.Unauthenticated, "Not an internal IP range"))
+		m0.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "No TLS used"))
+		m1.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Internal, "Failed to contact OAuth2 server"))
+		m2.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "Not an internal IP range"))
 
+		_, err := a.Authenticate(ctx)
 		require.Equal(
 			t,
 			status.Error(codes.Internal, "Failed to contact OAuth2 server"),
-			a.Authenticate(ctx))
+			err)
 	})
 
 	t.Run("InternalErrorIgnoredUponSuccess", func(t *testing.T) {
@@ -63,10 +67,12 @@ func TestAnyAuthenticatorExample(t *testing.T) {
 		// requests to be dropped that can be validated through
 		// some other backend. This prevents the service from
 		// going down entirely.
-		m0.EXPECT().Authenticate(ctx).Return(status.Error(codes.Unauthenticated, "No TLS used"))
-		m1.EXPECT().Authenticate(ctx).Return(status.Error(codes.Internal, "Failed to contact OAuth2 server"))
-		m2.EXPECT().Authenticate(ctx).Return(nil)
+		m0.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "No TLS used"))
+		m1.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Internal, "Failed to contact OAuth2 server"))
+		m2.EXPECT().Authenticate(ctx).Return("bob", nil)
 
-		require.NoError(t, a.Authenticate(ctx))
+		identity, err := a.Authenticate(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "bob", identity)
 	})
 }
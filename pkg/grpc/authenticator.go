@@ -6,6 +6,7 @@ import (
 
 	"github.com/buildbarn/bb-storage/pkg/clock"
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -15,8 +16,11 @@ import (
 // Authenticator can be used to grant or deny access to a gRPC server.
 // Implementations may grant access based on TLS connection state,
 // provided headers, source IP address ranges, etc. etc. etc.
+//
+// Upon success, the identity of the client is returned. The identity
+// is empty if access is granted without identifying the client.
 type Authenticator interface {
-	Authenticate(ctx context.Context) error
+	Authenticate(ctx context.Context) (string, error)
 }
 
 // NewAuthenticatorFromConfiguration creates a tree of Authenticator
@@ -56,12 +60,15 @@ func NewAuthenticatorFromConfiguration(policy *configuration.AuthenticationPolic
 // NewAuthenticatingUnaryInterceptor creates a gRPC request interceptor
 // for unary calls that passes all requests through an Authenticator.
 // This may be used to enable authentication support on a gRPC server.
+// The identity of the client is attached to the Context object, so that
+// it may be obtained by calling util.GetAuthenticatedIdentityFromContext().
 func NewAuthenticatingUnaryInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		if err := a.Authenticate(ctx); err != nil {
+		identity, err := a.Authenticate(ctx)
+		if err != nil {
 			return nil, err
 		}
-		return handler(ctx, req)
+		return handler(util.NewContextWithAuthenticatedIdentity(ctx, identity), req)
 	}
 }
 
@@ -71,9 +78,13 @@ func NewAuthenticatingUnaryInterceptor(a Authenticator) grpc.UnaryServerIntercep
 // gRPC server.
 func NewAuthenticatingStreamInterceptor(a Authenticator) grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		if err := a.Authenticate(ss.Context()); err != nil {
+		identity, err := a.Authenticate(ss.Context())
+		if err != nil {
 			return err
 		}
-		return handler(srv, ss)
+		return handler(srv, &contextOverridingServerStream{
+			ServerStream: ss,
+			ctx:          util.NewContextWithAuthenticatedIdentity(ss.Context(), identity),
+		})
 	}
 }
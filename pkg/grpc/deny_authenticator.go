@@ -21,6 +21,6 @@ func NewDenyAuthenticator(message string) Authenticator {
 	}
 }
 
-func (a denyAuthenticator) Authenticate(ctx context.Context) error {
-	return a.err
+func (a denyAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	return "", a.err
 }
@@ -0,0 +1,213 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// FairQueueClass is a class of clients of a FairQueue that share the
+// same weight and a limit on the number of requests processed
+// concurrently. Instances of FairQueueClass may not be shared between
+// multiple FairQueues.
+type FairQueueClass struct {
+	weight             uint32
+	maximumConcurrency int
+
+	inFlight int
+}
+
+// NewFairQueueClass creates a new class of clients. The maximum
+// concurrency may be zero to indicate that this class is only limited
+// by the concurrency of the FairQueue as a whole.
+func NewFairQueueClass(weight uint32, maximumConcurrency int) *FairQueueClass {
+	return &FairQueueClass{
+		weight:             weight,
+		maximumConcurrency: maximumConcurrency,
+	}
+}
+
+func (c *FairQueueClass) hasCapacity() bool {
+	return c.maximumConcurrency == 0 || c.inFlight < c.maximumConcurrency
+}
+
+// fairQueueFlow holds the state of requests of a single client
+// identity.
+type fairQueueFlow struct {
+	class *FairQueueClass
+	// Virtual time at which the last admitted request of this
+	// client finishes.
+	finishTime float64
+	inFlight   int
+	// Requests waiting to be admitted, in order of arrival. A
+	// channel is closed to admit its request.
+	waiters []chan struct{}
+}
+
+// FairQueue limits the number of requests processed concurrently and
+// admits queued requests fairly across client identities, using
+// start-time fair queuing. Every client is given a share of capacity
+// that is proportional to the weight of its class, meaning that a
+// client that submits many requests is not able to starve clients that
+// submit few.
+type FairQueue struct {
+	maximumConcurrency int
+	classes            map[string]*FairQueueClass
+	defaultClass       *FairQueueClass
+
+	lock        sync.Mutex
+	inFlight    int
+	virtualTime float64
+	flows       map[string]*fairQueueFlow
+}
+
+// NewFairQueue creates a FairQueue that admits at most a given number
+// of requests concurrently. Clients are assigned to a class based on
+// their identity. Clients whose identity is not part of any class are
+// assigned to the default class.
+func NewFairQueue(maximumConcurrency int, classes map[string]*FairQueueClass, defaultClass *FairQueueClass) *FairQueue {
+	return &FairQueue{
+		maximumConcurrency: maximumConcurrency,
+		classes:            classes,
+		defaultClass:       defaultClass,
+
+		flows: map[string]*fairQueueFlow{},
+	}
+}
+
+// admit a request of a flow, updating virtual time. This function must
+// be called with the lock held.
+func (fq *FairQueue) admit(flow *fairQueueFlow) {
+	startTime := flow.finishTime
+	if startTime < fq.virtualTime {
+		startTime = fq.virtualTime
+	}
+	fq.virtualTime = startTime
+	flow.finishTime = startTime + 1/float64(flow.class.weight)
+	flow.inFlight++
+	flow.class.inFlight++
+	fq.inFlight++
+}
+
+// dispatch admits as many queued requests as capacity permits,
+// repeatedly picking the flow whose next request has the lowest
+// virtual start time. This function must be called with the lock held.
+func (fq *FairQueue) dispatch() {
+	for fq.inFlight < fq.maximumConcurrency {
+		var bestIdentity string
+		var bestFlow *fairQueueFlow
+		var bestStartTime float64
+		for identity, flow := range fq.flows {
+			if len(flow.waiters) == 0 || !flow.class.hasCapacity() {
+				continue
+			}
+			startTime := flow.finishTime
+			if startTime < fq.virtualTime {
+				startTime = fq.virtualTime
+			}
+			// Break ties by identity to make scheduling
+			// deterministic.
+			if bestFlow == nil || startTime < bestStartTime || (startTime == bestStartTime && identity < bestIdentity) {
+				bestIdentity, bestFlow, bestStartTime = identity, flow, startTime
+			}
+		}
+		if bestFlow == nil {
+			return
+		}
+		fq.admit(bestFlow)
+		close(bestFlow.waiters[0])
+		bestFlow.waiters = bestFlow.waiters[1:]
+	}
+}
+
+// maybeRemoveFlow garbage collects the state of a flow once it no
+// longer has any requests. When the client submits requests later on,
+// they are scheduled starting at the virtual time at that point. This
+// function must be called with the lock held.
+func (fq *FairQueue) maybeRemoveFlow(identity string, flow *fairQueueFlow) {
+	if flow.inFlight == 0 && len(flow.waiters) == 0 {
+		delete(fq.flows, identity)
+	}
+}
+
+// getClass returns the class of a client with a given identity. This
+// function must be called with the lock held.
+func (fq *FairQueue) getClass(identity string) *FairQueueClass {
+	if class, ok := fq.classes[identity]; ok {
+		return class
+	}
+	return fq.defaultClass
+}
+
+// Reconfigure replaces the concurrency limit and the classes of the
+// FairQueue. Requests that are in flight or queued remain accounted
+// for, and are moved to the classes of which their clients are part in
+// the new configuration. If limits are raised, queued requests are
+// admitted immediately. The classes provided may not be in use by any
+// FairQueue.
+func (fq *FairQueue) Reconfigure(maximumConcurrency int, classes map[string]*FairQueueClass, defaultClass *FairQueueClass) {
+	fq.lock.Lock()
+	defer fq.lock.Unlock()
+
+	fq.maximumConcurrency = maximumConcurrency
+	fq.classes = classes
+	fq.defaultClass = defaultClass
+	for identity, flow := range fq.flows {
+		flow.class = fq.getClass(identity)
+		flow.class.inFlight += flow.inFlight
+	}
+	fq.dispatch()
+}
+
+func (fq *FairQueue) release(identity string, flow *fairQueueFlow) {
+	fq.lock.Lock()
+	defer fq.lock.Unlock()
+
+	flow.inFlight--
+	flow.class.inFlight--
+	fq.inFlight--
+	fq.dispatch()
+	fq.maybeRemoveFlow(identity, flow)
+}
+
+// Acquire blocks until a request of a client with a given identity may
+// be processed. Upon success, a function is returned that must be
+// called once processing of the request has completed.
+func (fq *FairQueue) Acquire(ctx context.Context, identity string) (func(), error) {
+	fq.lock.Lock()
+	flow, ok := fq.flows[identity]
+	if !ok {
+		flow = &fairQueueFlow{class: fq.getClass(identity)}
+		fq.flows[identity] = flow
+	}
+
+	// Enqueue the request and admit requests. If this request is
+	// not admitted immediately, wait for capacity to free up.
+	admitted := make(chan struct{})
+	flow.waiters = append(flow.waiters, admitted)
+	fq.dispatch()
+	fq.lock.Unlock()
+
+	release := func() { fq.release(identity, flow) }
+	select {
+	case <-admitted:
+		return release, nil
+	case <-ctx.Done():
+		fq.lock.Lock()
+		for i, waiter := range flow.waiters {
+			if waiter == admitted {
+				flow.waiters = append(flow.waiters[:i], flow.waiters[i+1:]...)
+				fq.maybeRemoveFlow(identity, flow)
+				fq.lock.Unlock()
+				return nil, util.StatusFromContext(ctx)
+			}
+		}
+		fq.lock.Unlock()
+
+		// The request got admitted while the context was
+		// being cancelled. Give up capacity immediately.
+		release()
+		return nil, util.StatusFromContext(ctx)
+	}
+}
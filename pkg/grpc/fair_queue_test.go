@@ -0,0 +1,106 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFairQueue(t *testing.T) {
+	ctx := context.Background()
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	// Allow up to three concurrent requests, of which only one may
+	// originate from the CI class.
+	fq := bb_grpc.NewFairQueue(
+		3,
+		map[string]*bb_grpc.FairQueueClass{
+			"ci": bb_grpc.NewFairQueueClass(1, 1),
+		},
+		bb_grpc.NewFairQueueClass(4, 0))
+
+	t.Run("ClassLimit", func(t *testing.T) {
+		releaseCI, err := fq.Acquire(ctx, "ci")
+		require.NoError(t, err)
+
+		// A second request from the CI class should block, as
+		// the class has reached its limit.
+		_, err = fq.Acquire(cancelledCtx, "ci")
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+
+		// Other clients should still be admitted.
+		releaseAlice, err := fq.Acquire(ctx, "alice")
+		require.NoError(t, err)
+		releaseBob, err := fq.Acquire(ctx, "bob")
+		require.NoError(t, err)
+
+		// The server as a whole is now at its limit.
+		_, err = fq.Acquire(cancelledCtx, "carol")
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+
+		releaseAlice()
+		releaseCI()
+		releaseCI, err = fq.Acquire(ctx, "ci")
+		require.NoError(t, err)
+		releaseBob()
+		releaseCI()
+	})
+
+	t.Run("WaitForCapacity", func(t *testing.T) {
+		// Requests that are queued should be admitted as soon
+		// as capacity frees up.
+		releaseCI, err := fq.Acquire(ctx, "ci")
+		require.NoError(t, err)
+		admitted := make(chan func())
+		go func() {
+			release, err := fq.Acquire(ctx, "ci")
+			require.NoError(t, err)
+			admitted <- release
+		}()
+		releaseCI()
+		(<-admitted)()
+	})
+}
+
+func TestFairQueueReconfigure(t *testing.T) {
+	ctx := context.Background()
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	fq := bb_grpc.NewFairQueue(1, map[string]*bb_grpc.FairQueueClass{}, bb_grpc.NewFairQueueClass(1, 0))
+	releaseAlice, err := fq.Acquire(ctx, "alice")
+	require.NoError(t, err)
+	admitted := make(chan func())
+	go func() {
+		release, err := fq.Acquire(ctx, "bob")
+		if err != nil {
+			panic(err)
+		}
+		admitted <- release
+	}()
+
+	// Raising the concurrency limit should cause the queued
+	// request to be admitted immediately.
+	fq.Reconfigure(3, map[string]*bb_grpc.FairQueueClass{
+		"alice": bb_grpc.NewFairQueueClass(1, 1),
+	}, bb_grpc.NewFairQueueClass(1, 0))
+	releaseBob := <-admitted
+
+	// The request that was in flight before reconfiguring should
+	// count towards the limit of the class of which the client is
+	// now part.
+	_, err = fq.Acquire(cancelledCtx, "alice")
+	require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+	releaseAlice()
+	releaseAlice, err = fq.Acquire(ctx, "alice")
+	require.NoError(t, err)
+
+	releaseAlice()
+	releaseBob()
+}
@@ -0,0 +1,149 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ClientIdentityFunc is called by the fair queuing interceptors to
+// obtain the identity of the client that issued a request.
+type ClientIdentityFunc func(ctx context.Context) string
+
+// NewAuthenticatedOrHeaderClientIdentityFunc creates a
+// ClientIdentityFunc that identifies clients by the identity
+// established by the authentication policy of the server. If the
+// authentication policy did not identify the client, the value of a
+// gRPC header is used instead. If that header is not provided either,
+// the tool invocation ID contained in the REv2 RequestMetadata is
+// used, so that concurrent builds of anonymous clients are scheduled
+// fairly.
+func NewAuthenticatedOrHeaderClientIdentityFunc(header string) ClientIdentityFunc {
+	return func(ctx context.Context) string {
+		if identity := util.GetAuthenticatedIdentityFromContext(ctx); identity != "" {
+			return identity
+		}
+		if header != "" {
+			if md, ok := metadata.FromIncomingContext(ctx); ok {
+				if values := md.Get(header); len(values) > 0 {
+					return values[0]
+				}
+			}
+		}
+		if requestMetadata := util.GetRequestMetadataFromContext(ctx); requestMetadata != nil {
+			return requestMetadata.ToolInvocationId
+		}
+		return ""
+	}
+}
+
+func newFairQueueClassFromConfiguration(configuration *configuration.FairQueuingClass) (*FairQueueClass, error) {
+	if configuration == nil {
+		return nil, status.Error(codes.InvalidArgument, "No class configuration provided")
+	}
+	if configuration.Weight == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Class weight must be positive")
+	}
+	return NewFairQueueClass(configuration.Weight, int(configuration.MaximumConcurrentRequests)), nil
+}
+
+// NewFairQueueFromConfiguration creates a FairQueue based on
+// parameters provided in a configuration file.
+func NewFairQueueFromConfiguration(configuration *configuration.FairQueuingConfiguration) (*FairQueue, error) {
+	if configuration.MaximumConcurrentRequests == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Maximum number of concurrent requests must be positive")
+	}
+	defaultClass, err := newFairQueueClassFromConfiguration(configuration.DefaultClass)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Default class: %s", status.Convert(err).Message())
+	}
+	classes := map[string]*FairQueueClass{}
+	for i, classConfiguration := range configuration.Classes {
+		class, err := newFairQueueClassFromConfiguration(classConfiguration)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Class at index %d: %s", i, status.Convert(err).Message())
+		}
+		for _, identity := range classConfiguration.Identities {
+			if _, ok := classes[identity]; ok {
+				return nil, status.Errorf(codes.InvalidArgument, "Identity %#v is part of multiple classes", identity)
+			}
+			classes[identity] = class
+		}
+	}
+	return NewFairQueue(int(configuration.MaximumConcurrentRequests), classes, defaultClass), nil
+}
+
+// fairQueuedServices are the gRPC services whose methods are subject
+// to fair queuing. Other services (e.g., Health and Execution) provide
+// long-lived streams, which would hold on to capacity for their entire
+// lifetime.
+var fairQueuedServices = map[string]bool{
+	"build.bazel.remote.execution.v2.ActionCache":               true,
+	"build.bazel.remote.execution.v2.ContentAddressableStorage": true,
+	"google.bytestream.ByteStream":                              true,
+}
+
+// isFairQueuedMethod returns whether a method, provided in the form
+// "/${service}/${method}", is subject to fair queuing.
+func isFairQueuedMethod(fullMethod string) bool {
+	if len(fullMethod) == 0 || fullMethod[0] != '/' {
+		return false
+	}
+	i := strings.LastIndexByte(fullMethod, '/')
+	return i > 0 && fairQueuedServices[fullMethod[1:i]]
+}
+
+// NewFairQueuingUnaryServerInterceptor creates a gRPC request
+// interceptor for unary calls that delays processing of requests until
+// they are admitted by a FairQueue. This can be used to prevent a
+// single client from starving others. Only requests against the
+// Action Cache, Content Addressable Storage and ByteStream services
+// are queued. Other requests are processed immediately, as are all
+// requests while fair queuing is disabled.
+func NewFairQueuingUnaryServerInterceptor(fairQueuing *ReloadableFairQueuing) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isFairQueuedMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		fq, identify := fairQueuing.get()
+		if fq == nil {
+			return handler(ctx, req)
+		}
+		release, err := fq.Acquire(ctx, identify(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// NewFairQueuingStreamServerInterceptor is identical to
+// NewFairQueuingUnaryServerInterceptor, except that it can be used for
+// streaming calls. Capacity is held for the entire duration of the
+// stream.
+func NewFairQueuingStreamServerInterceptor(fairQueuing *ReloadableFairQueuing) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isFairQueuedMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		fq, identify := fairQueuing.get()
+		if fq == nil {
+			return handler(srv, ss)
+		}
+		ctx := ss.Context()
+		release, err := fq.Acquire(ctx, identify(ctx))
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,96 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFairQueuingServerInterceptor(t *testing.T) {
+	ctx := context.Background()
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	// Let the server be at its limit for the remainder of the test.
+	fq := bb_grpc.NewFairQueue(1, map[string]*bb_grpc.FairQueueClass{}, bb_grpc.NewFairQueueClass(1, 0))
+	release, err := fq.Acquire(ctx, "")
+	require.NoError(t, err)
+	defer release()
+
+	identify := bb_grpc.NewAuthenticatedOrHeaderClientIdentityFunc("")
+	fairQueuing := bb_grpc.NewReloadableFairQueuing(fq, identify)
+	unaryInterceptor := bb_grpc.NewFairQueuingUnaryServerInterceptor(fairQueuing)
+	streamInterceptor := bb_grpc.NewFairQueuingStreamServerInterceptor(fairQueuing)
+
+	t.Run("UnaryQueued", func(t *testing.T) {
+		// Requests against the Content Addressable Storage
+		// should wait for capacity to become available.
+		_, err := unaryInterceptor(
+			cancelledCtx,
+			"request",
+			&grpc.UnaryServerInfo{FullMethod: "/build.bazel.remote.execution.v2.ContentAddressableStorage/FindMissingBlobs"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				panic("Request should not have been admitted")
+			})
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+	})
+
+	t.Run("UnaryNotQueued", func(t *testing.T) {
+		// Requests against other services should be processed
+		// immediately.
+		resp, err := unaryInterceptor(
+			cancelledCtx,
+			"request",
+			&grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return "response", nil
+			})
+		require.NoError(t, err)
+		require.Equal(t, "response", resp)
+	})
+
+	t.Run("StreamQueued", func(t *testing.T) {
+		err := streamInterceptor(
+			nil,
+			contextServerStream{ctx: cancelledCtx},
+			&grpc.StreamServerInfo{FullMethod: "/google.bytestream.ByteStream/Write"},
+			func(srv interface{}, ss grpc.ServerStream) error {
+				panic("Request should not have been admitted")
+			})
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+	})
+
+	t.Run("StreamNotQueued", func(t *testing.T) {
+		// Long-lived streams, such as the ones used to watch
+		// the health of the server, should not hold on to
+		// capacity.
+		require.NoError(t, streamInterceptor(
+			nil,
+			contextServerStream{ctx: cancelledCtx},
+			&grpc.StreamServerInfo{FullMethod: "/grpc.health.v1.Health/Watch"},
+			func(srv interface{}, ss grpc.ServerStream) error {
+				return nil
+			}))
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		// Once fair queuing is disabled, requests should no
+		// longer be queued.
+		fairQueuing.SetFairQueue(nil, identify)
+		defer fairQueuing.SetFairQueue(fq, identify)
+		resp, err := unaryInterceptor(
+			cancelledCtx,
+			"request",
+			&grpc.UnaryServerInfo{FullMethod: "/build.bazel.remote.execution.v2.ContentAddressableStorage/FindMissingBlobs"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return "response", nil
+			})
+		require.NoError(t, err)
+		require.Equal(t, "response", resp)
+	})
+}
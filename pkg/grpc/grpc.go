@@ -1,11 +1,17 @@
 package grpc
 
 import (
+	"context"
+	"crypto/tls"
+	"log"
 	"net"
 	"os"
+	"sync"
+	"time"
 
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,6 +19,10 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
 	"google.golang.org/grpc/status"
 
 	"go.opencensus.io/plugin/ocgrpc"
@@ -30,55 +40,174 @@ func NewGRPCClientFromConfiguration(configuration *configuration.GRPCClientConfi
 	if err != nil {
 		return nil, util.StatusWrap(err, "Failed to create TLS configuration")
 	}
-	var securityOption grpc.DialOption
+	var dialOptions []grpc.DialOption
 	if tlsConfig != nil {
-		securityOption = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	} else {
-		securityOption = grpc.WithInsecure()
+		dialOptions = append(dialOptions, grpc.WithInsecure())
 	}
 
-	return grpc.Dial(
-		configuration.Address,
-		securityOption,
-		grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor),
-		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor))
+	if keepaliveConfiguration := configuration.Keepalive; keepaliveConfiguration != nil {
+		keepaliveTime, err := ptypes.Duration(keepaliveConfiguration.Time)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain keepalive time")
+		}
+		keepaliveTimeout, err := ptypes.Duration(keepaliveConfiguration.Timeout)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain keepalive timeout")
+		}
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: keepaliveConfiguration.PermitWithoutStream,
+		}))
+	}
+	if windowSize := configuration.InitialWindowSizeBytes; windowSize != 0 {
+		dialOptions = append(dialOptions, grpc.WithInitialWindowSize(windowSize))
+	}
+	if windowSize := configuration.InitialConnectionWindowSizeBytes; windowSize != 0 {
+		dialOptions = append(dialOptions, grpc.WithInitialConnWindowSize(windowSize))
+	}
+	var callOptions []grpc.CallOption
+	if maxRecvMsgSize := configuration.MaximumReceivedMessageSizeBytes; maxRecvMsgSize != 0 {
+		callOptions = append(callOptions, grpc.MaxCallRecvMsgSize(int(maxRecvMsgSize)))
+	}
+	if maxSendMsgSize := configuration.MaximumSentMessageSizeBytes; maxSendMsgSize != 0 {
+		callOptions = append(callOptions, grpc.MaxCallSendMsgSize(int(maxSendMsgSize)))
+	}
+	if len(callOptions) > 0 {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(callOptions...))
+	}
+
+	unaryInterceptors := []grpc.UnaryClientInterceptor{grpc_prometheus.UnaryClientInterceptor}
+	streamInterceptors := []grpc.StreamClientInterceptor{grpc_prometheus.StreamClientInterceptor}
+	if len(configuration.AddMetadata) > 0 || len(configuration.ForwardMetadata) > 0 {
+		headersToAdd := metadata.MD{}
+		for _, headerValues := range configuration.AddMetadata {
+			headersToAdd.Append(headerValues.Header, headerValues.Values...)
+		}
+		unaryInterceptors = append(unaryInterceptors, NewMetadataUnaryClientInterceptor(headersToAdd, configuration.ForwardMetadata))
+		streamInterceptors = append(streamInterceptors, NewMetadataStreamClientInterceptor(headersToAdd, configuration.ForwardMetadata))
+	}
+	dialOptions = append(
+		dialOptions,
+		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(unaryInterceptors...)),
+		grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(streamInterceptors...)))
+
+	// Establish multiple connections to the same address by letting
+	// a resolver return the address multiple times. gRPC creates a
+	// separate connection for every distinct address, which is why
+	// the addresses are made to differ by their metadata.
+	target := configuration.Address
+	if poolSize := configuration.ConnectionPoolSize; poolSize > 1 {
+		addresses := make([]resolver.Address, 0, poolSize)
+		for i := 0; i < int(poolSize); i++ {
+			addresses = append(addresses, resolver.Address{
+				Addr:     configuration.Address,
+				Metadata: i,
+			})
+		}
+		r := manual.NewBuilderWithScheme("bb-connection-pool")
+		r.InitialState(resolver.State{Addresses: addresses})
+		dialOptions = append(
+			dialOptions,
+			grpc.WithResolvers(r),
+			grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy": "round_robin"}`))
+		target = r.Scheme() + ":///" + configuration.Address
+	} else if poolSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Connection pool size cannot be negative")
+	}
+
+	return grpc.Dial(target, dialOptions...)
 }
 
 // NewGRPCServersFromConfigurationAndServe creates a series of gRPC
 // servers based on a configuration stored in a list of Protobuf
 // messages. In then lets all of these gRPC servers listen on the
 // network addresses of UNIX socket paths provided.
-func NewGRPCServersFromConfigurationAndServe(configurations []*configuration.GRPCServerConfiguration, registrationFunc func(*grpc.Server)) error {
-	serveErrors := make(chan error)
-
+//
+// When the provided context is cancelled, all servers stop accepting
+// new RPCs. RPCs that are in flight (e.g., ByteStream writes) are given
+// the opportunity to complete, up to a given timeout. A timeout of zero
+// causes this function to wait indefinitely. This function returns nil
+// once all servers have stopped.
+//
+// Authentication policies, TLS certificates and fair queuing settings
+// (including concurrency limits) may be changed at runtime by sending updated configurations over the reloads channel.
+// Configurations that change any other settings are rejected. The
+// reloads channel is no longer read after the provided context is
+// cancelled, meaning that senders should stop sending at that point.
+//
+// If provided, the listening channel is closed once all listening
+// sockets have been created. This permits the caller to drop
+// privileges that are only needed to create them.
+func NewGRPCServersFromConfigurationAndServe(ctx context.Context, configurations []*configuration.GRPCServerConfiguration, registrationFunc func(*grpc.Server), gracefulStopTimeout time.Duration, reloads <-chan []*configuration.GRPCServerConfiguration, listening chan<- struct{}) error {
 	if len(configurations) == 0 {
 		return status.Error(codes.InvalidArgument, "Expected GRPC server configuration is missing")
 	}
 
+	// Buffer errors for all listeners, so that serving goroutines
+	// can terminate after the servers are stopped.
+	listenersCount := 0
+	for _, configuration := range configurations {
+		listenersCount += len(configuration.ListenAddresses) + len(configuration.ListenPaths)
+	}
+	serveErrors := make(chan error, listenersCount)
+
+	var servers []*grpc.Server
+	var settings []reloadableServerSettings
 	for _, configuration := range configurations {
 		// Create an authenticator for requests.
-		authenticator, err := NewAuthenticatorFromConfiguration(configuration.AuthenticationPolicy)
+		baseAuthenticator, err := NewAuthenticatorFromConfiguration(configuration.AuthenticationPolicy)
 		if err != nil {
 			return err
 		}
+		authenticator := NewReloadableAuthenticator(baseAuthenticator)
 
 		// Default server options.
+		unaryInterceptors := []grpc.UnaryServerInterceptor{
+			grpc_prometheus.UnaryServerInterceptor,
+			NewAuthenticatingUnaryInterceptor(authenticator),
+			RequestMetadataUnaryServerInterceptor,
+		}
+		streamInterceptors := []grpc.StreamServerInterceptor{
+			grpc_prometheus.StreamServerInterceptor,
+			NewAuthenticatingStreamInterceptor(authenticator),
+			RequestMetadataStreamServerInterceptor,
+		}
+
+		// Always install the fair queuing interceptors, so that
+		// fair queuing can be enabled at runtime.
+		fairQueue, identify, err := newFairQueuingFromConfiguration(configuration.FairQueuing)
+		if err != nil {
+			return err
+		}
+		fairQueuing := NewReloadableFairQueuing(fairQueue, identify)
+		unaryInterceptors = append(unaryInterceptors, NewFairQueuingUnaryServerInterceptor(fairQueuing))
+		streamInterceptors = append(streamInterceptors, NewFairQueuingStreamServerInterceptor(fairQueuing))
+
 		serverOptions := []grpc.ServerOption{
-			grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-				grpc_prometheus.UnaryServerInterceptor,
-				NewAuthenticatingUnaryInterceptor(authenticator))),
-			grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-				grpc_prometheus.StreamServerInterceptor,
-				NewAuthenticatingStreamInterceptor(authenticator))),
+			grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
+			grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
 			grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 		}
 
-		// Enable TLS if provided.
+		// Enable TLS if provided. Obtain the certificate through a
+		// callback, so that it can be replaced at runtime.
+		var certificate *util.ReloadableCertificate
 		if tlsConfig, err := util.NewTLSConfigFromServerConfiguration(configuration.Tls); err != nil {
 			return err
 		} else if tlsConfig != nil {
+			certificate = util.NewReloadableCertificate(tlsConfig.Certificates[0])
+			tlsConfig.Certificates = nil
+			tlsConfig.GetCertificate = certificate.GetCertificate
 			serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
 		}
+		settings = append(settings, reloadableServerSettings{
+			authenticator: authenticator,
+			certificate:   certificate,
+			fairQueuing:   fairQueuing,
+		})
 
 		if maxRecvMsgSize := configuration.MaximumReceivedMessageSizeBytes; maxRecvMsgSize != 0 {
 			serverOptions = append(serverOptions, grpc.MaxRecvMsgSize(int(maxRecvMsgSize)))
@@ -87,6 +216,7 @@ func NewGRPCServersFromConfigurationAndServe(configurations []*configuration.GRP
 		// Create server.
 		s := grpc.NewServer(serverOptions...)
 		registrationFunc(s)
+		servers = append(servers, s)
 
 		// Add Prometheus timing metrics.
 		grpc_prometheus.EnableHandlingTimeHistogram(grpc_prometheus.WithHistogramBuckets(prometheus.DefBuckets))
@@ -117,5 +247,159 @@ func NewGRPCServersFromConfigurationAndServe(configurations []*configuration.GRP
 			go func() { serveErrors <- s.Serve(sock) }()
 		}
 	}
-	return <-serveErrors
+	if listening != nil {
+		close(listening)
+	}
+
+ServeLoop:
+	for {
+		select {
+		case err := <-serveErrors:
+			return err
+		case newConfigurations := <-reloads:
+			if err := reloadServerSettings(configurations, newConfigurations, settings); err != nil {
+				log.Print("Failed to reload gRPC server settings: ", err)
+			} else {
+				log.Print("Reloaded gRPC server settings")
+			}
+		case <-ctx.Done():
+			break ServeLoop
+		}
+	}
+
+	// Stop accepting new RPCs, while letting existing ones
+	// complete. Forcefully terminate RPCs that don't complete
+	// within the timeout.
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *grpc.Server) {
+			s.GracefulStop()
+			wg.Done()
+		}(s)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	if gracefulStopTimeout > 0 {
+		timer := time.NewTimer(gracefulStopTimeout)
+		select {
+		case <-stopped:
+			timer.Stop()
+		case <-timer.C:
+			for _, s := range servers {
+				s.Stop()
+			}
+		}
+	}
+	<-stopped
+	return nil
+}
+
+// reloadableServerSettings contains the settings of a gRPC server that
+// may be changed at runtime.
+type reloadableServerSettings struct {
+	authenticator *ReloadableAuthenticator
+	certificate   *util.ReloadableCertificate
+	fairQueuing   *ReloadableFairQueuing
+}
+
+// newFairQueuingFromConfiguration creates the FairQueue and
+// ClientIdentityFunc used by a gRPC server. The FairQueue is nil if
+// fair queuing is disabled.
+func newFairQueuingFromConfiguration(configuration *configuration.FairQueuingConfiguration) (*FairQueue, ClientIdentityFunc, error) {
+	if configuration == nil {
+		return nil, nil, nil
+	}
+	fairQueue, err := NewFairQueueFromConfiguration(configuration)
+	if err != nil {
+		return nil, nil, util.StatusWrap(err, "Failed to create fair queue")
+	}
+	return fairQueue, NewAuthenticatedOrHeaderClientIdentityFunc(configuration.IdentityHeader), nil
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUnreloadableServerSettings returns an error if a new
+// configuration of a gRPC server contains changes to settings that
+// can only be applied by restarting.
+func checkUnreloadableServerSettings(oldConfiguration *configuration.GRPCServerConfiguration, newConfiguration *configuration.GRPCServerConfiguration) error {
+	if !equalStrings(oldConfiguration.ListenAddresses, newConfiguration.ListenAddresses) ||
+		!equalStrings(oldConfiguration.ListenPaths, newConfiguration.ListenPaths) {
+		return status.Error(codes.InvalidArgument, "Listen addresses and paths cannot be changed without restarting")
+	}
+	if oldConfiguration.MaximumReceivedMessageSizeBytes != newConfiguration.MaximumReceivedMessageSizeBytes {
+		return status.Error(codes.InvalidArgument, "The maximum received message size cannot be changed without restarting")
+	}
+	if (oldConfiguration.Tls == nil) != (newConfiguration.Tls == nil) {
+		return status.Error(codes.InvalidArgument, "TLS cannot be enabled or disabled without restarting")
+	}
+	return nil
+}
+
+// reloadServerSettings applies settings from a new configuration to a
+// set of running gRPC servers. Either all settings are applied, or none
+// of them are.
+//
+// Only the authentication policy (including the certificate
+// authorities used to validate client certificates), the TLS server
+// certificate and the fair queuing settings are reloaded. Changes to
+// other settings are rejected.
+func reloadServerSettings(oldConfigurations []*configuration.GRPCServerConfiguration, newConfigurations []*configuration.GRPCServerConfiguration, settings []reloadableServerSettings) error {
+	if len(oldConfigurations) != len(newConfigurations) {
+		return status.Error(codes.InvalidArgument, "The number of gRPC servers cannot be changed without restarting")
+	}
+	authenticators := make([]Authenticator, 0, len(newConfigurations))
+	certificates := make([]tls.Certificate, 0, len(newConfigurations))
+	fairQueues := make([]*FairQueue, 0, len(newConfigurations))
+	identifies := make([]ClientIdentityFunc, 0, len(newConfigurations))
+	for i, newConfiguration := range newConfigurations {
+		if err := checkUnreloadableServerSettings(oldConfigurations[i], newConfiguration); err != nil {
+			return util.StatusWrapf(err, "gRPC server at index %d", i)
+		}
+
+		authenticator, err := NewAuthenticatorFromConfiguration(newConfiguration.AuthenticationPolicy)
+		if err != nil {
+			return util.StatusWrapf(err, "gRPC server at index %d", i)
+		}
+		authenticators = append(authenticators, authenticator)
+
+		tlsConfig, err := util.NewTLSConfigFromServerConfiguration(newConfiguration.Tls)
+		if err != nil {
+			return util.StatusWrapf(err, "gRPC server at index %d", i)
+		}
+		if tlsConfig != nil {
+			certificates = append(certificates, tlsConfig.Certificates[0])
+		} else {
+			certificates = append(certificates, tls.Certificate{})
+		}
+
+		fairQueue, identify, err := newFairQueuingFromConfiguration(newConfiguration.FairQueuing)
+		if err != nil {
+			return util.StatusWrapf(err, "gRPC server at index %d", i)
+		}
+		fairQueues = append(fairQueues, fairQueue)
+		identifies = append(identifies, identify)
+	}
+
+	for i, s := range settings {
+		s.authenticator.SetAuthenticator(authenticators[i])
+		if s.certificate != nil {
+			s.certificate.SetCertificate(certificates[i])
+		}
+		s.fairQueuing.SetFairQueue(fairQueues[i], identifies[i])
+	}
+	return nil
 }
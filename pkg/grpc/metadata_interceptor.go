@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// addMetadataToContext returns a context for an outgoing RPC that
+// contains a set of fixed headers, followed by headers that are copied
+// from the incoming RPC.
+func addMetadataToContext(ctx context.Context, headersToAdd metadata.MD, headersToForward []string) context.Context {
+	var pairs []string
+	for header, values := range headersToAdd {
+		for _, value := range values {
+			pairs = append(pairs, header, value)
+		}
+	}
+	if len(headersToForward) > 0 {
+		if incoming, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, header := range headersToForward {
+				for _, value := range incoming.Get(header) {
+					pairs = append(pairs, header, value)
+				}
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// NewMetadataUnaryClientInterceptor creates a gRPC client interceptor
+// for unary RPCs that adds a set of fixed headers to every outgoing
+// RPC. It also copies a set of headers from the incoming RPC, if any,
+// from which the outgoing RPC originates. This can be used to forward
+// credentials of clients to backends.
+func NewMetadataUnaryClientInterceptor(headersToAdd metadata.MD, headersToForward []string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(addMetadataToContext(ctx, headersToAdd, headersToForward), method, req, reply, cc, opts...)
+	}
+}
+
+// NewMetadataStreamClientInterceptor is identical to
+// NewMetadataUnaryClientInterceptor, except that it can be used for
+// streaming RPCs.
+func NewMetadataStreamClientInterceptor(headersToAdd metadata.MD, headersToForward []string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(addMetadataToContext(ctx, headersToAdd, headersToForward), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,44 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMetadataUnaryClientInterceptor(t *testing.T) {
+	interceptor := bb_grpc.NewMetadataUnaryClientInterceptor(
+		metadata.Pairs("x-buildbarn-client", "bb_storage"),
+		[]string{"authorization"})
+
+	t.Run("NoIncomingMetadata", func(t *testing.T) {
+		// Only the fixed headers should be added.
+		require.NoError(t, interceptor(context.Background(), "/Foo/Bar", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, ok := metadata.FromOutgoingContext(ctx)
+			require.True(t, ok)
+			require.Equal(t, metadata.Pairs("x-buildbarn-client", "bb_storage"), md)
+			return nil
+		}))
+	})
+
+	t.Run("ForwardedMetadata", func(t *testing.T) {
+		// Headers listed should be copied from the incoming
+		// RPC, while others should be left out.
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer token",
+			"cookie", "secret"))
+		require.NoError(t, interceptor(ctx, "/Foo/Bar", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, ok := metadata.FromOutgoingContext(ctx)
+			require.True(t, ok)
+			require.Equal(t, metadata.Pairs(
+				"x-buildbarn-client", "bb_storage",
+				"authorization", "Bearer token"), md)
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,39 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+)
+
+// ReloadableAuthenticator is a decorator for Authenticator that allows
+// the backing Authenticator to be replaced at runtime. This permits
+// authentication policies to be changed without restarting the
+// server.
+type ReloadableAuthenticator struct {
+	lock          sync.RWMutex
+	authenticator Authenticator
+}
+
+// NewReloadableAuthenticator creates a ReloadableAuthenticator that
+// initially forwards all calls to a given Authenticator.
+func NewReloadableAuthenticator(authenticator Authenticator) *ReloadableAuthenticator {
+	return &ReloadableAuthenticator{
+		authenticator: authenticator,
+	}
+}
+
+// SetAuthenticator replaces the Authenticator to which calls are
+// forwarded.
+func (a *ReloadableAuthenticator) SetAuthenticator(authenticator Authenticator) {
+	a.lock.Lock()
+	a.authenticator = authenticator
+	a.lock.Unlock()
+}
+
+// Authenticate a request by forwarding it to the current Authenticator.
+func (a *ReloadableAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	a.lock.RLock()
+	authenticator := a.authenticator
+	a.lock.RUnlock()
+	return authenticator.Authenticate(ctx)
+}
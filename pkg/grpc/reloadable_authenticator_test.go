@@ -0,0 +1,36 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReloadableAuthenticator(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	m0 := mock.NewMockAuthenticator(ctrl)
+	m1 := mock.NewMockAuthenticator(ctrl)
+	a := bb_grpc.NewReloadableAuthenticator(m0)
+
+	// Calls should initially be forwarded to the first
+	// Authenticator.
+	m0.EXPECT().Authenticate(ctx).Return("", status.Error(codes.Unauthenticated, "No TLS used"))
+	_, err := a.Authenticate(ctx)
+	require.Equal(t, status.Error(codes.Unauthenticated, "No TLS used"), err)
+
+	// Once replaced, the new Authenticator should be used.
+	a.SetAuthenticator(m1)
+	m1.EXPECT().Authenticate(ctx).Return("alice", nil)
+	identity, err := a.Authenticate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "alice", identity)
+}
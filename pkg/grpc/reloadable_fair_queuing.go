@@ -0,0 +1,51 @@
+package grpc
+
+import (
+	"sync"
+)
+
+// ReloadableFairQueuing holds the FairQueue and ClientIdentityFunc used
+// by the fair queuing interceptors. It permits fair queuing to be
+// enabled, disabled and reconfigured without restarting the server.
+type ReloadableFairQueuing struct {
+	lock      sync.RWMutex
+	fairQueue *FairQueue
+	identify  ClientIdentityFunc
+}
+
+// NewReloadableFairQueuing creates a ReloadableFairQueuing that
+// initially uses a given FairQueue and ClientIdentityFunc. Fair
+// queuing is disabled if the FairQueue is nil.
+func NewReloadableFairQueuing(fairQueue *FairQueue, identify ClientIdentityFunc) *ReloadableFairQueuing {
+	return &ReloadableFairQueuing{
+		fairQueue: fairQueue,
+		identify:  identify,
+	}
+}
+
+// SetFairQueue replaces the FairQueue and ClientIdentityFunc. Fair
+// queuing is disabled if the FairQueue is nil.
+//
+// If fair queuing is already enabled, the existing FairQueue is
+// reconfigured to use the limits and classes of the one provided, so
+// that requests that are in flight remain accounted for. Requests that
+// are in flight while fair queuing is enabled after being disabled
+// don't count towards the limits.
+func (rfq *ReloadableFairQueuing) SetFairQueue(fairQueue *FairQueue, identify ClientIdentityFunc) {
+	rfq.lock.Lock()
+	defer rfq.lock.Unlock()
+
+	if rfq.fairQueue != nil && fairQueue != nil {
+		rfq.fairQueue.Reconfigure(fairQueue.maximumConcurrency, fairQueue.classes, fairQueue.defaultClass)
+	} else {
+		rfq.fairQueue = fairQueue
+	}
+	rfq.identify = identify
+}
+
+// get returns the current FairQueue and ClientIdentityFunc.
+func (rfq *ReloadableFairQueuing) get() (*FairQueue, ClientIdentityFunc) {
+	rfq.lock.RLock()
+	defer rfq.lock.RUnlock()
+	return rfq.fairQueue, rfq.identify
+}
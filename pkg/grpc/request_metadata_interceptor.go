@@ -0,0 +1,68 @@
+package grpc
+
+import (
+	"context"
+	"log"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"go.opencensus.io/trace"
+)
+
+// extractRequestMetadata parses the REv2 RequestMetadata provided by
+// the client and attaches it to the Context object of the request. The
+// identifiers contained in it are also added to the trace span of the
+// request, so that traces can be correlated with builds.
+//
+// RequestMetadata is only used for informational purposes. Requests
+// containing malformed RequestMetadata are therefore still processed,
+// as if no RequestMetadata was provided.
+func extractRequestMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	requestMetadata, err := util.ParseRequestMetadata(md)
+	if err != nil {
+		log.Print("Ignoring request metadata: ", err)
+		return ctx
+	}
+	if requestMetadata == nil {
+		return ctx
+	}
+	ctx = util.NewContextWithRequestMetadata(ctx, requestMetadata)
+	util.AddRequestMetadataToSpan(ctx, trace.FromContext(ctx))
+	return ctx
+}
+
+// RequestMetadataUnaryServerInterceptor is a gRPC request interceptor
+// for unary calls that attaches the REv2 RequestMetadata provided by
+// clients such as Bazel to the Context object. It may be obtained by
+// calling util.GetRequestMetadataFromContext().
+func RequestMetadataUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(extractRequestMetadata(ctx), req)
+}
+
+// contextOverridingServerStream is a decorator for grpc.ServerStream
+// that overrides the Context object of the stream.
+type contextOverridingServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *contextOverridingServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// RequestMetadataStreamServerInterceptor is identical to
+// RequestMetadataUnaryServerInterceptor, except that it can be used
+// for streaming calls.
+func RequestMetadataStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextOverridingServerStream{
+		ServerStream: ss,
+		ctx:          extractRequestMetadata(ss.Context()),
+	})
+}
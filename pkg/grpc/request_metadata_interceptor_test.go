@@ -0,0 +1,96 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestMetadataUnaryServerInterceptor(t *testing.T) {
+	data, err := proto.Marshal(&remoteexecution.RequestMetadata{
+		ToolInvocationId: "4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11",
+	})
+	require.NoError(t, err)
+
+	t.Run("NoMetadata", func(t *testing.T) {
+		resp, err := bb_grpc.RequestMetadataUnaryServerInterceptor(context.Background(), "request", nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			require.Nil(t, util.GetRequestMetadataFromContext(ctx))
+			return "response", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "response", resp)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(util.RequestMetadataHeader, string(data)))
+		resp, err := bb_grpc.RequestMetadataUnaryServerInterceptor(ctx, "request", nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			require.True(t, proto.Equal(&remoteexecution.RequestMetadata{
+				ToolInvocationId: "4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11",
+			}, util.GetRequestMetadataFromContext(ctx)))
+			return "response", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "response", resp)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		// Malformed request metadata should not cause requests
+		// to fail, as it is only used for informational
+		// purposes.
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(util.RequestMetadataHeader, "\xff"))
+		resp, err := bb_grpc.RequestMetadataUnaryServerInterceptor(ctx, "request", nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			require.Nil(t, util.GetRequestMetadataFromContext(ctx))
+			return "response", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "response", resp)
+	})
+}
+
+// contextServerStream is a grpc.ServerStream that only provides a
+// Context object.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss contextServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func TestRequestMetadataStreamServerInterceptor(t *testing.T) {
+	data, err := proto.Marshal(&remoteexecution.RequestMetadata{
+		ToolInvocationId: "4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11",
+	})
+	require.NoError(t, err)
+
+	t.Run("Success", func(t *testing.T) {
+		ss := contextServerStream{
+			ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(util.RequestMetadataHeader, string(data))),
+		}
+		require.NoError(t, bb_grpc.RequestMetadataStreamServerInterceptor(nil, ss, nil, func(srv interface{}, ss grpc.ServerStream) error {
+			require.True(t, proto.Equal(&remoteexecution.RequestMetadata{
+				ToolInvocationId: "4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11",
+			}, util.GetRequestMetadataFromContext(ss.Context())))
+			return nil
+		}))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		ss := contextServerStream{
+			ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(util.RequestMetadataHeader, "\xff")),
+		}
+		require.NoError(t, bb_grpc.RequestMetadataStreamServerInterceptor(nil, ss, nil, func(srv interface{}, ss grpc.ServerStream) error {
+			require.Nil(t, util.GetRequestMetadataFromContext(ss.Context()))
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,49 @@
+// +build linux,go1.16
+
+package hardening
+
+import (
+	"syscall"
+
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/hardening"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// setCredentials switches to a different user and group. The functions
+// in package syscall are used, as they apply the change to all threads
+// of the process.
+func setCredentials(credentials *pb.Credentials) error {
+	groups := make([]int, 0, len(credentials.AdditionalGroupIds))
+	for _, gid := range credentials.AdditionalGroupIds {
+		groups = append(groups, int(gid))
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return util.StatusWrapWithCode(err, codes.PermissionDenied, "Failed to set supplementary group IDs")
+	}
+	gid := int(credentials.GroupId)
+	if err := syscall.Setresgid(gid, gid, gid); err != nil {
+		return util.StatusWrapfWithCode(err, codes.PermissionDenied, "Failed to set group ID to %d", gid)
+	}
+	uid := int(credentials.UserId)
+	if err := syscall.Setresuid(uid, uid, uid); err != nil {
+		return util.StatusWrapfWithCode(err, codes.PermissionDenied, "Failed to set user ID to %d", uid)
+	}
+	return nil
+}
+
+// allThreadsSyscall invokes a system call on all threads of the
+// process. This is needed for system calls that only affect the
+// calling thread, as the Go runtime may schedule goroutines on any
+// thread.
+func allThreadsSyscall(trap, a1, a2, a3 uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return status.Error(codes.Unimplemented, "Invoking system calls on all threads requires a binary built without cgo")
+		}
+		return util.StatusWrapWithCode(errno, codes.Internal, "System call failed")
+	}
+	return nil
+}
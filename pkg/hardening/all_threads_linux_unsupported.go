@@ -0,0 +1,23 @@
+// +build linux,!go1.16
+
+package hardening
+
+import (
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/hardening"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Prior to Go 1.16, the Go runtime provides no way to invoke system
+// calls on all threads of the process. Invoking them on a single
+// thread would leave the process only partially restricted, so refuse
+// to do so.
+
+func setCredentials(credentials *pb.Credentials) error {
+	return status.Error(codes.Unimplemented, "Switching to a different user and group requires a binary built with Go 1.16 or later")
+}
+
+func allThreadsSyscall(trap, a1, a2, a3 uintptr) error {
+	return status.Error(codes.Unimplemented, "Invoking system calls on all threads requires a binary built with Go 1.16 or later")
+}
@@ -0,0 +1,20 @@
+// +build !linux
+
+package hardening
+
+import (
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/hardening"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ApplyConfiguration restricts the privileges of the current process,
+// based on options specified in a configuration file. This is not
+// supported on this platform.
+func ApplyConfiguration(configuration *pb.HardeningConfiguration) error {
+	if configuration == nil {
+		return nil
+	}
+	return status.Error(codes.Unimplemented, "Hardening is not supported on this platform")
+}
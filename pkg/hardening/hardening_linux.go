@@ -0,0 +1,74 @@
+// +build linux
+
+package hardening
+
+import (
+	"os"
+
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/hardening"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+)
+
+// ApplyConfiguration restricts the privileges of the current process,
+// based on options specified in a configuration file. It should be
+// called once the process has acquired all resources that require
+// elevated privileges, such as storage directories and listening
+// sockets.
+//
+// Restrictions are applied in an order that ensures that no step
+// prevents the next one from succeeding: Landlock rules are created
+// while all paths are still reachable, followed by changing the root
+// directory and credentials, followed by restricting the file system
+// access and system calls.
+func ApplyConfiguration(configuration *pb.HardeningConfiguration) error {
+	if configuration == nil {
+		return nil
+	}
+
+	var ruleset *landlockRuleset
+	if landlockConfiguration := configuration.Landlock; landlockConfiguration != nil {
+		var err error
+		ruleset, err = newLandlockRulesetFromConfiguration(landlockConfiguration)
+		if err != nil {
+			return util.StatusWrap(err, "Failed to create Landlock ruleset")
+		}
+		if ruleset != nil {
+			defer ruleset.close()
+		}
+	}
+
+	if chrootDirectory := configuration.ChrootDirectory; chrootDirectory != "" {
+		if err := unix.Chroot(chrootDirectory); err != nil {
+			return util.StatusWrapfWithCode(err, codes.PermissionDenied, "Failed to change root directory to %#v", chrootDirectory)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to change working directory to new root directory")
+		}
+	}
+
+	if runAs := configuration.RunAs; runAs != nil {
+		if err := setCredentials(runAs); err != nil {
+			return err
+		}
+	}
+
+	if ruleset != nil {
+		// Landlock requires that the process can no longer gain
+		// privileges through execve().
+		if err := allThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+			return util.StatusWrap(err, "Failed to prevent the process from gaining privileges")
+		}
+		if err := ruleset.restrictSelf(); err != nil {
+			return util.StatusWrap(err, "Failed to apply Landlock ruleset")
+		}
+	}
+	if configuration.Seccomp {
+		if err := installSeccompFilter(); err != nil {
+			return util.StatusWrap(err, "Failed to install seccomp filter")
+		}
+	}
+	return nil
+}
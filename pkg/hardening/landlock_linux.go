@@ -0,0 +1,142 @@
+// +build linux
+
+package hardening
+
+import (
+	"unsafe"
+
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/hardening"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+)
+
+// System call numbers and constants of the Landlock API, as defined in
+// <linux/landlock.h>. These system calls have the same number on all
+// architectures.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	landlockAccessFSExecute    = 1 << 0
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSReadFile   = 1 << 2
+	landlockAccessFSReadDir    = 1 << 3
+	landlockAccessFSRemoveDir  = 1 << 4
+	landlockAccessFSRemoveFile = 1 << 5
+	landlockAccessFSMakeChar   = 1 << 6
+	landlockAccessFSMakeDir    = 1 << 7
+	landlockAccessFSMakeReg    = 1 << 8
+	landlockAccessFSMakeSock   = 1 << 9
+	landlockAccessFSMakeFifo   = 1 << 10
+	landlockAccessFSMakeBlock  = 1 << 11
+	landlockAccessFSMakeSym    = 1 << 12
+
+	// All rights supported by version 1 of the Landlock ABI. All of
+	// them are restricted, meaning that executing files is never
+	// permitted.
+	landlockAccessFSAll = 1<<13 - 1
+
+	// Rights that may be granted on files, as opposed to
+	// directories.
+	landlockAccessFSFile = landlockAccessFSExecute | landlockAccessFSWriteFile | landlockAccessFSReadFile
+
+	landlockAccessFSReadOnly  = landlockAccessFSReadFile | landlockAccessFSReadDir
+	landlockAccessFSReadWrite = landlockAccessFSReadOnly | landlockAccessFSWriteFile |
+		landlockAccessFSRemoveDir | landlockAccessFSRemoveFile | landlockAccessFSMakeDir |
+		landlockAccessFSMakeReg | landlockAccessFSMakeSock | landlockAccessFSMakeFifo |
+		landlockAccessFSMakeSym
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr corresponds to struct
+// landlock_path_beneath_attr. The kernel's version of this structure
+// is packed, which is compatible with the first 12 bytes of this one.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFD      int32
+}
+
+// landlockRuleset is a set of Landlock rules that has not yet been
+// applied to the current process.
+type landlockRuleset struct {
+	fd int
+}
+
+// newLandlockRulesetFromConfiguration creates a Landlock ruleset that
+// only grants access to the paths listed in the configuration. If the
+// kernel does not support Landlock and best effort mode is enabled, no
+// ruleset is returned.
+func newLandlockRulesetFromConfiguration(configuration *pb.LandlockConfiguration) (*landlockRuleset, error) {
+	if _, _, errno := unix.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion); errno != 0 {
+		if (errno == unix.ENOSYS || errno == unix.EOPNOTSUPP) && configuration.BestEffort {
+			return nil, nil
+		}
+		return nil, util.StatusWrapWithCode(errno, codes.Unimplemented, "Landlock is not supported by the kernel")
+	}
+
+	attr := landlockRulesetAttr{handledAccessFS: landlockAccessFSAll}
+	fd, _, errno := unix.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return nil, util.StatusWrapWithCode(errno, codes.Internal, "Failed to create ruleset")
+	}
+	r := &landlockRuleset{fd: int(fd)}
+	for _, path := range configuration.ReadWritePaths {
+		if err := r.addPath(path, landlockAccessFSReadWrite); err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+	for _, path := range configuration.ReadOnlyPaths {
+		if err := r.addPath(path, landlockAccessFSReadOnly); err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *landlockRuleset) addPath(path string, allowedAccess uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to open %#v", path)
+	}
+	defer unix.Close(fd)
+
+	// Rights that only apply to directories cannot be granted on
+	// other kinds of files.
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to obtain file type of %#v", path)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		allowedAccess &= landlockAccessFSFile
+	}
+
+	attr := landlockPathBeneathAttr{
+		allowedAccess: allowedAccess,
+		parentFD:      int32(fd),
+	}
+	if _, _, errno := unix.Syscall6(sysLandlockAddRule, uintptr(r.fd), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return util.StatusWrapfWithCode(errno, codes.Internal, "Failed to add rule for %#v", path)
+	}
+	return nil
+}
+
+// restrictSelf applies the ruleset to all threads of the current
+// process. The process must no longer be able to gain privileges.
+func (r *landlockRuleset) restrictSelf() error {
+	return allThreadsSyscall(sysLandlockRestrictSelf, uintptr(r.fd), 0, 0)
+}
+
+func (r *landlockRuleset) close() {
+	unix.Close(r.fd)
+}
@@ -0,0 +1,133 @@
+// +build linux,amd64 linux,arm64
+
+package hardening
+
+import (
+	"runtime"
+	"unsafe"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Constants of the seccomp API, as defined in <linux/seccomp.h>.
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1 << 0
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	// Offsets of fields in struct seccomp_data.
+	seccompDataNROffset   = 0
+	seccompDataArchOffset = 4
+)
+
+// seccompDeniedSyscalls is the list of system calls that cause the
+// seccomp filter to return EPERM. None of these are needed by a storage
+// process that has completed its initialization, while they are
+// commonly used to escalate privileges or escape a sandbox.
+var seccompDeniedSyscalls = []uint32{
+	unix.SYS_ADD_KEY,
+	unix.SYS_BPF,
+	unix.SYS_CHROOT,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_EXECVE,
+	unix.SYS_EXECVEAT,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_MOUNT,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PTRACE,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETGID,
+	unix.SYS_SETGROUPS,
+	unix.SYS_SETNS,
+	unix.SYS_SETREGID,
+	unix.SYS_SETRESGID,
+	unix.SYS_SETRESUID,
+	unix.SYS_SETREUID,
+	unix.SYS_SETUID,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_UMOUNT2,
+	unix.SYS_UNSHARE,
+}
+
+func newSeccompStatement(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func newSeccompJump(code uint16, k uint32, jt uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, K: k}
+}
+
+// newSeccompProgram creates a BPF program that kills the process when
+// system calls are made using a foreign calling convention, and causes
+// denied system calls to fail with EPERM.
+func newSeccompProgram() []unix.SockFilter {
+	program := []unix.SockFilter{
+		newSeccompStatement(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArchOffset),
+		newSeccompJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, seccompAuditArch, 1),
+		newSeccompStatement(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+		newSeccompStatement(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNROffset),
+	}
+
+	// Emit a conditional jump for every denied system call. Jump
+	// offsets are relative to the next instruction, and are filled
+	// in once the position of the final instruction is known.
+	var jumps []int
+	if seccompForeignSyscallMask != 0 {
+		jumps = append(jumps, len(program))
+		program = append(program, newSeccompJump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, seccompForeignSyscallMask, 0))
+	}
+	for _, nr := range seccompDeniedSyscalls {
+		jumps = append(jumps, len(program))
+		program = append(program, newSeccompJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0))
+	}
+	program = append(program, newSeccompStatement(unix.BPF_RET|unix.BPF_K, seccompRetAllow))
+	deny := len(program)
+	program = append(program, newSeccompStatement(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(unix.EPERM)))
+	for _, jump := range jumps {
+		program[jump].Jt = uint8(deny - jump - 1)
+	}
+	return program
+}
+
+// installSeccompFilter installs a seccomp filter on all threads of the
+// current process. The filter is installed with the TSYNC flag, which
+// causes the kernel to apply it to all threads, and to propagate the
+// calling thread's inability to gain privileges to them as well. This
+// means that both steps can be performed on the calling thread.
+func installSeccompFilter() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to prevent the process from gaining privileges")
+	}
+	program := newSeccompProgram()
+	fprog := unix.SockFprog{
+		Len:    uint16(len(program)),
+		Filter: &program[0],
+	}
+	thread, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return util.StatusWrapWithCode(errno, codes.Internal, "Failed to install filter")
+	}
+	if thread != 0 {
+		return status.Errorf(codes.Internal, "Failed to synchronize filter to thread %d", thread)
+	}
+	return nil
+}
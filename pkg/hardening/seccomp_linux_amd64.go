@@ -0,0 +1,11 @@
+package hardening
+
+const (
+	// seccompAuditArch is the value of AUDIT_ARCH_X86_64.
+	seccompAuditArch = 0xc000003e
+
+	// seccompForeignSyscallMask is set on system call numbers of the
+	// x32 ABI, which shares the architecture value of x86-64. System
+	// calls made using this ABI are denied.
+	seccompForeignSyscallMask = 0x40000000
+)
@@ -0,0 +1,10 @@
+package hardening
+
+const (
+	// seccompAuditArch is the value of AUDIT_ARCH_AARCH64.
+	seccompAuditArch = 0xc00000b7
+
+	// seccompForeignSyscallMask is not needed on this architecture,
+	// as there is no alternative ABI sharing its architecture value.
+	seccompForeignSyscallMask = 0
+)
@@ -0,0 +1,12 @@
+// +build linux,!amd64,!arm64
+
+package hardening
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func installSeccompFilter() error {
+	return status.Error(codes.Unimplemented, "Seccomp filters are not supported on this architecture")
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HealthChecker is used by Prober to determine whether a component
+// of a service (e.g., a storage backend) is functional.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+type findMissingHealthChecker struct {
+	blobAccess blobstore.BlobAccess
+	digests    digest.Set
+}
+
+// NewFindMissingHealthChecker creates a HealthChecker that probes a
+// storage backend by calling FindMissing() against it for a single
+// object. The outcome of the call is ignored, as long as it succeeds.
+// This is a cheap way of determining whether backends of the Content
+// Addressable Storage (e.g., a disk or a bucket) are reachable.
+func NewFindMissingHealthChecker(blobAccess blobstore.BlobAccess, probeDigest digest.Digest) HealthChecker {
+	return &findMissingHealthChecker{
+		blobAccess: blobAccess,
+		digests:    digest.NewSetBuilder().Add(probeDigest).Build(),
+	}
+}
+
+func (hc *findMissingHealthChecker) CheckHealth(ctx context.Context) error {
+	_, err := hc.blobAccess.FindMissing(ctx, hc.digests)
+	return err
+}
+
+type getHealthChecker struct {
+	blobAccess       blobstore.BlobAccess
+	probeDigest      digest.Digest
+	maximumSizeBytes int
+}
+
+// NewGetHealthChecker creates a HealthChecker that probes a storage
+// backend by calling Get() against it for a single object. The object
+// being absent is not considered to be a failure. This HealthChecker
+// may be used for backends of the Action Cache, as these do not
+// support FindMissing().
+func NewGetHealthChecker(blobAccess blobstore.BlobAccess, probeDigest digest.Digest, maximumSizeBytes int) HealthChecker {
+	return &getHealthChecker{
+		blobAccess:       blobAccess,
+		probeDigest:      probeDigest,
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+func (hc *getHealthChecker) CheckHealth(ctx context.Context) error {
+	if _, err := hc.blobAccess.Get(ctx, hc.probeDigest).ToByteSlice(hc.maximumSizeBytes); err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	proberPrometheusMetrics sync.Once
+
+	proberHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "health",
+			Name:      "prober_healthy",
+			Help:      "Whether the last probe of a component succeeded (1) or failed (0).",
+		},
+		[]string{"name"})
+)
+
+// Prober periodically calls into a set of HealthCheckers to determine
+// whether the service is ready to accept traffic. The outcome is
+// exposed both through the grpc.health.v1.Health service and through
+// an HTTP handler, so that load balancers can stop sending traffic to
+// instances for which a backend (e.g., a disk or a bucket) is
+// unreachable.
+//
+// Until the first round of probes has completed, the service is
+// reported as not being ready.
+type Prober struct {
+	names        []string
+	checkers     map[string]HealthChecker
+	clock        clock.Clock
+	timeout      time.Duration
+	healthServer *health.Server
+	healthy      map[string]prometheus.Gauge
+
+	lock         sync.Mutex
+	probed       bool
+	shuttingDown bool
+	failures     map[string]error
+}
+
+// NewProber creates a Prober for a set of named HealthCheckers. Every
+// probe is subject to a timeout. The serving status of the provided
+// gRPC health server is updated after every round of probes.
+func NewProber(checkers map[string]HealthChecker, clock clock.Clock, timeout time.Duration, healthServer *health.Server) *Prober {
+	proberPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(proberHealthy)
+	})
+
+	names := make([]string, 0, len(checkers))
+	healthy := map[string]prometheus.Gauge{}
+	for name := range checkers {
+		names = append(names, name)
+		healthy[name] = proberHealthy.WithLabelValues(name)
+	}
+	sort.Strings(names)
+
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return &Prober{
+		names:        names,
+		checkers:     checkers,
+		clock:        clock,
+		timeout:      timeout,
+		healthServer: healthServer,
+		healthy:      healthy,
+	}
+}
+
+// Probe calls into all HealthCheckers once and updates the readiness
+// of the service accordingly.
+func (p *Prober) Probe(ctx context.Context) {
+	failures := map[string]error{}
+	for _, name := range p.names {
+		ctxWithTimeout, cancel := p.clock.NewContextWithTimeout(ctx, p.timeout)
+		err := p.checkers[name].CheckHealth(ctxWithTimeout)
+		cancel()
+		if err != nil {
+			failures[name] = util.StatusWrapf(err, "Probe of %#v failed", name)
+			p.healthy[name].Set(0)
+		} else {
+			p.healthy[name].Set(1)
+		}
+	}
+
+	p.lock.Lock()
+	p.probed = true
+	p.failures = failures
+	p.lock.Unlock()
+
+	if len(failures) == 0 {
+		p.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	} else {
+		p.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// Run calls Probe() at a fixed interval. The first round of probes is
+// performed immediately. When the provided context is cancelled, the
+// service is permanently reported as not being ready, so that load
+// balancers stop sending traffic while the service shuts down.
+func (p *Prober) Run(ctx context.Context, interval time.Duration) {
+	for {
+		p.Probe(ctx)
+		timer, t := p.clock.NewTimer(interval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			p.lock.Lock()
+			p.shuttingDown = true
+			p.lock.Unlock()
+			p.healthServer.Shutdown()
+			return
+		}
+	}
+}
+
+// ServeHTTP implements an HTTP handler that returns 200 if the last
+// round of probes succeeded, and 503 otherwise. It can be used to
+// implement endpoints such as /readyz.
+func (p *Prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	probed, shuttingDown := p.probed, p.shuttingDown
+	var messages []string
+	for _, name := range p.names {
+		if err, ok := p.failures[name]; ok {
+			messages = append(messages, err.Error())
+		}
+	}
+	p.lock.Unlock()
+
+	if shuttingDown {
+		http.Error(w, "Service is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if !probed {
+		http.Error(w, "Service has not been probed yet", http.StatusServiceUnavailable)
+		return
+	}
+	if len(messages) > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, message := range messages {
+			fmt.Fprintln(w, message)
+		}
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
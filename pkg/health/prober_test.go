@@ -0,0 +1,98 @@
+package health_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/health"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	grpc_health "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestProber(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	casChecker := mock.NewMockHealthChecker(ctrl)
+	acChecker := mock.NewMockHealthChecker(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	healthServer := grpc_health.NewServer()
+	prober := health.NewProber(
+		map[string]health.HealthChecker{
+			"ac":  acChecker,
+			"cas": casChecker,
+		},
+		clock,
+		5*time.Second,
+		healthServer)
+
+	requireStatus := func(t *testing.T, servingStatus healthpb.HealthCheckResponse_ServingStatus, httpStatus int, body string) {
+		response, err := healthServer.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		require.Equal(t, servingStatus, response.Status)
+
+		recorder := httptest.NewRecorder()
+		prober.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		require.Equal(t, httpStatus, recorder.Code)
+		require.Equal(t, body, recorder.Body.String())
+	}
+	expectProbe := func(checker *mock.MockHealthChecker, err error) {
+		ctxWithTimeout, cancel := context.WithCancel(ctx)
+		clock.EXPECT().NewContextWithTimeout(ctx, 5*time.Second).Return(ctxWithTimeout, cancel)
+		checker.EXPECT().CheckHealth(ctxWithTimeout).Return(err)
+	}
+
+	t.Run("NotProbedYet", func(t *testing.T) {
+		requireStatus(t, healthpb.HealthCheckResponse_NOT_SERVING, http.StatusServiceUnavailable, "Service has not been probed yet\n")
+	})
+
+	t.Run("Healthy", func(t *testing.T) {
+		expectProbe(acChecker, nil)
+		expectProbe(casChecker, nil)
+		prober.Probe(ctx)
+		requireStatus(t, healthpb.HealthCheckResponse_SERVING, http.StatusOK, "OK\n")
+	})
+
+	t.Run("Unhealthy", func(t *testing.T) {
+		expectProbe(acChecker, nil)
+		expectProbe(casChecker, status.Error(codes.Unavailable, "Disk unreachable"))
+		prober.Probe(ctx)
+		requireStatus(t, healthpb.HealthCheckResponse_NOT_SERVING, http.StatusServiceUnavailable, "rpc error: code = Unavailable desc = Probe of \"cas\" failed: Disk unreachable\n")
+	})
+
+	t.Run("Recovered", func(t *testing.T) {
+		expectProbe(acChecker, nil)
+		expectProbe(casChecker, nil)
+		prober.Probe(ctx)
+		requireStatus(t, healthpb.HealthCheckResponse_SERVING, http.StatusOK, "OK\n")
+	})
+
+	t.Run("ShuttingDown", func(t *testing.T) {
+		// Once the context passed to Run() is cancelled, the
+		// service should permanently be reported as not ready.
+		ctxWithCancel, cancel := context.WithCancel(ctx)
+		for _, checker := range []*mock.MockHealthChecker{acChecker, casChecker} {
+			ctxWithTimeout, cancelTimeout := context.WithCancel(ctx)
+			clock.EXPECT().NewContextWithTimeout(ctxWithCancel, 5*time.Second).Return(ctxWithTimeout, cancelTimeout)
+			checker.EXPECT().CheckHealth(ctxWithTimeout).Return(nil)
+		}
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Minute).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+			cancel()
+			return timer, nil
+		})
+		timer.EXPECT().Stop().Return(true)
+
+		prober.Run(ctxWithCancel, time.Minute)
+		requireStatus(t, healthpb.HealthCheckResponse_NOT_SERVING, http.StatusServiceUnavailable, "Service is shutting down\n")
+	})
+}
@@ -0,0 +1,154 @@
+package iscc
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/iscc"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type initialSizeClassCacheServer struct {
+	blobAccess                            blobstore.BlobAccess
+	maximumMessageSizeBytes               int
+	maximumPreviousExecutionsPerSizeClass int
+}
+
+// NewInitialSizeClassCacheServer creates a gRPC service for serving
+// the contents of an Initial Size Class Cache (ISCC). Statistics are
+// stored in a BlobAccess that is created with
+// blobstore.ISCCStorageType.
+//
+// Statistics provided to UpdatePreviousExecutionStats() are merged
+// into the ones that are already stored, unless the caller requests
+// that they are replaced. For every size class, only
+// the most recent executions are retained. Merging is performed by
+// reading the existing statistics and writing the merged copy back,
+// meaning that concurrent updates of the same entry may cause some
+// executions to be lost. This is acceptable, as the statistics are
+// only used to make predictions.
+func NewInitialSizeClassCacheServer(blobAccess blobstore.BlobAccess, maximumMessageSizeBytes int, maximumPreviousExecutionsPerSizeClass int) iscc.InitialSizeClassCacheServer {
+	return &initialSizeClassCacheServer{
+		blobAccess:                            blobAccess,
+		maximumMessageSizeBytes:               maximumMessageSizeBytes,
+		maximumPreviousExecutionsPerSizeClass: maximumPreviousExecutionsPerSizeClass,
+	}
+}
+
+func (s *initialSizeClassCacheServer) getPreviousExecutionStats(ctx context.Context, reducedActionDigest digest.Digest) (*iscc.PreviousExecutionStats, error) {
+	data, err := s.blobAccess.Get(ctx, reducedActionDigest).ToByteSlice(s.maximumMessageSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	var stats iscc.PreviousExecutionStats
+	if err := proto.Unmarshal(data, &stats); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to unmarshal message")
+	}
+	return &stats, nil
+}
+
+func (s *initialSizeClassCacheServer) GetPreviousExecutionStats(ctx context.Context, request *iscc.GetPreviousExecutionStatsRequest) (*iscc.PreviousExecutionStats, error) {
+	reducedActionDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.ReducedActionDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid reduced action digest")
+	}
+	return s.getPreviousExecutionStats(ctx, reducedActionDigest)
+}
+
+func validatePreviousExecution(previousExecution *iscc.PreviousExecution) error {
+	switch outcome := previousExecution.Outcome.(type) {
+	case *iscc.PreviousExecution_Failed:
+	case *iscc.PreviousExecution_TimedOut:
+		if _, err := ptypes.Duration(outcome.TimedOut); err != nil {
+			return util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid execution time")
+		}
+	case *iscc.PreviousExecution_Succeeded:
+		if _, err := ptypes.Duration(outcome.Succeeded); err != nil {
+			return util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid execution time")
+		}
+	default:
+		return status.Error(codes.InvalidArgument, "No outcome provided")
+	}
+	if previousExecution.MaximumResidentSetSizeBytes < 0 {
+		return status.Error(codes.InvalidArgument, "Maximum resident set size cannot be negative")
+	}
+	return nil
+}
+
+// mergePreviousExecutionStats merges statistics into the ones that are
+// already stored.
+func (s *initialSizeClassCacheServer) mergePreviousExecutionStats(ctx context.Context, reducedActionDigest digest.Digest, update *iscc.PreviousExecutionStats) (*iscc.PreviousExecutionStats, error) {
+	stats, err := s.getPreviousExecutionStats(ctx, reducedActionDigest)
+	if status.Code(err) == codes.NotFound {
+		stats = &iscc.PreviousExecutionStats{}
+	} else if err != nil {
+		return nil, util.StatusWrap(err, "Failed to obtain existing previous execution stats")
+	}
+	if stats.SizeClasses == nil {
+		stats.SizeClasses = map[uint32]*iscc.PerSizeClassStats{}
+	}
+	for sizeClass, perSizeClassStats := range update.SizeClasses {
+		existing, ok := stats.SizeClasses[sizeClass]
+		if !ok {
+			existing = &iscc.PerSizeClassStats{}
+			stats.SizeClasses[sizeClass] = existing
+		}
+		existing.PreviousExecutions = append(existing.PreviousExecutions, perSizeClassStats.GetPreviousExecutions()...)
+		if excess := len(existing.PreviousExecutions) - s.maximumPreviousExecutionsPerSizeClass; excess > 0 {
+			existing.PreviousExecutions = existing.PreviousExecutions[excess:]
+		}
+	}
+	if update.LastSeenFailure != nil {
+		// The new timestamp has been validated by the caller,
+		// while the existing timestamp is treated as absent if
+		// invalid.
+		newTime, _ := ptypes.Timestamp(update.LastSeenFailure)
+		if oldTime, err := ptypes.Timestamp(stats.LastSeenFailure); err != nil || newTime.After(oldTime) {
+			stats.LastSeenFailure = update.LastSeenFailure
+		}
+	}
+	return stats, nil
+}
+
+func (s *initialSizeClassCacheServer) UpdatePreviousExecutionStats(ctx context.Context, request *iscc.UpdatePreviousExecutionStatsRequest) (*empty.Empty, error) {
+	reducedActionDigest, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.ReducedActionDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid reduced action digest")
+	}
+	update := request.PreviousExecutionStats
+	if update == nil {
+		return nil, status.Error(codes.InvalidArgument, "No previous execution stats provided")
+	}
+	for sizeClass, perSizeClassStats := range update.SizeClasses {
+		for i, previousExecution := range perSizeClassStats.GetPreviousExecutions() {
+			if err := validatePreviousExecution(previousExecution); err != nil {
+				return nil, util.StatusWrapf(err, "Size class %d, previous execution at index %d", sizeClass, i)
+			}
+		}
+	}
+	if update.LastSeenFailure != nil {
+		if _, err := ptypes.Timestamp(update.LastSeenFailure); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid last seen failure time")
+		}
+	}
+
+	stats := update
+	if !request.Replace {
+		stats, err = s.mergePreviousExecutionStats(ctx, reducedActionDigest, update)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.blobAccess.Put(ctx, reducedActionDigest, buffer.NewProtoBufferFromProto(stats, buffer.UserProvided)); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
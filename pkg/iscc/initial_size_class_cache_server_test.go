@@ -0,0 +1,171 @@
+package iscc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/iscc"
+	iscc_pb "github.com/buildbarn/bb-storage/pkg/proto/iscc"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func succeeded(seconds int64) *iscc_pb.PreviousExecution {
+	return &iscc_pb.PreviousExecution{
+		Outcome: &iscc_pb.PreviousExecution_Succeeded{
+			Succeeded: &duration.Duration{Seconds: seconds},
+		},
+		MaximumResidentSetSizeBytes: 1 << 20,
+	}
+}
+
+func TestInitialSizeClassCacheServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	server := iscc.NewInitialSizeClassCacheServer(blobAccess, 1000, 2)
+
+	reducedActionDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	expectPut := func(t *testing.T, expected *iscc_pb.PreviousExecutionStats) {
+		blobAccess.EXPECT().Put(ctx, reducedActionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(1000)
+				require.NoError(t, err)
+				var stats iscc_pb.PreviousExecutionStats
+				require.NoError(t, proto.Unmarshal(data, &stats))
+				require.True(t, proto.Equal(expected, &stats))
+				return nil
+			})
+	}
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		stats := &iscc_pb.PreviousExecutionStats{
+			SizeClasses: map[uint32]*iscc_pb.PerSizeClassStats{
+				4: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(10)}},
+			},
+		}
+		blobAccess.EXPECT().Get(ctx, reducedActionDigest).Return(buffer.NewProtoBufferFromProto(stats, buffer.Irreparable))
+
+		response, err := server.GetPreviousExecutionStats(ctx, &iscc_pb.GetPreviousExecutionStatsRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: reducedActionDigest.GetPartialDigest(),
+		})
+		require.NoError(t, err)
+		require.True(t, proto.Equal(stats, response))
+	})
+
+	t.Run("UpdateNoOutcome", func(t *testing.T) {
+		_, err := server.UpdatePreviousExecutionStats(ctx, &iscc_pb.UpdatePreviousExecutionStatsRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: reducedActionDigest.GetPartialDigest(),
+			PreviousExecutionStats: &iscc_pb.PreviousExecutionStats{
+				SizeClasses: map[uint32]*iscc_pb.PerSizeClassStats{
+					4: {PreviousExecutions: []*iscc_pb.PreviousExecution{{}}},
+				},
+			},
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Size class 4, previous execution at index 0: No outcome provided"), err)
+	})
+
+	t.Run("UpdateNotFound", func(t *testing.T) {
+		// In the absence of existing statistics, the provided
+		// statistics should be stored as is.
+		update := &iscc_pb.PreviousExecutionStats{
+			SizeClasses: map[uint32]*iscc_pb.PerSizeClassStats{
+				4: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(10)}},
+			},
+			LastSeenFailure: &timestamp.Timestamp{Seconds: 1000},
+		}
+		blobAccess.EXPECT().Get(ctx, reducedActionDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		expectPut(t, update)
+
+		_, err := server.UpdatePreviousExecutionStats(ctx, &iscc_pb.UpdatePreviousExecutionStatsRequest{
+			InstanceName:           "instance",
+			ReducedActionDigest:    reducedActionDigest.GetPartialDigest(),
+			PreviousExecutionStats: update,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("UpdateGetFailure", func(t *testing.T) {
+		blobAccess.EXPECT().Get(ctx, reducedActionDigest).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Server on fire")))
+
+		_, err := server.UpdatePreviousExecutionStats(ctx, &iscc_pb.UpdatePreviousExecutionStatsRequest{
+			InstanceName:           "instance",
+			ReducedActionDigest:    reducedActionDigest.GetPartialDigest(),
+			PreviousExecutionStats: &iscc_pb.PreviousExecutionStats{},
+		})
+		require.Equal(t, status.Error(codes.Internal, "Failed to obtain existing previous execution stats: Server on fire"), err)
+	})
+
+	t.Run("UpdateMerge", func(t *testing.T) {
+		// Executions should be appended to the ones that are
+		// already stored, discarding the oldest ones when
+		// exceeding the limit. The last seen failure should
+		// only move forward.
+		blobAccess.EXPECT().Get(ctx, reducedActionDigest).Return(buffer.NewProtoBufferFromProto(&iscc_pb.PreviousExecutionStats{
+			SizeClasses: map[uint32]*iscc_pb.PerSizeClassStats{
+				4: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(10), succeeded(11)}},
+				8: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(5)}},
+			},
+			LastSeenFailure: &timestamp.Timestamp{Seconds: 2000},
+		}, buffer.Irreparable))
+		expectPut(t, &iscc_pb.PreviousExecutionStats{
+			SizeClasses: map[uint32]*iscc_pb.PerSizeClassStats{
+				1: {PreviousExecutions: []*iscc_pb.PreviousExecution{{
+					Outcome: &iscc_pb.PreviousExecution_Failed{Failed: &empty.Empty{}},
+				}}},
+				4: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(11), succeeded(12)}},
+				8: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(5)}},
+			},
+			LastSeenFailure: &timestamp.Timestamp{Seconds: 2000},
+		})
+
+		_, err := server.UpdatePreviousExecutionStats(ctx, &iscc_pb.UpdatePreviousExecutionStatsRequest{
+			InstanceName:        "instance",
+			ReducedActionDigest: reducedActionDigest.GetPartialDigest(),
+			PreviousExecutionStats: &iscc_pb.PreviousExecutionStats{
+				SizeClasses: map[uint32]*iscc_pb.PerSizeClassStats{
+					1: {PreviousExecutions: []*iscc_pb.PreviousExecution{{
+						Outcome: &iscc_pb.PreviousExecution_Failed{Failed: &empty.Empty{}},
+					}}},
+					4: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(12)}},
+				},
+				LastSeenFailure: &timestamp.Timestamp{Seconds: 1000},
+			},
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("UpdateReplace", func(t *testing.T) {
+		// Storage nodes that relay writes to another storage
+		// node have already merged the statistics. The
+		// statistics should then be stored as is, without
+		// reading the existing ones.
+		update := &iscc_pb.PreviousExecutionStats{
+			SizeClasses: map[uint32]*iscc_pb.PerSizeClassStats{
+				4: {PreviousExecutions: []*iscc_pb.PreviousExecution{succeeded(10), succeeded(11)}},
+			},
+		}
+		expectPut(t, update)
+
+		_, err := server.UpdatePreviousExecutionStats(ctx, &iscc_pb.UpdatePreviousExecutionStatsRequest{
+			InstanceName:           "instance",
+			ReducedActionDigest:    reducedActionDigest.GetPartialDigest(),
+			PreviousExecutionStats: update,
+			Replace:                true,
+		})
+		require.NoError(t, err)
+	})
+}
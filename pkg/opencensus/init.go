@@ -13,6 +13,11 @@ import (
 	"go.opencensus.io/zpages"
 )
 
+// defaultSamplingProbability is the probability with which traces are
+// sampled if not all of them are sampled. It is identical to the
+// default used by OpenCensus.
+const defaultSamplingProbability = 1e-4
+
 // Initialize sets up Opentracing with Jaeger and a Prometheus exporter.
 func Initialize(configuration *pb.JaegerConfiguration) {
 	if configuration != nil {
@@ -39,8 +44,16 @@ func Initialize(configuration *pb.JaegerConfiguration) {
 			log.Fatal("Failed to create the Jaeger exporter:", err)
 		}
 		trace.RegisterExporter(je)
-		if configuration.AlwaysSample {
-			trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
-		}
+		ApplySamplingConfiguration(configuration)
+	}
+}
+
+// ApplySamplingConfiguration sets the sampler used for new traces. It
+// may be called at runtime to change sampling without restarting.
+func ApplySamplingConfiguration(configuration *pb.JaegerConfiguration) {
+	if configuration.GetAlwaysSample() {
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	} else {
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(defaultSamplingProbability)})
 	}
 }
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"context"
+)
+
+type authenticatedIdentityKey struct{}
+
+// NewContextWithAuthenticatedIdentity attaches the identity of a
+// client that was established by an authentication policy to a Context
+// object. The identity can be used to isolate clients from each other
+// (e.g., when deduplicating or queueing requests).
+func NewContextWithAuthenticatedIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, authenticatedIdentityKey{}, identity)
+}
+
+// GetAuthenticatedIdentityFromContext returns the identity of a client
+// attached to a Context object. If the client is anonymous or no
+// authentication was performed, an empty string is returned.
+func GetAuthenticatedIdentityFromContext(ctx context.Context) string {
+	if identity, ok := ctx.Value(authenticatedIdentityKey{}).(string); ok {
+		return identity
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+type detachedContext struct {
+	context.Context
+}
+
+// NewDetachedContext creates a Context that preserves the values of
+// its parent (e.g., credentials and request metadata), but is never
+// cancelled and has no deadline. It can be used to start operations
+// that should not be cancelled when the caller that triggered them
+// goes away. Callers are responsible for bounding the lifetime of such
+// operations by other means (e.g., by applying a timeout).
+func NewDetachedContext(parent context.Context) context.Context {
+	return detachedContext{Context: parent}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
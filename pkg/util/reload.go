@@ -0,0 +1,37 @@
+package util
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ReloadConfigurationOnHangup re-reads a configuration file every time
+// the process receives SIGHUP. The newConfiguration function is called
+// to obtain an empty Protobuf message into which the configuration is
+// unmarshaled. Upon success, the resulting message is passed to the
+// apply function. Configuration files that fail to load are reported,
+// but otherwise ignored.
+//
+// This permits settings that don't affect the topology of a service
+// (e.g., authentication policies and TLS certificates) to be changed
+// without restarting it, which would cause in-memory caches to be
+// emptied.
+func ReloadConfigurationOnHangup(path string, newConfiguration func() proto.Message, apply func(proto.Message)) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			configuration := newConfiguration()
+			if err := UnmarshalConfigurationFromFile(path, configuration); err != nil {
+				log.Printf("Failed to reload configuration from %s: %s", path, err)
+				continue
+			}
+			log.Printf("Reloaded configuration from %s", path)
+			apply(configuration)
+		}
+	}()
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"go.opencensus.io/trace"
+)
+
+// RequestMetadataHeader is the name of the gRPC header in which clients
+// such as Bazel provide a marshaled REv2 RequestMetadata message.
+const RequestMetadataHeader = "build.bazel.remote.execution.v2.requestmetadata-bin"
+
+type requestMetadataKey struct{}
+
+// ParseRequestMetadata extracts the REv2 RequestMetadata message from
+// the headers of an incoming gRPC request. If no RequestMetadata is
+// provided, nil is returned.
+func ParseRequestMetadata(md metadata.MD) (*remoteexecution.RequestMetadata, error) {
+	values := md.Get(RequestMetadataHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var requestMetadata remoteexecution.RequestMetadata
+	if err := proto.Unmarshal([]byte(values[0]), &requestMetadata); err != nil {
+		return nil, StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal request metadata")
+	}
+	return &requestMetadata, nil
+}
+
+// NewContextWithRequestMetadata attaches an REv2 RequestMetadata
+// message to a Context object, so that it may be inspected by storage
+// backends (e.g., for logging and accounting).
+func NewContextWithRequestMetadata(ctx context.Context, requestMetadata *remoteexecution.RequestMetadata) context.Context {
+	return context.WithValue(ctx, requestMetadataKey{}, requestMetadata)
+}
+
+// GetRequestMetadataFromContext returns the REv2 RequestMetadata
+// message attached to a Context object. If no RequestMetadata is
+// attached, nil is returned.
+func GetRequestMetadataFromContext(ctx context.Context) *remoteexecution.RequestMetadata {
+	if requestMetadata, ok := ctx.Value(requestMetadataKey{}).(*remoteexecution.RequestMetadata); ok {
+		return requestMetadata
+	}
+	return nil
+}
+
+// DescribeRequestMetadata returns a human readable description of the
+// REv2 RequestMetadata message attached to a Context object, prefixed
+// with a space. It can be appended to log messages to correlate them
+// with the build that triggered them. If no RequestMetadata is
+// attached, an empty string is returned.
+func DescribeRequestMetadata(ctx context.Context) string {
+	requestMetadata := GetRequestMetadataFromContext(ctx)
+	if requestMetadata == nil {
+		return ""
+	}
+	var fields []string
+	if id := requestMetadata.ToolInvocationId; id != "" {
+		fields = append(fields, fmt.Sprintf("tool invocation %#v", id))
+	}
+	if id := requestMetadata.CorrelatedInvocationsId; id != "" {
+		fields = append(fields, fmt.Sprintf("correlated invocations %#v", id))
+	}
+	if id := requestMetadata.ActionId; id != "" {
+		fields = append(fields, fmt.Sprintf("action %#v", id))
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(fields, ", ") + ")"
+}
+
+// AddRequestMetadataToSpan adds the identifiers contained in the REv2
+// RequestMetadata message attached to a Context object to a trace span,
+// so that traces can be correlated with builds. Spans created by
+// storage backends should call this as well, as attributes of parent
+// spans are not inherited.
+func AddRequestMetadataToSpan(ctx context.Context, span *trace.Span) {
+	requestMetadata := GetRequestMetadataFromContext(ctx)
+	if requestMetadata == nil || span == nil {
+		return
+	}
+	var attributes []trace.Attribute
+	if id := requestMetadata.ToolInvocationId; id != "" {
+		attributes = append(attributes, trace.StringAttribute("tool_invocation_id", id))
+	}
+	if id := requestMetadata.CorrelatedInvocationsId; id != "" {
+		attributes = append(attributes, trace.StringAttribute("correlated_invocations_id", id))
+	}
+	if id := requestMetadata.ActionId; id != "" {
+		attributes = append(attributes, trace.StringAttribute("action_id", id))
+	}
+	span.AddAttributes(attributes...)
+}
@@ -0,0 +1,65 @@
+package util_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseRequestMetadata(t *testing.T) {
+	t.Run("NotProvided", func(t *testing.T) {
+		requestMetadata, err := util.ParseRequestMetadata(metadata.Pairs("authorization", "Bearer token"))
+		require.NoError(t, err)
+		require.Nil(t, requestMetadata)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		data, err := proto.Marshal(&remoteexecution.RequestMetadata{
+			ToolInvocationId: "4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11",
+			ActionId:         "de0a1b5c",
+		})
+		require.NoError(t, err)
+
+		requestMetadata, err := util.ParseRequestMetadata(metadata.Pairs(util.RequestMetadataHeader, string(data)))
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&remoteexecution.RequestMetadata{
+			ToolInvocationId: "4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11",
+			ActionId:         "de0a1b5c",
+		}, requestMetadata))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := util.ParseRequestMetadata(metadata.Pairs(util.RequestMetadataHeader, "\xff"))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestDescribeRequestMetadata(t *testing.T) {
+	t.Run("NotAttached", func(t *testing.T) {
+		require.Equal(t, "", util.DescribeRequestMetadata(context.Background()))
+	})
+
+	t.Run("NoIdentifiers", func(t *testing.T) {
+		ctx := util.NewContextWithRequestMetadata(context.Background(), &remoteexecution.RequestMetadata{})
+		require.Equal(t, "", util.DescribeRequestMetadata(ctx))
+	})
+
+	t.Run("Identifiers", func(t *testing.T) {
+		ctx := util.NewContextWithRequestMetadata(context.Background(), &remoteexecution.RequestMetadata{
+			ToolInvocationId:        "4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11",
+			CorrelatedInvocationsId: "ci-build-1234",
+		})
+		require.Equal(
+			t,
+			" (tool invocation \"4f7b6fa1-0c26-4a4c-9a5a-2f3d6b0e7c11\", correlated invocations \"ci-build-1234\")",
+			util.DescribeRequestMetadata(ctx))
+	})
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// NewShutdownContext creates a context that is cancelled as soon as
+// the process receives SIGINT or SIGTERM. This permits services to
+// drain traffic and release resources before terminating. Receiving
+// one of these signals a second time terminates the process
+// immediately.
+func NewShutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-signals
+		log.Printf("Received signal %#v, shutting down", s.String())
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
+
+var (
+	shutdownHooksLock sync.Mutex
+	shutdownHooks     []func(ctx context.Context) error
+)
+
+// RegisterShutdownHook registers a function that is called by
+// RunShutdownHooks. Storage backends may use this to complete work
+// that is performed asynchronously and to flush state to disk, so that
+// it is not lost when the process terminates.
+func RegisterShutdownHook(hook func(ctx context.Context) error) {
+	shutdownHooksLock.Lock()
+	shutdownHooks = append(shutdownHooks, hook)
+	shutdownHooksLock.Unlock()
+}
+
+// RunShutdownHooks calls all functions registered through
+// RegisterShutdownHook in reverse order of registration. As decorators
+// are created after the storage backends they wrap, this causes
+// decorators to be drained before the backends that they write to are
+// flushed. This function should be called after all servers have
+// stopped processing requests. Errors are logged, so that a failure to
+// flush one backend doesn't prevent others from being flushed.
+func RunShutdownHooks(ctx context.Context) {
+	shutdownHooksLock.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownHooksLock.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			log.Print("Shutdown hook failed: ", err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"sync"
 
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/tls"
 
@@ -64,3 +65,35 @@ func NewTLSConfigFromServerConfiguration(configuration *configuration.TLSServerC
 
 	return &tlsConfig, nil
 }
+
+// ReloadableCertificate holds a TLS certificate that may be replaced
+// at runtime. Its GetCertificate() function may be used as part of a
+// tls.Config, so that servers use the latest certificate for new
+// connections without needing to be restarted.
+type ReloadableCertificate struct {
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// NewReloadableCertificate creates a ReloadableCertificate that
+// initially holds a given certificate.
+func NewReloadableCertificate(certificate tls.Certificate) *ReloadableCertificate {
+	return &ReloadableCertificate{
+		certificate: &certificate,
+	}
+}
+
+// SetCertificate replaces the certificate that is returned.
+func (rc *ReloadableCertificate) SetCertificate(certificate tls.Certificate) {
+	rc.lock.Lock()
+	rc.certificate = &certificate
+	rc.lock.Unlock()
+}
+
+// GetCertificate returns the current certificate. Its signature is
+// compatible with tls.Config's GetCertificate field.
+func (rc *ReloadableCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	rc.lock.RLock()
+	defer rc.lock.RUnlock()
+	return rc.certificate, nil
+}